@@ -0,0 +1,63 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"syscall"
+)
+
+// listenFDsStart is the first file descriptor systemd passes to a
+// socket-activated service (SD_LISTEN_FDS_START).
+const listenFDsStart = 3
+
+// activationFDs reports how many sockets systemd passed to this process,
+// per the LISTEN_PID/LISTEN_FDS protocol. It returns 0 when the variables
+// are unset or were meant for another process (LISTEN_PID names a
+// different pid).
+func activationFDs(getenv envLookup, pid int) (int, error) {
+	fds := getenv("LISTEN_FDS")
+	if fds == "" {
+		return 0, nil
+	}
+	if listenPID := getenv("LISTEN_PID"); listenPID != "" {
+		n, err := strconv.Atoi(listenPID)
+		if err != nil {
+			return 0, fmt.Errorf("invalid LISTEN_PID %q", listenPID)
+		}
+		if n != pid {
+			return 0, nil
+		}
+	}
+	n, err := strconv.Atoi(fds)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid LISTEN_FDS %q", fds)
+	}
+	return n, nil
+}
+
+// systemdListener returns the listening socket passed by systemd socket
+// activation, or nil when wmux was not socket-activated. Exactly one socket
+// is accepted. The activation variables are cleared so tmux and the shells
+// it starts do not inherit them.
+func systemdListener() (net.Listener, error) {
+	n, err := activationFDs(os.Getenv, os.Getpid())
+	if err != nil || n == 0 {
+		return nil, err
+	}
+	for _, name := range []string{"LISTEN_PID", "LISTEN_FDS", "LISTEN_FDNAMES"} {
+		_ = os.Unsetenv(name)
+	}
+	if n != 1 {
+		return nil, fmt.Errorf("systemd passed %d sockets; wmux listens on exactly one", n)
+	}
+	syscall.CloseOnExec(listenFDsStart)
+	f := os.NewFile(listenFDsStart, "systemd-socket")
+	defer f.Close()
+	ln, err := net.FileListener(f)
+	if err != nil {
+		return nil, fmt.Errorf("systemd socket: %w", err)
+	}
+	return ln, nil
+}
@@ -0,0 +1,33 @@
+package main
+
+import "testing"
+
+func TestActivationFDs(t *testing.T) {
+	env := func(vars map[string]string) envLookup {
+		return func(name string) string { return vars[name] }
+	}
+	tests := []struct {
+		name    string
+		vars    map[string]string
+		want    int
+		wantErr bool
+	}{
+		{name: "not activated", vars: nil, want: 0},
+		{name: "activated", vars: map[string]string{"LISTEN_PID": "42", "LISTEN_FDS": "1"}, want: 1},
+		{name: "no pid check", vars: map[string]string{"LISTEN_FDS": "2"}, want: 2},
+		{name: "other process", vars: map[string]string{"LISTEN_PID": "7", "LISTEN_FDS": "1"}, want: 0},
+		{name: "bad fds", vars: map[string]string{"LISTEN_PID": "42", "LISTEN_FDS": "one"}, wantErr: true},
+		{name: "bad pid", vars: map[string]string{"LISTEN_PID": "x", "LISTEN_FDS": "1"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := activationFDs(env(tt.vars), 42)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Fatalf("fds = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
@@ -5,30 +5,81 @@ import (
 	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"log"
+	"log/slog"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
+	"strconv"
 	"strings"
 	"syscall"
 	"time"
+	"unicode"
 
+	"github.com/ampcode/wmux/internal/assets"
 	"github.com/ampcode/wmux/internal/httpd"
 	"github.com/ampcode/wmux/internal/policy"
+	"github.com/ampcode/wmux/internal/termimg"
 	"github.com/ampcode/wmux/internal/tmuxproc"
 	"github.com/ampcode/wmux/internal/wshub"
 )
 
 type config struct {
-	listen         string
-	targetSession  string
-	staticDir      string
-	tmuxBin        string
-	tmuxSocketName string
-	tmuxSocketPath string
-	term           string
-	restartBackoff time.Duration
-	restartMax     time.Duration
+	listen          string
+	targetSession   string
+	staticDir       string
+	recordDir       string
+	tmuxBin         string
+	tmuxSocketName  string
+	tmuxSocketPath  string
+	tmuxConf        string
+	term            string
+	restartBackoff  time.Duration
+	restartMax      time.Duration
+	noCreate        bool
+	initialCmd      string
+	initialShell    string
+	initialWinList  string
+	initialWindows  []string
+	clientBuffer    int
+	flowControl     bool
+	wsMaxMessage    int
+	tmuxMaxLine     int
+	maxOutputChunk  int
+	maxCapture      int
+	captureOverflow string
+	maxClients      int
+	wsMaxAge        time.Duration
+	killOrphans     bool
+	resyncDebounce  time.Duration
+	createTimeout   time.Duration
+	maxCreateEnv    int
+	maxCreateEnvVal int
+	readHdrTimeout  time.Duration
+	readTimeout     time.Duration
+	writeTimeout    time.Duration
+	idleTimeout     time.Duration
+	imageFont       string
+	imageCell       string
+	imageCellW      int
+	imageCellH      int
+	settableOpts    string
+	optionPolicy    policy.OptionPolicy
+	sentinel        string
+	extraFieldList  string
+	extraPaneFields []string
+	logLevel        string
+	logFormat       string
+	quiet           bool
+	replayFile      string
+	replayRealtime  bool
+	corsOriginList  string
+	corsOrigins     []string
+	basePath        string
+	enableDebug     bool
+	policyFile      string
 }
 
 func main() {
@@ -56,12 +107,49 @@ func parseConfigFrom(fs *flag.FlagSet, args []string, getenv envLookup) (config,
 	fs.StringVar(&cfg.listen, "listen", envOrLookup(getenv, "WMUX_LISTEN", "127.0.0.1:8080"), "HTTP listen address")
 	fs.StringVar(&cfg.targetSession, "target-session", envOrLookup(getenv, "WMUX_TARGET_SESSION", "webui"), "tmux session to serve")
 	fs.StringVar(&cfg.staticDir, "static-dir", envOrLookup(getenv, "WMUX_STATIC_DIR", ""), "optional static assets directory")
+	fs.StringVar(&cfg.recordDir, "record-dir", envOrLookup(getenv, "WMUX_RECORD_DIR", ""), "directory for pane recordings (.cast); recording is disabled when empty")
 	fs.StringVar(&cfg.tmuxBin, "tmux-bin", envOrLookup(getenv, "WMUX_TMUX_BIN", "tmux"), "path to tmux binary")
 	fs.StringVar(&cfg.tmuxSocketName, "tmux-socket-name", envOrLookup(getenv, "WMUX_TMUX_SOCKET_NAME", ""), "tmux socket name (maps to tmux -L)")
 	fs.StringVar(&cfg.tmuxSocketPath, "tmux-socket-path", envOrLookup(getenv, "WMUX_TMUX_SOCKET_PATH", ""), "tmux socket path (maps to tmux -S)")
-	fs.StringVar(&cfg.term, "term", envOrLookup(getenv, "WMUX_TERM", "ghostty"), "default terminal renderer for generated pane links (ghostty or xterm)")
+	fs.StringVar(&cfg.tmuxConf, "tmux-conf", envOrLookup(getenv, "WMUX_TMUX_CONF", ""), "tmux config file used when wmux starts the tmux server (maps to tmux -f)")
+	fs.StringVar(&cfg.term, "term", envOrLookup(getenv, "WMUX_TERM", "ghostty"), "default terminal renderer for generated pane links ("+assets.TerminalRendererList()+")")
 	fs.DurationVar(&cfg.restartBackoff, "restart-backoff", durationEnvOrLookup(getenv, "WMUX_RESTART_BACKOFF", 500*time.Millisecond), "restart backoff base")
 	fs.DurationVar(&cfg.restartMax, "restart-max-backoff", durationEnvOrLookup(getenv, "WMUX_RESTART_MAX_BACKOFF", 10*time.Second), "restart backoff max")
+	fs.BoolVar(&cfg.noCreate, "no-create-session", boolEnvOrLookup(getenv, "WMUX_NO_CREATE_SESSION", false), "fail instead of creating the target session when it is missing")
+	fs.StringVar(&cfg.initialCmd, "initial-cmd", envOrLookup(getenv, "WMUX_INITIAL_CMD", ""), "command (argv split on whitespace) for the first pane when wmux creates the target session (default: shell)")
+	fs.StringVar(&cfg.initialWinList, "initial-windows", envOrLookup(getenv, "WMUX_INITIAL_WINDOWS", ""), "comma-separated names of extra windows to open when wmux creates the target session")
+	fs.IntVar(&cfg.clientBuffer, "client-buffer", intEnvOrLookup(getenv, "WMUX_CLIENT_BUFFER", wshub.DefaultClientBuffer), "outbound WebSocket messages buffered per client before it is dropped")
+	fs.BoolVar(&cfg.flowControl, "flow-control", boolEnvOrLookup(getenv, "WMUX_FLOW_CONTROL", false), "pause a pane's output in tmux while a client receiving it is backed up, instead of dropping the client")
+	fs.IntVar(&cfg.wsMaxMessage, "ws-max-message", intEnvOrLookup(getenv, "WMUX_WS_MAX_MESSAGE", wshub.DefaultMaxMessageBytes), "maximum inbound WebSocket message size in bytes")
+	fs.IntVar(&cfg.tmuxMaxLine, "tmux-max-line", intEnvOrLookup(getenv, "WMUX_TMUX_MAX_LINE", tmuxproc.DefaultMaxLineBytes), "longest tmux control-mode output line in bytes; longer lines are dropped with a warning")
+	fs.IntVar(&cfg.maxOutputChunk, "max-output-chunk", intEnvOrLookup(getenv, "WMUX_MAX_OUTPUT_CHUNK", 0), "split pane_output messages whose data exceeds this many bytes (0 = unlimited)")
+	fs.IntVar(&cfg.maxCapture, "max-capture-bytes", intEnvOrLookup(getenv, "WMUX_MAX_CAPTURE_BYTES", wshub.DefaultMaxCaptureBytes), "largest pane capture returned by the HTTP API, in bytes")
+	fs.StringVar(&cfg.captureOverflow, "capture-overflow", envOrLookup(getenv, "WMUX_CAPTURE_OVERFLOW", wshub.CaptureTruncate), "what to do with a capture over --max-capture-bytes: truncate (with a marker line) or error (413)")
+	fs.IntVar(&cfg.maxClients, "max-clients", intEnvOrLookup(getenv, "WMUX_MAX_CLIENTS", 0), "maximum concurrent WebSocket clients; further connections get 503 (0 = unlimited)")
+	fs.DurationVar(&cfg.wsMaxAge, "ws-max-age", durationEnvOrLookup(getenv, "WMUX_WS_MAX_AGE", 0), "close WebSocket connections with 1012 after this long so clients reconnect (0 = never)")
+	fs.BoolVar(&cfg.killOrphans, "kill-orphaned-panes", boolEnvOrLookup(getenv, "WMUX_KILL_ORPHANED_PANES", false), "kill panes whose creation response arrives after the create request timed out")
+	fs.DurationVar(&cfg.resyncDebounce, "resync-debounce", durationEnvOrLookup(getenv, "WMUX_RESYNC_DEBOUNCE", wshub.DefaultResyncDebounce), "minimum interval between automatic tmux state resyncs")
+	fs.DurationVar(&cfg.createTimeout, "create-timeout", durationEnvOrLookup(getenv, "WMUX_CREATE_TIMEOUT", httpd.DefaultCreatePaneTimeout), "maximum time a create-pane API request waits before returning 504")
+	fs.IntVar(&cfg.maxCreateEnv, "max-create-env", intEnvOrLookup(getenv, "WMUX_MAX_CREATE_ENV", httpd.DefaultMaxCreateEnv), "most env entries a create-pane API request may set")
+	fs.IntVar(&cfg.maxCreateEnvVal, "max-create-env-value", intEnvOrLookup(getenv, "WMUX_MAX_CREATE_ENV_VALUE", httpd.DefaultMaxCreateEnvValue), "largest env value a create-pane API request may set, in bytes")
+	fs.DurationVar(&cfg.readHdrTimeout, "read-header-timeout", durationEnvOrLookup(getenv, "WMUX_READ_HEADER_TIMEOUT", 10*time.Second), "maximum time to read an HTTP request's headers (0 = no limit)")
+	fs.DurationVar(&cfg.readTimeout, "read-timeout", durationEnvOrLookup(getenv, "WMUX_READ_TIMEOUT", time.Minute), "maximum time to read an HTTP request, including its body (0 = no limit)")
+	fs.DurationVar(&cfg.writeTimeout, "write-timeout", durationEnvOrLookup(getenv, "WMUX_WRITE_TIMEOUT", time.Minute), "maximum time to write an HTTP response; WebSockets, /api/output and pane creation are exempt (0 = no limit)")
+	fs.DurationVar(&cfg.idleTimeout, "idle-timeout", durationEnvOrLookup(getenv, "WMUX_IDLE_TIMEOUT", 2*time.Minute), "how long an idle keep-alive HTTP connection stays open (0 = no limit)")
+	fs.StringVar(&cfg.imageFont, "image-font", envOrLookup(getenv, "WMUX_IMAGE_FONT", ""), "BDF font file for pane PNG snapshots (default: builtin 5x7 font)")
+	fs.StringVar(&cfg.imageCell, "image-cell", envOrLookup(getenv, "WMUX_IMAGE_CELL", ""), "pixel size of one cell in pane PNG snapshots, as WxH (default: twice the font cell)")
+	fs.StringVar(&cfg.settableOpts, "settable-options", envOrLookup(getenv, "WMUX_SETTABLE_OPTIONS", policy.DefaultSettableOptions), "comma-separated tmux options POST /api/options may set (supported: "+strings.Join(policy.SupportedOptions(), ", ")+"; empty = none)")
+	fs.StringVar(&cfg.sentinel, "sentinel", envOrLookup(getenv, "WMUX_SENTINEL", ""), "marker prefix for wmux's own tmux format output (default: random per run)")
+	fs.StringVar(&cfg.extraFieldList, "extra-pane-fields", envOrLookup(getenv, "WMUX_EXTRA_PANE_FIELDS", ""), "comma-separated tmux format variables (e.g. pane_tty,pane_current_path) reported in each pane's extra map")
+	fs.StringVar(&cfg.logLevel, "log-level", envOrLookup(getenv, "WMUX_LOG_LEVEL", "info"), "log level: debug, info, warn, or error")
+	fs.StringVar(&cfg.logFormat, "log-format", envOrLookup(getenv, "WMUX_LOG_FORMAT", "text"), "log output format: text or json")
+	fs.StringVar(&cfg.policyFile, "policy-file", envOrLookup(getenv, "WMUX_POLICY_FILE", ""), "file listing the tmux commands clients may run, one per line; reloaded on SIGHUP (default: built-in list)")
+	fs.StringVar(&cfg.corsOriginList, "cors-origins", envOrLookup(getenv, "WMUX_CORS_ORIGINS", ""), "comma-separated origins (e.g. https://tools.example.com) allowed to call /api/ from a browser; empty disables CORS")
+	fs.StringVar(&cfg.basePath, "base-path", envOrLookup(getenv, "WMUX_BASE_PATH", ""), "path prefix (e.g. /wmux) to serve every route under, for reverse proxies that mount wmux on a subpath")
+	fs.BoolVar(&cfg.enableDebug, "enable-debug", boolEnvOrLookup(getenv, "WMUX_ENABLE_DEBUG", false), "serve GET /ws/raw, which streams raw tmux control-mode output and sends client lines to tmux unchecked by the command policy, and the /api/debug/parse-errors, /api/debug/pending, /api/debug/output-latency and /api/debug/parser-queue endpoints")
+	fs.StringVar(&cfg.replayFile, "replay-file", envOrLookup(getenv, "WMUX_REPLAY_FILE", ""), "feed a captured tmux control-mode transcript to the hub instead of running tmux (for debugging)")
+	fs.BoolVar(&cfg.replayRealtime, "replay-realtime", boolEnvOrLookup(getenv, "WMUX_REPLAY_REALTIME", false), "honor \"#t <seconds>\" timing lines in --replay-file instead of replaying as fast as possible")
+	fs.BoolVar(&cfg.quiet, "quiet", boolEnvOrLookup(getenv, "WMUX_QUIET", false), "log errors only, overriding --log-level")
 	if err := fs.Parse(args); err != nil {
 		return config{}, err
 	}
@@ -80,66 +168,351 @@ func normalizeAndValidateConfig(cfg config) (config, error) {
 	if err := socket.Validate(); err != nil {
 		return cfg, errors.New("--tmux-socket-name and --tmux-socket-path are mutually exclusive")
 	}
+	cfg.tmuxConf = strings.TrimSpace(cfg.tmuxConf)
+	if cfg.tmuxConf != "" {
+		if _, err := os.Stat(cfg.tmuxConf); err != nil {
+			return cfg, fmt.Errorf("--tmux-conf: %w", err)
+		}
+	}
+
+	cfg.initialCmd = strings.TrimSpace(cfg.initialCmd)
+	if cfg.initialCmd != "" {
+		if cfg.noCreate {
+			return cfg, errors.New("--initial-cmd cannot be used with --no-create-session")
+		}
+		argv, err := parseInitialCommand(cfg.initialCmd)
+		if err != nil {
+			return cfg, fmt.Errorf("--initial-cmd: %w", err)
+		}
+		cfg.initialShell = wshub.JoinShellCommand(argv)
+	}
+	initialWindows, err := parseInitialWindows(cfg.initialWinList)
+	if err != nil {
+		return cfg, fmt.Errorf("--initial-windows: %w", err)
+	}
+	if len(initialWindows) > 0 && cfg.noCreate {
+		return cfg, errors.New("--initial-windows cannot be used with --no-create-session")
+	}
+	cfg.initialWindows = initialWindows
 
 	cfg.term = normalizeDefaultTerm(cfg.term)
 	if cfg.term == "" {
-		return cfg, errors.New("--term must be one of: ghostty, xterm")
+		return cfg, errors.New("--term must be one of: " + assets.TerminalRendererList())
+	}
+
+	cfg.listen = strings.TrimSpace(cfg.listen)
+	if cfg.listen != "" {
+		if err := validateListenAddr(cfg.listen); err != nil {
+			return cfg, err
+		}
+	}
+
+	if cfg.clientBuffer == 0 {
+		cfg.clientBuffer = wshub.DefaultClientBuffer
+	}
+	if cfg.clientBuffer < 0 {
+		return cfg, errors.New("--client-buffer must be positive")
+	}
+	if cfg.wsMaxMessage == 0 {
+		cfg.wsMaxMessage = wshub.DefaultMaxMessageBytes
+	}
+	if cfg.wsMaxMessage < 0 {
+		return cfg, errors.New("--ws-max-message must be positive")
+	}
+	if cfg.tmuxMaxLine == 0 {
+		cfg.tmuxMaxLine = tmuxproc.DefaultMaxLineBytes
+	}
+	if cfg.tmuxMaxLine < 0 {
+		return cfg, errors.New("--tmux-max-line must be positive")
+	}
+	if cfg.maxOutputChunk != 0 && cfg.maxOutputChunk < wshub.MinOutputChunk {
+		return cfg, fmt.Errorf("--max-output-chunk must be 0 or at least %d", wshub.MinOutputChunk)
+	}
+	if cfg.maxCapture == 0 {
+		cfg.maxCapture = wshub.DefaultMaxCaptureBytes
+	}
+	if cfg.maxCapture < 0 {
+		return cfg, errors.New("--max-capture-bytes must be positive")
+	}
+	cfg.captureOverflow = strings.ToLower(strings.TrimSpace(cfg.captureOverflow))
+	switch cfg.captureOverflow {
+	case "":
+		cfg.captureOverflow = wshub.CaptureTruncate
+	case wshub.CaptureTruncate, wshub.CaptureError:
+	default:
+		return cfg, fmt.Errorf("--capture-overflow must be %s or %s", wshub.CaptureTruncate, wshub.CaptureError)
+	}
+	if cfg.maxClients < 0 {
+		return cfg, errors.New("--max-clients cannot be negative")
+	}
+	if cfg.wsMaxAge < 0 {
+		return cfg, errors.New("--ws-max-age cannot be negative")
+	}
+
+	if cfg.createTimeout == 0 {
+		cfg.createTimeout = httpd.DefaultCreatePaneTimeout
+	}
+	if cfg.createTimeout < 0 {
+		return cfg, errors.New("--create-timeout must be positive")
+	}
+	if cfg.maxCreateEnv < 0 {
+		return cfg, errors.New("--max-create-env cannot be negative")
+	}
+	if cfg.maxCreateEnvVal < 0 {
+		return cfg, errors.New("--max-create-env-value cannot be negative")
+	}
+	for _, timeout := range []struct {
+		flag string
+		d    time.Duration
+	}{
+		{"--read-header-timeout", cfg.readHdrTimeout},
+		{"--read-timeout", cfg.readTimeout},
+		{"--write-timeout", cfg.writeTimeout},
+		{"--idle-timeout", cfg.idleTimeout},
+	} {
+		if timeout.d < 0 {
+			return cfg, fmt.Errorf("%s cannot be negative", timeout.flag)
+		}
+	}
+
+	cfg.imageFont = strings.TrimSpace(cfg.imageFont)
+	cfg.imageCell = strings.TrimSpace(cfg.imageCell)
+	if cfg.imageCell != "" {
+		w, h, err := parseCellSize(cfg.imageCell)
+		if err != nil {
+			return cfg, fmt.Errorf("--image-cell: %w", err)
+		}
+		cfg.imageCellW, cfg.imageCellH = w, h
+	}
+
+	optionPolicy, err := policy.ParseOptionPolicy(cfg.settableOpts)
+	if err != nil {
+		return cfg, fmt.Errorf("--settable-options: %w", err)
+	}
+	cfg.optionPolicy = optionPolicy
+
+	cfg.replayFile = strings.TrimSpace(cfg.replayFile)
+	if cfg.replayRealtime && cfg.replayFile == "" {
+		return cfg, errors.New("--replay-realtime requires --replay-file")
+	}
+
+	cfg.sentinel = strings.TrimSpace(cfg.sentinel)
+	if cfg.sentinel == "" && cfg.replayFile != "" {
+		// A transcript carries the sentinel of the run that captured it;
+		// a random one would match none of its model rows.
+		cfg.sentinel = wshub.DefaultSentinel
+	}
+	if cfg.sentinel == "" {
+		cfg.sentinel = wshub.RandomSentinel()
+	}
+	if err := wshub.ValidateSentinel(cfg.sentinel); err != nil {
+		return cfg, fmt.Errorf("--sentinel: %w", err)
+	}
+
+	extraPaneFields, err := wshub.ParseExtraPaneFields(cfg.extraFieldList)
+	if err != nil {
+		return cfg, fmt.Errorf("--extra-pane-fields: %w", err)
+	}
+	cfg.extraPaneFields = extraPaneFields
+
+	corsOrigins, err := httpd.ParseCORSOrigins(cfg.corsOriginList)
+	if err != nil {
+		return cfg, fmt.Errorf("--cors-origins: %w", err)
+	}
+	cfg.corsOrigins = corsOrigins
+
+	basePath, err := httpd.ParseBasePath(cfg.basePath)
+	if err != nil {
+		return cfg, fmt.Errorf("--base-path: %w", err)
+	}
+	cfg.basePath = basePath
+
+	cfg.logLevel = strings.ToLower(strings.TrimSpace(cfg.logLevel))
+	if cfg.logLevel == "" {
+		cfg.logLevel = "info"
+	}
+	if _, err := parseLogLevel(cfg.logLevel); err != nil {
+		return cfg, err
+	}
+	if cfg.quiet {
+		cfg.logLevel = "error"
+	}
+	cfg.logFormat = strings.ToLower(strings.TrimSpace(cfg.logFormat))
+	if cfg.logFormat == "" {
+		cfg.logFormat = "text"
+	}
+	if cfg.logFormat != "text" && cfg.logFormat != "json" {
+		return cfg, fmt.Errorf("invalid --log-format %q (allowed: text, json)", cfg.logFormat)
 	}
 
 	return cfg, nil
 }
 
 func run(cfg config) error {
+	startedAt := time.Now()
 	var err error
 	cfg, err = normalizeAndValidateConfig(cfg)
 	if err != nil {
 		return err
 	}
 
-	socket := tmuxproc.SocketTarget{Name: cfg.tmuxSocketName, Path: cfg.tmuxSocketPath}
-	autoCreateSession := len(socket.Args()) == 0
-
-	if err := tmuxproc.CheckTmux(cfg.tmuxBin, socket); err != nil {
+	logger, err := newLogger(os.Stderr, cfg.logLevel, cfg.logFormat)
+	if err != nil {
 		return err
 	}
-	if autoCreateSession {
-		if err := tmuxproc.EnsureSession(cfg.tmuxBin, socket, cfg.targetSession); err != nil {
-			log.Printf("wmux: initial ensure target session failed: %v", err)
+	slog.SetDefault(logger)
+
+	var imageFont *termimg.Font
+	if cfg.imageFont != "" {
+		if imageFont, err = loadImageFont(cfg.imageFont); err != nil {
+			return fmt.Errorf("--image-font: %w", err)
 		}
 	}
 
-	hub := wshub.New(policy.Default(), cfg.targetSession)
-	manager := tmuxproc.NewManager(tmuxproc.Config{
-		TmuxBin:           cfg.tmuxBin,
-		TargetSession:     cfg.targetSession,
-		Socket:            socket,
-		AutoCreateSession: autoCreateSession,
-		BackoffBase:       cfg.restartBackoff,
-		BackoffMax:        cfg.restartMax,
-		OnStdoutLine:      hub.BroadcastTmuxStdoutLine,
-		OnStderrLine:      hub.BroadcastTmuxStderrLine,
-		OnConnected:       hub.BroadcastConnected,
-		OnDisconnect:      hub.BroadcastDisconnected,
-	})
-	if err := hub.BindTmux(manager); err != nil {
+	// Take over a socket-activated listener before starting any tmux
+	// process, so none of them inherits the socket or its LISTEN_* vars.
+	listenAddr := cfg.listen
+	ln, err := systemdListener()
+	if err != nil {
 		return err
 	}
+	if ln != nil {
+		listenAddr = "systemd:" + ln.Addr().String()
+	}
+
+	socket := tmuxproc.SocketTarget{Name: cfg.tmuxSocketName, Path: cfg.tmuxSocketPath, ConfigFile: cfg.tmuxConf}
+	autoCreateSession := len(socket.Args()) == 0 && !cfg.noCreate
+
+	var replayFile *os.File
+	if cfg.replayFile != "" {
+		if replayFile, err = os.Open(cfg.replayFile); err != nil {
+			return fmt.Errorf("--replay-file: %w", err)
+		}
+	} else {
+		if err := tmuxproc.CheckTmux(cfg.tmuxBin, socket); err != nil {
+			return err
+		}
+		if cfg.noCreate {
+			if !tmuxproc.SessionExists(cfg.tmuxBin, socket, cfg.targetSession) {
+				return fmt.Errorf("target session %q does not exist (--no-create-session)", cfg.targetSession)
+			}
+		} else if autoCreateSession {
+			if err := tmuxproc.EnsureSession(cfg.tmuxBin, socket, cfg.targetSession, cfg.initialShell, cfg.initialWindows); err != nil {
+				logger.Warn("initial ensure target session failed", "session", cfg.targetSession, "err", err)
+			}
+		}
+	}
+
+	cmdPolicy := policy.Default()
+	if cfg.policyFile != "" {
+		if cmdPolicy, err = policy.LoadFile(cfg.policyFile); err != nil {
+			return fmt.Errorf("--policy-file: %w", err)
+		}
+	}
+
+	hub := wshub.NewWithOptions(cmdPolicy, cfg.targetSession, wshub.Options{
+		KillOrphanedPanes: cfg.killOrphans,
+		ResyncDebounce:    cfg.resyncDebounce,
+		Sentinel:          cfg.sentinel,
+		ExtraPaneFields:   cfg.extraPaneFields,
+		MaxOutputChunk:    cfg.maxOutputChunk,
+		MaxCaptureBytes:   cfg.maxCapture,
+		CaptureOverflow:   cfg.captureOverflow,
+		FlowControl:       cfg.flowControl,
+		Logger:            logger,
+	})
 
 	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
 	defer cancel()
 
-	go manager.Run(ctx)
+	if cfg.policyFile != "" {
+		go reloadPolicyOnHUP(ctx, cfg.policyFile, hub, logger)
+	}
+
+	var tmuxConnectedSince func() (time.Time, bool)
+	if replayFile != nil {
+		if err := hub.BindTmux(replaySender{logger: logger}); err != nil {
+			return err
+		}
+		replayStarted := time.Now()
+		tmuxConnectedSince = func() (time.Time, bool) { return replayStarted, true }
+		go func() {
+			defer replayFile.Close()
+			hub.BroadcastConnectedNoSync()
+			if err := replayTranscript(ctx, replayFile, cfg.replayRealtime, hub.BroadcastTmuxStdoutLine); err != nil && ctx.Err() == nil {
+				logger.Error("replay failed", "file", cfg.replayFile, "err", err)
+				return
+			}
+			logger.Info("replay finished; still serving the replayed state", "file", cfg.replayFile)
+		}()
+	} else {
+		manager := tmuxproc.NewManager(tmuxproc.Config{
+			TmuxBin:           cfg.tmuxBin,
+			TargetSession:     cfg.targetSession,
+			Socket:            socket,
+			AutoCreateSession: autoCreateSession,
+			InitialCommand:    cfg.initialShell,
+			InitialWindows:    cfg.initialWindows,
+			BackoffBase:       cfg.restartBackoff,
+			BackoffMax:        cfg.restartMax,
+			OnStdoutLine:      hub.BroadcastTmuxStdoutLine,
+			OnStderrLine:      hub.BroadcastTmuxStderrLine,
+			OnConnected:       hub.BroadcastConnected,
+			OnDisconnect:      hub.BroadcastDisconnected,
+			OnReconnecting:    hub.BroadcastReconnecting,
+			MaxLineBytes:      cfg.tmuxMaxLine,
+			Logger:            logger,
+		})
+		if err := hub.BindTmux(manager); err != nil {
+			return err
+		}
+		tmuxConnectedSince = manager.ConnectedSince
+		go manager.Run(ctx)
+	}
 
 	handler, err := httpd.NewServer(httpd.Config{
-		StaticDir:   cfg.staticDir,
-		Hub:         hub,
-		DefaultTerm: cfg.term,
+		StaticDir:          cfg.staticDir,
+		RecordDir:          cfg.recordDir,
+		Hub:                hub,
+		DefaultTerm:        cfg.term,
+		ClientBuffer:       cfg.clientBuffer,
+		MaxMessageBytes:    int64(cfg.wsMaxMessage),
+		MaxClients:         cfg.maxClients,
+		WSMaxAge:           cfg.wsMaxAge,
+		CreatePaneTimeout:  cfg.createTimeout,
+		MaxCreateEnv:       cfg.maxCreateEnv,
+		MaxCreateEnvValue:  cfg.maxCreateEnvVal,
+		ImageFont:          imageFont,
+		ImageCellWidth:     cfg.imageCellW,
+		ImageCellHeight:    cfg.imageCellH,
+		SettableOptions:    cfg.optionPolicy,
+		Logger:             logger,
+		StartedAt:          startedAt,
+		TmuxConnectedSince: tmuxConnectedSince,
+		CORSOrigins:        cfg.corsOrigins,
+		BasePath:           cfg.basePath,
+		EnableRawWS:        cfg.enableDebug,
+		EnableDebug:        cfg.enableDebug,
 	})
 	if err != nil {
 		return err
 	}
 
-	srv := &http.Server{Addr: cfg.listen, Handler: handler}
+	if ln == nil {
+		var cleanup func()
+		if ln, cleanup, err = listen(cfg.listen); err != nil {
+			return err
+		}
+		defer cleanup()
+	}
+
+	srv := &http.Server{
+		Handler:           handler,
+		ReadHeaderTimeout: cfg.readHdrTimeout,
+		ReadTimeout:       cfg.readTimeout,
+		WriteTimeout:      cfg.writeTimeout,
+		IdleTimeout:       cfg.idleTimeout,
+	}
 	go func() {
 		<-ctx.Done()
 		shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), 4*time.Second)
@@ -147,14 +520,181 @@ func run(cfg config) error {
 		_ = srv.Shutdown(shutdownCtx)
 	}()
 
-	log.Printf("wmux listening on %s target-session=%s socket=%s", cfg.listen, cfg.targetSession, describeSocket(socket))
-	err = srv.ListenAndServe()
+	if replayFile != nil {
+		logger.Info("wmux listening", "addr", listenAddr, "target_session", cfg.targetSession, "replay_file", cfg.replayFile)
+	} else {
+		logger.Info("wmux listening", "addr", listenAddr, "target_session", cfg.targetSession, "socket", describeSocket(socket))
+	}
+	if cfg.enableDebug {
+		logger.Warn("debug enabled: /ws/raw sends client lines to tmux unchecked by the command policy")
+	}
+	err = srv.Serve(ln)
 	if err != nil && !errors.Is(err, http.ErrServerClosed) {
 		return err
 	}
 	return nil
 }
 
+func parseLogLevel(s string) (slog.Level, error) {
+	switch s {
+	case "debug":
+		return slog.LevelDebug, nil
+	case "info":
+		return slog.LevelInfo, nil
+	case "warn":
+		return slog.LevelWarn, nil
+	case "error":
+		return slog.LevelError, nil
+	}
+	return 0, fmt.Errorf("invalid --log-level %q (allowed: debug, info, warn, error)", s)
+}
+
+func newLogger(w io.Writer, level, format string) (*slog.Logger, error) {
+	lvl, err := parseLogLevel(level)
+	if err != nil {
+		return nil, err
+	}
+	opts := &slog.HandlerOptions{Level: lvl}
+	if format == "json" {
+		return slog.New(slog.NewJSONHandler(w, opts)), nil
+	}
+	return slog.New(slog.NewTextHandler(w, opts)), nil
+}
+
+// parseCellSize parses a "WxH" pixel size such as "12x24".
+func parseCellSize(s string) (int, int, error) {
+	ws, hs, ok := strings.Cut(strings.ToLower(s), "x")
+	if !ok {
+		return 0, 0, fmt.Errorf("invalid size %q (want WxH)", s)
+	}
+	w, errW := strconv.Atoi(ws)
+	h, errH := strconv.Atoi(hs)
+	if errW != nil || errH != nil || w <= 0 || h <= 0 || w > 256 || h > 256 {
+		return 0, 0, fmt.Errorf("invalid size %q (want WxH, each 1-256)", s)
+	}
+	return w, h, nil
+}
+
+// parseInitialCommand splits s on whitespace into an argv. Control
+// characters are rejected since the command ends up in a tmux argument.
+func parseInitialCommand(s string) ([]string, error) {
+	for _, r := range s {
+		if unicode.IsControl(r) && r != '\t' {
+			return nil, fmt.Errorf("command cannot contain control characters")
+		}
+	}
+	argv := strings.Fields(s)
+	if len(argv) == 0 {
+		return nil, fmt.Errorf("command cannot be empty")
+	}
+	return argv, nil
+}
+
+// maxInitialWindowName matches the window name limit of
+// POST /api/windows/{id}/rename.
+const maxInitialWindowName = 256
+
+// parseInitialWindows splits a comma-separated list of window names. Names
+// are trimmed and must be unique; they cannot contain control characters or
+// the tmux target separators ':' and '.', so each can later be used as a
+// window target.
+func parseInitialWindows(s string) ([]string, error) {
+	if strings.TrimSpace(s) == "" {
+		return nil, nil
+	}
+	var names []string
+	seen := make(map[string]bool)
+	for _, part := range strings.Split(s, ",") {
+		name := strings.TrimSpace(part)
+		switch {
+		case name == "":
+			return nil, errors.New("window names cannot be empty")
+		case len(name) > maxInitialWindowName:
+			return nil, fmt.Errorf("window name %q exceeds %d bytes", name, maxInitialWindowName)
+		case strings.ContainsAny(name, ":."):
+			return nil, fmt.Errorf("window name %q cannot contain ':' or '.'", name)
+		case strings.ContainsFunc(name, unicode.IsControl):
+			return nil, fmt.Errorf("window name %q cannot contain control characters", name)
+		case seen[name]:
+			return nil, fmt.Errorf("duplicate window name %q", name)
+		}
+		seen[name] = true
+		names = append(names, name)
+	}
+	return names, nil
+}
+
+func loadImageFont(path string) (*termimg.Font, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return termimg.ParseBDF(f)
+}
+
+const unixListenPrefix = "unix:"
+
+func validateListenAddr(addr string) error {
+	if path, ok := strings.CutPrefix(addr, unixListenPrefix); ok {
+		if strings.TrimSpace(path) == "" {
+			return errors.New("--listen unix: address requires a socket path")
+		}
+		return nil
+	}
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		return fmt.Errorf("--listen %q is not host:port (wrap IPv6 hosts in brackets, e.g. [::1]:8080, or use unix:/path): %v", addr, err)
+	}
+	return nil
+}
+
+// listen opens a TCP listener for host:port addresses or a unix socket for
+// "unix:/path" addresses. The returned cleanup removes the socket file.
+func listen(addr string) (net.Listener, func(), error) {
+	path, ok := strings.CutPrefix(addr, unixListenPrefix)
+	if !ok {
+		if addr == "" {
+			addr = ":http"
+		}
+		ln, err := net.Listen("tcp", addr)
+		return ln, func() {}, err
+	}
+	if info, err := os.Lstat(path); err == nil && info.Mode()&os.ModeSocket != 0 {
+		_ = os.Remove(path)
+	}
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, nil, err
+	}
+	return ln, func() { _ = os.Remove(path) }, nil
+}
+
+// reloadPolicyOnHUP re-reads the policy file into hub on each SIGHUP until
+// ctx ends. A file that fails to load leaves the current policy in place.
+func reloadPolicyOnHUP(ctx context.Context, path string, hub *wshub.Hub, logger *slog.Logger) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+			reloadPolicy(path, hub, logger)
+		}
+	}
+}
+
+func reloadPolicy(path string, hub *wshub.Hub, logger *slog.Logger) {
+	p, err := policy.LoadFile(path)
+	if err != nil {
+		logger.Error("policy reload failed; keeping the current policy", "file", path, "err", err)
+		return
+	}
+	hub.SetPolicy(p)
+	logger.Info("policy reloaded", "file", path, "allowed", strings.Join(p.Allowed(), ","))
+}
+
 func describeSocket(socket tmuxproc.SocketTarget) string {
 	if socket.Name != "" {
 		return fmt.Sprintf("name:%s", socket.Name)
@@ -167,7 +707,7 @@ func describeSocket(socket tmuxproc.SocketTarget) string {
 
 func normalizeDefaultTerm(raw string) string {
 	v := strings.ToLower(strings.TrimSpace(raw))
-	if v == "ghostty" || v == "xterm" {
+	if assets.IsTerminalRenderer(v) {
 		return v
 	}
 	return ""
@@ -188,6 +728,30 @@ func durationEnvOr(name string, fallback time.Duration) time.Duration {
 	return durationEnvOrLookup(os.Getenv, name, fallback)
 }
 
+func intEnvOrLookup(getenv envLookup, name string, fallback int) int {
+	raw := strings.TrimSpace(getenv(name))
+	if raw == "" {
+		return fallback
+	}
+	v, err := strconv.Atoi(raw)
+	if err != nil {
+		return fallback
+	}
+	return v
+}
+
+func boolEnvOrLookup(getenv envLookup, name string, fallback bool) bool {
+	raw := strings.TrimSpace(getenv(name))
+	if raw == "" {
+		return fallback
+	}
+	v, err := strconv.ParseBool(raw)
+	if err != nil {
+		return fallback
+	}
+	return v
+}
+
 func durationEnvOrLookup(getenv envLookup, name string, fallback time.Duration) time.Duration {
 	raw := strings.TrimSpace(getenv(name))
 	if raw == "" {
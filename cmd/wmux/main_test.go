@@ -1,9 +1,18 @@
 package main
 
 import (
+	"bytes"
 	"flag"
 	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
+
+	"github.com/ampcode/wmux/internal/policy"
+	"github.com/ampcode/wmux/internal/wshub"
 )
 
 func TestParseConfigFromParsesSocketNameFlag(t *testing.T) {
@@ -62,3 +71,368 @@ func TestNormalizeAndValidateConfigAllowsDefaultSocket(t *testing.T) {
 		t.Fatalf("expected empty socket targeting in default mode: %#v", cfg)
 	}
 }
+
+func TestParseConfigFromReadsNoCreateSession(t *testing.T) {
+	fs := flag.NewFlagSet("wmux-test", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+
+	cfg, err := parseConfigFrom(fs, []string{"--no-create-session"}, func(string) string { return "" })
+	if err != nil {
+		t.Fatalf("parseConfigFrom: %v", err)
+	}
+	if !cfg.noCreate {
+		t.Fatalf("noCreate = false, want true")
+	}
+
+	fs = flag.NewFlagSet("wmux-test", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	env := map[string]string{"WMUX_NO_CREATE_SESSION": "true"}
+	cfg, err = parseConfigFrom(fs, nil, func(name string) string { return env[name] })
+	if err != nil {
+		t.Fatalf("parseConfigFrom: %v", err)
+	}
+	if !cfg.noCreate {
+		t.Fatalf("noCreate from env = false, want true")
+	}
+}
+
+func TestParseConfigFromReadsClientBuffer(t *testing.T) {
+	fs := flag.NewFlagSet("wmux-test", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+
+	cfg, err := parseConfigFrom(fs, []string{"--client-buffer", "1024"}, func(string) string { return "" })
+	if err != nil {
+		t.Fatalf("parseConfigFrom: %v", err)
+	}
+	if cfg.clientBuffer != 1024 {
+		t.Fatalf("clientBuffer = %d, want %d", cfg.clientBuffer, 1024)
+	}
+}
+
+func TestNormalizeAndValidateConfigRejectsNegativeClientBuffer(t *testing.T) {
+	_, err := normalizeAndValidateConfig(config{
+		targetSession: "dev",
+		term:          "ghostty",
+		clientBuffer:  -1,
+	})
+	if err == nil {
+		t.Fatalf("expected client buffer validation error")
+	}
+}
+
+func TestParseConfigWSMaxAgeFromEnv(t *testing.T) {
+	fs := flag.NewFlagSet("wmux-test", flag.ContinueOnError)
+	cfg, err := parseConfigFrom(fs, nil, func(key string) string {
+		if key == "WMUX_WS_MAX_AGE" {
+			return "15m"
+		}
+		return ""
+	})
+	if err != nil {
+		t.Fatalf("parseConfigFrom: %v", err)
+	}
+	if cfg.wsMaxAge != 15*time.Minute {
+		t.Fatalf("wsMaxAge = %v, want 15m", cfg.wsMaxAge)
+	}
+
+	if _, err := normalizeAndValidateConfig(config{targetSession: "dev", term: "ghostty", wsMaxAge: -time.Second}); err == nil {
+		t.Fatalf("expected ws max age validation error")
+	}
+}
+
+func TestNormalizeAndValidateConfigSentinel(t *testing.T) {
+	cfg, err := normalizeAndValidateConfig(config{targetSession: "dev", term: "ghostty"})
+	if err != nil {
+		t.Fatalf("normalizeAndValidateConfig: %v", err)
+	}
+	if cfg.sentinel == "" || cfg.sentinel == wshub.DefaultSentinel {
+		t.Fatalf("sentinel = %q, want a random per-run sentinel", cfg.sentinel)
+	}
+
+	if _, err := normalizeAndValidateConfig(config{targetSession: "dev", term: "ghostty", sentinel: "bad prefix"}); err == nil {
+		t.Fatalf("expected sentinel validation error")
+	}
+}
+
+func TestNormalizeAndValidateConfigExtraPaneFields(t *testing.T) {
+	cfg, err := normalizeAndValidateConfig(config{targetSession: "dev", term: "ghostty", extraFieldList: "pane_tty, pane_current_path"})
+	if err != nil {
+		t.Fatalf("normalizeAndValidateConfig: %v", err)
+	}
+	if strings.Join(cfg.extraPaneFields, ",") != "pane_tty,pane_current_path" {
+		t.Fatalf("extraPaneFields = %q", cfg.extraPaneFields)
+	}
+
+	if _, err := normalizeAndValidateConfig(config{targetSession: "dev", term: "ghostty", extraFieldList: "#{pane_tty}"}); err == nil {
+		t.Fatalf("expected extra pane field validation error")
+	}
+}
+
+func TestNormalizeAndValidateConfigLogging(t *testing.T) {
+	cfg, err := normalizeAndValidateConfig(config{targetSession: "dev", term: "ghostty", logLevel: " DEBUG ", logFormat: "JSON"})
+	if err != nil {
+		t.Fatalf("normalizeAndValidateConfig: %v", err)
+	}
+	if cfg.logLevel != "debug" || cfg.logFormat != "json" {
+		t.Fatalf("log config = %q/%q, want debug/json", cfg.logLevel, cfg.logFormat)
+	}
+
+	if _, err := normalizeAndValidateConfig(config{targetSession: "dev", term: "ghostty", logLevel: "verbose"}); err == nil {
+		t.Fatalf("expected log level validation error")
+	}
+	if _, err := normalizeAndValidateConfig(config{targetSession: "dev", term: "ghostty", logFormat: "xml"}); err == nil {
+		t.Fatalf("expected log format validation error")
+	}
+
+	cfg, err = normalizeAndValidateConfig(config{targetSession: "dev", term: "ghostty", logLevel: "debug", quiet: true})
+	if err != nil {
+		t.Fatalf("normalizeAndValidateConfig quiet: %v", err)
+	}
+	if cfg.logLevel != "error" {
+		t.Fatalf("quiet log level = %q, want error", cfg.logLevel)
+	}
+}
+
+func TestNewLoggerJSONRespectsLevel(t *testing.T) {
+	var buf bytes.Buffer
+	logger, err := newLogger(&buf, "warn", "json")
+	if err != nil {
+		t.Fatalf("newLogger: %v", err)
+	}
+	logger.Info("hidden")
+	logger.Warn("shown", "pane", "%1")
+
+	out := buf.String()
+	if strings.Contains(out, "hidden") {
+		t.Fatalf("info record logged at warn level: %s", out)
+	}
+	if !strings.Contains(out, `"msg":"shown"`) || !strings.Contains(out, `"pane":"%1"`) {
+		t.Fatalf("output = %s, want JSON warn record", out)
+	}
+}
+
+func TestNormalizeAndValidateConfigTmuxConf(t *testing.T) {
+	conf := filepath.Join(t.TempDir(), "wmux.tmux.conf")
+	if err := os.WriteFile(conf, []byte("set -g status off\n"), 0o644); err != nil {
+		t.Fatalf("write conf: %v", err)
+	}
+	cfg, err := normalizeAndValidateConfig(config{targetSession: "dev", term: "ghostty", tmuxConf: " " + conf + " "})
+	if err != nil {
+		t.Fatalf("normalizeAndValidateConfig: %v", err)
+	}
+	if cfg.tmuxConf != conf {
+		t.Fatalf("tmuxConf = %q, want %q", cfg.tmuxConf, conf)
+	}
+
+	if _, err := normalizeAndValidateConfig(config{targetSession: "dev", term: "ghostty", tmuxConf: filepath.Join(t.TempDir(), "missing.conf")}); err == nil {
+		t.Fatalf("expected error for missing tmux conf")
+	}
+}
+
+func TestNormalizeAndValidateConfigImageCell(t *testing.T) {
+	cfg, err := normalizeAndValidateConfig(config{targetSession: "dev", term: "ghostty", imageCell: "12X24"})
+	if err != nil {
+		t.Fatalf("normalizeAndValidateConfig: %v", err)
+	}
+	if cfg.imageCellW != 12 || cfg.imageCellH != 24 {
+		t.Fatalf("image cell = %dx%d, want 12x24", cfg.imageCellW, cfg.imageCellH)
+	}
+
+	for _, bad := range []string{"12", "0x10", "axb", "12x1000"} {
+		if _, err := normalizeAndValidateConfig(config{targetSession: "dev", term: "ghostty", imageCell: bad}); err == nil {
+			t.Fatalf("expected error for --image-cell %q", bad)
+		}
+	}
+}
+
+func TestNormalizeAndValidateConfigInitialCmd(t *testing.T) {
+	cfg, err := normalizeAndValidateConfig(config{targetSession: "dev", term: "ghostty", initialCmd: "  htop  -d 10 "})
+	if err != nil {
+		t.Fatalf("normalizeAndValidateConfig: %v", err)
+	}
+	if cfg.initialShell != "htop -d 10" {
+		t.Fatalf("initial shell command = %q, want %q", cfg.initialShell, "htop -d 10")
+	}
+
+	cfg, err = normalizeAndValidateConfig(config{targetSession: "dev", term: "ghostty", initialCmd: "menu it's"})
+	if err != nil {
+		t.Fatalf("normalizeAndValidateConfig: %v", err)
+	}
+	if want := `menu 'it'\''s'`; cfg.initialShell != want {
+		t.Fatalf("initial shell command = %q, want %q", cfg.initialShell, want)
+	}
+
+	if _, err := normalizeAndValidateConfig(config{targetSession: "dev", term: "ghostty", initialCmd: "htop\x1b"}); err == nil {
+		t.Fatalf("expected error for control characters in --initial-cmd")
+	}
+	if _, err := normalizeAndValidateConfig(config{targetSession: "dev", term: "ghostty", initialCmd: "htop", noCreate: true}); err == nil {
+		t.Fatalf("expected error for --initial-cmd with --no-create-session")
+	}
+}
+
+func TestNormalizeAndValidateConfigInitialWindows(t *testing.T) {
+	cfg, err := normalizeAndValidateConfig(config{targetSession: "dev", term: "ghostty", initialWinList: " logs, build shell ,editor"})
+	if err != nil {
+		t.Fatalf("normalizeAndValidateConfig: %v", err)
+	}
+	if got := strings.Join(cfg.initialWindows, "|"); got != "logs|build shell|editor" {
+		t.Fatalf("initial windows = %q, want logs|build shell|editor", got)
+	}
+
+	for _, list := range []string{"logs,", "logs,logs", "a:b", "v1.2", "tab\x1b"} {
+		if _, err := normalizeAndValidateConfig(config{targetSession: "dev", term: "ghostty", initialWinList: list}); err == nil {
+			t.Fatalf("expected error for --initial-windows %q", list)
+		}
+	}
+	if _, err := normalizeAndValidateConfig(config{targetSession: "dev", term: "ghostty", initialWinList: "logs", noCreate: true}); err == nil {
+		t.Fatalf("expected error for --initial-windows with --no-create-session")
+	}
+}
+
+func TestNormalizeAndValidateConfigBasePath(t *testing.T) {
+	cfg, err := normalizeAndValidateConfig(config{targetSession: "dev", term: "ghostty", basePath: "/tools/wmux/"})
+	if err != nil {
+		t.Fatalf("normalizeAndValidateConfig: %v", err)
+	}
+	if cfg.basePath != "/tools/wmux" {
+		t.Fatalf("base path = %q, want /tools/wmux", cfg.basePath)
+	}
+
+	for _, path := range []string{"wmux", "/a//b", "/a/../b", "/wmux?x=1", "/w mux"} {
+		if _, err := normalizeAndValidateConfig(config{targetSession: "dev", term: "ghostty", basePath: path}); err == nil {
+			t.Fatalf("expected error for --base-path %q", path)
+		}
+	}
+}
+
+func TestParseConfigFromHTTPTimeouts(t *testing.T) {
+	fs := flag.NewFlagSet("wmux-test", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	env := map[string]string{"WMUX_WRITE_TIMEOUT": "0"}
+	cfg, err := parseConfigFrom(fs, []string{"--idle-timeout", "30s"}, func(name string) string { return env[name] })
+	if err != nil {
+		t.Fatalf("parseConfigFrom: %v", err)
+	}
+	if cfg.readHdrTimeout != 10*time.Second || cfg.readTimeout != time.Minute || cfg.writeTimeout != 0 || cfg.idleTimeout != 30*time.Second {
+		t.Fatalf("timeouts = %v/%v/%v/%v, want 10s/1m/0/30s", cfg.readHdrTimeout, cfg.readTimeout, cfg.writeTimeout, cfg.idleTimeout)
+	}
+
+	for _, bad := range []config{
+		{targetSession: "dev", term: "ghostty", readHdrTimeout: -time.Second},
+		{targetSession: "dev", term: "ghostty", writeTimeout: -time.Second},
+	} {
+		if _, err := normalizeAndValidateConfig(bad); err == nil {
+			t.Fatalf("expected error for negative timeout in %+v", bad)
+		}
+	}
+}
+
+func TestNormalizeAndValidateConfigRejectsNegativeEnvCaps(t *testing.T) {
+	for _, bad := range []config{
+		{targetSession: "dev", term: "ghostty", maxCreateEnv: -1},
+		{targetSession: "dev", term: "ghostty", maxCreateEnvVal: -1},
+	} {
+		if _, err := normalizeAndValidateConfig(bad); err == nil {
+			t.Fatalf("expected error for negative env cap in %+v", bad)
+		}
+	}
+}
+
+func TestNormalizeAndValidateConfigSettableOptions(t *testing.T) {
+	cfg, err := normalizeAndValidateConfig(config{targetSession: "dev", term: "ghostty", settableOpts: "mouse,status"})
+	if err != nil {
+		t.Fatalf("normalizeAndValidateConfig: %v", err)
+	}
+	if got := strings.Join(cfg.optionPolicy.Allowed(), ","); got != "mouse,status" {
+		t.Fatalf("settable options = %q, want mouse,status", got)
+	}
+
+	if _, err := normalizeAndValidateConfig(config{targetSession: "dev", term: "ghostty", settableOpts: "mouse,default-shell"}); err == nil {
+		t.Fatalf("expected error for unsupported option")
+	}
+}
+
+func TestValidateListenAddr(t *testing.T) {
+	for _, addr := range []string{"127.0.0.1:8080", "[::1]:8080", ":8080", "unix:/tmp/wmux.sock"} {
+		if err := validateListenAddr(addr); err != nil {
+			t.Fatalf("validateListenAddr(%q): %v", addr, err)
+		}
+	}
+	for _, addr := range []string{"::1:8080", "localhost", "unix:"} {
+		if err := validateListenAddr(addr); err == nil {
+			t.Fatalf("validateListenAddr(%q) succeeded, want error", addr)
+		}
+	}
+}
+
+func TestListenUnixSocketRemovesSocketOnCleanup(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "wmux.sock")
+	ln, cleanup, err := listen("unix:" + path)
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	if got := ln.Addr().Network(); got != "unix" {
+		t.Fatalf("network = %q, want unix", got)
+	}
+	_ = ln.Close()
+	cleanup()
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatalf("expected socket file removed, stat err = %v", err)
+	}
+}
+
+func TestReloadPolicySwapsHubPolicy(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "policy.txt")
+	if err := os.WriteFile(path, []byte("# read-only\nlist-panes\nCapture-Pane\n\n"), 0o644); err != nil {
+		t.Fatalf("write policy: %v", err)
+	}
+	var logs bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&logs, nil))
+	hub := wshub.New(policy.Default(), "dev")
+
+	reloadPolicy(path, hub, logger)
+	if got := strings.Join(hub.Policy().Allowed(), ","); got != "capture-pane,list-panes" {
+		t.Fatalf("allowed = %q, want capture-pane,list-panes", got)
+	}
+	if err := hub.Policy().ValidateCommand("send-keys"); err == nil {
+		t.Fatalf("send-keys still allowed after reload")
+	}
+
+	if err := os.WriteFile(path, []byte("list-panes\nsend keys\n"), 0o644); err != nil {
+		t.Fatalf("write policy: %v", err)
+	}
+	reloadPolicy(path, hub, logger)
+	if got := strings.Join(hub.Policy().Allowed(), ","); got != "capture-pane,list-panes" {
+		t.Fatalf("allowed = %q after a bad reload, want the previous policy kept", got)
+	}
+	if !strings.Contains(logs.String(), "policy reload failed") || !strings.Contains(logs.String(), "line 2") {
+		t.Fatalf("log = %q, want the reload failure with its line", logs.String())
+	}
+}
+
+func TestReloadPolicyRejectsAdminCommands(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "policy.txt")
+	if err := os.WriteFile(path, []byte("list-panes\nkill-session\n"), 0o644); err != nil {
+		t.Fatalf("write policy: %v", err)
+	}
+	var logs bytes.Buffer
+	hub := wshub.New(policy.Default(), "dev")
+
+	reloadPolicy(path, hub, slog.New(slog.NewTextHandler(&logs, nil)))
+	if err := hub.Policy().ValidateCommand("kill-session"); err == nil {
+		t.Fatalf("kill-session allowed over /ws after reload")
+	}
+	if !strings.Contains(logs.String(), "reserved for the admin API") {
+		t.Fatalf("log = %q, want the reserved command reported", logs.String())
+	}
+}
+
+func TestNormalizeAndValidateConfigMaxOutputChunk(t *testing.T) {
+	if _, err := normalizeAndValidateConfig(config{targetSession: "dev", term: "ghostty", maxOutputChunk: 2}); err == nil {
+		t.Fatalf("expected error for a chunk size below the minimum")
+	}
+	cfg, err := normalizeAndValidateConfig(config{targetSession: "dev", term: "ghostty", maxOutputChunk: 4096})
+	if err != nil || cfg.maxOutputChunk != 4096 {
+		t.Fatalf("maxOutputChunk = %d, err = %v", cfg.maxOutputChunk, err)
+	}
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ampcode/wmux/internal/wshub"
+)
+
+// replayTimeDirective marks a timing line in a replay transcript:
+// "#t <seconds>" gives the offset from the start of the replay at which the
+// following lines are fed.
+const replayTimeDirective = "#t "
+
+// maxReplayLine bounds one transcript line; control-mode output lines can be
+// long (escaped pane output, layouts).
+const maxReplayLine = 4 << 20
+
+// replaySender stands in for the tmux control client in --replay-file mode.
+// Commands have nowhere to go, so they are logged and refused with
+// wshub.ErrReplayMode: nothing will answer them, and a command left pending
+// would be paired with the next %begin/%end block in the transcript.
+type replaySender struct {
+	logger *slog.Logger
+}
+
+func (s replaySender) Send(line string) error {
+	s.logger.Debug("replay: discarding tmux command", "command", line)
+	return wshub.ErrReplayMode
+}
+
+// replayTranscript feeds a captured control-mode transcript to onLine, one
+// line at a time. With realtime set, "#t <seconds>" lines delay the lines
+// after them until that offset from the start; otherwise they are skipped
+// and everything is fed as fast as possible. It stops early when ctx ends.
+func replayTranscript(ctx context.Context, r io.Reader, realtime bool, onLine func(string)) error {
+	start := time.Now()
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 64*1024), maxReplayLine)
+	lineNo := 0
+	for sc.Scan() {
+		lineNo++
+		line := strings.TrimSuffix(sc.Text(), "\r")
+		if rest, ok := strings.CutPrefix(line, replayTimeDirective); ok {
+			secs, err := strconv.ParseFloat(strings.TrimSpace(rest), 64)
+			if err != nil || secs < 0 {
+				return fmt.Errorf("line %d: invalid time directive %q", lineNo, line)
+			}
+			if !realtime {
+				continue
+			}
+			wait := time.Until(start.Add(time.Duration(secs * float64(time.Second))))
+			if wait <= 0 {
+				continue
+			}
+			select {
+			case <-time.After(wait):
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+			continue
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		onLine(line)
+	}
+	return sc.Err()
+}
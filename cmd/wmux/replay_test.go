@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ampcode/wmux/internal/policy"
+	"github.com/ampcode/wmux/internal/wshub"
+)
+
+func TestReplayTranscriptSkipsTimeDirectives(t *testing.T) {
+	transcript := "%begin 1 1 0\r\n#t 5\n%end 1 1 0\n\n%output %1 hi\n"
+	var got []string
+	start := time.Now()
+	if err := replayTranscript(context.Background(), strings.NewReader(transcript), false, func(line string) {
+		got = append(got, line)
+	}); err != nil {
+		t.Fatalf("replayTranscript: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("fast replay took %s; time directives should be ignored", elapsed)
+	}
+	want := []string{"%begin 1 1 0", "%end 1 1 0", "", "%output %1 hi"}
+	if strings.Join(got, "|") != strings.Join(want, "|") {
+		t.Fatalf("lines = %q, want %q", got, want)
+	}
+}
+
+func TestReplayTranscriptRealtimeWaitsForOffsets(t *testing.T) {
+	transcript := "%output %1 a\n#t 0.15\n%output %1 b\n"
+	start := time.Now()
+	var at []time.Duration
+	if err := replayTranscript(context.Background(), strings.NewReader(transcript), true, func(string) {
+		at = append(at, time.Since(start))
+	}); err != nil {
+		t.Fatalf("replayTranscript: %v", err)
+	}
+	if len(at) != 2 {
+		t.Fatalf("fed %d lines, want 2", len(at))
+	}
+	if at[0] >= 150*time.Millisecond || at[1] < 150*time.Millisecond {
+		t.Fatalf("line offsets = %v, want the second line at or after 150ms and the first before", at)
+	}
+}
+
+func TestReplayTranscriptStopsOnCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	fed := 0
+	err := replayTranscript(ctx, strings.NewReader("%output %1 a\n#t 60\n%output %1 b\n"), true, func(string) {
+		fed++
+		cancel()
+	})
+	if err != context.Canceled {
+		t.Fatalf("err = %v, want context.Canceled", err)
+	}
+	if fed != 1 {
+		t.Fatalf("fed %d lines, want 1", fed)
+	}
+}
+
+func TestReplayTranscriptRejectsBadDirective(t *testing.T) {
+	err := replayTranscript(context.Background(), strings.NewReader("%output %1 a\n#t soon\n"), false, func(string) {})
+	if err == nil || !strings.Contains(err.Error(), "line 2") {
+		t.Fatalf("err = %v, want a line 2 time directive error", err)
+	}
+}
+
+func TestReplaySenderLeavesNoPendingCommands(t *testing.T) {
+	hub := wshub.New(policy.Default(), "dev")
+	if err := hub.BindTmux(replaySender{logger: slog.New(slog.DiscardHandler)}); err != nil {
+		t.Fatalf("BindTmux: %v", err)
+	}
+	hub.BroadcastConnectedNoSync()
+	if reason := hub.CurrentUnavailableReason(); reason != "" {
+		t.Fatalf("unavailable reason = %q, want connected", reason)
+	}
+	if err := hub.RequestStateSync(); !errors.Is(err, wshub.ErrReplayMode) {
+		t.Fatalf("RequestStateSync err = %v, want ErrReplayMode", err)
+	}
+
+	// Anything left pending would take the transcript's next command block
+	// as its response.
+	if pending := hub.PendingSnapshot(); len(pending) != 0 {
+		t.Fatalf("pending = %#v, want none", pending)
+	}
+}
+
+func TestNormalizeAndValidateConfigReplay(t *testing.T) {
+	cfg, err := normalizeAndValidateConfig(config{targetSession: "dev", term: "ghostty", replayFile: "session.log"})
+	if err != nil {
+		t.Fatalf("normalizeAndValidateConfig: %v", err)
+	}
+	if cfg.sentinel != wshub.DefaultSentinel {
+		t.Fatalf("sentinel = %q, want default %q in replay mode", cfg.sentinel, wshub.DefaultSentinel)
+	}
+
+	if _, err := normalizeAndValidateConfig(config{targetSession: "dev", term: "ghostty", replayRealtime: true}); err == nil {
+		t.Fatalf("expected error for --replay-realtime without --replay-file")
+	}
+}
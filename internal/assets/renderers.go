@@ -0,0 +1,30 @@
+package assets
+
+import "strings"
+
+// TerminalRenderers lists the terminal renderers the web UI (app.js) can
+// load, in preference order. The first entry is the fallback default.
+var TerminalRenderers = []string{"ghostty", "xterm"}
+
+// DefaultTerminalRenderer returns the renderer used when none (or an unknown
+// one) is requested.
+func DefaultTerminalRenderer() string {
+	return TerminalRenderers[0]
+}
+
+// IsTerminalRenderer reports whether name is a supported renderer. Matching
+// is exact; callers normalize case and whitespace first.
+func IsTerminalRenderer(name string) bool {
+	for _, r := range TerminalRenderers {
+		if r == name {
+			return true
+		}
+	}
+	return false
+}
+
+// TerminalRendererList returns the supported renderers joined for messages,
+// e.g. "ghostty, xterm".
+func TerminalRendererList() string {
+	return strings.Join(TerminalRenderers, ", ")
+}
@@ -1,5 +1,8 @@
 const terminalHostEl = document.getElementById("terminal-host");
+const statusEl = document.getElementById("status");
 const terminalRenderer = parseTerminalRenderer(location.search);
+// The server points <base> at the path prefix wmux is mounted under.
+const basePath = new URL(document.baseURI).pathname.replace(/\/$/, "");
 
 const initialTargetPaneId = parseTargetPaneId(location.pathname);
 
@@ -16,6 +19,12 @@ const state = {
   termBundle: null,
   resizeTimer: null,
   refreshTimer: null,
+  reconnectTimer: null,
+  // synced is false until the server sends "ready"; before that an empty
+  // state may just mean the first sync has not finished.
+  synced: false,
+  sentinel: "__WMUX__",
+  cursorMarker: "__WMUX_CURSOR",
 };
 
 boot();
@@ -27,7 +36,8 @@ async function boot() {
 }
 
 function parseTargetPaneId(pathname) {
-  const m = pathname.match(/^\/p\/([^/]+)$/);
+  if (!pathname.startsWith(`${basePath}/`)) return "";
+  const m = pathname.slice(basePath.length).match(/^\/p\/([^/]+)$/);
   if (!m) return "";
   return normalizePublicPaneId(m[1]);
 }
@@ -41,8 +51,8 @@ function parseTerminalRenderer(search) {
 async function loadTerminalRuntime(renderer) {
   if (renderer === "ghostty") {
     try {
-      const ghosttyModule = await import("/vendor/ghostty/ghostty-web.js");
-      const ghostty = await ghosttyModule.Ghostty.load("/vendor/ghostty/ghostty-vt.wasm");
+      const ghosttyModule = await import(`${basePath}/vendor/ghostty/ghostty-web.js`);
+      const ghostty = await ghosttyModule.Ghostty.load(`${basePath}/vendor/ghostty/ghostty-vt.wasm`);
       return {
         renderer: "ghostty",
         createTerminal(options) {
@@ -76,14 +86,15 @@ function createXtermRuntime() {
 
 function connect() {
   const proto = location.protocol === "https:" ? "wss" : "ws";
-  const ws = new WebSocket(`${proto}://${location.host}/ws`);
+  const ws = new WebSocket(`${proto}://${location.host}${basePath}/ws`, "wmux.v1");
   state.ws = ws;
 
   ws.addEventListener("open", () => {
-    requestModelSync();
+    ws.send(JSON.stringify({ t: "hello", term: state.terminalRuntime?.renderer || terminalRenderer }));
   });
 
   ws.addEventListener("close", () => {
+    state.synced = false;
     setTimeout(connect, 1000);
   });
 
@@ -100,6 +111,15 @@ function connect() {
 }
 
 function handleServerMessage(msg) {
+  if (msg.t === "protocol") {
+    // The server picks the sentinel per run; sync only once it is known so
+    // our list-panes rows are recognized.
+    state.sentinel = msg.protocol?.sentinel || state.sentinel;
+    state.cursorMarker = msg.protocol?.cursor_marker || state.cursorMarker;
+    requestModelSync();
+    return;
+  }
+
   if (msg.t === "tmux_command") {
     if (msg.command?.success === false && Array.isArray(msg.command.output) && msg.command.output.length) {
       console.warn(msg.command.output.join("\n"));
@@ -155,11 +175,38 @@ function handleServerMessage(msg) {
     return;
   }
 
+  if (msg.t === "pane_dead") {
+    const dead = msg.pane_dead;
+    if (!dead || normalizePublicPaneId(dead.pane_id) !== state.currentPaneId) return;
+    terminalHostEl.classList.add("pane-dead");
+    return;
+  }
+
+  if (msg.t === "tmux_reconnecting") {
+    showReconnecting(msg.reconnect);
+    return;
+  }
+
+  if (msg.t === "ready") {
+    state.synced = true;
+    clearReconnecting();
+    if (!state.currentPaneId && state.terminalRuntime) {
+      warnPaneNotFound();
+    }
+    return;
+  }
+
   if (msg.t === "tmux_restarted") {
+    state.synced = false;
     requestModelSync();
     return;
   }
 
+  if (msg.t === "tmux_config_error") {
+    console.error(`tmux config error: ${msg.message || ""}`);
+    return;
+  }
+
   if (msg.t === "error") {
     console.warn(msg.message || "unknown error");
   }
@@ -177,6 +224,7 @@ function applyState(snapshot) {
       width: Number(p.width || 0),
       height: Number(p.height || 0),
       active: !!p.active,
+      dead: !!p.dead,
     });
   }
 
@@ -191,24 +239,55 @@ function applyState(snapshot) {
 
   if (!resolved) {
     state.currentPaneId = null;
-    console.warn(`pane not found: ${state.targetPaneId === "" ? "(missing in URL)" : state.targetPaneId}`);
+    if (state.synced) warnPaneNotFound();
     return;
   }
 
   if (!state.termBundle) {
     state.termBundle = createTerminal();
   }
+  terminalHostEl.classList.toggle("pane-dead", resolved.dead);
 
   if (state.currentPaneId !== resolved.paneId) {
     state.currentPaneId = resolved.paneId;
     state.termBundle.term.reset();
     const tmuxPaneId = tmuxPaneTarget(resolved.paneId);
     sendArgv(["capture-pane", "-p", "-e", "-N", "-t", tmuxPaneId]);
-    sendArgv(["display-message", "-p", "-t", tmuxPaneId, "__WMUX_CURSOR\t#{pane_cursor_x}\t#{pane_cursor_y}"]);
+    sendArgv(["display-message", "-p", "-t", tmuxPaneId, `${state.cursorMarker}\t#{pane_cursor_x}\t#{pane_cursor_y}`]);
     schedulePaneResize();
   }
 }
 
+// showReconnecting counts down to the tmux manager's next reconnect attempt
+// until the next tmux_reconnecting or ready message.
+function showReconnecting(reconnect) {
+  clearReconnecting();
+  if (!reconnect || !statusEl) return;
+  const retryAt = Date.now() + Math.max(0, Number(reconnect.retry_in_ms || 0));
+  const attempt = Number(reconnect.attempt || 0);
+  const render = () => {
+    const secs = Math.max(0, Math.ceil((retryAt - Date.now()) / 1000));
+    statusEl.textContent = secs > 0
+      ? `tmux unavailable, reconnecting in ${secs}s (attempt ${attempt})`
+      : `tmux unavailable, reconnecting (attempt ${attempt})`;
+  };
+  render();
+  statusEl.hidden = false;
+  state.reconnectTimer = setInterval(render, 1000);
+}
+
+function clearReconnecting() {
+  if (state.reconnectTimer) {
+    clearInterval(state.reconnectTimer);
+    state.reconnectTimer = null;
+  }
+  if (statusEl) statusEl.hidden = true;
+}
+
+function warnPaneNotFound() {
+  console.warn(`pane not found: ${state.targetPaneId === "" ? "(missing in URL)" : state.targetPaneId}`);
+}
+
 function resolveTargetPane(paneId, panes) {
   if (!paneId) return null;
   return panes.get(paneId) || null;
@@ -277,12 +356,12 @@ function sendKeyStroke(tmuxPaneId, ch) {
 }
 
 function requestModelSync() {
-  sendArgv(["list-panes", "-a", "-F", "__WMUX___pane\t#{session_name}\t#{pane_id}\t#{window_id}\t#{pane_index}\t#{pane_active}\t#{pane_left}\t#{pane_top}\t#{pane_width}\t#{pane_height}\t#{pane_current_command}\t#{pane_title}\t#{window_index}\t#{window_name}"]);
+  sendArgv(["list-panes", "-a", "-F", `${state.sentinel}_pane\t#{session_name}\t#{pane_id}\t#{window_id}\t#{pane_index}\t#{pane_active}\t#{pane_left}\t#{pane_top}\t#{pane_width}\t#{pane_height}\t#{pane_current_command}\t#{pane_title}\t#{window_index}\t#{window_name}\t#{window_active}\t#{pane_dead}\t#{pane_dead_status}\t#{pane_pid}\t#{pane_start_command}`]);
 }
 
 function paneURLFor(paneId) {
   const query = location.search || "";
-  return `/p/${encodeURIComponent(paneId)}${query}`;
+  return `${basePath}/p/${encodeURIComponent(paneId)}${query}`;
 }
 
 function scheduleModelRefresh() {
@@ -352,7 +431,7 @@ function maybeReportUnicodeIssue(source, paneID, data) {
     recent_messages: state.debug.recentMessages.slice(-20),
   };
 
-  fetch("/api/debug/unicode", {
+  fetch(`${basePath}/api/debug/unicode`, {
     method: "POST",
     headers: { "Content-Type": "application/json" },
     body: JSON.stringify(payload),
@@ -0,0 +1,115 @@
+// Package castrec writes and reads terminal output as asciinema v2 cast files.
+package castrec
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+)
+
+// Header is the first line of an asciinema v2 cast file.
+type Header struct {
+	Version   int               `json:"version"`
+	Width     int               `json:"width"`
+	Height    int               `json:"height"`
+	Timestamp int64             `json:"timestamp,omitempty"`
+	Title     string            `json:"title,omitempty"`
+	Env       map[string]string `json:"env,omitempty"`
+}
+
+// Writer emits a cast header followed by output events timestamped relative
+// to the recording start.
+type Writer struct {
+	w     io.Writer
+	start time.Time
+}
+
+// NewWriter writes hdr (forcing version 2 and the start timestamp) and
+// returns a Writer whose event times are measured from start.
+func NewWriter(w io.Writer, hdr Header, start time.Time) (*Writer, error) {
+	hdr.Version = 2
+	if hdr.Width <= 0 || hdr.Height <= 0 {
+		return nil, fmt.Errorf("cast header requires positive width and height")
+	}
+	if hdr.Timestamp == 0 {
+		hdr.Timestamp = start.Unix()
+	}
+	if err := writeJSONLine(w, hdr); err != nil {
+		return nil, err
+	}
+	return &Writer{w: w, start: start}, nil
+}
+
+// WriteOutput appends an "o" event carrying data observed at time at.
+func (cw *Writer) WriteOutput(at time.Time, data string) error {
+	elapsed := at.Sub(cw.start).Seconds()
+	if elapsed < 0 {
+		elapsed = 0
+	}
+	return writeJSONLine(cw.w, []any{elapsed, "o", data})
+}
+
+func writeJSONLine(w io.Writer, v any) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	b = append(b, '\n')
+	_, err = w.Write(b)
+	return err
+}
+
+// Event is one event line of a cast file.
+type Event struct {
+	// Time is seconds since the recording start.
+	Time float64
+	// Type is "o" for output; other asciinema types ("i", "r", "m") are
+	// passed through for callers to skip or handle.
+	Type string
+	Data string
+}
+
+// Reader reads a cast file written by Writer, or any asciinema v2 file.
+type Reader struct {
+	Header Header
+	dec    *json.Decoder
+}
+
+// NewReader reads and checks the header line of a cast file.
+func NewReader(r io.Reader) (*Reader, error) {
+	dec := json.NewDecoder(r)
+	var hdr Header
+	if err := dec.Decode(&hdr); err != nil {
+		return nil, fmt.Errorf("cast header: %w", err)
+	}
+	if hdr.Version != 2 {
+		return nil, fmt.Errorf("unsupported cast version %d", hdr.Version)
+	}
+	return &Reader{Header: hdr, dec: dec}, nil
+}
+
+// Next returns the next event, or io.EOF after the last one.
+func (cr *Reader) Next() (Event, error) {
+	var raw []json.RawMessage
+	if err := cr.dec.Decode(&raw); err != nil {
+		if err == io.EOF {
+			return Event{}, io.EOF
+		}
+		return Event{}, fmt.Errorf("cast event: %w", err)
+	}
+	var ev Event
+	if len(raw) != 3 {
+		return Event{}, fmt.Errorf("cast event has %d fields, want 3", len(raw))
+	}
+	if err := json.Unmarshal(raw[0], &ev.Time); err != nil {
+		return Event{}, fmt.Errorf("cast event time: %w", err)
+	}
+	if err := json.Unmarshal(raw[1], &ev.Type); err != nil {
+		return Event{}, fmt.Errorf("cast event type: %w", err)
+	}
+	if err := json.Unmarshal(raw[2], &ev.Data); err != nil {
+		return Event{}, fmt.Errorf("cast event data: %w", err)
+	}
+	return ev, nil
+}
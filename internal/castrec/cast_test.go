@@ -0,0 +1,91 @@
+package castrec
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWriterEmitsHeaderAndRelativeEvents(t *testing.T) {
+	var buf bytes.Buffer
+	start := time.Unix(1700000000, 0)
+	w, err := NewWriter(&buf, Header{Width: 120, Height: 40, Env: map[string]string{"TERM": "xterm-256color"}}, start)
+	if err != nil {
+		t.Fatalf("NewWriter: %v", err)
+	}
+	if err := w.WriteOutput(start.Add(1500*time.Millisecond), "hi\r\n"); err != nil {
+		t.Fatalf("WriteOutput: %v", err)
+	}
+
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("lines = %d, want 2: %q", len(lines), buf.String())
+	}
+	var hdr Header
+	if err := json.Unmarshal([]byte(lines[0]), &hdr); err != nil {
+		t.Fatalf("decode header: %v", err)
+	}
+	if hdr.Version != 2 || hdr.Width != 120 || hdr.Height != 40 || hdr.Timestamp != 1700000000 {
+		t.Fatalf("unexpected header: %#v", hdr)
+	}
+	var ev []any
+	if err := json.Unmarshal([]byte(lines[1]), &ev); err != nil {
+		t.Fatalf("decode event: %v", err)
+	}
+	if len(ev) != 3 || ev[0].(float64) != 1.5 || ev[1] != "o" || ev[2] != "hi\r\n" {
+		t.Fatalf("unexpected event: %#v", ev)
+	}
+}
+
+func TestNewWriterRejectsEmptyGeometry(t *testing.T) {
+	if _, err := NewWriter(&bytes.Buffer{}, Header{}, time.Now()); err == nil {
+		t.Fatalf("expected error for zero width/height")
+	}
+}
+
+func TestReaderReadsWriterOutput(t *testing.T) {
+	var buf bytes.Buffer
+	start := time.Unix(1700000000, 0)
+	w, err := NewWriter(&buf, Header{Width: 80, Height: 24, Title: "demo"}, start)
+	if err != nil {
+		t.Fatalf("NewWriter: %v", err)
+	}
+	_ = w.WriteOutput(start, "one")
+	_ = w.WriteOutput(start.Add(250*time.Millisecond), "two\r\n")
+
+	r, err := NewReader(&buf)
+	if err != nil {
+		t.Fatalf("NewReader: %v", err)
+	}
+	if r.Header.Width != 80 || r.Header.Height != 24 || r.Header.Title != "demo" {
+		t.Fatalf("header = %#v", r.Header)
+	}
+	for _, want := range []Event{{Time: 0, Type: "o", Data: "one"}, {Time: 0.25, Type: "o", Data: "two\r\n"}} {
+		got, err := r.Next()
+		if err != nil {
+			t.Fatalf("Next: %v", err)
+		}
+		if got != want {
+			t.Fatalf("event = %#v, want %#v", got, want)
+		}
+	}
+	if _, err := r.Next(); err != io.EOF {
+		t.Fatalf("Next at end = %v, want io.EOF", err)
+	}
+}
+
+func TestNewReaderRejectsOtherVersions(t *testing.T) {
+	if _, err := NewReader(strings.NewReader(`{"version":1,"width":80,"height":24}` + "\n")); err == nil {
+		t.Fatalf("expected error for version 1")
+	}
+	r, err := NewReader(strings.NewReader(`{"version":2,"width":80,"height":24}` + "\n" + `[1.0,"o"]` + "\n"))
+	if err != nil {
+		t.Fatalf("NewReader: %v", err)
+	}
+	if _, err := r.Next(); err == nil || err == io.EOF {
+		t.Fatalf("Next on short event = %v, want error", err)
+	}
+}
@@ -0,0 +1,137 @@
+package httpd
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// ParseBasePath normalizes the path prefix wmux is served under behind a
+// reverse proxy ("/wmux/" becomes "/wmux"). Empty and "/" mean the root and
+// return "". The prefix must be an absolute, clean path without a query,
+// fragment, or characters that need escaping.
+func ParseBasePath(s string) (string, error) {
+	s = strings.TrimSpace(s)
+	if s == "" || s == "/" {
+		return "", nil
+	}
+	if !strings.HasPrefix(s, "/") {
+		return "", fmt.Errorf("base path %q must start with /", s)
+	}
+	s = strings.TrimSuffix(s, "/")
+	for _, segment := range strings.Split(s[1:], "/") {
+		if segment == "" || segment == "." || segment == ".." {
+			return "", fmt.Errorf("base path %q is not a clean path", s)
+		}
+		for _, r := range segment {
+			if !isBasePathRune(r) {
+				return "", fmt.Errorf("base path %q contains %q", s, r)
+			}
+		}
+	}
+	return s, nil
+}
+
+func isBasePathRune(r rune) bool {
+	return r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || strings.ContainsRune("-._~", r)
+}
+
+type basePathKey struct{}
+
+// basePathFrom returns the prefix the request was routed under, "" at the
+// root.
+func basePathFrom(r *http.Request) string {
+	base, _ := r.Context().Value(basePathKey{}).(string)
+	return base
+}
+
+// mountAtBasePath serves next under base: base+"/..." reaches next with the
+// prefix stripped and recorded for basePathFrom, a bare base redirects to
+// base+"/", and every other path is 404.
+func mountAtBasePath(base string, next http.Handler) http.Handler {
+	stripped := http.StripPrefix(base, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), basePathKey{}, base)))
+	}))
+	mux := http.NewServeMux()
+	mux.Handle(base+"/", stripped)
+	mux.HandleFunc(base, func(w http.ResponseWriter, r *http.Request) {
+		target := base + "/"
+		if r.URL.RawQuery != "" {
+			target += "?" + r.URL.RawQuery
+		}
+		http.Redirect(w, r, target, http.StatusMovedPermanently)
+	})
+	return mux
+}
+
+// rebaseHref prefixes a root-absolute href with base. Hrefs built by this
+// package are root-absolute, so documents are built as if served at the root
+// and rebased on the way out.
+func rebaseHref(base, href string) string {
+	if base == "" || !strings.HasPrefix(href, "/") {
+		return href
+	}
+	return base + href
+}
+
+func rebaseLinks(base string, links []hypermediaLink) []hypermediaLink {
+	out := make([]hypermediaLink, len(links))
+	for i, link := range links {
+		link.Href = rebaseHref(base, link.Href)
+		link.Example = rebaseHref(base, link.Example)
+		out[i] = link
+	}
+	return out
+}
+
+// rebaseDocument returns doc with every link, example, and action href
+// prefixed with base.
+func rebaseDocument(doc hypermediaDocument, base string) hypermediaDocument {
+	if base == "" {
+		return doc
+	}
+	doc.Links = rebaseLinks(base, doc.Links)
+	actions := make([]hypermediaAction, len(doc.Actions))
+	for i, action := range doc.Actions {
+		action.Href = rebaseHref(base, action.Href)
+		actions[i] = action
+	}
+	doc.Actions = actions
+	if doc.Windows != nil {
+		windows := make([]windowDocument, len(doc.Windows))
+		for i, window := range doc.Windows {
+			window.Links = rebaseLinks(base, window.Links)
+			window.Panes = rebasePanes(base, window.Panes)
+			windows[i] = window
+		}
+		doc.Windows = windows
+	}
+	doc.Panes = rebasePanes(base, doc.Panes)
+	return doc
+}
+
+func rebasePanes(base string, panes []paneDocument) []paneDocument {
+	if panes == nil {
+		return nil
+	}
+	out := make([]paneDocument, len(panes))
+	for i, pane := range panes {
+		pane.Links = rebaseLinks(base, pane.Links)
+		out[i] = pane
+	}
+	return out
+}
+
+// indexBaseTag is the <base> element of index.html. serveIndex points it
+// at the base path so the page's relative asset, API, and WebSocket URLs
+// resolve under it.
+const indexBaseTag = `<base href="/" />`
+
+func rebaseIndex(page []byte, base string) []byte {
+	if base == "" {
+		return page
+	}
+	return bytes.Replace(page, []byte(indexBaseTag), []byte(`<base href="`+base+`/" />`), 1)
+}
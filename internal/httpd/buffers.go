@@ -0,0 +1,107 @@
+package httpd
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"regexp"
+
+	"github.com/ampcode/wmux/internal/wshub"
+)
+
+const (
+	// maxBufferBytes caps the data of one POST /api/buffers request.
+	maxBufferBytes = 1 << 20
+	// maxBufferRequestBytes bounds the request body, leaving room for JSON
+	// escaping of maxBufferBytes of data.
+	maxBufferRequestBytes = 8 << 20
+)
+
+// bufferNamePattern restricts buffer names to a conservative character set.
+var bufferNamePattern = regexp.MustCompile(`^[A-Za-z0-9_.\-]{1,64}$`)
+
+type setBufferRequest struct {
+	Name string `json:"name"`
+	Data string `json:"data"`
+}
+
+type pasteBufferRequest struct {
+	Name string `json:"name"`
+}
+
+// serveAPIBuffers stores data in a tmux paste buffer: POST {"name","data"}.
+// Without a name tmux picks one and the buffer becomes the most recent.
+func serveAPIBuffers(w http.ResponseWriter, r *http.Request, hub *wshub.Hub) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err := hub.Policy().ValidateCommand("set-buffer"); err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+
+	var req setBufferRequest
+	if !decodeBufferRequest(w, r, &req) {
+		return
+	}
+	if len(req.Data) > maxBufferBytes {
+		http.Error(w, "data exceeds 1 MiB", http.StatusRequestEntityTooLarge)
+		return
+	}
+	if req.Name != "" && !bufferNamePattern.MatchString(req.Name) {
+		http.Error(w, "invalid buffer name", http.StatusBadRequest)
+		return
+	}
+	if err := hub.SetBuffer(r.Context(), req.Name, req.Data); err != nil {
+		writeTmuxError(w, hub, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// serveAPIPanePaste pastes a tmux paste buffer into a pane. The body is
+// optional; {"name"} selects a buffer other than the most recent.
+func serveAPIPanePaste(w http.ResponseWriter, r *http.Request, hub *wshub.Hub, paneID string) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	tmuxPaneID, found := hub.TargetSessionPaneIDByPublicID(paneID)
+	if !found {
+		http.Error(w, "pane not found", http.StatusNotFound)
+		return
+	}
+	if err := hub.Policy().ValidateCommand("paste-buffer"); err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+
+	var req pasteBufferRequest
+	if r.ContentLength != 0 && !decodeBufferRequest(w, r, &req) {
+		return
+	}
+	if req.Name != "" && !bufferNamePattern.MatchString(req.Name) {
+		http.Error(w, "invalid buffer name", http.StatusBadRequest)
+		return
+	}
+	if err := hub.PasteBuffer(r.Context(), tmuxPaneID, req.Name); err != nil {
+		writeTmuxError(w, hub, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func decodeBufferRequest(w http.ResponseWriter, r *http.Request, v any) bool {
+	dec := json.NewDecoder(io.LimitReader(r.Body, maxBufferRequestBytes))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		http.Error(w, "invalid json", http.StatusBadRequest)
+		return false
+	}
+	if err := dec.Decode(&struct{}{}); err != io.EOF {
+		http.Error(w, "invalid json", http.StatusBadRequest)
+		return false
+	}
+	return true
+}
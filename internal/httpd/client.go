@@ -0,0 +1,55 @@
+package httpd
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+
+	"github.com/ampcode/wmux/internal/wshub"
+)
+
+type clientSizeRequest struct {
+	Width  int `json:"width"`
+	Height int `json:"height"`
+}
+
+// serveAPIClient reports the tmux control client's size.
+func serveAPIClient(w http.ResponseWriter, r *http.Request, hub *wshub.Hub) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(hub.ClientSize())
+}
+
+// serveAPIClientSize resizes the tmux control client: POST
+// {"width","height"}. It answers with the new size.
+func serveAPIClientSize(w http.ResponseWriter, r *http.Request, hub *wshub.Hub) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err := hub.Policy().ValidateCommand("refresh-client"); err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+
+	var req clientSizeRequest
+	dec := json.NewDecoder(io.LimitReader(r.Body, 1<<20))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&req); err != nil {
+		http.Error(w, "invalid json", http.StatusBadRequest)
+		return
+	}
+	if err := wshub.ValidateClientSize(req.Width, req.Height); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := hub.ResizeClient(r.Context(), req.Width, req.Height); err != nil {
+		writeTmuxError(w, hub, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(hub.ClientSize())
+}
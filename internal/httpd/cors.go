@@ -0,0 +1,89 @@
+package httpd
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/ampcode/wmux/internal/reqid"
+)
+
+const (
+	corsAllowMethods = "GET, POST, DELETE"
+	corsAllowHeaders = "Content-Type, " + reqid.Header + ", " + idempotencyKeyHeader
+	// corsExposeHeaders are the response headers a cross-origin script may
+	// read.
+	corsExposeHeaders = reqid.Header + ", " + idempotentReplayedHeader
+	// corsMaxAge is how long, in seconds, a browser may cache a preflight.
+	corsMaxAge = "600"
+)
+
+// ParseCORSOrigins parses a comma-separated list of origins for
+// Config.CORSOrigins. Each entry must be a bare http or https origin such as
+// https://tools.example.com:8443, with no path, query or wildcard. Entries
+// are lowercased, duplicates are dropped and an empty list yields nil.
+func ParseCORSOrigins(list string) ([]string, error) {
+	var out []string
+	seen := map[string]struct{}{}
+	for _, raw := range strings.Split(list, ",") {
+		raw = strings.TrimSpace(raw)
+		if raw == "" {
+			continue
+		}
+		origin, err := normalizeOrigin(raw)
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := seen[origin]; ok {
+			continue
+		}
+		seen[origin] = struct{}{}
+		out = append(out, origin)
+	}
+	return out, nil
+}
+
+func normalizeOrigin(raw string) (string, error) {
+	u, err := url.Parse(raw)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" ||
+		u.User != nil || strings.TrimSuffix(u.Path, "/") != "" || u.RawQuery != "" || u.Fragment != "" ||
+		strings.Contains(u.Host, "*") {
+		return "", fmt.Errorf("invalid origin %q: want scheme://host[:port], e.g. https://tools.example.com", raw)
+	}
+	return strings.ToLower(u.Scheme + "://" + u.Host), nil
+}
+
+// allowCORS adds CORS headers to /api/ responses for requests whose Origin
+// is in origins, echoing that origin rather than "*". Preflight OPTIONS
+// requests from an allowed origin are answered here with 204; everything
+// else, including requests from other origins, reaches next unchanged.
+func allowCORS(origins []string, next http.Handler) http.Handler {
+	allowed := make(map[string]struct{}, len(origins))
+	for _, origin := range origins {
+		allowed[origin] = struct{}{}
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasPrefix(r.URL.Path, "/api/") {
+			next.ServeHTTP(w, r)
+			return
+		}
+		h := w.Header()
+		h.Add("Vary", "Origin")
+		origin := r.Header.Get("Origin")
+		if _, ok := allowed[strings.ToLower(origin)]; !ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+		h.Set("Access-Control-Allow-Origin", origin)
+		h.Set("Access-Control-Expose-Headers", corsExposeHeaders)
+		if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+			h.Set("Access-Control-Allow-Methods", corsAllowMethods)
+			h.Set("Access-Control-Allow-Headers", corsAllowHeaders)
+			h.Set("Access-Control-Max-Age", corsMaxAge)
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,136 @@
+package httpd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// maxGrepContext caps the context query parameter of /api/contents?grep.
+const maxGrepContext = 100
+
+type grepLine struct {
+	Line  int    `json:"line"`
+	Text  string `json:"text"`
+	Match bool   `json:"match"`
+}
+
+type grepBlock struct {
+	Lines []grepLine `json:"lines"`
+}
+
+type grepResult struct {
+	PaneID  string      `json:"pane_id"`
+	Query   string      `json:"query"`
+	Regex   bool        `json:"regex"`
+	Context int         `json:"context"`
+	Blocks  []grepBlock `json:"blocks"`
+	// Truncated is set when more than maxSearchResults lines matched;
+	// blocks stop at the last match kept.
+	Truncated bool `json:"truncated"`
+}
+
+// grepQuery is the parsed ?grep, regex and context parameters of
+// /api/contents.
+type grepQuery struct {
+	query   string
+	regex   bool
+	context int
+	match   func(string) bool
+}
+
+// parseGrepQuery reads the grep parameters, returning nil when grep is
+// absent.
+func parseGrepQuery(r *http.Request) (*grepQuery, error) {
+	q := r.URL.Query()
+	if !q.Has("grep") {
+		return nil, nil
+	}
+	g := &grepQuery{query: q.Get("grep"), regex: parseQueryFlag(r, "regex")}
+	if g.query == "" {
+		return nil, fmt.Errorf("grep cannot be empty")
+	}
+	g.match = func(line string) bool { return strings.Contains(line, g.query) }
+	if g.regex {
+		re, err := regexp.Compile(g.query)
+		if err != nil {
+			return nil, fmt.Errorf("invalid regex: %w", err)
+		}
+		g.match = re.MatchString
+	}
+	if raw := strings.TrimSpace(q.Get("context")); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 0 || n > maxGrepContext {
+			return nil, fmt.Errorf("context must be 0 to %d", maxGrepContext)
+		}
+		g.context = n
+	}
+	return g, nil
+}
+
+// grepContent returns the lines of content that match, each with up to
+// context lines either side, like grep -C. Blocks whose context touches or
+// overlaps are merged. Line numbers are 1-based. At most limit matching
+// lines are kept.
+func grepContent(content string, match func(string) bool, context, limit int) ([]grepBlock, bool) {
+	lines := strings.Split(strings.TrimSuffix(content, "\n"), "\n")
+	matches := make([]bool, len(lines))
+	var hits []int
+	truncated := false
+	for i, line := range lines {
+		if !match(line) {
+			continue
+		}
+		if len(hits) == limit {
+			truncated = true
+			break
+		}
+		matches[i] = true
+		hits = append(hits, i)
+	}
+
+	blocks := []grepBlock{}
+	end := 0 // index after the last line emitted
+	for _, i := range hits {
+		from := max(i-context, 0)
+		if len(blocks) == 0 || from > end {
+			blocks = append(blocks, grepBlock{})
+		} else {
+			from = end
+		}
+		to := min(i+context+1, len(lines))
+		block := &blocks[len(blocks)-1]
+		for n := from; n < to; n++ {
+			// A later match past limit is shown as context only.
+			block.Lines = append(block.Lines, grepLine{Line: n + 1, Text: lines[n], Match: matches[n]})
+		}
+		end = max(end, to)
+	}
+	return blocks, truncated
+}
+
+// writeGrepResult answers /api/contents?grep as JSON when the client
+// accepts it, or as text with blocks separated by "--" lines.
+func writeGrepResult(w http.ResponseWriter, r *http.Request, res grepResult) {
+	if strings.Contains(strings.ToLower(r.Header.Get("Accept")), "application/json") {
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(res)
+		return
+	}
+	var b strings.Builder
+	for i, block := range res.Blocks {
+		if i > 0 {
+			b.WriteString("--\n")
+		}
+		for _, line := range block.Lines {
+			b.WriteString(line.Text)
+			b.WriteByte('\n')
+		}
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	_, _ = io.WriteString(w, b.String())
+}
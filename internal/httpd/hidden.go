@@ -0,0 +1,72 @@
+package httpd
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/ampcode/wmux/internal/wshub"
+)
+
+type hidePaneRequest struct {
+	PaneID string `json:"pane_id"`
+}
+
+// serveAPIAdminHidePane hides a target-session pane from the HTTP API
+// without touching it in tmux: POST {"pane_id"}. It answers with the
+// resulting hidden set.
+func serveAPIAdminHidePane(w http.ResponseWriter, r *http.Request, hub *wshub.Hub) {
+	paneID, ok := decodeHidePaneRequest(w, r)
+	if !ok {
+		return
+	}
+	tmuxPaneID, found := hub.TargetSessionPaneIDByPublicID(paneID)
+	if !found {
+		http.Error(w, "pane not found", http.StatusNotFound)
+		return
+	}
+	hub.HidePane(tmuxPaneID)
+	writeHiddenPanes(w, hub)
+}
+
+// serveAPIAdminUnhidePane makes a pane hidden by serveAPIAdminHidePane
+// visible again: POST {"pane_id"}.
+func serveAPIAdminUnhidePane(w http.ResponseWriter, r *http.Request, hub *wshub.Hub) {
+	paneID, ok := decodeHidePaneRequest(w, r)
+	if !ok {
+		return
+	}
+	if !hub.UnhidePane("%" + paneID) {
+		http.Error(w, "pane not hidden", http.StatusNotFound)
+		return
+	}
+	writeHiddenPanes(w, hub)
+}
+
+func decodeHidePaneRequest(w http.ResponseWriter, r *http.Request) (string, bool) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return "", false
+	}
+	var req hidePaneRequest
+	dec := json.NewDecoder(io.LimitReader(r.Body, 4096))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&req); err != nil {
+		http.Error(w, "invalid json", http.StatusBadRequest)
+		return "", false
+	}
+	paneID := strings.TrimPrefix(strings.TrimSpace(req.PaneID), "%")
+	if paneID == "" {
+		http.Error(w, "pane_id is required", http.StatusBadRequest)
+		return "", false
+	}
+	return paneID, true
+}
+
+func writeHiddenPanes(w http.ResponseWriter, hub *wshub.Hub) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]any{
+		"hidden_panes": hub.HiddenPanes(),
+	})
+}
@@ -0,0 +1,140 @@
+package httpd
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/ampcode/wmux/internal/wshub"
+)
+
+const (
+	// idempotencyTTL is how long POST /api/panes remembers an
+	// Idempotency-Key after the pane was created.
+	idempotencyTTL = 10 * time.Minute
+	// maxIdempotencyKeys bounds the remembered keys; the oldest completed
+	// key is forgotten first.
+	maxIdempotencyKeys = 1024
+	// maxIdempotencyKeyLength caps the Idempotency-Key header, in bytes.
+	maxIdempotencyKeyLength = 255
+
+	idempotencyKeyHeader = "Idempotency-Key"
+	// idempotentReplayedHeader marks a response replayed for a repeated
+	// Idempotency-Key.
+	idempotentReplayedHeader = "Idempotent-Replayed"
+)
+
+var errIdempotencyKeyReused = errors.New("Idempotency-Key was already used with a different request")
+
+// createPaneKeys remembers the pane each recent Idempotency-Key created, so
+// a retried POST /api/panes returns that pane instead of making another.
+// Keys live in memory only and are not shared between wmux processes.
+type createPaneKeys struct {
+	mu      sync.Mutex
+	entries map[string]*keyedCreate
+	now     func() time.Time
+}
+
+// keyedCreate is one Idempotency-Key. done is closed once the create it
+// guards has finished; ok reports whether it produced pane.
+type keyedCreate struct {
+	request [sha256.Size]byte
+	done    chan struct{}
+	ok      bool
+	pane    wshub.PaneInfo
+	at      time.Time
+}
+
+func newCreatePaneKeys() *createPaneKeys {
+	return &createPaneKeys{entries: map[string]*keyedCreate{}, now: time.Now}
+}
+
+// idempotencyFingerprint identifies the request a key was first used with:
+// its query string and body.
+func idempotencyFingerprint(r *http.Request, body []byte) [sha256.Size]byte {
+	return sha256.Sum256(append([]byte(r.URL.RawQuery+"\x00"), body...))
+}
+
+// claim looks key up. When a create for key already succeeded it returns
+// that entry with replay set. Otherwise the caller owns the returned entry
+// and must pass it to finish or abandon. A request for a key whose create
+// is still running waits for it; if that create fails, the waiter takes
+// over.
+func (k *createPaneKeys) claim(ctx context.Context, key string, request [sha256.Size]byte) (entry *keyedCreate, replay bool, err error) {
+	for {
+		k.mu.Lock()
+		k.expireLocked()
+		existing, ok := k.entries[key]
+		if !ok {
+			k.evictLocked()
+			entry = &keyedCreate{request: request, done: make(chan struct{})}
+			k.entries[key] = entry
+			k.mu.Unlock()
+			return entry, false, nil
+		}
+		k.mu.Unlock()
+
+		if existing.request != request {
+			return nil, false, errIdempotencyKeyReused
+		}
+		select {
+		case <-ctx.Done():
+			return nil, false, ctx.Err()
+		case <-existing.done:
+		}
+		if existing.ok {
+			return existing, true, nil
+		}
+	}
+}
+
+// finish records that entry's create produced pane.
+func (k *createPaneKeys) finish(entry *keyedCreate, pane wshub.PaneInfo) {
+	k.mu.Lock()
+	entry.ok = true
+	entry.pane = pane
+	entry.at = k.now()
+	k.mu.Unlock()
+	close(entry.done)
+}
+
+// abandon forgets key after its create failed, so a retry creates afresh.
+func (k *createPaneKeys) abandon(key string, entry *keyedCreate) {
+	k.mu.Lock()
+	if k.entries[key] == entry {
+		delete(k.entries, key)
+	}
+	k.mu.Unlock()
+	close(entry.done)
+}
+
+func (k *createPaneKeys) expireLocked() {
+	cutoff := k.now().Add(-idempotencyTTL)
+	for key, entry := range k.entries {
+		if entry.ok && entry.at.Before(cutoff) {
+			delete(k.entries, key)
+		}
+	}
+}
+
+// evictLocked makes room for one more key by dropping the oldest completed
+// ones. Keys whose create is still running are kept, so the map can
+// briefly exceed maxIdempotencyKeys under that many concurrent creates.
+func (k *createPaneKeys) evictLocked() {
+	for len(k.entries) >= maxIdempotencyKeys {
+		var oldestKey string
+		var oldest *keyedCreate
+		for key, entry := range k.entries {
+			if entry.ok && (oldest == nil || entry.at.Before(oldest.at)) {
+				oldestKey, oldest = key, entry
+			}
+		}
+		if oldest == nil {
+			return
+		}
+		delete(k.entries, oldestKey)
+	}
+}
@@ -0,0 +1,43 @@
+package httpd
+
+import (
+	"image/png"
+	"net/http"
+
+	"github.com/ampcode/wmux/internal/termimg"
+	"github.com/ampcode/wmux/internal/wshub"
+)
+
+// serveAPIPaneImage renders the pane's visible contents, with colors, as a
+// PNG sized to the pane's cell grid.
+func serveAPIPaneImage(w http.ResponseWriter, r *http.Request, hub *wshub.Hub, opts termimg.Options, paneID string) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	pane, found := targetSessionPaneByPublicID(hub, paneID)
+	if !found {
+		http.Error(w, "pane not found", http.StatusNotFound)
+		return
+	}
+	if pane.Width <= 0 || pane.Height <= 0 {
+		setRetryAfter(w, hub.RetryAfter())
+		http.Error(w, "pane size unknown", http.StatusServiceUnavailable)
+		return
+	}
+
+	content, err := hub.CapturePaneContent(r.Context(), pane.TmuxPaneID, true)
+	if err != nil {
+		writeTmuxError(w, hub, err)
+		return
+	}
+	img, err := termimg.Render(termimg.ParseANSI(content, pane.Width, pane.Height), opts)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+
+	w.Header().Set("Content-Type", "image/png")
+	w.Header().Set("Cache-Control", "no-store")
+	_ = png.Encode(w, img)
+}
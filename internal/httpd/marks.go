@@ -0,0 +1,77 @@
+package httpd
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+
+	"github.com/ampcode/wmux/internal/wshub"
+)
+
+// paneMarks remembers, per tmux pane id, the history size recorded by the
+// last POST /api/panes/{pane_id}/mark. Marks live in memory only.
+type paneMarks struct {
+	mu    sync.Mutex
+	marks map[string]int
+}
+
+func newPaneMarks() *paneMarks {
+	return &paneMarks{marks: map[string]int{}}
+}
+
+func (m *paneMarks) set(tmuxPaneID string, historySize int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.marks[tmuxPaneID] = historySize
+}
+
+func (m *paneMarks) get(tmuxPaneID string) (int, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	n, ok := m.marks[tmuxPaneID]
+	return n, ok
+}
+
+type paneMarkResponse struct {
+	PaneID      string `json:"pane_id"`
+	HistorySize int    `json:"history_size"`
+}
+
+// serveAPIPaneMark records the pane's current history size so that
+// /api/contents/{pane_id}?since_mark=1 can later capture from there.
+func serveAPIPaneMark(w http.ResponseWriter, r *http.Request, hub *wshub.Hub, marks *paneMarks, paneID string) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	tmuxPaneID, found := hub.TargetSessionPaneIDByPublicID(paneID)
+	if !found {
+		http.Error(w, "pane not found", http.StatusNotFound)
+		return
+	}
+	size, err := hub.HistorySize(r.Context(), tmuxPaneID)
+	if err != nil {
+		writeTmuxError(w, hub, err)
+		return
+	}
+	marks.set(tmuxPaneID, size)
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(paneMarkResponse{PaneID: paneID, HistorySize: size})
+}
+
+// markCaptureStart returns the capture-pane start line for the pane's mark:
+// the line that was the top of the screen when the mark was taken. History
+// trimmed at history-limit since then makes the start approximate, and a
+// history that shrank (clear-history) starts at the top of the screen.
+func markCaptureStart(r *http.Request, hub *wshub.Hub, marks *paneMarks, tmuxPaneID string) (int, bool, error) {
+	mark, ok := marks.get(tmuxPaneID)
+	if !ok {
+		return 0, false, nil
+	}
+	size, err := hub.HistorySize(r.Context(), tmuxPaneID)
+	if err != nil {
+		return 0, true, err
+	}
+	return min(mark-size, 0), true, nil
+}
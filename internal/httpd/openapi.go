@@ -0,0 +1,241 @@
+package httpd
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/ampcode/wmux/internal/wshub"
+)
+
+// openAPIDocument describes the state, pane, contents and create-pane
+// endpoints as OpenAPI 3.1. Response schemas are derived from the Go types
+// the handlers encode, and the create-pane body is createPaneSchema, so the
+// description follows the handlers rather than restating them.
+func openAPIDocument(basePath string) map[string]any {
+	schemas := map[string]any{}
+	doc := jsonSchemaFor(reflect.TypeOf(hypermediaDocument{}), schemas)
+	grep := jsonSchemaFor(reflect.TypeOf(grepResult{}), schemas)
+
+	server := basePath
+	if server == "" {
+		server = "/"
+	}
+	paneID := map[string]any{
+		"name":        "pane_id",
+		"in":          "path",
+		"required":    true,
+		"description": "Public pane id, the tmux pane id without its '%'.",
+		"schema":      map[string]any{"type": "string", "pattern": "^[0-9]+$"},
+	}
+	nested := openAPIFlag("nested", "Group panes under their windows (JSON only).")
+	stateGet := func(summary string, contentTypes ...string) map[string]any {
+		return map[string]any{
+			"get": map[string]any{
+				"summary":    summary,
+				"parameters": []any{nested},
+				"responses": map[string]any{
+					"200": openAPIResponse("The target session's windows and panes.", doc, contentTypes...),
+					"304": map[string]any{"description": "The ETag in If-None-Match still matches."},
+				},
+			},
+		}
+	}
+
+	return map[string]any{
+		"openapi": "3.1.0",
+		"info": map[string]any{
+			"title":       "wmux",
+			"version":     "1",
+			"description": "HTTP API for a tmux session served by wmux. The hypermedia documents link to every other route.",
+		},
+		"servers": []any{map[string]any{"url": server}},
+		"paths": map[string]any{
+			"/api/state":      stateGet("Session state, negotiated by Accept.", "application/json", "text/html"),
+			"/api/state.json": stateGet("Session state as JSON.", "application/json"),
+			"/api/state.html": stateGet("Session state as HTML.", "text/html"),
+			"/api/panes": map[string]any{
+				"post": map[string]any{
+					"summary": "Create a pane in the target session.",
+					"parameters": []any{
+						map[string]any{
+							"name":        idempotencyKeyHeader,
+							"in":          "header",
+							"description": "Retrying with the same key and request returns the pane the first request created.",
+							"schema":      map[string]any{"type": "string", "maxLength": maxIdempotencyKeyLength},
+						},
+						openAPIFlag("cursor", "Also report the new pane's cursor position."),
+						openAPIFlag("wait_ready", "Wait until the pane looks ready for input."),
+						openAPIQuery("ready_regex", "Ready once the last non-blank screen line matches this Go regular expression.", map[string]any{"type": "string"}),
+						openAPIQuery("ready_command", "Ready once #{pane_current_command} equals this name.", map[string]any{"type": "string"}),
+						openAPIQuery("ready_timeout_ms", "How long to wait for readiness.", map[string]any{
+							"type": "integer", "minimum": 1, "maximum": wshub.MaxPaneReadyTimeout.Milliseconds(),
+						}),
+					},
+					"requestBody": map[string]any{
+						"required": false,
+						"content":  map[string]any{"application/json": map[string]any{"schema": createPaneSchema()}},
+					},
+					"responses": map[string]any{
+						"200": openAPIResponse("The pane an earlier request with the same Idempotency-Key created.", doc, "application/json"),
+						"201": openAPIResponse("The new pane; Location names it.", doc, "application/json"),
+						"400": openAPIError("The body or a query parameter is invalid."),
+						"422": openAPIError("The Idempotency-Key was used with a different request."),
+						"502": openAPIError("tmux failed to create the pane."),
+						"503": openAPIError("tmux is not connected; see Retry-After."),
+						"504": openAPIError("Creating the pane exceeded --create-timeout."),
+					},
+				},
+			},
+			"/api/panes/{pane_id}": map[string]any{
+				"get": map[string]any{
+					"summary":    "One pane and the actions it supports.",
+					"parameters": []any{paneID},
+					"responses": map[string]any{
+						"200": openAPIResponse("The pane.", doc, "application/json", "text/html"),
+						"404": openAPIError("No such pane."),
+						"503": openAPIError("tmux state is not synced yet; see Retry-After."),
+					},
+				},
+			},
+			"/api/contents/{pane_id}": map[string]any{
+				"get": map[string]any{
+					"summary": "Capture a pane's contents.",
+					"parameters": []any{
+						paneID,
+						openAPIFlag("escapes", "Include escape sequences."),
+						openAPIQuery("sanitize", "Which escape sequences to keep with escapes.", map[string]any{
+							"type": "string", "enum": []string{sanitizeAll, sanitizeSGR, sanitizeNone},
+						}),
+						openAPIFlag("join", "Join wrapped lines (capture-pane -J)."),
+						openAPIFlag("since_mark", "Capture from the pane's mark."),
+						openAPIFlag("trim", "Remove trailing spaces from each row."),
+						openAPIFlag("pad", "Pad with blank rows to the pane height."),
+						openAPIFlag("trailing_newline", "End the last row with a newline (default true)."),
+						openAPIQuery("grep", "Return only matching rows, grep -C style.", map[string]any{"type": "string", "minLength": 1}),
+						openAPIFlag("regex", "Treat grep as a Go regular expression."),
+						openAPIQuery("context", "Rows of context around each grep match.", map[string]any{
+							"type": "integer", "minimum": 0, "maximum": maxGrepContext,
+						}),
+					},
+					"responses": map[string]any{
+						"200": map[string]any{
+							"description": "The capture, or with grep the matching blocks; JSON only for grep with Accept: application/json.",
+							"content": map[string]any{
+								"text/plain":       map[string]any{"schema": map[string]any{"type": "string"}},
+								"application/json": map[string]any{"schema": grep},
+							},
+						},
+						"400": openAPIError("A query parameter is invalid."),
+						"404": openAPIError("No such pane."),
+						"409": openAPIError("since_mark without a mark."),
+						"413": openAPIError("The capture exceeds --max-capture-bytes."),
+						"502": openAPIError("tmux failed to capture the pane."),
+						"503": openAPIError("tmux is not connected or not synced; see Retry-After."),
+					},
+				},
+			},
+		},
+		"components": map[string]any{"schemas": schemas},
+	}
+}
+
+func serveAPIOpenAPI(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(openAPIDocument(basePathFrom(r)))
+}
+
+func openAPIQuery(name, description string, schema map[string]any) map[string]any {
+	return map[string]any{"name": name, "in": "query", "description": description, "schema": schema}
+}
+
+// openAPIFlag describes a query flag read by parseQueryFlag.
+func openAPIFlag(name, description string) map[string]any {
+	return openAPIQuery(name, description, map[string]any{
+		"type": "string", "enum": []string{"1", "true", "yes", "0", "false", "no"},
+	})
+}
+
+func openAPIResponse(description string, schema map[string]any, contentTypes ...string) map[string]any {
+	content := map[string]any{}
+	for _, ct := range contentTypes {
+		if ct == "text/html" {
+			content[ct] = map[string]any{"schema": map[string]any{"type": "string"}}
+			continue
+		}
+		content[ct] = map[string]any{"schema": schema}
+	}
+	return map[string]any{"description": description, "content": content}
+}
+
+func openAPIError(description string) map[string]any {
+	return map[string]any{
+		"description": description,
+		"content":     map[string]any{"text/plain": map[string]any{"schema": map[string]any{"type": "string"}}},
+	}
+}
+
+// jsonSchemaFor describes values of t as encoding/json marshals them. Named
+// struct types are added to schemas under their capitalized Go name and
+// referenced with $ref. Fields without omitempty are required.
+func jsonSchemaFor(t reflect.Type, schemas map[string]any) map[string]any {
+	switch t.Kind() {
+	case reflect.Pointer:
+		return jsonSchemaFor(t.Elem(), schemas)
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]any{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number"}
+	case reflect.Slice, reflect.Array:
+		return map[string]any{"type": "array", "items": jsonSchemaFor(t.Elem(), schemas)}
+	case reflect.Map:
+		return map[string]any{"type": "object", "additionalProperties": jsonSchemaFor(t.Elem(), schemas)}
+	case reflect.Struct:
+		name := capitalize(t.Name())
+		if _, ok := schemas[name]; !ok {
+			schemas[name] = nil // placeholder, in case t refers to itself
+			props := map[string]any{}
+			required := []string{}
+			for i := 0; i < t.NumField(); i++ {
+				f := t.Field(i)
+				tag := f.Tag.Get("json")
+				if !f.IsExported() || tag == "-" {
+					continue
+				}
+				field, opts, _ := strings.Cut(tag, ",")
+				if field == "" {
+					field = f.Name
+				}
+				props[field] = jsonSchemaFor(f.Type, schemas)
+				if !strings.Contains(opts, "omitempty") {
+					required = append(required, field)
+				}
+			}
+			schema := map[string]any{"type": "object", "properties": props}
+			if len(required) > 0 {
+				schema["required"] = required
+			}
+			schemas[name] = schema
+		}
+		return map[string]any{"$ref": "#/components/schemas/" + name}
+	}
+	// Interfaces such as hypermediaAction.Schema hold any JSON value.
+	return map[string]any{}
+}
+
+func capitalize(s string) string {
+	r, n := utf8.DecodeRuneInString(s)
+	return string(unicode.ToUpper(r)) + s[n:]
+}
@@ -0,0 +1,55 @@
+package httpd
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+
+	"github.com/ampcode/wmux/internal/policy"
+	"github.com/ampcode/wmux/internal/wshub"
+)
+
+type setOptionRequest struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// serveAPIOptions lists the settable tmux options (GET) or sets one on the
+// target session (POST {"name","value"}). Only options in settable can be
+// changed, and only to values that pass its validation.
+func serveAPIOptions(w http.ResponseWriter, r *http.Request, hub *wshub.Hub, settable policy.OptionPolicy) {
+	switch r.Method {
+	case http.MethodGet:
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"settable": settable.Allowed(),
+		})
+		return
+	case http.MethodPost:
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req setOptionRequest
+	dec := json.NewDecoder(io.LimitReader(r.Body, 1<<20))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&req); err != nil {
+		http.Error(w, "invalid json", http.StatusBadRequest)
+		return
+	}
+	if err := dec.Decode(&struct{}{}); err != io.EOF {
+		http.Error(w, "invalid json", http.StatusBadRequest)
+		return
+	}
+	value, err := settable.Validate(req.Name, req.Value)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := hub.SetSessionOption(r.Context(), req.Name, value); err != nil {
+		writeTmuxError(w, hub, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,177 @@
+package httpd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/ampcode/wmux/internal/castrec"
+	"github.com/ampcode/wmux/internal/wshub"
+)
+
+// recordingBuffer is the output subscription depth for a recording. A
+// recording that falls further behind than this is stopped.
+const recordingBuffer = 4096
+
+var (
+	errRecordingDisabled = errors.New("recording disabled; start wmux with --record-dir")
+	errAlreadyRecording  = errors.New("pane is already being recorded")
+)
+
+// paneRecorder tracks active asciinema recordings, at most one per pane.
+type paneRecorder struct {
+	dir string
+	hub *wshub.Hub
+
+	mu     sync.Mutex
+	active map[string]*paneRecording
+}
+
+type paneRecording struct {
+	PaneID    string `json:"pane_id"`
+	Path      string `json:"path"`
+	StartedAt string `json:"started_at"`
+
+	cancel func()
+	done   chan struct{}
+}
+
+type startRecordingRequest struct {
+	Path string `json:"path"`
+}
+
+func newPaneRecorder(dir string, hub *wshub.Hub) *paneRecorder {
+	return &paneRecorder{dir: dir, hub: hub, active: map[string]*paneRecording{}}
+}
+
+// start begins recording pane output to name inside the record directory.
+// An empty name picks a timestamped default.
+func (pr *paneRecorder) start(pane wshub.PaneInfo, name string) (*paneRecording, error) {
+	if pr.dir == "" {
+		return nil, errRecordingDisabled
+	}
+	now := time.Now()
+	if name == "" {
+		name = fmt.Sprintf("pane-%s-%s.cast", pane.PaneID, now.UTC().Format("20060102T150405Z"))
+	}
+	if !filepath.IsLocal(name) {
+		return nil, fmt.Errorf("recording path must be relative to the record directory")
+	}
+	path := filepath.Join(pr.dir, name)
+
+	pr.mu.Lock()
+	defer pr.mu.Unlock()
+	if _, ok := pr.active[pane.PaneID]; ok {
+		return nil, errAlreadyRecording
+	}
+
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	cw, err := castrec.NewWriter(f, castrec.Header{
+		Width:  pane.Width,
+		Height: pane.Height,
+		Title:  recordingTitlePrefix + pane.PaneID,
+	}, now)
+	if err != nil {
+		_ = f.Close()
+		_ = os.Remove(path)
+		return nil, err
+	}
+
+	chunks, cancel := pr.hub.SubscribePaneOutput(recordingBuffer)
+	rec := &paneRecording{
+		PaneID:    pane.PaneID,
+		Path:      path,
+		StartedAt: now.UTC().Format(time.RFC3339Nano),
+		cancel:    cancel,
+		done:      make(chan struct{}),
+	}
+	pr.active[pane.PaneID] = rec
+	go pr.run(rec, chunks, cw, f)
+	return rec, nil
+}
+
+func (pr *paneRecorder) run(rec *paneRecording, chunks <-chan wshub.PaneOutput, cw *castrec.Writer, f io.Closer) {
+	defer close(rec.done)
+	defer func() { _ = f.Close() }()
+	defer pr.forget(rec)
+	for chunk := range chunks {
+		if chunk.PaneID != rec.PaneID {
+			continue
+		}
+		if err := cw.WriteOutput(time.Now(), chunk.Data); err != nil {
+			rec.cancel()
+			return
+		}
+	}
+}
+
+func (pr *paneRecorder) forget(rec *paneRecording) {
+	pr.mu.Lock()
+	defer pr.mu.Unlock()
+	if pr.active[rec.PaneID] == rec {
+		delete(pr.active, rec.PaneID)
+	}
+}
+
+// stop ends the recording for paneID and waits for the file to be closed.
+func (pr *paneRecorder) stop(paneID string) (*paneRecording, bool) {
+	pr.mu.Lock()
+	rec, ok := pr.active[paneID]
+	pr.mu.Unlock()
+	if !ok {
+		return nil, false
+	}
+	rec.cancel()
+	<-rec.done
+	return rec, true
+}
+
+func serveAPIPaneRecord(w http.ResponseWriter, r *http.Request, hub *wshub.Hub, recorder *paneRecorder, paneID string) {
+	switch r.Method {
+	case http.MethodPost:
+		pane, found := targetSessionPaneByPublicID(hub, paneID)
+		if !found {
+			http.Error(w, "pane not found", http.StatusNotFound)
+			return
+		}
+		var req startRecordingRequest
+		if err := json.NewDecoder(io.LimitReader(r.Body, 1<<20)).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+			http.Error(w, "invalid JSON body", http.StatusBadRequest)
+			return
+		}
+		rec, err := recorder.start(pane, req.Path)
+		switch {
+		case errors.Is(err, errRecordingDisabled):
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		case errors.Is(err, errAlreadyRecording):
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		case err != nil:
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		_ = json.NewEncoder(w).Encode(rec)
+	case http.MethodDelete:
+		rec, ok := recorder.stop(paneID)
+		if !ok {
+			http.Error(w, "pane is not being recorded", http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(rec)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,207 @@
+package httpd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"math"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ampcode/wmux/internal/castrec"
+	"github.com/gorilla/websocket"
+)
+
+// maxReplaySpeed caps the speed query parameter of recording playback.
+const maxReplaySpeed = 100
+
+// recordingTitlePrefix starts the cast header title of pane recordings;
+// playback reads the pane id back from it.
+const recordingTitlePrefix = "wmux pane "
+
+var replayUpgrader = websocket.Upgrader{
+	CheckOrigin: func(_ *http.Request) bool { return true },
+}
+
+// replayMsg mirrors the /ws server message envelope, so a client can feed
+// playback to the same terminal code that renders live pane_output.
+type replayMsg struct {
+	T          string         `json:"t"`
+	Message    string         `json:"message,omitempty"`
+	Replay     *replayInfo    `json:"replay,omitempty"`
+	PaneOutput *replayPayload `json:"pane_output,omitempty"`
+}
+
+type replayInfo struct {
+	Name   string  `json:"name"`
+	PaneID string  `json:"pane_id"`
+	Width  int     `json:"width"`
+	Height int     `json:"height"`
+	Title  string  `json:"title,omitempty"`
+	Speed  float64 `json:"speed"`
+	Seek   float64 `json:"seek"`
+}
+
+type replayPayload struct {
+	PaneID string `json:"pane_id"`
+	Data   string `json:"data"`
+}
+
+// serveAPIRecordings serves GET /api/recordings/{name}/play, a WebSocket
+// that plays a .cast file from the record directory back as pane_output
+// messages at the recorded pace divided by speed.
+func serveAPIRecordings(w http.ResponseWriter, r *http.Request, dir string) {
+	name, ok := strings.CutSuffix(strings.TrimPrefix(r.URL.Path, "/api/recordings/"), "/play")
+	if !ok || name == "" {
+		http.NotFound(w, r)
+		return
+	}
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if dir == "" {
+		http.Error(w, errRecordingDisabled.Error(), http.StatusForbidden)
+		return
+	}
+	if !filepath.IsLocal(name) {
+		http.Error(w, "recording name must be relative to the record directory", http.StatusBadRequest)
+		return
+	}
+	speed, seek, err := parseReplayParams(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	f, err := os.Open(filepath.Join(dir, name))
+	if errors.Is(err, fs.ErrNotExist) {
+		http.Error(w, "recording not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer f.Close()
+	cast, err := castrec.NewReader(f)
+	if err != nil {
+		http.Error(w, "invalid cast file: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	conn, err := replayUpgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	// The client sends nothing; reading only notices it going away.
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+	go func() {
+		defer cancel()
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	paneID, _ := strings.CutPrefix(cast.Header.Title, recordingTitlePrefix)
+	info := &replayInfo{
+		Name:   name,
+		PaneID: paneID,
+		Width:  cast.Header.Width,
+		Height: cast.Header.Height,
+		Title:  cast.Header.Title,
+		Speed:  speed,
+		Seek:   seek,
+	}
+	if err := conn.WriteJSON(replayMsg{T: "replay_start", Replay: info}); err != nil {
+		return
+	}
+	if err := playCast(ctx, conn, cast, paneID, speed, seek); err != nil {
+		if ctx.Err() != nil {
+			return
+		}
+		_ = conn.WriteJSON(replayMsg{T: "error", Message: err.Error()})
+	} else {
+		_ = conn.WriteJSON(replayMsg{T: "replay_end"})
+	}
+	_ = conn.WriteControl(websocket.CloseMessage,
+		websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""),
+		time.Now().Add(time.Second))
+}
+
+// playCast writes the output events of cast to conn. Output before seek is
+// sent at once as a single message, so the terminal reaches the state it had
+// at seek; later events are delayed by their recorded gaps divided by speed.
+func playCast(ctx context.Context, conn *websocket.Conn, cast *castrec.Reader, paneID string, speed, seek float64) error {
+	var skipped strings.Builder
+	flush := func() error {
+		if skipped.Len() == 0 {
+			return nil
+		}
+		defer skipped.Reset()
+		return conn.WriteJSON(replayMsg{T: "pane_output", PaneOutput: &replayPayload{PaneID: paneID, Data: skipped.String()}})
+	}
+
+	last := seek
+	for {
+		ev, err := cast.Next()
+		if errors.Is(err, io.EOF) {
+			return flush()
+		}
+		if err != nil {
+			return err
+		}
+		if ev.Type != "o" {
+			continue
+		}
+		if ev.Time < seek {
+			skipped.WriteString(ev.Data)
+			continue
+		}
+		if err := flush(); err != nil {
+			return err
+		}
+		if gap := ev.Time - last; gap > 0 {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(time.Duration(gap / speed * float64(time.Second))):
+			}
+			last = ev.Time
+		}
+		if err := conn.WriteJSON(replayMsg{T: "pane_output", PaneOutput: &replayPayload{PaneID: paneID, Data: ev.Data}}); err != nil {
+			return err
+		}
+	}
+}
+
+// parseReplayParams reads speed (a multiplier, default 1) and seek (seconds
+// into the recording, default 0).
+func parseReplayParams(r *http.Request) (speed, seek float64, err error) {
+	q := r.URL.Query()
+	speed = 1
+	if raw := strings.TrimSpace(q.Get("speed")); raw != "" {
+		speed, err = strconv.ParseFloat(raw, 64)
+		if err != nil || !(speed > 0 && speed <= maxReplaySpeed) {
+			return 0, 0, fmt.Errorf("speed must be a number above 0 and at most %d", maxReplaySpeed)
+		}
+	}
+	if raw := strings.TrimSpace(q.Get("seek")); raw != "" {
+		seek, err = strconv.ParseFloat(raw, 64)
+		if err != nil || !(seek >= 0) || math.IsInf(seek, 1) {
+			return 0, 0, fmt.Errorf("seek must be a non-negative number of seconds")
+		}
+	}
+	return speed, seek, nil
+}
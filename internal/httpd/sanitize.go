@@ -0,0 +1,85 @@
+package httpd
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Values accepted by the sanitize query parameter of /api/contents.
+const (
+	sanitizeAll  = "all"
+	sanitizeSGR  = "sgr"
+	sanitizeNone = "none"
+)
+
+func parseSanitizeMode(raw string) (string, error) {
+	switch v := strings.ToLower(strings.TrimSpace(raw)); v {
+	case "":
+		return sanitizeAll, nil
+	case sanitizeAll, sanitizeSGR, sanitizeNone:
+		return v, nil
+	default:
+		return "", fmt.Errorf("sanitize must be one of: all, sgr, none")
+	}
+}
+
+// sanitizeEscapes filters escape sequences out of captured pane content.
+// sanitizeAll returns content unchanged, sanitizeSGR keeps only SGR
+// (CSI ... m) sequences, and sanitizeNone removes every escape sequence.
+// Unterminated sequences at the end of content are dropped.
+func sanitizeEscapes(content, mode string) string {
+	if mode == sanitizeAll || !strings.Contains(content, "\x1b") {
+		return content
+	}
+
+	var b strings.Builder
+	b.Grow(len(content))
+	for i := 0; i < len(content); {
+		if content[i] != '\x1b' {
+			b.WriteByte(content[i])
+			i++
+			continue
+		}
+		end, isSGR := escapeSequenceEnd(content, i)
+		if isSGR && mode == sanitizeSGR {
+			b.WriteString(content[i:end])
+		}
+		i = end
+	}
+	return b.String()
+}
+
+// escapeSequenceEnd returns the index just past the escape sequence that
+// starts at content[i] and whether it is an SGR sequence.
+func escapeSequenceEnd(content string, i int) (int, bool) {
+	if i+1 >= len(content) {
+		return len(content), false
+	}
+	switch content[i+1] {
+	case '[':
+		// CSI: parameter and intermediate bytes, then one final byte.
+		for j := i + 2; j < len(content); j++ {
+			if c := content[j]; c >= 0x40 && c <= 0x7e {
+				return j + 1, c == 'm'
+			}
+		}
+		return len(content), false
+	case ']', 'P', '_', '^', 'X':
+		// OSC, DCS, APC, PM and SOS run until ST (ESC \); OSC may also end
+		// with BEL.
+		for j := i + 2; j < len(content); j++ {
+			if content[j] == '\a' && content[i+1] == ']' {
+				return j + 1, false
+			}
+			if content[j] == '\x1b' && j+1 < len(content) && content[j+1] == '\\' {
+				return j + 2, false
+			}
+		}
+		return len(content), false
+	case '(', ')', '*', '+', '#', '%':
+		// Charset designation and similar: one intermediate plus a final byte.
+		return min(i+3, len(content)), false
+	default:
+		return i + 2, false
+	}
+}
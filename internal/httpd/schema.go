@@ -0,0 +1,181 @@
+package httpd
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"unicode/utf8"
+)
+
+// validateSchema checks v, a value decoded by encoding/json with UseNumber,
+// against schema. path names v in errors; "" is the request body. It
+// understands the subset of JSON Schema that wmux's advertised actions use:
+// type, properties, required, additionalProperties, propertyNames, items,
+// minLength, maxLength and pattern. Other keywords are ignored, so a schema
+// must not rely on them for enforcement.
+func validateSchema(schema map[string]any, v any, path string) error {
+	if want, ok := schema["type"].(string); ok {
+		if !schemaTypeMatches(want, v) {
+			return fmt.Errorf("%s: must be %s", schemaPathLabel(path), schemaTypeName(want))
+		}
+	}
+
+	switch x := v.(type) {
+	case map[string]any:
+		return validateSchemaObject(schema, x, path)
+	case []any:
+		if items, ok := schema["items"].(map[string]any); ok {
+			for i, item := range x {
+				if err := validateSchema(items, item, fmt.Sprintf("%s[%d]", path, i)); err != nil {
+					return err
+				}
+			}
+		}
+	case string:
+		return validateSchemaString(schema, x, path)
+	}
+	return nil
+}
+
+func validateSchemaObject(schema map[string]any, obj map[string]any, path string) error {
+	for _, name := range schemaStrings(schema["required"]) {
+		if _, ok := obj[name]; !ok {
+			return fmt.Errorf("%s is required", joinSchemaPath(path, name))
+		}
+	}
+
+	// Walk keys in order so the reported error does not depend on map
+	// iteration.
+	keys := make([]string, 0, len(obj))
+	for k := range obj {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	properties, _ := schema["properties"].(map[string]any)
+	names, _ := schema["propertyNames"].(map[string]any)
+	for _, k := range keys {
+		child := joinSchemaPath(path, k)
+		if names != nil {
+			if err := validateSchema(names, k, "property name"); err != nil {
+				return fmt.Errorf("%s: %w", child, err)
+			}
+		}
+		if sub, ok := properties[k].(map[string]any); ok {
+			if err := validateSchema(sub, obj[k], child); err != nil {
+				return err
+			}
+			continue
+		}
+		switch extra := schema["additionalProperties"].(type) {
+		case bool:
+			if !extra {
+				return fmt.Errorf("%s: unknown field", child)
+			}
+		case map[string]any:
+			if err := validateSchema(extra, obj[k], child); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func validateSchemaString(schema map[string]any, s, path string) error {
+	n := utf8.RuneCountInString(s)
+	if lo, ok := schemaInt(schema["minLength"]); ok && n < lo {
+		return fmt.Errorf("%s: must be at least %d characters", schemaPathLabel(path), lo)
+	}
+	if hi, ok := schemaInt(schema["maxLength"]); ok && n > hi {
+		return fmt.Errorf("%s: must be at most %d characters", schemaPathLabel(path), hi)
+	}
+	if pattern, ok := schema["pattern"].(string); ok {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return fmt.Errorf("%s: invalid schema pattern %q: %w", schemaPathLabel(path), pattern, err)
+		}
+		if !re.MatchString(s) {
+			return fmt.Errorf("%s: must match %s", schemaPathLabel(path), pattern)
+		}
+	}
+	return nil
+}
+
+func schemaTypeMatches(want string, v any) bool {
+	switch want {
+	case "object":
+		_, ok := v.(map[string]any)
+		return ok
+	case "array":
+		_, ok := v.([]any)
+		return ok
+	case "string":
+		_, ok := v.(string)
+		return ok
+	case "boolean":
+		_, ok := v.(bool)
+		return ok
+	case "number":
+		_, ok := v.(json.Number)
+		return ok
+	case "integer":
+		n, ok := v.(json.Number)
+		if !ok {
+			return false
+		}
+		_, err := n.Int64()
+		return err == nil
+	case "null":
+		return v == nil
+	}
+	return true
+}
+
+func schemaTypeName(t string) string {
+	switch t {
+	case "object", "array", "integer":
+		return "an " + t
+	}
+	return "a " + t
+}
+
+func schemaStrings(v any) []string {
+	switch x := v.(type) {
+	case []string:
+		return x
+	case []any:
+		out := make([]string, 0, len(x))
+		for _, s := range x {
+			if s, ok := s.(string); ok {
+				out = append(out, s)
+			}
+		}
+		return out
+	}
+	return nil
+}
+
+func schemaInt(v any) (int, bool) {
+	switch x := v.(type) {
+	case int:
+		return x, true
+	case float64:
+		return int(x), true
+	}
+	return 0, false
+}
+
+func schemaPathLabel(path string) string {
+	if path == "" {
+		return "request body"
+	}
+	return path
+}
+
+func joinSchemaPath(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
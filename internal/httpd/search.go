@@ -0,0 +1,116 @@
+package httpd
+
+import (
+	"encoding/json"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/ampcode/wmux/internal/wshub"
+)
+
+const (
+	// maxSearchResults caps the matches returned by /api/search; the limit
+	// query parameter can only lower it.
+	maxSearchResults = 500
+	// searchConcurrency bounds the captures in flight for one search so a
+	// large session does not flood the control client.
+	searchConcurrency = 4
+)
+
+type searchMatch struct {
+	PaneID string `json:"pane_id"`
+	Line   int    `json:"line"`
+	Text   string `json:"text"`
+}
+
+type searchPaneError struct {
+	PaneID string `json:"pane_id"`
+	Error  string `json:"error"`
+}
+
+type searchResult struct {
+	Query     string            `json:"query"`
+	Regex     bool              `json:"regex"`
+	Matches   []searchMatch     `json:"matches"`
+	Truncated bool              `json:"truncated"`
+	Errors    []searchPaneError `json:"errors,omitempty"`
+}
+
+// serveAPISearch captures every target-session pane and returns the lines
+// matching q, as a substring or, with regex=1, a regular expression. Matches
+// are ordered by pane and then line, with 1-based line numbers.
+func serveAPISearch(w http.ResponseWriter, r *http.Request, hub *wshub.Hub) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	query := r.URL.Query().Get("q")
+	if query == "" {
+		http.Error(w, "q is required", http.StatusBadRequest)
+		return
+	}
+	useRegex := parseQueryFlag(r, "regex")
+	match := func(line string) bool { return strings.Contains(line, query) }
+	if useRegex {
+		re, err := regexp.Compile(query)
+		if err != nil {
+			http.Error(w, "invalid regex: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		match = re.MatchString
+	}
+	limit := maxSearchResults
+	if raw := strings.TrimSpace(r.URL.Query().Get("limit")); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n <= 0 {
+			http.Error(w, "limit must be a positive integer", http.StatusBadRequest)
+			return
+		}
+		limit = min(n, maxSearchResults)
+	}
+	withEscapes := parseEscapesFlag(r)
+
+	panes := hub.CurrentTargetSessionPaneInfos()
+	contents := make([]string, len(panes))
+	errs := make([]error, len(panes))
+	sem := make(chan struct{}, searchConcurrency)
+	var wg sync.WaitGroup
+	for i, pane := range panes {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+			contents[i], errs[i] = hub.CapturePaneContent(r.Context(), pane.TmuxPaneID, withEscapes)
+		}()
+	}
+	wg.Wait()
+
+	res := searchResult{Query: query, Regex: useRegex, Matches: []searchMatch{}}
+	for i, pane := range panes {
+		if errs[i] != nil {
+			res.Errors = append(res.Errors, searchPaneError{PaneID: pane.PaneID, Error: errs[i].Error()})
+			continue
+		}
+		for n, line := range strings.Split(contents[i], "\n") {
+			if !match(line) {
+				continue
+			}
+			if len(res.Matches) == limit {
+				res.Truncated = true
+				break
+			}
+			res.Matches = append(res.Matches, searchMatch{PaneID: pane.PaneID, Line: n + 1, Text: line})
+		}
+		if res.Truncated {
+			break
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(res)
+}
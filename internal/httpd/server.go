@@ -1,41 +1,175 @@
 package httpd
 
 import (
+	"bufio"
+	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"hash/fnv"
 	"html/template"
 	"io"
 	"io/fs"
+	"log/slog"
+	"mime"
+	"net"
 	"net/http"
 	"net/url"
 	"os"
+	"path"
 	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
 
 	"github.com/ampcode/wmux/internal/assets"
+	"github.com/ampcode/wmux/internal/policy"
+	"github.com/ampcode/wmux/internal/reqid"
+	"github.com/ampcode/wmux/internal/termimg"
 	"github.com/ampcode/wmux/internal/wshub"
 )
 
 type Config struct {
-	StaticDir   string
-	Hub         *wshub.Hub
-	DefaultTerm string
+	StaticDir    string
+	Hub          *wshub.Hub
+	DefaultTerm  string
+	ClientBuffer int
+	// MaxMessageBytes caps inbound WebSocket frames; 0 uses the hub default.
+	MaxMessageBytes int64
+	// MaxClients caps concurrent WebSocket connections; 0 is unlimited.
+	MaxClients int
+	// WSMaxAge closes WebSocket connections after this long so clients
+	// reconnect; 0 disables it.
+	WSMaxAge time.Duration
+	// CreatePaneTimeout bounds a POST /api/panes request; 0 uses
+	// DefaultCreatePaneTimeout.
+	CreatePaneTimeout time.Duration
+	// MaxCreateEnv caps the number of env entries a POST /api/panes body
+	// may carry, and MaxCreateEnvValue the bytes in each value; 0 uses
+	// DefaultMaxCreateEnv and DefaultMaxCreateEnvValue.
+	MaxCreateEnv      int
+	MaxCreateEnvValue int
+	// ImageFont and ImageCellWidth/ImageCellHeight control
+	// /api/panes/{id}/image rendering; zero values use the builtin font at
+	// twice its cell size.
+	ImageFont       *termimg.Font
+	ImageCellWidth  int
+	ImageCellHeight int
+	// SettableOptions lists the tmux options POST /api/options may change.
+	// The zero value allows none.
+	SettableOptions policy.OptionPolicy
+	// RecordDir is where pane recordings are written. Recording is
+	// disabled when empty.
+	RecordDir string
+	// Logger receives per-request debug logs. Requests are not logged when
+	// nil.
+	Logger *slog.Logger
+	// StartedAt is reported as the server start time by /api/info; zero
+	// uses the time NewServer is called.
+	StartedAt time.Time
+	// TmuxConnectedSince reports when the current tmux control connection
+	// was established, for /api/info. Nil reports no connection.
+	TmuxConnectedSince func() (time.Time, bool)
+	// CORSOrigins lists the browser origins allowed to call /api/ routes
+	// cross-origin (see ParseCORSOrigins). Empty sends no CORS headers.
+	CORSOrigins []string
+	// BasePath is the normalized path prefix (see ParseBasePath) every
+	// route is served under, for reverse proxies that mount wmux on a
+	// subpath. Empty serves from the root.
+	BasePath string
+	// EnableRawWS serves GET /ws/raw (see wshub.Hub.RawWSHandler). It
+	// bypasses the command policy, so it is off unless --enable-debug is
+	// set.
+	EnableRawWS bool
+	// EnableDebug serves the /api/debug/ endpoints that expose hub
+	// internals: pending, output-latency and parser-queue.
+	EnableDebug bool
 }
 
+// DefaultCreatePaneTimeout is used when Config.CreatePaneTimeout is unset.
+const DefaultCreatePaneTimeout = 10 * time.Second
+
+// DefaultMaxCreateEnv and DefaultMaxCreateEnvValue are used when
+// Config.MaxCreateEnv and Config.MaxCreateEnvValue are unset. Each entry
+// becomes a split-window -e argument, so these keep the command line well
+// below what tmux and exec accept.
+const (
+	DefaultMaxCreateEnv      = 128
+	DefaultMaxCreateEnvValue = 16 << 10
+)
+
 func NewServer(cfg Config) (http.Handler, error) {
 	defaultTerm := normalizeDefaultTerm(cfg.DefaultTerm)
+	createTimeout := cfg.CreatePaneTimeout
+	if createTimeout <= 0 {
+		createTimeout = DefaultCreatePaneTimeout
+	}
+	envCaps := createEnvLimits{maxVars: cfg.MaxCreateEnv, maxValueBytes: cfg.MaxCreateEnvValue}
+	if envCaps.maxVars <= 0 {
+		envCaps.maxVars = DefaultMaxCreateEnv
+	}
+	if envCaps.maxValueBytes <= 0 {
+		envCaps.maxValueBytes = DefaultMaxCreateEnvValue
+	}
+
+	if cfg.StartedAt.IsZero() {
+		cfg.StartedAt = time.Now()
+	}
+
+	recorder := newPaneRecorder(cfg.RecordDir, cfg.Hub)
+	marks := newPaneMarks()
+	createKeys := newCreatePaneKeys()
+	imageOpts := termimg.Options{Font: cfg.ImageFont, CellWidth: cfg.ImageCellWidth, CellHeight: cfg.ImageCellHeight}
 
 	mux := http.NewServeMux()
-	mux.HandleFunc("/ws", cfg.Hub.HandleWS)
+	mux.HandleFunc("/ws", longLived(cfg.Hub.WSHandler(wshub.WSConfig{
+		ClientBuffer:    cfg.ClientBuffer,
+		MaxMessageBytes: cfg.MaxMessageBytes,
+		MaxClients:      cfg.MaxClients,
+		MaxAge:          cfg.WSMaxAge,
+	})))
+	if cfg.EnableRawWS {
+		mux.HandleFunc("/ws/raw", longLived(cfg.Hub.RawWSHandler()))
+	}
 	mux.HandleFunc("/api/state", func(w http.ResponseWriter, r *http.Request) { serveAPIState(w, r, cfg.Hub, defaultTerm) })
 	mux.HandleFunc("/api/state.json", func(w http.ResponseWriter, r *http.Request) { serveAPIState(w, r, cfg.Hub, defaultTerm) })
+	mux.HandleFunc("/api/openapi.json", serveAPIOpenAPI)
 	mux.HandleFunc("/api/state.html", func(w http.ResponseWriter, r *http.Request) { serveAPIState(w, r, cfg.Hub, defaultTerm) })
-	mux.HandleFunc("/api/contents/", func(w http.ResponseWriter, r *http.Request) { serveAPIContents(w, r, cfg.Hub) })
-	mux.HandleFunc("/api/panes/", func(w http.ResponseWriter, r *http.Request) { serveAPIPane(w, r, cfg.Hub, defaultTerm) })
-	mux.HandleFunc("/api/panes", func(w http.ResponseWriter, r *http.Request) { serveAPIPanes(w, r, cfg.Hub, defaultTerm) })
+	mux.HandleFunc("/api/contents/", func(w http.ResponseWriter, r *http.Request) { serveAPIContents(w, r, cfg.Hub, marks) })
+	mux.HandleFunc("/api/panes/", func(w http.ResponseWriter, r *http.Request) {
+		serveAPIPane(w, r, cfg.Hub, recorder, marks, imageOpts, defaultTerm)
+	})
+	mux.HandleFunc("/api/panes/keys", func(w http.ResponseWriter, r *http.Request) { serveAPIPanesKeys(w, r, cfg.Hub) })
+	mux.HandleFunc("/api/recordings/", longLived(func(w http.ResponseWriter, r *http.Request) { serveAPIRecordings(w, r, cfg.RecordDir) }))
+	mux.HandleFunc("/api/windows/", func(w http.ResponseWriter, r *http.Request) { serveAPIWindow(w, r, cfg.Hub, defaultTerm) })
+	mux.HandleFunc("/api/panes", func(w http.ResponseWriter, r *http.Request) {
+		serveAPIPanes(w, r, cfg.Hub, defaultTerm, createTimeout, envCaps, createKeys)
+	})
+	mux.HandleFunc("/api/buffers", func(w http.ResponseWriter, r *http.Request) { serveAPIBuffers(w, r, cfg.Hub) })
+	mux.HandleFunc("/api/options", func(w http.ResponseWriter, r *http.Request) { serveAPIOptions(w, r, cfg.Hub, cfg.SettableOptions) })
+	mux.HandleFunc("/api/search", func(w http.ResponseWriter, r *http.Request) { serveAPISearch(w, r, cfg.Hub) })
+	mux.HandleFunc("/api/output", longLived(func(w http.ResponseWriter, r *http.Request) { serveAPIOutput(w, r, cfg.Hub) }))
+	mux.HandleFunc("/api/client", func(w http.ResponseWriter, r *http.Request) { serveAPIClient(w, r, cfg.Hub) })
+	mux.HandleFunc("/api/client/size", func(w http.ResponseWriter, r *http.Request) { serveAPIClientSize(w, r, cfg.Hub) })
+	mux.HandleFunc("/api/policy", func(w http.ResponseWriter, r *http.Request) { serveAPIPolicy(w, r, cfg.Hub) })
+	mux.HandleFunc("/api/info", func(w http.ResponseWriter, r *http.Request) {
+		serveAPIInfo(w, r, cfg.Hub, cfg.StartedAt, cfg.TmuxConnectedSince)
+	})
+	mux.HandleFunc("/api/admin/clients", func(w http.ResponseWriter, r *http.Request) { serveAPIAdminClients(w, r, cfg.Hub) })
+	mux.HandleFunc("/api/admin/hide-pane", func(w http.ResponseWriter, r *http.Request) { serveAPIAdminHidePane(w, r, cfg.Hub) })
+	mux.HandleFunc("/api/admin/unhide-pane", func(w http.ResponseWriter, r *http.Request) { serveAPIAdminUnhidePane(w, r, cfg.Hub) })
+	mux.HandleFunc("/api/admin/kill-session", func(w http.ResponseWriter, r *http.Request) { serveAPIAdminKillSession(w, r, cfg.Hub) })
 	mux.HandleFunc("/api/debug/unicode", func(w http.ResponseWriter, r *http.Request) { serveAPIDebugUnicode(w, r, cfg.Hub) })
+	if cfg.EnableDebug {
+		mux.HandleFunc("/api/debug/parse-errors", func(w http.ResponseWriter, r *http.Request) { serveAPIDebugParseErrors(w, r, cfg.Hub) })
+		mux.HandleFunc("/api/debug/pending", func(w http.ResponseWriter, r *http.Request) { serveAPIDebugPending(w, r, cfg.Hub) })
+		mux.HandleFunc("/api/debug/output-latency", func(w http.ResponseWriter, r *http.Request) { serveAPIDebugOutputLatency(w, r, cfg.Hub) })
+		mux.HandleFunc("/api/debug/parser-queue", func(w http.ResponseWriter, r *http.Request) { serveAPIDebugParserQueue(w, r, cfg.Hub) })
+	}
 	mux.HandleFunc("/p", func(w http.ResponseWriter, r *http.Request) {
 		if redirectURL, ok := ensureTermQuery(r, defaultTerm); ok {
 			http.Redirect(w, r, redirectURL, http.StatusFound)
@@ -62,53 +196,281 @@ func NewServer(cfg Config) (http.Handler, error) {
 		}
 		staticHandler.ServeHTTP(w, r)
 	}))
-	return mux, nil
+	var handler http.Handler = mux
+	if len(cfg.CORSOrigins) > 0 {
+		handler = allowCORS(cfg.CORSOrigins, handler)
+	}
+	if cfg.BasePath != "" {
+		handler = mountAtBasePath(cfg.BasePath, handler)
+	}
+	if cfg.Logger != nil {
+		handler = logRequests(cfg.Logger, handler)
+	}
+	return assignRequestIDs(handler), nil
+}
+
+// assignRequestIDs gives each request an id, the inbound X-Request-ID when
+// it is valid or a fresh one otherwise. The id is echoed in the response
+// header and stored in the request context for reqid.Logger, which also
+// tags the tmux commands the request triggers.
+func assignRequestIDs(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(reqid.Header)
+		if !reqid.Valid(id) {
+			id = reqid.New()
+		}
+		w.Header().Set(reqid.Header, id)
+		next.ServeHTTP(w, r.WithContext(reqid.NewContext(r.Context(), id)))
+	})
+}
+
+// logRequests logs each request at debug level once the handler returns.
+func logRequests(logger *slog.Logger, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !logger.Enabled(r.Context(), slog.LevelDebug) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rec, r)
+		reqid.Logger(r.Context(), logger).Debug("http request", "method", r.Method, "path", r.URL.Path, "status", rec.status, "duration", time.Since(start), "remote_addr", r.RemoteAddr)
+	})
+}
+
+// longLived lifts the http.Server's read and write timeouts for handlers
+// whose responses stay open, such as WebSockets and /api/output. Those end
+// when the client goes away or the hub drops them, not on a deadline.
+func longLived(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		clearConnDeadlines(w)
+		next(w, r)
+	}
+}
+
+// clearConnDeadlines removes the connection's read and write deadlines.
+// Writers that cannot set deadlines, such as test recorders, are left alone.
+func clearConnDeadlines(w http.ResponseWriter) {
+	rc := http.NewResponseController(w)
+	_ = rc.SetReadDeadline(time.Time{})
+	_ = rc.SetWriteDeadline(time.Time{})
+}
+
+// statusRecorder captures the response status while still exposing the
+// Flusher and Hijacker interfaces that /api/output and /ws depend on.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (s *statusRecorder) WriteHeader(code int) {
+	s.status = code
+	s.ResponseWriter.WriteHeader(code)
+}
+
+func (s *statusRecorder) Flush() {
+	if f, ok := s.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (s *statusRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := s.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, fmt.Errorf("response does not support hijacking")
+	}
+	s.status = http.StatusSwitchingProtocols
+	return h.Hijack()
+}
+
+func (s *statusRecorder) Unwrap() http.ResponseWriter {
+	return s.ResponseWriter
 }
 
 func staticHandler(staticDir string) (http.Handler, error) {
 	if staticDir != "" {
-		return http.FileServer(http.Dir(staticDir)), nil
+		return precompressedHandler(os.DirFS(staticDir), http.FileServer(http.Dir(staticDir))), nil
 	}
 	sub, err := fs.Sub(assets.Web, "web")
 	if err != nil {
 		return nil, err
 	}
-	return http.FileServerFS(sub), nil
+	return precompressedHandler(sub, http.FileServerFS(sub)), nil
 }
 
-func serveIndex(w http.ResponseWriter, _ *http.Request, staticDir string) {
-	if staticDir != "" {
-		f, err := os.Open(filepath.Join(staticDir, "index.html"))
-		if err != nil {
-			http.Error(w, "index.html not found", http.StatusNotFound)
+// precompressedEncodings lists the sibling suffix for each content coding
+// precompressedHandler can serve, in order of preference on equal q-values.
+var precompressedEncodings = []struct {
+	coding string
+	suffix string
+}{
+	{"br", ".br"},
+	{"gzip", ".gz"},
+}
+
+// precompressedHandler serves a pre-compressed "<name>.br" or "<name>.gz"
+// sibling from fsys when the client accepts that coding, and otherwise falls
+// through to next. Among acceptable siblings the highest q-value wins.
+func precompressedHandler(fsys fs.FS, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			next.ServeHTTP(w, r)
 			return
 		}
-		defer f.Close()
-		w.Header().Set("Content-Type", "text/html; charset=utf-8")
-		_, _ = io.Copy(w, f)
-		return
+		name := strings.TrimPrefix(path.Clean("/"+r.URL.Path), "/")
+		if name == "" || strings.HasSuffix(r.URL.Path, "/") || isPrecompressedName(name) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		var (
+			best     precompressedSibling
+			bestQ    float64
+			siblings int
+		)
+		for _, enc := range precompressedEncodings {
+			sib, ok := openPrecompressedSibling(fsys, name+enc.suffix)
+			if !ok {
+				continue
+			}
+			siblings++
+			if q := acceptEncodingQ(r, enc.coding); q > bestQ {
+				if best.file != nil {
+					_ = best.file.Close()
+				}
+				best, bestQ = sib, q
+				best.coding = enc.coding
+				continue
+			}
+			_ = sib.file.Close()
+		}
+		if siblings > 0 {
+			w.Header().Add("Vary", "Accept-Encoding")
+		}
+		if best.file == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		defer best.file.Close()
+
+		if ctype := mime.TypeByExtension(path.Ext(name)); ctype != "" {
+			w.Header().Set("Content-Type", ctype)
+		}
+		w.Header().Set("Content-Encoding", best.coding)
+		http.ServeContent(w, r, name, best.info.ModTime(), best.content)
+	})
+}
+
+type precompressedSibling struct {
+	coding  string
+	file    fs.File
+	info    fs.FileInfo
+	content io.ReadSeeker
+}
+
+func openPrecompressedSibling(fsys fs.FS, name string) (precompressedSibling, bool) {
+	f, err := fsys.Open(name)
+	if err != nil {
+		return precompressedSibling{}, false
+	}
+	info, err := f.Stat()
+	content, seekable := f.(io.ReadSeeker)
+	if err != nil || !info.Mode().IsRegular() || !seekable {
+		_ = f.Close()
+		return precompressedSibling{}, false
+	}
+	return precompressedSibling{file: f, info: info, content: content}, true
+}
+
+func isPrecompressedName(name string) bool {
+	for _, enc := range precompressedEncodings {
+		if strings.HasSuffix(name, enc.suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+// acceptEncodingQ returns the q-value the request's Accept-Encoding gives
+// coding: its own entry if listed, else the "*" entry, else 0.
+func acceptEncodingQ(r *http.Request, coding string) float64 {
+	wildcard := 0.0
+	for _, part := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		name = strings.TrimSpace(name)
+		v := 1.0
+		if qs, ok := strings.CutPrefix(strings.ReplaceAll(strings.TrimSpace(params), " ", ""), "q="); ok {
+			parsed, err := strconv.ParseFloat(qs, 64)
+			if err != nil {
+				continue
+			}
+			v = parsed
+		}
+		switch {
+		case strings.EqualFold(name, coding):
+			return v
+		case name == "*":
+			wildcard = v
+		}
+	}
+	return wildcard
+}
+
+func serveIndex(w http.ResponseWriter, r *http.Request, staticDir string) {
+	var (
+		b   []byte
+		err error
+	)
+	if staticDir != "" {
+		b, err = os.ReadFile(filepath.Join(staticDir, "index.html"))
+	} else {
+		b, err = fs.ReadFile(assets.Web, "web/index.html")
 	}
-	b, err := fs.ReadFile(assets.Web, "web/index.html")
 	if err != nil {
 		http.Error(w, "index.html not found", http.StatusNotFound)
 		return
 	}
 	w.Header().Set("Content-Type", "text/html; charset=utf-8")
-	_, _ = w.Write(b)
+	_, _ = w.Write(rebaseIndex(b, basePathFrom(r)))
 }
 
 func serveAPIRoot(w http.ResponseWriter, r *http.Request, hub *wshub.Hub, defaultTerm string) {
-	_ = hub.RefreshState(750 * time.Millisecond)
-	doc := buildHypermediaDocument("/", hub.CurrentTargetSessionPaneInfos(), hub.CurrentUnavailableReason(), defaultTerm)
+	_ = hub.RefreshState(r.Context(), 750*time.Millisecond)
+	doc := buildHypermediaDocument("/", hub.CurrentTargetSessionPaneInfos(), hub.CurrentTargetSessionWindowInfos(), hub.CurrentUnavailableReason(), defaultTerm)
 	serveHypermediaDocument(w, r, doc)
 }
 
 func serveAPIState(w http.ResponseWriter, r *http.Request, hub *wshub.Hub, defaultTerm string) {
-	_ = hub.RefreshState(750 * time.Millisecond)
-	doc := buildHypermediaDocument(r.URL.Path, hub.CurrentTargetSessionPaneInfos(), hub.CurrentUnavailableReason(), defaultTerm)
+	_ = hub.RefreshState(r.Context(), 750*time.Millisecond)
+	panes, windows := hub.CurrentTargetSessionPaneInfos(), hub.CurrentTargetSessionWindowInfos()
+	doc := buildHypermediaDocument(r.URL.Path, panes, windows, hub.CurrentUnavailableReason(), defaultTerm)
+	if parseQueryFlag(r, "nested") && negotiateStateFormat(r) == "json" {
+		doc = nestPanesInWindows(doc, panes, windows)
+	}
 	serveHypermediaDocument(w, r, doc)
 }
 
+// nestPanesInWindows moves each pane of doc, built from panes and windows,
+// into its window's panes array, for clients that walk the tmux hierarchy
+// instead of joining the flat lists. Panes whose window is not listed stay
+// at the top level.
+func nestPanesInWindows(doc hypermediaDocument, panes []wshub.PaneInfo, windows []wshub.WindowInfo) hypermediaDocument {
+	windowIndex := make(map[string]int, len(windows))
+	for i, window := range windows {
+		windowIndex[window.TmuxWindowID] = i
+	}
+	flat := make([]paneDocument, 0)
+	for i, pane := range doc.Panes {
+		if wi, ok := windowIndex[panes[i].TmuxWindowID]; ok {
+			doc.Windows[wi].Panes = append(doc.Windows[wi].Panes, pane)
+			continue
+		}
+		flat = append(flat, pane)
+	}
+	doc.Panes = flat
+	return doc
+}
+
 type hypermediaLink struct {
 	Rel       string `json:"rel"`
 	Href      string `json:"href"`
@@ -137,15 +499,35 @@ type hypermediaAction struct {
 }
 
 type paneDocument struct {
-	PaneID      string           `json:"pane_id"`
-	PaneIndex   int              `json:"pane_index"`
-	Name        string           `json:"name"`
-	SessionName string           `json:"session_name"`
-	WindowIndex int              `json:"window_index"`
-	WindowName  string           `json:"window_name"`
-	Width       int              `json:"width"`
-	Height      int              `json:"height"`
-	Links       []hypermediaLink `json:"links,omitempty"`
+	PaneID       string            `json:"pane_id"`
+	PaneIndex    int               `json:"pane_index"`
+	Name         string            `json:"name"`
+	SessionName  string            `json:"session_name"`
+	WindowIndex  int               `json:"window_index"`
+	WindowName   string            `json:"window_name"`
+	Width        int               `json:"width"`
+	Height       int               `json:"height"`
+	Dead         bool              `json:"dead"`
+	DeadStatus   int               `json:"dead_status"`
+	PID          int               `json:"pid"`
+	StartCommand string            `json:"start_command"`
+	Extra        map[string]string `json:"extra,omitempty"`
+	// Cursor is only reported by POST /api/panes?cursor=1.
+	Cursor *wshub.PaneCursor `json:"cursor,omitempty"`
+	// Ready is only reported by POST /api/panes?wait_ready=1.
+	Ready *bool            `json:"ready,omitempty"`
+	Links []hypermediaLink `json:"links,omitempty"`
+}
+
+type windowDocument struct {
+	WindowID  string           `json:"window_id"`
+	Index     int              `json:"index"`
+	Name      string           `json:"name"`
+	Active    bool             `json:"active"`
+	PaneCount int              `json:"pane_count"`
+	Links     []hypermediaLink `json:"links,omitempty"`
+	// Panes is only filled by GET /api/state.json?nested=1.
+	Panes []paneDocument `json:"panes,omitempty"`
 }
 
 type unavailableDocument struct {
@@ -157,33 +539,72 @@ type hypermediaDocument struct {
 	DefaultTerm string               `json:"default_term"`
 	Links       []hypermediaLink     `json:"links"`
 	Actions     []hypermediaAction   `json:"actions,omitempty"`
+	Windows     []windowDocument     `json:"windows,omitempty"`
 	Panes       []paneDocument       `json:"panes"`
 	Unavailable *unavailableDocument `json:"unavailable,omitempty"`
 }
 
+// serveHypermediaDocument renders doc as HTML or JSON. The response carries
+// a weak ETag of the rendered body, and a matching If-None-Match gets 304.
 func serveHypermediaDocument(w http.ResponseWriter, r *http.Request, doc hypermediaDocument) {
-	format := negotiateStateFormat(r)
-	if format == "html" {
-		w.Header().Set("Content-Type", "text/html; charset=utf-8")
-		_ = stateHTMLTemplate.Execute(w, struct {
+	doc = rebaseDocument(doc, basePathFrom(r))
+	var body bytes.Buffer
+	contentType := "application/json"
+	if negotiateStateFormat(r) == "html" {
+		contentType = "text/html; charset=utf-8"
+		_ = stateHTMLTemplate.Execute(&body, struct {
 			Doc                   hypermediaDocument
 			CreatePaneRequestBody string
 		}{
 			Doc:                   doc,
 			CreatePaneRequestBody: "{\n  \"env\": {\"NAME\": \"value\"},\n  \"cwd\": \"/absolute/path\",\n  \"cmd\": [\"bash\", \"-lc\", \"echo hello\"]\n}",
 		})
+	} else {
+		_ = json.NewEncoder(&body).Encode(doc)
+	}
+
+	etag := weakETag(body.Bytes())
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Vary", "Accept")
+	if etagMatches(r.Header.Get("If-None-Match"), etag) {
+		w.WriteHeader(http.StatusNotModified)
 		return
 	}
+	w.Header().Set("Content-Type", contentType)
+	_, _ = w.Write(body.Bytes())
+}
 
-	w.Header().Set("Content-Type", "application/json")
-	_ = json.NewEncoder(w).Encode(doc)
+func weakETag(body []byte) string {
+	sum := fnv.New64a()
+	_, _ = sum.Write(body)
+	return fmt.Sprintf(`W/"%016x"`, sum.Sum64())
+}
+
+// etagMatches applies the weak comparison of If-None-Match: any listed tag
+// equal to etag, ignoring W/ prefixes, or "*".
+func etagMatches(ifNoneMatch, etag string) bool {
+	if ifNoneMatch == "" {
+		return false
+	}
+	want := strings.TrimPrefix(etag, "W/")
+	for _, tag := range strings.Split(ifNoneMatch, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag == "*" || strings.TrimPrefix(tag, "W/") == want {
+			return true
+		}
+	}
+	return false
 }
 
-func buildHypermediaDocument(selfPath string, panes []wshub.PaneInfo, unavailableReason string, defaultTerm string) hypermediaDocument {
+func buildHypermediaDocument(selfPath string, panes []wshub.PaneInfo, windows []wshub.WindowInfo, unavailableReason string, defaultTerm string) hypermediaDocument {
 	examplePaneID := "0"
 	if len(panes) > 0 {
 		examplePaneID = panes[0].PaneID
 	}
+	exampleWindowID := "0"
+	if len(windows) > 0 {
+		exampleWindowID = windows[0].WindowID
+	}
 
 	doc := hypermediaDocument{
 		Resource:    "wmux",
@@ -193,15 +614,26 @@ func buildHypermediaDocument(selfPath string, panes []wshub.PaneInfo, unavailabl
 			{Rel: "root", Href: "/", Method: "GET"},
 			{Rel: "state", Href: "/api/state.json", Method: "GET", Type: "application/json"},
 			{Rel: "state-html", Href: "/api/state.html", Method: "GET", Type: "text/html"},
+			{Rel: "openapi", Href: "/api/openapi.json", Method: "GET", Type: "application/json"},
 			{Rel: "pane", Href: "/p/{pane_id}{?term}", Method: "GET", Type: "text/html", Templated: true, Example: paneTargetHref(examplePaneID, defaultTerm)},
 			{Rel: "pane-resource", Href: "/api/panes/{pane_id}", Method: "GET", Type: "application/json", Templated: true, Example: paneAPIHref(examplePaneID)},
+			{Rel: "window-resource", Href: "/api/windows/{window_id}", Method: "GET", Type: "application/json", Templated: true, Example: windowAPIHref(exampleWindowID)},
 			{Rel: "pane-contents", Href: "/api/contents/{pane_id}{?escapes}", Method: "GET", Type: "text/plain; charset=utf-8", Templated: true, Example: "/api/contents/" + examplePaneID},
+			{Rel: "pane-format", Href: "/api/panes/{pane_id}/format{?fmt}", Method: "GET", Type: "text/plain; charset=utf-8", Templated: true, Example: paneAPIHref(examplePaneID) + "/format?fmt=%23%7Bpane_pid%7D"},
 			{Rel: "create-pane", Href: "/api/panes", Method: "POST", Type: "application/json"},
+			{Rel: "search", Href: "/api/search{?q,regex,escapes,limit}", Method: "GET", Type: "application/json", Templated: true, Example: "/api/search?q=ERROR"},
+			{Rel: "output", Href: "/api/output", Method: "GET", Type: "application/x-ndjson"},
+			{Rel: "policy", Href: "/api/policy", Method: "GET", Type: "application/json"},
+			{Rel: "info", Href: "/api/info", Method: "GET", Type: "application/json"},
+			{Rel: "options", Href: "/api/options", Method: "GET", Type: "application/json"},
 			{Rel: "ws", Href: "/ws", Method: "GET"},
 		},
 		Actions: []hypermediaAction{createPaneAction()},
 		Panes:   make([]paneDocument, 0, len(panes)),
 	}
+	for _, window := range windows {
+		doc.Windows = append(doc.Windows, windowResource(window))
+	}
 	for _, pane := range panes {
 		doc.Panes = append(doc.Panes, paneResource(pane, defaultTerm))
 	}
@@ -223,150 +655,747 @@ func createPaneAction() hypermediaAction {
 			{Name: "env", Type: "object", Description: "Optional environment variables map; keys must match [A-Za-z_][A-Za-z0-9_]*."},
 			{Name: "cwd", Type: "string", Description: "Optional working directory path."},
 			{Name: "cmd", Type: "array[string]", Description: "Optional command argv executed in the new pane."},
+			{Name: "size", Type: "string", Description: "Optional size of the new pane: a cell count (1-1000) or a percentage (1%-99%)."},
+		},
+		Schema: createPaneSchema(),
+	}
+}
+
+// createEnvLimits caps the env map of a POST /api/panes body. Like the size
+// range, the caps are configurable and so checked outside createPaneSchema.
+type createEnvLimits struct {
+	maxVars       int
+	maxValueBytes int
+}
+
+func (l createEnvLimits) check(env map[string]string) error {
+	if len(env) > l.maxVars {
+		return fmt.Errorf("env: must have at most %d entries, got %d", l.maxVars, len(env))
+	}
+	keys := make([]string, 0, len(env))
+	for k := range env {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		if n := len(env[k]); n > l.maxValueBytes {
+			return fmt.Errorf("env.%s: must be at most %d bytes, got %d", k, l.maxValueBytes, n)
+		}
+	}
+	return nil
+}
+
+// createPaneSchema is the body schema createPaneAction advertises and
+// serveAPIPanes enforces with validateSchema. The size range is checked
+// separately by wshub.ParsePaneSize.
+func createPaneSchema() map[string]any {
+	return map[string]any{
+		"$schema":              "https://json-schema.org/draft/2020-12/schema",
+		"type":                 "object",
+		"additionalProperties": false,
+		"properties": map[string]any{
+			"env": map[string]any{
+				"type":                 "object",
+				"propertyNames":        map[string]any{"pattern": "^[A-Za-z_][A-Za-z0-9_]*$"},
+				"additionalProperties": map[string]any{"type": "string"},
+			},
+			"cwd": map[string]any{
+				"type":      "string",
+				"minLength": 1,
+				"pattern":   `\S`,
+			},
+			"cmd": map[string]any{
+				"type":  "array",
+				"items": map[string]any{"type": "string"},
+			},
+			"size": map[string]any{
+				"type":    "string",
+				"pattern": "^[0-9]+%?$",
+			},
+		},
+	}
+}
+
+func paneResource(pane wshub.PaneInfo, defaultTerm string) paneDocument {
+	return paneDocument{
+		PaneID:       pane.PaneID,
+		PaneIndex:    pane.PaneIndex,
+		Name:         pane.Name,
+		SessionName:  pane.SessionName,
+		WindowIndex:  pane.WindowIndex,
+		WindowName:   pane.WindowName,
+		Width:        pane.Width,
+		Height:       pane.Height,
+		Dead:         pane.Dead,
+		DeadStatus:   pane.DeadStatus,
+		PID:          pane.PID,
+		StartCommand: pane.StartCommand,
+		Extra:        pane.Extra,
+		Cursor:       pane.Cursor,
+		Ready:        pane.Ready,
+		Links: []hypermediaLink{
+			{Rel: "self", Href: paneAPIHref(pane.PaneID), Method: "GET", Type: "application/json"},
+			{Rel: "terminal", Href: paneTargetHref(pane.PaneID, defaultTerm), Method: "GET", Type: "text/html"},
+			{Rel: "contents", Href: "/api/contents/" + pane.PaneID, Method: "GET", Type: "text/plain; charset=utf-8"},
+			{Rel: "contents-escaped", Href: "/api/contents/" + pane.PaneID + "?escapes=1", Method: "GET", Type: "text/plain; charset=utf-8"},
+			{Rel: "format", Href: paneAPIHref(pane.PaneID) + "/format{?fmt}", Method: "GET", Type: "text/plain; charset=utf-8", Templated: true},
+			{Rel: "image", Href: paneAPIHref(pane.PaneID) + "/image", Method: "GET", Type: "image/png"},
+		},
+	}
+}
+
+func serveAPIContents(w http.ResponseWriter, r *http.Request, hub *wshub.Hub, marks *paneMarks) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	paneID, ok := parsePanePathID(r.URL.EscapedPath(), "/api/contents/")
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	tmuxPaneID, found := hub.TargetSessionPaneIDByPublicID(paneID)
+	if !found {
+		writePaneNotFound(w, hub)
+		return
+	}
+
+	sanitize, err := parseSanitizeMode(r.URL.Query().Get("sanitize"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	grep, err := parseGrepQuery(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	opts := wshub.CaptureOptions{
+		Escapes:     parseEscapesFlag(r) && sanitize != sanitizeNone,
+		JoinWrapped: parseQueryFlag(r, "join"),
+	}
+	if parseQueryFlag(r, "since_mark") {
+		start, marked, err := markCaptureStart(r, hub, marks, tmuxPaneID)
+		if !marked {
+			http.Error(w, "no mark set for pane; POST /api/panes/"+paneID+"/mark first", http.StatusConflict)
+			return
+		}
+		if err != nil {
+			writeTmuxError(w, hub, err)
+			return
+		}
+		opts.Start = &start
+	}
+	content, err := hub.CapturePane(r.Context(), tmuxPaneID, opts)
+	if err != nil {
+		writeTmuxError(w, hub, err)
+		return
+	}
+	if opts.Escapes {
+		content = sanitizeEscapes(content, sanitize)
+	}
+	if parseQueryFlag(r, "trim") {
+		content = trimTrailingSpace(content)
+	}
+	if grep != nil {
+		blocks, truncated := grepContent(content, grep.match, grep.context, maxSearchResults)
+		writeGrepResult(w, r, grepResult{
+			PaneID:    paneID,
+			Query:     grep.query,
+			Regex:     grep.regex,
+			Context:   grep.context,
+			Blocks:    blocks,
+			Truncated: truncated,
+		})
+		return
+	}
+	if parseQueryFlag(r, "pad") {
+		for _, pane := range hub.CurrentTargetSessionPaneInfos() {
+			if pane.PaneID == paneID {
+				content = padContentToHeight(content, pane.Height)
+				break
+			}
+		}
+	}
+	if parseQueryFlagDefault(r, "trailing_newline", true) {
+		content = terminateLastLine(content)
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	_, _ = io.WriteString(w, content)
+}
+
+// writePaneNotFound answers a lookup of an unknown pane: 404 once the hub
+// has synced with tmux, and 503 with Retry-After before that, since the pane
+// may exist but not be known yet.
+func writePaneNotFound(w http.ResponseWriter, hub *wshub.Hub) {
+	if !hub.Synced() {
+		setRetryAfter(w, hub.RetryAfter())
+		http.Error(w, "not ready: tmux state not synced yet", http.StatusServiceUnavailable)
+		return
+	}
+	http.Error(w, "pane not found", http.StatusNotFound)
+}
+
+// serveAPIOutput streams output from all panes as newline-delimited JSON,
+// one {"pane_id","data"} object per chunk in arrival order.
+func serveAPIOutput(w http.ResponseWriter, r *http.Request, hub *wshub.Hub) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+
+	chunks, cancel := hub.SubscribePaneOutput(0)
+	defer cancel()
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	enc := json.NewEncoder(w)
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case chunk, ok := <-chunks:
+			if !ok {
+				return
+			}
+			if err := enc.Encode(chunk); err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
+
+type createPaneRequest struct {
+	Env  map[string]string `json:"env"`
+	Cwd  string            `json:"cwd"`
+	Cmd  []string          `json:"cmd"`
+	Size string            `json:"size"`
+}
+
+func serveAPIPane(w http.ResponseWriter, r *http.Request, hub *wshub.Hub, recorder *paneRecorder, marks *paneMarks, imageOpts termimg.Options, defaultTerm string) {
+	if paneID, sub, ok := parsePaneSubresourcePath(r.URL.EscapedPath(), "/api/panes/"); ok {
+		switch sub {
+		case "format":
+			if r.Method != http.MethodGet {
+				http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+				return
+			}
+			serveAPIPaneFormat(w, r, hub, paneID)
+		case "record":
+			serveAPIPaneRecord(w, r, hub, recorder, paneID)
+		case "image":
+			serveAPIPaneImage(w, r, hub, imageOpts, paneID)
+		case "paste":
+			serveAPIPanePaste(w, r, hub, paneID)
+		case "mark":
+			serveAPIPaneMark(w, r, hub, marks, paneID)
+		default:
+			if _, ok := paneOperationDescriptions[sub]; ok {
+				serveAPIPaneOperation(w, r, hub, paneID, sub)
+				return
+			}
+			http.NotFound(w, r)
+		}
+		return
+	}
+
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	paneID, ok := parsePanePathID(r.URL.EscapedPath(), "/api/panes/")
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	pane, found := targetSessionPaneByPublicID(hub, paneID)
+	// HEAD is an existence check against the in-memory model: no tmux
+	// command and no body.
+	if r.Method == http.MethodHead {
+		if !found {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	if !found {
+		http.Error(w, "pane not found", http.StatusNotFound)
+		return
+	}
+
+	doc := hypermediaDocument{
+		Resource:    "wmux-pane",
+		DefaultTerm: normalizeDefaultTerm(defaultTerm),
+		Links: []hypermediaLink{
+			{Rel: "self", Href: paneAPIHref(pane.PaneID), Method: "GET", Type: "application/json"},
+			{Rel: "collection", Href: "/api/state.json", Method: "GET", Type: "application/json"},
+			{Rel: "root", Href: "/", Method: "GET"},
+		},
+		Actions: append([]hypermediaAction{createPaneAction()}, paneOperationActions(hub, pane.PaneID)...),
+		Panes:   []paneDocument{paneResource(pane, defaultTerm)},
+	}
+	serveHypermediaDocument(w, r, doc)
+}
+
+// tmuxErrorStatus maps a failed tmux command to its response status: 503
+// while tmux is not connected, 413 for a capture over the size limit, and
+// 502 for anything tmux reported.
+func tmuxErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, wshub.ErrTmuxNotReady):
+		return http.StatusServiceUnavailable
+	case errors.Is(err, wshub.ErrCaptureTooLarge):
+		return http.StatusRequestEntityTooLarge
+	case errors.Is(err, wshub.ErrReplayMode):
+		return http.StatusNotImplemented
+	}
+	return http.StatusBadGateway
+}
+
+// writeTmuxError answers a failed tmux command with tmuxErrorStatus. A 503
+// carries Retry-After so clients wait out the reconnect.
+func writeTmuxError(w http.ResponseWriter, hub *wshub.Hub, err error) {
+	status := tmuxErrorStatus(err)
+	if status == http.StatusServiceUnavailable {
+		setRetryAfter(w, hub.RetryAfter())
+	}
+	http.Error(w, err.Error(), status)
+}
+
+// setRetryAfter sets Retry-After to d in whole seconds, rounded up and at
+// least 1.
+func setRetryAfter(w http.ResponseWriter, d time.Duration) {
+	secs := max(int64((d+time.Second-1)/time.Second), 1)
+	w.Header().Set("Retry-After", strconv.FormatInt(secs, 10))
+}
+
+// paneOperationDescriptions documents each wshub.PaneOperations entry for
+// its hypermedia action.
+var paneOperationDescriptions = map[string]string{
+	"clear":     "Clear the screen (sends C-l).",
+	"interrupt": "Interrupt the foreground process (sends C-c).",
+	"eof":       "Send end-of-file to the foreground process (sends C-d).",
+}
+
+// paneOperationActions lists the named pane operations the policy permits.
+func paneOperationActions(hub *wshub.Hub, paneID string) []hypermediaAction {
+	var out []hypermediaAction
+	for _, name := range wshub.PaneOperations() {
+		if !hub.PaneOperationAllowed(name) {
+			continue
+		}
+		out = append(out, hypermediaAction{
+			Name:        name,
+			Method:      "POST",
+			Href:        paneAPIHref(paneID) + "/" + name,
+			Description: paneOperationDescriptions[name],
+		})
+	}
+	return out
+}
+
+func serveAPIPaneOperation(w http.ResponseWriter, r *http.Request, hub *wshub.Hub, paneID, name string) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	tmuxPaneID, found := hub.TargetSessionPaneIDByPublicID(paneID)
+	if !found {
+		http.Error(w, "pane not found", http.StatusNotFound)
+		return
+	}
+	if !hub.PaneOperationAllowed(name) {
+		http.Error(w, "operation blocked by policy", http.StatusForbidden)
+		return
+	}
+	if err := hub.RunPaneOperation(r.Context(), tmuxPaneID, name); err != nil {
+		writeTmuxError(w, hub, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// maxKeysPanes bounds the pane_ids of one POST /api/panes/keys request.
+const maxKeysPanes = 64
+
+type sendKeysRequest struct {
+	PaneIDs []string `json:"pane_ids"`
+	Literal string   `json:"literal"`
+}
+
+type sendKeysResult struct {
+	PaneID string `json:"pane_id"`
+	OK     bool   `json:"ok"`
+	Error  string `json:"error,omitempty"`
+}
+
+// serveAPIPanesKeys types the same literal text into each listed pane, one
+// pane after another. A failing pane does not stop the others; each gets its
+// own entry in the response.
+func serveAPIPanesKeys(w http.ResponseWriter, r *http.Request, hub *wshub.Hub) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req sendKeysRequest
+	dec := json.NewDecoder(io.LimitReader(r.Body, 1<<20))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&req); err != nil {
+		http.Error(w, "invalid json", http.StatusBadRequest)
+		return
+	}
+	if err := dec.Decode(&struct{}{}); err != io.EOF {
+		http.Error(w, "invalid json", http.StatusBadRequest)
+		return
+	}
+	if len(req.PaneIDs) == 0 || len(req.PaneIDs) > maxKeysPanes {
+		http.Error(w, fmt.Sprintf("pane_ids must list 1 to %d panes", maxKeysPanes), http.StatusBadRequest)
+		return
+	}
+	if req.Literal == "" {
+		http.Error(w, "literal cannot be empty", http.StatusBadRequest)
+		return
+	}
+	if err := hub.Policy().ValidateCommand("send-keys"); err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+
+	results := make([]sendKeysResult, 0, len(req.PaneIDs))
+	seen := make(map[string]bool, len(req.PaneIDs))
+	for _, paneID := range req.PaneIDs {
+		tmuxPaneID, found := hub.TargetSessionPaneIDByPublicID(paneID)
+		if !found {
+			results = append(results, sendKeysResult{PaneID: paneID, Error: "pane not found"})
+			continue
+		}
+		if seen[tmuxPaneID] {
+			continue
+		}
+		seen[tmuxPaneID] = true
+		res := sendKeysResult{PaneID: strings.TrimPrefix(tmuxPaneID, "%"), OK: true}
+		if err := hub.SendKeys(r.Context(), tmuxPaneID, req.Literal); err != nil {
+			res.OK, res.Error = false, err.Error()
+		}
+		results = append(results, res)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]any{"results": results})
+}
+
+func windowResource(window wshub.WindowInfo) windowDocument {
+	return windowDocument{
+		WindowID:  window.WindowID,
+		Index:     window.Index,
+		Name:      window.Name,
+		Active:    window.Active,
+		PaneCount: window.PaneCount,
+		Links: []hypermediaLink{
+			{Rel: "self", Href: windowAPIHref(window.WindowID), Method: "GET", Type: "application/json"},
+		},
+	}
+}
+
+func renameWindowAction(windowID string) hypermediaAction {
+	return hypermediaAction{
+		Name:        "rename-window",
+		Title:       "Rename Window",
+		Method:      "POST",
+		Href:        windowAPIHref(windowID) + "/rename",
+		Type:        "application/json",
+		Description: "Rename this tmux window.",
+		Fields: []hypermediaActionField{
+			{Name: "name", Type: "string", Required: true, Description: "New window name; single line, at most 256 bytes."},
 		},
 		Schema: map[string]any{
 			"$schema":              "https://json-schema.org/draft/2020-12/schema",
 			"type":                 "object",
 			"additionalProperties": false,
+			"required":             []string{"name"},
 			"properties": map[string]any{
-				"env": map[string]any{
-					"type":                 "object",
-					"additionalProperties": map[string]any{"type": "string"},
-				},
-				"cwd": map[string]any{
+				"name": map[string]any{
 					"type":      "string",
 					"minLength": 1,
-				},
-				"cmd": map[string]any{
-					"type":  "array",
-					"items": map[string]any{"type": "string"},
+					"maxLength": maxWindowNameLength,
 				},
 			},
 		},
 	}
 }
 
-func paneResource(pane wshub.PaneInfo, defaultTerm string) paneDocument {
-	return paneDocument{
-		PaneID:      pane.PaneID,
-		PaneIndex:   pane.PaneIndex,
-		Name:        pane.Name,
-		SessionName: pane.SessionName,
-		WindowIndex: pane.WindowIndex,
-		WindowName:  pane.WindowName,
-		Width:       pane.Width,
-		Height:      pane.Height,
+func serveAPIWindow(w http.ResponseWriter, r *http.Request, hub *wshub.Hub, defaultTerm string) {
+	if windowID, sub, ok := parsePaneSubresourcePath(r.URL.EscapedPath(), "/api/windows/"); ok {
+		switch sub {
+		case "rename":
+			serveAPIWindowRename(w, r, hub, windowID)
+		default:
+			http.NotFound(w, r)
+		}
+		return
+	}
+
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	windowID, ok := parsePanePathID(r.URL.EscapedPath(), "/api/windows/")
+	if !ok || strings.HasPrefix(windowID, "@") {
+		http.NotFound(w, r)
+		return
+	}
+	window, panes, found := hub.TargetSessionWindowByPublicID(windowID)
+	if !found {
+		http.Error(w, "window not found", http.StatusNotFound)
+		return
+	}
+
+	doc := hypermediaDocument{
+		Resource:    "wmux-window",
+		DefaultTerm: normalizeDefaultTerm(defaultTerm),
 		Links: []hypermediaLink{
-			{Rel: "self", Href: paneAPIHref(pane.PaneID), Method: "GET", Type: "application/json"},
-			{Rel: "terminal", Href: paneTargetHref(pane.PaneID, defaultTerm), Method: "GET", Type: "text/html"},
-			{Rel: "contents", Href: "/api/contents/" + pane.PaneID, Method: "GET", Type: "text/plain; charset=utf-8"},
-			{Rel: "contents-escaped", Href: "/api/contents/" + pane.PaneID + "?escapes=1", Method: "GET", Type: "text/plain; charset=utf-8"},
+			{Rel: "self", Href: windowAPIHref(window.WindowID), Method: "GET", Type: "application/json"},
+			{Rel: "collection", Href: "/api/state.json", Method: "GET", Type: "application/json"},
+			{Rel: "root", Href: "/", Method: "GET"},
 		},
+		Actions: []hypermediaAction{renameWindowAction(window.WindowID)},
+		Windows: []windowDocument{windowResource(window)},
+		Panes:   make([]paneDocument, 0, len(panes)),
+	}
+	for _, pane := range panes {
+		doc.Panes = append(doc.Panes, paneResource(pane, defaultTerm))
 	}
+	serveHypermediaDocument(w, r, doc)
 }
 
-func serveAPIContents(w http.ResponseWriter, r *http.Request, hub *wshub.Hub) {
-	if r.Method != http.MethodGet {
+const maxWindowNameLength = 256
+
+type renameWindowRequest struct {
+	Name string `json:"name"`
+}
+
+func serveAPIWindowRename(w http.ResponseWriter, r *http.Request, hub *wshub.Hub, windowID string) {
+	if r.Method != http.MethodPost {
 		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
 
-	paneID, ok := parsePanePathID(r.URL.EscapedPath(), "/api/contents/")
-	if !ok {
-		http.NotFound(w, r)
+	var req renameWindowRequest
+	dec := json.NewDecoder(io.LimitReader(r.Body, 1<<20))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&req); err != nil {
+		http.Error(w, "invalid json", http.StatusBadRequest)
+		return
+	}
+	if err := validateWindowName(req.Name); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	tmuxPaneID, found := hub.TargetSessionPaneIDByPublicID(paneID)
+	window, _, found := hub.TargetSessionWindowByPublicID(windowID)
 	if !found {
-		http.Error(w, "pane not found", http.StatusNotFound)
+		http.Error(w, "window not found", http.StatusNotFound)
 		return
 	}
-
-	withEscapes := parseEscapesFlag(r)
-	content, err := hub.CapturePaneContent(tmuxPaneID, withEscapes)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadGateway)
+	if err := hub.RenameWindow(r.Context(), window.TmuxWindowID, req.Name); err != nil {
+		writeTmuxError(w, hub, err)
 		return
 	}
-
-	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
-	_, _ = io.WriteString(w, content)
+	w.WriteHeader(http.StatusNoContent)
 }
 
-type createPaneRequest struct {
-	Env map[string]string `json:"env"`
-	Cwd string            `json:"cwd"`
-	Cmd []string          `json:"cmd"`
+func validateWindowName(name string) error {
+	if strings.TrimSpace(name) == "" {
+		return fmt.Errorf("name is required")
+	}
+	if len(name) > maxWindowNameLength {
+		return fmt.Errorf("name exceeds %d bytes", maxWindowNameLength)
+	}
+	if strings.ContainsAny(name, "\r\n") {
+		return fmt.Errorf("name must be a single line")
+	}
+	return nil
 }
 
-func serveAPIPane(w http.ResponseWriter, r *http.Request, hub *wshub.Hub, defaultTerm string) {
-	if r.Method != http.MethodGet {
-		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
-		return
-	}
+const maxPaneFormatLength = 512
 
-	paneID, ok := parsePanePathID(r.URL.EscapedPath(), "/api/panes/")
-	if !ok {
-		http.NotFound(w, r)
+func serveAPIPaneFormat(w http.ResponseWriter, r *http.Request, hub *wshub.Hub, paneID string) {
+	format := r.URL.Query().Get("fmt")
+	if err := validatePaneFormat(format); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
-	pane, found := targetSessionPaneByPublicID(hub, paneID)
+
+	tmuxPaneID, found := hub.TargetSessionPaneIDByPublicID(paneID)
 	if !found {
 		http.Error(w, "pane not found", http.StatusNotFound)
 		return
 	}
 
-	doc := hypermediaDocument{
-		Resource:    "wmux-pane",
-		DefaultTerm: normalizeDefaultTerm(defaultTerm),
-		Links: []hypermediaLink{
-			{Rel: "self", Href: paneAPIHref(pane.PaneID), Method: "GET", Type: "application/json"},
-			{Rel: "collection", Href: "/api/state.json", Method: "GET", Type: "application/json"},
-			{Rel: "root", Href: "/", Method: "GET"},
-		},
-		Actions: []hypermediaAction{createPaneAction()},
-		Panes:   []paneDocument{paneResource(pane, defaultTerm)},
+	value, err := hub.DisplayPaneFormat(r.Context(), tmuxPaneID, format)
+	if err != nil {
+		writeTmuxError(w, hub, err)
+		return
 	}
-	serveHypermediaDocument(w, r, doc)
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	_, _ = io.WriteString(w, value)
+}
+
+func validatePaneFormat(format string) error {
+	if strings.TrimSpace(format) == "" {
+		return fmt.Errorf("fmt is required")
+	}
+	if len(format) > maxPaneFormatLength {
+		return fmt.Errorf("fmt exceeds %d bytes", maxPaneFormatLength)
+	}
+	if strings.ContainsAny(format, "\r\n") {
+		return fmt.Errorf("fmt cannot contain newlines")
+	}
+	return nil
 }
 
-func serveAPIPanes(w http.ResponseWriter, r *http.Request, hub *wshub.Hub, defaultTerm string) {
+func serveAPIPanes(w http.ResponseWriter, r *http.Request, hub *wshub.Hub, defaultTerm string, timeout time.Duration, envCaps createEnvLimits, keys *createPaneKeys) {
 	if r.Method != http.MethodPost {
 		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
 
+	body, err := io.ReadAll(io.LimitReader(r.Body, 1<<20))
+	if err != nil {
+		http.Error(w, "invalid json", http.StatusBadRequest)
+		return
+	}
+	empty := len(bytes.TrimSpace(body)) == 0
+	var raw any = map[string]any{}
+	if !empty {
+		dec := json.NewDecoder(bytes.NewReader(body))
+		dec.UseNumber()
+		if err := dec.Decode(&raw); err != nil {
+			http.Error(w, "invalid json", http.StatusBadRequest)
+			return
+		}
+		if err := dec.Decode(&struct{}{}); err != io.EOF {
+			http.Error(w, "invalid json", http.StatusBadRequest)
+			return
+		}
+	}
+	if err := validateSchema(createPaneSchema(), raw, ""); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 	var req createPaneRequest
-	dec := json.NewDecoder(io.LimitReader(r.Body, 1<<20))
-	dec.DisallowUnknownFields()
-	if err := dec.Decode(&req); err != nil {
-		if err != io.EOF {
+	if !empty {
+		// The body matched the schema, so every field has its Go type.
+		if err := json.Unmarshal(body, &req); err != nil {
 			http.Error(w, "invalid json", http.StatusBadRequest)
 			return
 		}
-	} else if err := dec.Decode(&struct{}{}); err != io.EOF {
-		http.Error(w, "invalid json", http.StatusBadRequest)
+	}
+	if req.Size != "" {
+		if _, _, err := wshub.ParsePaneSize(req.Size); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	if err := envCaps.check(req.Env); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
-	if err := validateCreatePaneRequest(req); err != nil {
+	waitReady, err := parseReadySignal(r)
+	if err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
+	if waitReady != nil {
+		// The ready wait comes on top of creating the pane.
+		timeout += waitReady.Timeout
+	}
+	// The request is bounded by timeout below, which may exceed the
+	// server's write timeout.
+	clearConnDeadlines(w)
+
+	ctx, cancel := context.WithTimeout(r.Context(), timeout)
+	defer cancel()
+
+	var keyed *keyedCreate
+	if key := r.Header.Get(idempotencyKeyHeader); key != "" {
+		if len(key) > maxIdempotencyKeyLength {
+			http.Error(w, fmt.Sprintf("Idempotency-Key must be at most %d bytes", maxIdempotencyKeyLength), http.StatusBadRequest)
+			return
+		}
+		entry, replay, err := keys.claim(ctx, key, idempotencyFingerprint(r, body))
+		switch {
+		case errors.Is(err, errIdempotencyKeyReused):
+			http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+			return
+		case errors.Is(err, context.DeadlineExceeded):
+			http.Error(w, "timed out waiting for the request with the same Idempotency-Key", http.StatusGatewayTimeout)
+			return
+		case err != nil:
+			return
+		case replay:
+			w.Header().Set(idempotentReplayedHeader, "true")
+			writeCreatedPane(w, r, entry.pane, defaultTerm, http.StatusOK)
+			return
+		}
+		keyed = entry
+		defer func() {
+			if keyed != nil {
+				keys.abandon(key, keyed)
+			}
+		}()
+	}
 
-	pane, err := hub.CreatePane(wshub.CreatePaneOptions{
-		Env: req.Env,
-		Cwd: req.Cwd,
-		Cmd: req.Cmd,
+	pane, err := hub.CreatePaneContext(ctx, wshub.CreatePaneOptions{
+		Env:       req.Env,
+		Cwd:       req.Cwd,
+		Cmd:       req.Cmd,
+		Size:      req.Size,
+		Cursor:    parseQueryFlag(r, "cursor"),
+		WaitReady: waitReady,
 	})
+	if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, wshub.ErrCommandTimeout) {
+		http.Error(w, "timed out creating pane", http.StatusGatewayTimeout)
+		return
+	}
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadGateway)
+		writeTmuxError(w, hub, err)
 		return
 	}
 
 	if resolved, found := targetSessionPaneByPublicID(hub, pane.PaneID); found {
+		resolved.Cursor = pane.Cursor
+		resolved.Ready = pane.Ready
 		pane = resolved
 	}
+	if keyed != nil {
+		keys.finish(keyed, pane)
+		keyed = nil
+	}
+	writeCreatedPane(w, r, pane, defaultTerm, http.StatusCreated)
+}
+
+// writeCreatedPane answers POST /api/panes with the pane document for pane
+// and a Location header naming it.
+func writeCreatedPane(w http.ResponseWriter, r *http.Request, pane wshub.PaneInfo, defaultTerm string, status int) {
 	location := paneAPIHref(pane.PaneID)
 	doc := hypermediaDocument{
 		Resource:    "wmux-pane",
@@ -380,57 +1409,71 @@ func serveAPIPanes(w http.ResponseWriter, r *http.Request, hub *wshub.Hub, defau
 		Panes:   []paneDocument{paneResource(pane, defaultTerm)},
 	}
 
+	base := basePathFrom(r)
 	w.Header().Set("Content-Type", "application/json")
-	w.Header().Set("Location", location)
-	w.WriteHeader(http.StatusCreated)
-	_ = json.NewEncoder(w).Encode(doc)
+	w.Header().Set("Location", rebaseHref(base, location))
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(rebaseDocument(doc, base))
 }
 
-func validateCreatePaneRequest(req createPaneRequest) error {
-	if req.Cwd != "" && strings.TrimSpace(req.Cwd) == "" {
-		return fmt.Errorf("cwd cannot be blank")
+// parseReadySignal reads the ?wait_ready query parameters of POST
+// /api/panes: ready_regex and ready_command select the signal (see
+// wshub.PaneReadySignal) and ready_timeout_ms bounds the wait. It returns
+// nil when wait_ready is off; the other parameters alone turn it on.
+func parseReadySignal(r *http.Request) (*wshub.PaneReadySignal, error) {
+	q := r.URL.Query()
+	if !parseQueryFlag(r, "wait_ready") && !q.Has("ready_regex") && !q.Has("ready_command") && !q.Has("ready_timeout_ms") {
+		return nil, nil
 	}
-	for key := range req.Env {
-		if !isValidEnvKey(key) {
-			return fmt.Errorf("invalid env key: %q", key)
+	sig := &wshub.PaneReadySignal{Timeout: wshub.DefaultPaneReadyTimeout}
+	if raw := q.Get("ready_regex"); raw != "" {
+		re, err := regexp.Compile(raw)
+		if err != nil {
+			return nil, fmt.Errorf("invalid ready_regex: %w", err)
 		}
+		sig.Prompt = re
 	}
-	return nil
-}
-
-func isValidEnvKey(v string) bool {
-	if v == "" {
-		return false
-	}
-	for i := 0; i < len(v); i++ {
-		ch := v[i]
-		isLetter := (ch >= 'a' && ch <= 'z') || (ch >= 'A' && ch <= 'Z')
-		isDigit := ch >= '0' && ch <= '9'
-		if i == 0 {
-			if !isLetter && ch != '_' {
-				return false
-			}
-			continue
-		}
-		if !isLetter && !isDigit && ch != '_' {
-			return false
+	sig.Command = strings.TrimSpace(q.Get("ready_command"))
+	if raw := strings.TrimSpace(q.Get("ready_timeout_ms")); raw != "" {
+		ms, err := strconv.Atoi(raw)
+		if err != nil || ms <= 0 || time.Duration(ms)*time.Millisecond > wshub.MaxPaneReadyTimeout {
+			return nil, fmt.Errorf("ready_timeout_ms must be 1 to %d", wshub.MaxPaneReadyTimeout.Milliseconds())
 		}
+		sig.Timeout = time.Duration(ms) * time.Millisecond
 	}
-	return true
+	return sig, nil
 }
 
 func ensureTermQuery(r *http.Request, defaultTerm string) (string, bool) {
 	query := r.URL.Query()
 	current := strings.ToLower(strings.TrimSpace(query.Get("term")))
-	desired := normalizeDefaultTerm(current)
-	if current != "ghostty" && current != "xterm" {
+	desired := current
+	if !assets.IsTerminalRenderer(current) {
 		desired = normalizeDefaultTerm(defaultTerm)
 	}
 	if current == desired {
 		return "", false
 	}
-	query.Set("term", desired)
-	return r.URL.Path + "?" + query.Encode(), true
+	return basePathFrom(r) + r.URL.EscapedPath() + "?" + withTermParam(r.URL.RawQuery, desired), true
+}
+
+// withTermParam drops any term params from rawQuery and appends
+// term=value. Other params keep their original order and encoding, since
+// the front end may keep state in the query string.
+func withTermParam(rawQuery, value string) string {
+	var kept []string
+	for _, part := range strings.Split(rawQuery, "&") {
+		if part == "" {
+			continue
+		}
+		rawKey, _, _ := strings.Cut(part, "=")
+		if key, err := url.QueryUnescape(rawKey); err == nil && key == "term" {
+			continue
+		}
+		kept = append(kept, part)
+	}
+	kept = append(kept, "term="+url.QueryEscape(value))
+	return strings.Join(kept, "&")
 }
 
 func parsePanePathID(escapedPath, prefix string) (string, bool) {
@@ -448,11 +1491,80 @@ func parsePanePathID(escapedPath, prefix string) (string, bool) {
 	return id, true
 }
 
+// parsePaneSubresourcePath parses "<prefix>{pane_id}/{sub}" paths.
+func parsePaneSubresourcePath(escapedPath, prefix string) (string, string, bool) {
+	if !strings.HasPrefix(escapedPath, prefix) {
+		return "", "", false
+	}
+	raw, sub, ok := strings.Cut(strings.TrimPrefix(escapedPath, prefix), "/")
+	if !ok || sub == "" || strings.Contains(sub, "/") {
+		return "", "", false
+	}
+	id, ok := parsePanePathID(prefix+raw, prefix)
+	if !ok {
+		return "", "", false
+	}
+	return id, sub, true
+}
+
 func parseEscapesFlag(r *http.Request) bool {
-	v := strings.ToLower(strings.TrimSpace(r.URL.Query().Get("escapes")))
+	return parseQueryFlag(r, "escapes")
+}
+
+func parseQueryFlag(r *http.Request, name string) bool {
+	v := strings.ToLower(strings.TrimSpace(r.URL.Query().Get(name)))
 	return v == "1" || v == "true" || v == "yes"
 }
 
+// parseQueryFlagDefault is parseQueryFlag for flags that default to on:
+// absent or unrecognized values give def, and 0|false|no turns it off.
+func parseQueryFlagDefault(r *http.Request, name string, def bool) bool {
+	switch strings.ToLower(strings.TrimSpace(r.URL.Query().Get(name))) {
+	case "1", "true", "yes":
+		return true
+	case "0", "false", "no":
+		return false
+	}
+	return def
+}
+
+// terminateLastLine newline-terminates the final row of a capture, which
+// tmux returns as lines without terminators. An empty capture stays empty.
+func terminateLastLine(content string) string {
+	if content == "" {
+		return content
+	}
+	return content + "\n"
+}
+
+// trimTrailingSpace removes trailing spaces and tabs from each captured
+// row. It works on display lines, so with join each joined row is trimmed
+// once; spaces followed by an escape sequence are not trailing and stay.
+func trimTrailingSpace(content string) string {
+	lines := strings.Split(content, "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimRight(line, " \t")
+	}
+	return strings.Join(lines, "\n")
+}
+
+// padContentToHeight appends blank rows so content has at least height
+// lines, restoring trailing empty rows of the pane grid. Escape sequences
+// never span lines, so this is safe for escape-decorated captures too.
+func padContentToHeight(content string, height int) string {
+	rows := strings.Count(content, "\n") + 1
+	if content == "" {
+		rows = 0
+	}
+	if rows >= height {
+		return content
+	}
+	if content == "" {
+		return strings.Repeat("\n", height-1)
+	}
+	return content + strings.Repeat("\n", height-rows)
+}
+
 type unicodeDebugClientMessage struct {
 	At      string `json:"at"`
 	Type    string `json:"type"`
@@ -490,35 +1602,72 @@ type unicodeDebugRecord struct {
 	ReceivedAt string                    `json:"received_at"`
 	Client     unicodeDebugClientReport  `json:"client"`
 	Server     unicodeDebugServerCapture `json:"server"`
+
+	receivedAt time.Time
 }
 
+const (
+	// maxUnicodeDebugReports and unicodeDebugReportMaxAge bound the unicode
+	// debug store; a report is dropped when either limit is exceeded.
+	maxUnicodeDebugReports   = 50
+	unicodeDebugReportMaxAge = time.Hour
+)
+
 type unicodeDebugStore struct {
 	mu      sync.Mutex
 	nextID  int64
 	reports []unicodeDebugRecord
+	// now is the store's clock; nil means time.Now.
+	now func() time.Time
+}
+
+func (s *unicodeDebugStore) clock() time.Time {
+	if s.now != nil {
+		return s.now()
+	}
+	return time.Now()
 }
 
+// add stamps r with an id and the receive time and stores it.
 func (s *unicodeDebugStore) add(r unicodeDebugRecord) int64 {
 	s.mu.Lock()
 	defer s.mu.Unlock()
+	now := s.clock()
 	s.nextID++
 	r.ID = s.nextID
+	r.receivedAt = now
+	r.ReceivedAt = now.UTC().Format(time.RFC3339Nano)
 	s.reports = append(s.reports, r)
-	if len(s.reports) > 50 {
-		s.reports = append([]unicodeDebugRecord{}, s.reports[len(s.reports)-50:]...)
+	if len(s.reports) > maxUnicodeDebugReports {
+		s.reports = append([]unicodeDebugRecord{}, s.reports[len(s.reports)-maxUnicodeDebugReports:]...)
 	}
+	s.evictExpired(now)
 	return r.ID
 }
 
 func (s *unicodeDebugStore) latest() (unicodeDebugRecord, bool) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
+	s.evictExpired(s.clock())
 	if len(s.reports) == 0 {
 		return unicodeDebugRecord{}, false
 	}
 	return s.reports[len(s.reports)-1], true
 }
 
+// evictExpired drops reports older than unicodeDebugReportMaxAge. Callers
+// must hold s.mu.
+func (s *unicodeDebugStore) evictExpired(now time.Time) {
+	cutoff := now.Add(-unicodeDebugReportMaxAge)
+	keep := 0
+	for keep < len(s.reports) && s.reports[keep].receivedAt.Before(cutoff) {
+		keep++
+	}
+	if keep > 0 {
+		s.reports = append([]unicodeDebugRecord{}, s.reports[keep:]...)
+	}
+}
+
 var unicodeReports = &unicodeDebugStore{}
 
 func serveAPIDebugUnicode(w http.ResponseWriter, r *http.Request, hub *wshub.Hub) {
@@ -530,20 +1679,17 @@ func serveAPIDebugUnicode(w http.ResponseWriter, r *http.Request, hub *wshub.Hub
 			return
 		}
 
-		record := unicodeDebugRecord{
-			ReceivedAt: time.Now().UTC().Format(time.RFC3339Nano),
-			Client:     clientReport,
-		}
+		record := unicodeDebugRecord{Client: clientReport}
 
 		if tmuxPaneID, ok := hub.TargetSessionPaneIDByPublicID(clientReport.PaneID); ok {
 			record.Server.TmuxPaneID = tmuxPaneID
-			if plain, err := hub.CapturePaneContent(tmuxPaneID, false); err != nil {
+			if plain, err := hub.CapturePaneContent(r.Context(), tmuxPaneID, false); err != nil {
 				record.Server.PlainCaptureError = err.Error()
 			} else {
 				record.Server.PlainSample = truncateRunes(plain, 2048)
 				record.Server.PlainHexPreview = hexPreview(plain, 128)
 			}
-			if escaped, err := hub.CapturePaneContent(tmuxPaneID, true); err != nil {
+			if escaped, err := hub.CapturePaneContent(r.Context(), tmuxPaneID, true); err != nil {
 				record.Server.EscapedCaptureError = err.Error()
 			} else {
 				record.Server.EscapedSample = truncateRunes(escaped, 2048)
@@ -558,7 +1704,7 @@ func serveAPIDebugUnicode(w http.ResponseWriter, r *http.Request, hub *wshub.Hub
 		_ = json.NewEncoder(w).Encode(map[string]any{
 			"ok":          true,
 			"report_id":   id,
-			"latest_path": "/api/debug/unicode",
+			"latest_path": rebaseHref(basePathFrom(r), "/api/debug/unicode"),
 		})
 		return
 	case http.MethodGet:
@@ -576,6 +1722,155 @@ func serveAPIDebugUnicode(w http.ResponseWriter, r *http.Request, hub *wshub.Hub
 	}
 }
 
+type infoResponse struct {
+	StartedAt             string `json:"started_at"`
+	UptimeSeconds         int64  `json:"uptime_seconds"`
+	Uptime                string `json:"uptime"`
+	TmuxConnected         bool   `json:"tmux_connected"`
+	TmuxConnectedAt       string `json:"tmux_connected_at,omitempty"`
+	TmuxConnectionSeconds int64  `json:"tmux_connection_seconds"`
+	TmuxConnection        string `json:"tmux_connection,omitempty"`
+	// TmuxConfigErrors lists recent %config-error messages, oldest first.
+	TmuxConfigErrors []wshub.ConfigErrorRecord `json:"tmux_config_errors"`
+	// WSProtocols lists the /ws subprotocols the server accepts, preferred
+	// first.
+	WSProtocols []string `json:"ws_protocols"`
+}
+
+// serveAPIInfo reports how long the server has been running, how long the
+// current tmux control connection has been up, and config lines tmux
+// rejected.
+func serveAPIInfo(w http.ResponseWriter, r *http.Request, hub *wshub.Hub, startedAt time.Time, tmuxConnectedSince func() (time.Time, bool)) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	now := time.Now()
+	uptime := now.Sub(startedAt)
+	info := infoResponse{
+		StartedAt:        startedAt.UTC().Format(time.RFC3339),
+		UptimeSeconds:    int64(uptime.Seconds()),
+		Uptime:           uptime.Truncate(time.Second).String(),
+		TmuxConfigErrors: hub.RecentConfigErrors(),
+		WSProtocols:      wshub.SupportedProtocols(),
+	}
+	if tmuxConnectedSince != nil {
+		if since, ok := tmuxConnectedSince(); ok {
+			connected := now.Sub(since)
+			info.TmuxConnected = true
+			info.TmuxConnectedAt = since.UTC().Format(time.RFC3339)
+			info.TmuxConnectionSeconds = int64(connected.Seconds())
+			info.TmuxConnection = connected.Truncate(time.Second).String()
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(info)
+}
+
+func serveAPIPolicy(w http.ResponseWriter, r *http.Request, hub *wshub.Hub) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]any{
+		"allowed": hub.Policy().Allowed(),
+	})
+}
+
+func serveAPIAdminClients(w http.ResponseWriter, r *http.Request, hub *wshub.Hub) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]any{
+		"clients": hub.Clients(),
+	})
+}
+
+// killSessionRequest is the body of POST /api/admin/kill-session. Naming
+// the session guards against killing the wrong wmux's session by mistake.
+type killSessionRequest struct {
+	Session string `json:"session"`
+}
+
+// serveAPIAdminKillSession kills the target session and answers 202 without
+// waiting: tmux ends the control connection as the session goes away. The
+// body must be JSON, which a cross-site form cannot send without a CORS
+// preflight.
+func serveAPIAdminKillSession(w http.ResponseWriter, r *http.Request, hub *wshub.Hub) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType != "application/json" {
+		http.Error(w, "Content-Type must be application/json", http.StatusUnsupportedMediaType)
+		return
+	}
+	var req killSessionRequest
+	dec := json.NewDecoder(io.LimitReader(r.Body, 4096))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&req); err != nil {
+		http.Error(w, "invalid json", http.StatusBadRequest)
+		return
+	}
+	if req.Session == "" {
+		http.Error(w, "session is required", http.StatusBadRequest)
+		return
+	}
+	err := hub.KillSession(r.Context(), req.Session)
+	if errors.Is(err, wshub.ErrNotTargetSession) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		writeTmuxError(w, hub, err)
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func serveAPIDebugParseErrors(w http.ResponseWriter, r *http.Request, hub *wshub.Hub) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]any{
+		"parse_errors": hub.RecentParseErrors(),
+	})
+}
+
+func serveAPIDebugOutputLatency(w http.ResponseWriter, r *http.Request, hub *wshub.Hub) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(hub.OutputLatencySnapshot())
+}
+
+func serveAPIDebugParserQueue(w http.ResponseWriter, r *http.Request, hub *wshub.Hub) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(hub.ParserQueueStats())
+}
+
+func serveAPIDebugPending(w http.ResponseWriter, r *http.Request, hub *wshub.Hub) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]any{
+		"pending": hub.PendingSnapshot(),
+	})
+}
+
 func truncateRunes(s string, max int) string {
 	r := []rune(s)
 	if len(r) <= max {
@@ -608,6 +1903,10 @@ func targetSessionPaneByPublicID(hub *wshub.Hub, paneID string) (wshub.PaneInfo,
 	return wshub.PaneInfo{}, false
 }
 
+func windowAPIHref(windowID string) string {
+	return "/api/windows/" + url.PathEscape(windowID)
+}
+
 func paneAPIHref(paneID string) string {
 	return "/api/panes/" + url.PathEscape(paneID)
 }
@@ -620,10 +1919,10 @@ func paneTargetHref(paneID, defaultTerm string) string {
 
 func normalizeDefaultTerm(raw string) string {
 	v := strings.ToLower(strings.TrimSpace(raw))
-	if v == "xterm" {
-		return "xterm"
+	if assets.IsTerminalRenderer(v) {
+		return v
 	}
-	return "ghostty"
+	return assets.DefaultTerminalRenderer()
 }
 
 func negotiateStateFormat(r *http.Request) string {
@@ -723,16 +2022,31 @@ var stateHTMLTemplate = template.Must(template.New("state").Parse(`<!doctype htm
     <pre><code>{{.CreatePaneRequestBody}}</code></pre>
   </section>
 
+  {{if .Doc.Windows}}
+  <section>
+    <h2>Windows</h2>
+    <ul>
+    {{range .Doc.Windows}}
+      <li>
+        <strong>{{.Name}}</strong>
+        <span class="meta">index={{.Index}} panes={{.PaneCount}} (window_id={{.WindowID}}){{if .Active}} active{{end}}</span>
+        {{range .Links}}<a href="{{.Href}}">{{.Rel}}</a>{{end}}
+      </li>
+    {{end}}
+    </ul>
+  </section>
+  {{end}}
+
   <section>
     <h2>Available Panes</h2>
     <ul>
     {{range .Doc.Panes}}
       <li>
         <strong>{{if .Name}}{{.Name}}{{else}}pane {{.PaneID}}{{end}}</strong>
-        <span class="meta">{{.Width}}x{{.Height}} (pane_id={{.PaneID}})</span>
+        <span class="meta">{{.Width}}x{{.Height}} (pane_id={{.PaneID}}){{if .PID}} pid={{.PID}}{{end}}{{if .Dead}} dead (exit {{.DeadStatus}}){{end}}</span>
         <ul>
         {{range .Links}}
-          <li><code>{{.Method}}</code> <a href="{{.Href}}">{{.Href}}</a> <span class="meta">rel={{.Rel}}</span></li>
+          <li><code>{{.Method}}</code> {{if .Templated}}<code>{{.Href}}</code>{{else}}<a href="{{.Href}}">{{.Href}}</a>{{end}} <span class="meta">rel={{.Rel}}</span></li>
         {{end}}
         </ul>
       </li>
@@ -1,16 +1,29 @@
 package httpd
 
 import (
+	"bytes"
+	"context"
+	"crypto/sha256"
 	"encoding/json"
+	"image/png"
+	"log/slog"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
 	"strings"
 	"sync"
 	"testing"
+	"testing/fstest"
 	"time"
 
+	"github.com/ampcode/wmux/internal/assets"
 	"github.com/ampcode/wmux/internal/policy"
 	"github.com/ampcode/wmux/internal/wshub"
+	"github.com/gorilla/websocket"
 )
 
 func TestPaneTargetHrefUsesPaneIDPath(t *testing.T) {
@@ -232,6 +245,46 @@ func TestPaneRouteNormalizesInvalidTermQueryUsingDefault(t *testing.T) {
 	}
 }
 
+func TestPaneRouteRedirectPreservesQueryOrderAndEncoding(t *testing.T) {
+	hub := wshub.New(policy.Default(), "webui")
+	h, err := NewServer(Config{Hub: hub, DefaultTerm: "xterm"})
+	if err != nil {
+		t.Fatalf("NewServer: %v", err)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/p/13?z=1&term=bogus&a=b%20c&m&term=other", nil)
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusFound {
+		t.Fatalf("status = %d, body = %s", rec.Code, rec.Body.String())
+	}
+	want := "/p/13?z=1&a=b%20c&m&term=xterm"
+	if got := rec.Header().Get("Location"); got != want {
+		t.Fatalf("location = %q, want %q", got, want)
+	}
+}
+
+func TestPaneRouteFallsBackWhenDefaultTermUnknown(t *testing.T) {
+	hub := wshub.New(policy.Default(), "webui")
+	h, err := NewServer(Config{Hub: hub, DefaultTerm: "bogus"})
+	if err != nil {
+		t.Fatalf("NewServer: %v", err)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/p/13?term=alsobogus", nil)
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusFound {
+		t.Fatalf("status = %d, body = %s", rec.Code, rec.Body.String())
+	}
+	want := "/p/13?term=" + assets.DefaultTerminalRenderer()
+	if got := rec.Header().Get("Location"); got != want {
+		t.Fatalf("location = %q, want %q", got, want)
+	}
+}
+
 func TestAPIContentsReturnsRawPlainPaneContents(t *testing.T) {
 	hub := wshub.New(policy.Default(), "webui")
 	tmux := &scriptedTmuxSender{hub: hub}
@@ -258,12 +311,12 @@ func TestAPIContentsReturnsRawPlainPaneContents(t *testing.T) {
 	if got := rec.Header().Get("Content-Type"); !strings.HasPrefix(got, "text/plain") {
 		t.Fatalf("content-type = %q, want text/plain", got)
 	}
-	if got := rec.Body.String(); got != "plain-line" {
-		t.Fatalf("body = %q, want %q", got, "plain-line")
+	if got := rec.Body.String(); got != "plain-line\n" {
+		t.Fatalf("body = %q, want %q", got, "plain-line\n")
 	}
 }
 
-func TestAPIContentsReturnsRawEscapedPaneContents(t *testing.T) {
+func TestAPIContentsJoinAddsJoinWrappedFlag(t *testing.T) {
 	hub := wshub.New(policy.Default(), "webui")
 	tmux := &scriptedTmuxSender{hub: hub}
 	if err := hub.BindTmux(tmux); err != nil {
@@ -279,22 +332,31 @@ func TestAPIContentsReturnsRawEscapedPaneContents(t *testing.T) {
 		t.Fatalf("NewServer: %v", err)
 	}
 
-	req := httptest.NewRequest(http.MethodGet, "/api/contents/13?escapes=1", nil)
-	rec := httptest.NewRecorder()
-	h.ServeHTTP(rec, req)
-
-	if rec.Code != http.StatusOK {
-		t.Fatalf("status = %d, body = %s", rec.Code, rec.Body.String())
-	}
-	if got := rec.Header().Get("Content-Type"); !strings.HasPrefix(got, "text/plain") {
-		t.Fatalf("content-type = %q, want text/plain", got)
+	cases := []struct {
+		query string
+		cmd   string
+	}{
+		{"?join=1", "capture-pane -p -J -N -t %13"},
+		{"?join=1&escapes=1", "capture-pane -p -e -J -N -t %13"},
 	}
-	if got := rec.Body.String(); got != "\u001b[31mred\u001b[0m" {
-		t.Fatalf("body = %q, want escape-decorated output", got)
+	for _, tc := range cases {
+		req := httptest.NewRequest(http.MethodGet, "/api/contents/13"+tc.query, nil)
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%s: status = %d, body = %s", tc.query, rec.Code, rec.Body.String())
+		}
+		if got := tmux.LastCommandWithPrefix("capture-pane "); got != tc.cmd {
+			t.Fatalf("%s: command = %q, want %q", tc.query, got, tc.cmd)
+		}
+		if got := rec.Body.String(); got != "joined-logical-line\n" {
+			t.Fatalf("%s: body = %q, want joined line", tc.query, got)
+		}
 	}
 }
 
-func TestAPIContentsReturnsNotFoundForUnknownPane(t *testing.T) {
+func TestAPIContentsOmitsTrailingNewlineWhenDisabled(t *testing.T) {
 	hub := wshub.New(policy.Default(), "webui")
 	tmux := &scriptedTmuxSender{hub: hub}
 	if err := hub.BindTmux(tmux); err != nil {
@@ -310,18 +372,21 @@ func TestAPIContentsReturnsNotFoundForUnknownPane(t *testing.T) {
 		t.Fatalf("NewServer: %v", err)
 	}
 
-	req := httptest.NewRequest(http.MethodGet, "/api/contents/1", nil)
+	req := httptest.NewRequest(http.MethodGet, "/api/contents/13?trailing_newline=0", nil)
 	rec := httptest.NewRecorder()
 	h.ServeHTTP(rec, req)
 
-	if rec.Code != http.StatusNotFound {
+	if rec.Code != http.StatusOK {
 		t.Fatalf("status = %d, body = %s", rec.Code, rec.Body.String())
 	}
+	if got := rec.Body.String(); got != "plain-line" {
+		t.Fatalf("body = %q, want %q", got, "plain-line")
+	}
 }
 
-func TestAPIStateReturnsStablePaneIDWithoutAbsolutePaneID(t *testing.T) {
+func TestAPIContentsEmptyPaneReturnsEmptyBody(t *testing.T) {
 	hub := wshub.New(policy.Default(), "webui")
-	tmux := &scriptedTmuxSender{hub: hub}
+	tmux := &scriptedTmuxSender{hub: hub, emptyCapture: true}
 	if err := hub.BindTmux(tmux); err != nil {
 		t.Fatalf("BindTmux: %v", err)
 	}
@@ -335,38 +400,19 @@ func TestAPIStateReturnsStablePaneIDWithoutAbsolutePaneID(t *testing.T) {
 		t.Fatalf("NewServer: %v", err)
 	}
 
-	req := httptest.NewRequest(http.MethodGet, "/api/state.json", nil)
+	req := httptest.NewRequest(http.MethodGet, "/api/contents/13", nil)
 	rec := httptest.NewRecorder()
 	h.ServeHTTP(rec, req)
 
 	if rec.Code != http.StatusOK {
 		t.Fatalf("status = %d, body = %s", rec.Code, rec.Body.String())
 	}
-
-	var payload struct {
-		Panes []map[string]any `json:"panes"`
-	}
-	if err := json.Unmarshal(rec.Body.Bytes(), &payload); err != nil {
-		t.Fatalf("decode response: %v", err)
-	}
-	if len(payload.Panes) == 0 {
-		t.Fatalf("expected at least one pane")
-	}
-	if got, ok := payload.Panes[0]["pane_id"]; !ok || got != "13" {
-		t.Fatalf("stable pane id missing or unexpected: %v", payload.Panes[0])
-	}
-	if got, ok := payload.Panes[0]["window_name"]; !ok || got != "main" {
-		t.Fatalf("window_name missing or unexpected: %v", payload.Panes[0])
-	}
-	if got, ok := payload.Panes[0]["window_index"]; !ok || got != float64(0) {
-		t.Fatalf("window_index missing or unexpected: %v", payload.Panes[0])
-	}
-	if _, ok := payload.Panes[0]["id"]; ok {
-		t.Fatalf("unexpected absolute pane id field present: %v", payload.Panes[0])
+	if got := rec.Body.String(); got != "" {
+		t.Fatalf("body = %q, want empty", got)
 	}
 }
 
-func TestAPIPaneReturnsSinglePaneResource(t *testing.T) {
+func TestAPIContentsReturnsRawEscapedPaneContents(t *testing.T) {
 	hub := wshub.New(policy.Default(), "webui")
 	tmux := &scriptedTmuxSender{hub: hub}
 	if err := hub.BindTmux(tmux); err != nil {
@@ -382,57 +428,53 @@ func TestAPIPaneReturnsSinglePaneResource(t *testing.T) {
 		t.Fatalf("NewServer: %v", err)
 	}
 
-	req := httptest.NewRequest(http.MethodGet, "/api/panes/13", nil)
+	req := httptest.NewRequest(http.MethodGet, "/api/contents/13?escapes=1", nil)
 	rec := httptest.NewRecorder()
 	h.ServeHTTP(rec, req)
 
 	if rec.Code != http.StatusOK {
 		t.Fatalf("status = %d, body = %s", rec.Code, rec.Body.String())
 	}
-
-	var payload struct {
-		Resource string `json:"resource"`
-		Panes    []struct {
-			PaneID string `json:"pane_id"`
-			Links  []struct {
-				Rel  string `json:"rel"`
-				Href string `json:"href"`
-			} `json:"links"`
-		} `json:"panes"`
-	}
-	if err := json.Unmarshal(rec.Body.Bytes(), &payload); err != nil {
-		t.Fatalf("decode response: %v", err)
-	}
-	if payload.Resource != "wmux-pane" {
-		t.Fatalf("resource = %q, want %q", payload.Resource, "wmux-pane")
-	}
-	if len(payload.Panes) != 1 || payload.Panes[0].PaneID != "13" {
-		t.Fatalf("unexpected panes payload: %#v", payload.Panes)
+	if got := rec.Header().Get("Content-Type"); !strings.HasPrefix(got, "text/plain") {
+		t.Fatalf("content-type = %q, want text/plain", got)
 	}
-	if !hasPaneLink(payload.Panes[0].Links, "self", "/api/panes/13") {
-		t.Fatalf("pane self link missing: %#v", payload.Panes[0].Links)
+	if got := rec.Body.String(); got != "\u001b[31mred\u001b[0m\n" {
+		t.Fatalf("body = %q, want escape-decorated output", got)
 	}
 }
 
-func TestAPIPaneReturnsNotFoundForUnknownPane(t *testing.T) {
+func TestAPIContentsPadsToPaneHeight(t *testing.T) {
 	hub := wshub.New(policy.Default(), "webui")
+	tmux := &scriptedTmuxSender{hub: hub}
+	if err := hub.BindTmux(tmux); err != nil {
+		t.Fatalf("BindTmux: %v", err)
+	}
+	if err := hub.RequestStateSync(); err != nil {
+		t.Fatalf("RequestStateSync: %v", err)
+	}
+	waitForTargetPaneID(t, hub, "13")
+
 	h, err := NewServer(Config{Hub: hub})
 	if err != nil {
 		t.Fatalf("NewServer: %v", err)
 	}
 
-	req := httptest.NewRequest(http.MethodGet, "/api/panes/404", nil)
+	req := httptest.NewRequest(http.MethodGet, "/api/contents/13?pad=1", nil)
 	rec := httptest.NewRecorder()
 	h.ServeHTTP(rec, req)
 
-	if rec.Code != http.StatusNotFound {
+	if rec.Code != http.StatusOK {
 		t.Fatalf("status = %d, body = %s", rec.Code, rec.Body.String())
 	}
+	want := "plain-line" + strings.Repeat("\n", 40)
+	if got := rec.Body.String(); got != want {
+		t.Fatalf("body = %q, want %q", got, want)
+	}
 }
 
-func TestAPIPanesCreatesPaneWithOptions(t *testing.T) {
+func TestAPIContentsTrimsTrailingSpaceOnRequest(t *testing.T) {
 	hub := wshub.New(policy.Default(), "webui")
-	tmux := &scriptedTmuxSender{hub: hub}
+	tmux := &scriptedTmuxSender{hub: hub, captureLines: []string{"$ make   ", "ok\t ", "  indented"}}
 	if err := hub.BindTmux(tmux); err != nil {
 		t.Fatalf("BindTmux: %v", err)
 	}
@@ -446,113 +488,217 @@ func TestAPIPanesCreatesPaneWithOptions(t *testing.T) {
 		t.Fatalf("NewServer: %v", err)
 	}
 
-	body := strings.NewReader(`{"env":{"FOO":"bar","ALPHA":"1"},"cwd":"/tmp/work dir","cmd":["bash","-lc","echo hi"]}`)
-	req := httptest.NewRequest(http.MethodPost, "/api/panes", body)
-	req.Header.Set("Content-Type", "application/json")
-	rec := httptest.NewRecorder()
-	h.ServeHTTP(rec, req)
-
-	if rec.Code != http.StatusCreated {
-		t.Fatalf("status = %d, body = %s", rec.Code, rec.Body.String())
-	}
-	if got := rec.Header().Get("Location"); got != "/api/panes/14" {
-		t.Fatalf("location = %q, want %q", got, "/api/panes/14")
+	for _, tc := range []struct {
+		query string
+		want  string
+	}{
+		{"", "$ make   \nok\t \n  indented\n"},
+		{"?trim=0", "$ make   \nok\t \n  indented\n"},
+		{"?trim=1", "$ make\nok\n  indented\n"},
+	} {
+		req := httptest.NewRequest(http.MethodGet, "/api/contents/13"+tc.query, nil)
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%q: status = %d, body = %s", tc.query, rec.Code, rec.Body.String())
+		}
+		if got := rec.Body.String(); got != tc.want {
+			t.Fatalf("%q: body = %q, want %q", tc.query, got, tc.want)
+		}
 	}
+}
 
-	var payload struct {
-		Resource string `json:"resource"`
-		Actions  []struct {
-			Name   string         `json:"name"`
-			Schema map[string]any `json:"schema"`
-		} `json:"actions"`
-		Panes []struct {
-			PaneID string `json:"pane_id"`
-			Links  []struct {
-				Rel  string `json:"rel"`
-				Href string `json:"href"`
-			} `json:"links"`
-		} `json:"panes"`
+func TestAPIContentsGrepReturnsMatchesWithContext(t *testing.T) {
+	hub := wshub.New(policy.Default(), "webui")
+	tmux := &scriptedTmuxSender{hub: hub, captureLines: []string{"a", "ERROR one", "b", "c", "d", "e", "ERROR two", "f"}}
+	if err := hub.BindTmux(tmux); err != nil {
+		t.Fatalf("BindTmux: %v", err)
 	}
-	if err := json.Unmarshal(rec.Body.Bytes(), &payload); err != nil {
-		t.Fatalf("decode response: %v", err)
+	if err := hub.RequestStateSync(); err != nil {
+		t.Fatalf("RequestStateSync: %v", err)
 	}
-	if payload.Resource != "wmux-pane" {
-		t.Fatalf("resource = %q, want %q", payload.Resource, "wmux-pane")
+	waitForTargetPaneID(t, hub, "13")
+
+	h, err := NewServer(Config{Hub: hub})
+	if err != nil {
+		t.Fatalf("NewServer: %v", err)
 	}
-	if len(payload.Panes) != 1 || payload.Panes[0].PaneID != "14" {
-		t.Fatalf("unexpected panes payload: %#v", payload.Panes)
+
+	for _, tc := range []struct {
+		query string
+		want  string
+	}{
+		{"?grep=ERROR", "ERROR one\n--\nERROR two\n"},
+		{"?grep=ERROR&context=1", "a\nERROR one\nb\n--\ne\nERROR two\nf\n"},
+		{"?grep=ERROR&context=2", "a\nERROR one\nb\nc\nd\ne\nERROR two\nf\n"},
+		{"?grep=%5EERROR+t&regex=1", "ERROR two\n"},
+		{"?grep=nothing&context=3", ""},
+	} {
+		req := httptest.NewRequest(http.MethodGet, "/api/contents/13"+tc.query, nil)
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%q: status = %d, body = %s", tc.query, rec.Code, rec.Body.String())
+		}
+		if got := rec.Body.String(); got != tc.want {
+			t.Fatalf("%q: body = %q, want %q", tc.query, got, tc.want)
+		}
 	}
-	if !hasPaneLink(payload.Panes[0].Links, "self", "/api/panes/14") {
-		t.Fatalf("missing self link in create response: %#v", payload.Panes[0].Links)
+
+	req := httptest.NewRequest(http.MethodGet, "/api/contents/13?grep=two&context=1", nil)
+	req.Header.Set("Accept", "application/json")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("json: status = %d, body = %s", rec.Code, rec.Body.String())
 	}
-	if len(payload.Actions) == 0 || payload.Actions[0].Name != "create-pane" {
-		t.Fatalf("missing create-pane action: %#v", payload.Actions)
+	var res grepResult
+	if err := json.Unmarshal(rec.Body.Bytes(), &res); err != nil {
+		t.Fatalf("decode: %v; body = %s", err, rec.Body.String())
 	}
-	if _, ok := payload.Actions[0].Schema["properties"]; !ok {
-		t.Fatalf("missing machine-readable schema in action: %#v", payload.Actions[0].Schema)
+	want := []grepLine{{Line: 6, Text: "e"}, {Line: 7, Text: "ERROR two", Match: true}, {Line: 8, Text: "f"}}
+	if res.PaneID != "13" || res.Context != 1 || len(res.Blocks) != 1 || !reflect.DeepEqual(res.Blocks[0].Lines, want) {
+		t.Fatalf("json result = %+v, want one block %+v", res, want)
 	}
 
-	line := tmux.LastCommandWithPrefix("split-window ")
-	if line == "" {
-		t.Fatalf("missing split-window command")
-	}
-	if !strings.Contains(line, "split-window -P -F '#{pane_id}' -t webui") {
-		t.Fatalf("unexpected split-window command: %q", line)
-	}
-	if !strings.Contains(line, "-c '/tmp/work dir'") {
-		t.Fatalf("split-window missing cwd: %q", line)
+	for _, query := range []string{"?grep=", "?grep=(&regex=1", "?grep=x&context=-1", "?grep=x&context=101"} {
+		req := httptest.NewRequest(http.MethodGet, "/api/contents/13"+query, nil)
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		if rec.Code != http.StatusBadRequest {
+			t.Fatalf("%q: status = %d, want 400", query, rec.Code)
+		}
 	}
-	if !strings.Contains(line, "-e 'ALPHA=1' -e 'FOO=bar'") {
-		t.Fatalf("split-window missing env vars: %q", line)
+}
+
+func TestSanitizeEscapes(t *testing.T) {
+	in := "\x1b[31mred\x1b[0m \x1b]8;;https://x\x07link\x1b]8;;\x1b\\ \x1b[2J\x1b(Bend\x1b[1"
+	cases := map[string]string{
+		sanitizeAll:  in,
+		sanitizeSGR:  "\x1b[31mred\x1b[0m link end",
+		sanitizeNone: "red link end",
 	}
-	if !strings.Contains(line, "'bash -lc '\\''echo hi'\\'''") {
-		t.Fatalf("split-window missing cmd argv: %q", line)
+	for mode, want := range cases {
+		if got := sanitizeEscapes(in, mode); got != want {
+			t.Fatalf("sanitizeEscapes(%s) = %q, want %q", mode, got, want)
+		}
 	}
 }
 
-func TestAPIPanesRejectsInvalidEnvKey(t *testing.T) {
+func TestAPIContentsSanitize(t *testing.T) {
 	hub := wshub.New(policy.Default(), "webui")
 	tmux := &scriptedTmuxSender{hub: hub}
 	if err := hub.BindTmux(tmux); err != nil {
 		t.Fatalf("BindTmux: %v", err)
 	}
+	if err := hub.RequestStateSync(); err != nil {
+		t.Fatalf("RequestStateSync: %v", err)
+	}
+	waitForTargetPaneID(t, hub, "13")
 
 	h, err := NewServer(Config{Hub: hub})
 	if err != nil {
 		t.Fatalf("NewServer: %v", err)
 	}
 
-	body := strings.NewReader(`{"env":{"BAD-KEY":"v"}}`)
-	req := httptest.NewRequest(http.MethodPost, "/api/panes", body)
-	req.Header.Set("Content-Type", "application/json")
-	rec := httptest.NewRecorder()
-	h.ServeHTTP(rec, req)
-
-	if rec.Code != http.StatusBadRequest {
-		t.Fatalf("status = %d, body = %s", rec.Code, rec.Body.String())
+	cases := []struct {
+		query string
+		code  int
+		body  string
+	}{
+		{"?escapes=1&sanitize=sgr", http.StatusOK, "\u001b[31mred\u001b[0m\n"},
+		{"?escapes=1&sanitize=none", http.StatusOK, "plain-line\n"},
+		{"?escapes=1&sanitize=bogus", http.StatusBadRequest, ""},
 	}
-	if line := tmux.LastCommandWithPrefix("split-window "); line != "" {
-		t.Fatalf("unexpected split-window command: %q", line)
+	for _, tc := range cases {
+		req := httptest.NewRequest(http.MethodGet, "/api/contents/13"+tc.query, nil)
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+
+		if rec.Code != tc.code {
+			t.Fatalf("%s: status = %d, body = %s", tc.query, rec.Code, rec.Body.String())
+		}
+		if tc.code == http.StatusOK && rec.Body.String() != tc.body {
+			t.Fatalf("%s: body = %q, want %q", tc.query, rec.Body.String(), tc.body)
+		}
 	}
 }
 
-func TestAPIPanesRejectsNonPost(t *testing.T) {
+func TestAPISearchMatchesCapturedPaneLines(t *testing.T) {
 	hub := wshub.New(policy.Default(), "webui")
+	tmux := &scriptedTmuxSender{hub: hub}
+	if err := hub.BindTmux(tmux); err != nil {
+		t.Fatalf("BindTmux: %v", err)
+	}
+	if err := hub.RequestStateSync(); err != nil {
+		t.Fatalf("RequestStateSync: %v", err)
+	}
+	waitForTargetPaneID(t, hub, "13")
+
 	h, err := NewServer(Config{Hub: hub})
 	if err != nil {
 		t.Fatalf("NewServer: %v", err)
 	}
 
-	req := httptest.NewRequest(http.MethodGet, "/api/panes", nil)
-	rec := httptest.NewRecorder()
-	h.ServeHTTP(rec, req)
+	cases := []struct {
+		query   string
+		code    int
+		matches int
+	}{
+		{"?q=plain", http.StatusOK, 1},
+		{"?q=PLAIN", http.StatusOK, 0},
+		{"?q=%5Epl.*e%24&regex=1", http.StatusOK, 1},
+		{"?q=%5Epl.*e%24", http.StatusOK, 0},
+		{"?q=red&escapes=1", http.StatusOK, 1},
+		{"?q=(&regex=1", http.StatusBadRequest, 0},
+		{"?q=plain&limit=0", http.StatusBadRequest, 0},
+		{"", http.StatusBadRequest, 0},
+	}
+	for _, tc := range cases {
+		req := httptest.NewRequest(http.MethodGet, "/api/search"+tc.query, nil)
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+
+		if rec.Code != tc.code {
+			t.Fatalf("%s: status = %d, body = %s", tc.query, rec.Code, rec.Body.String())
+		}
+		if tc.code != http.StatusOK {
+			continue
+		}
+		var res searchResult
+		if err := json.Unmarshal(rec.Body.Bytes(), &res); err != nil {
+			t.Fatalf("%s: decode: %v", tc.query, err)
+		}
+		if len(res.Matches) != tc.matches || res.Truncated {
+			t.Fatalf("%s: result = %+v, want %d matches", tc.query, res, tc.matches)
+		}
+		if tc.matches > 0 && (res.Matches[0].PaneID != "13" || res.Matches[0].Line != 1) {
+			t.Fatalf("%s: match = %+v, want pane 13 line 1", tc.query, res.Matches[0])
+		}
+	}
+}
 
-	if rec.Code != http.StatusMethodNotAllowed {
-		t.Fatalf("status = %d, body = %s", rec.Code, rec.Body.String())
+func TestPadContentToHeight(t *testing.T) {
+	cases := []struct {
+		content string
+		height  int
+		want    string
+	}{
+		{"a\nb", 4, "a\nb\n\n"},
+		{"a\nb\nc", 2, "a\nb\nc"},
+		{"", 3, "\n\n"},
+		{"a", 0, "a"},
+	}
+	for _, tc := range cases {
+		if got := padContentToHeight(tc.content, tc.height); got != tc.want {
+			t.Fatalf("padContentToHeight(%q, %d) = %q, want %q", tc.content, tc.height, got, tc.want)
+		}
 	}
 }
 
-func TestAPIDebugUnicodeCapturesLatestReport(t *testing.T) {
+func TestAPIContentsReturnsNotFoundForUnknownPane(t *testing.T) {
 	hub := wshub.New(policy.Default(), "webui")
 	tmux := &scriptedTmuxSender{hub: hub}
 	if err := hub.BindTmux(tmux); err != nil {
@@ -568,156 +714,3119 @@ func TestAPIDebugUnicodeCapturesLatestReport(t *testing.T) {
 		t.Fatalf("NewServer: %v", err)
 	}
 
-	body := strings.NewReader(`{"renderer":"ghostty","url":"http://localhost/p/13?term=ghostty","user_agent":"ua","source":"pane_snapshot","pane_id":"13","data_length":32,"data_sample":"bad\ufffdchars"}`)
-	postReq := httptest.NewRequest(http.MethodPost, "/api/debug/unicode", body)
-	postReq.Header.Set("Content-Type", "application/json")
-	postRec := httptest.NewRecorder()
-	h.ServeHTTP(postRec, postReq)
+	req := httptest.NewRequest(http.MethodGet, "/api/contents/1", nil)
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
 
-	if postRec.Code != http.StatusOK {
-		t.Fatalf("post status = %d, body = %s", postRec.Code, postRec.Body.String())
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("status = %d, body = %s", rec.Code, rec.Body.String())
 	}
+}
 
-	var postResp map[string]any
-	if err := json.Unmarshal(postRec.Body.Bytes(), &postResp); err != nil {
-		t.Fatalf("decode post response: %v", err)
-	}
+func TestAPIContentsReturnsNotReadyBeforeFirstSync(t *testing.T) {
+	hub := wshub.New(policy.Default(), "webui")
+	if err := hub.BindTmux(silentTmuxSender{}); err != nil {
+		t.Fatalf("BindTmux: %v", err)
+	}
+	h, err := NewServer(Config{Hub: hub})
+	if err != nil {
+		t.Fatalf("NewServer: %v", err)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/api/contents/13", nil)
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("status = %d, body = %s", rec.Code, rec.Body.String())
+	}
+	if rec.Header().Get("Retry-After") == "" {
+		t.Fatalf("missing Retry-After header")
+	}
+}
+
+func TestAPIStateReturnsStablePaneIDWithoutAbsolutePaneID(t *testing.T) {
+	hub := wshub.New(policy.Default(), "webui")
+	tmux := &scriptedTmuxSender{hub: hub}
+	if err := hub.BindTmux(tmux); err != nil {
+		t.Fatalf("BindTmux: %v", err)
+	}
+	if err := hub.RequestStateSync(); err != nil {
+		t.Fatalf("RequestStateSync: %v", err)
+	}
+	waitForTargetPaneID(t, hub, "13")
+
+	h, err := NewServer(Config{Hub: hub})
+	if err != nil {
+		t.Fatalf("NewServer: %v", err)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/api/state.json", nil)
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, body = %s", rec.Code, rec.Body.String())
+	}
+
+	var payload struct {
+		Panes []map[string]any `json:"panes"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &payload); err != nil {
+		t.Fatalf("decode response: %v", err)
+	}
+	if len(payload.Panes) == 0 {
+		t.Fatalf("expected at least one pane")
+	}
+	if got, ok := payload.Panes[0]["pane_id"]; !ok || got != "13" {
+		t.Fatalf("stable pane id missing or unexpected: %v", payload.Panes[0])
+	}
+	if got, ok := payload.Panes[0]["window_name"]; !ok || got != "main" {
+		t.Fatalf("window_name missing or unexpected: %v", payload.Panes[0])
+	}
+	if got, ok := payload.Panes[0]["window_index"]; !ok || got != float64(0) {
+		t.Fatalf("window_index missing or unexpected: %v", payload.Panes[0])
+	}
+	if _, ok := payload.Panes[0]["id"]; ok {
+		t.Fatalf("unexpected absolute pane id field present: %v", payload.Panes[0])
+	}
+}
+
+func TestAPIStateHonorsIfNoneMatch(t *testing.T) {
+	hub := wshub.New(policy.Default(), "webui")
+	tmux := &scriptedTmuxSender{hub: hub}
+	if err := hub.BindTmux(tmux); err != nil {
+		t.Fatalf("BindTmux: %v", err)
+	}
+	if err := hub.RequestStateSync(); err != nil {
+		t.Fatalf("RequestStateSync: %v", err)
+	}
+	waitForTargetPaneID(t, hub, "13")
+
+	h, err := NewServer(Config{Hub: hub})
+	if err != nil {
+		t.Fatalf("NewServer: %v", err)
+	}
+
+	get := func(ifNoneMatch string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/api/state.json", nil)
+		if ifNoneMatch != "" {
+			req.Header.Set("If-None-Match", ifNoneMatch)
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+
+	first := get("")
+	etag := first.Header().Get("ETag")
+	if first.Code != http.StatusOK || !strings.HasPrefix(etag, `W/"`) || first.Body.Len() == 0 {
+		t.Fatalf("first: status = %d, etag = %q, body = %d bytes", first.Code, etag, first.Body.Len())
+	}
+
+	second := get(etag)
+	if second.Code != http.StatusNotModified || second.Body.Len() != 0 {
+		t.Fatalf("second: status = %d, body = %q, want empty 304", second.Code, second.Body.String())
+	}
+	if second.Header().Get("ETag") != etag {
+		t.Fatalf("304 etag = %q, want %q", second.Header().Get("ETag"), etag)
+	}
+
+	if rec := get(`"other", ` + strings.TrimPrefix(etag, "W/")); rec.Code != http.StatusNotModified {
+		t.Fatalf("list with strong form of etag: status = %d, want 304", rec.Code)
+	}
+	if rec := get(`W/"0000000000000000"`); rec.Code != http.StatusOK || rec.Body.Len() == 0 {
+		t.Fatalf("stale etag: status = %d, want 200 with body", rec.Code)
+	}
+}
+
+func TestAPIStateIncludesWindowsWithActiveWindow(t *testing.T) {
+	hub := wshub.New(policy.Default(), "webui")
+	tmux := &scriptedTmuxSender{hub: hub}
+	if err := hub.BindTmux(tmux); err != nil {
+		t.Fatalf("BindTmux: %v", err)
+	}
+	if err := hub.RequestStateSync(); err != nil {
+		t.Fatalf("RequestStateSync: %v", err)
+	}
+	waitForTargetPaneID(t, hub, "13")
+
+	h, err := NewServer(Config{Hub: hub})
+	if err != nil {
+		t.Fatalf("NewServer: %v", err)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/api/state.json", nil)
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+
+	var payload struct {
+		Windows []windowDocument `json:"windows"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &payload); err != nil {
+		t.Fatalf("decode response: %v", err)
+	}
+	if len(payload.Windows) != 1 {
+		t.Fatalf("unexpected windows: %#v", payload.Windows)
+	}
+	if got := payload.Windows[0]; got.Name != "main" || !got.Active || got.PaneCount != 1 {
+		t.Fatalf("unexpected window document: %#v", got)
+	}
+}
+
+func TestAPIStateNestedGroupsPanesUnderWindows(t *testing.T) {
+	hub := wshub.New(policy.Default(), "webui")
+	tmux := &scriptedTmuxSender{hub: hub}
+	if err := hub.BindTmux(tmux); err != nil {
+		t.Fatalf("BindTmux: %v", err)
+	}
+	if err := hub.RequestStateSync(); err != nil {
+		t.Fatalf("RequestStateSync: %v", err)
+	}
+	waitForTargetPaneID(t, hub, "13")
+
+	h, err := NewServer(Config{Hub: hub, BasePath: "/wmux"})
+	if err != nil {
+		t.Fatalf("NewServer: %v", err)
+	}
+	get := func(target string) hypermediaDocument {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, target, nil))
+		var doc hypermediaDocument
+		if err := json.Unmarshal(rec.Body.Bytes(), &doc); err != nil {
+			t.Fatalf("decode %s: %v", target, err)
+		}
+		return doc
+	}
+
+	flat := get("/wmux/api/state.json")
+	if len(flat.Panes) != 1 || len(flat.Windows) != 1 || flat.Windows[0].Panes != nil {
+		t.Fatalf("default state is not flat: panes=%d windows=%#v", len(flat.Panes), flat.Windows)
+	}
+
+	nested := get("/wmux/api/state.json?nested=1")
+	if len(nested.Panes) != 0 {
+		t.Fatalf("nested top-level panes = %#v, want none", nested.Panes)
+	}
+	if len(nested.Windows) != 1 || len(nested.Windows[0].Panes) != 1 {
+		t.Fatalf("nested windows = %#v, want one window holding one pane", nested.Windows)
+	}
+	pane := nested.Windows[0].Panes[0]
+	if pane.PaneID != "13" || len(pane.Links) == 0 || pane.Links[0].Href != "/wmux/api/panes/13" {
+		t.Fatalf("nested pane = %#v, want pane 13 with rebased links", pane)
+	}
+}
+
+func TestAPIPaneReturnsSinglePaneResource(t *testing.T) {
+	hub := wshub.New(policy.Default(), "webui")
+	tmux := &scriptedTmuxSender{hub: hub}
+	if err := hub.BindTmux(tmux); err != nil {
+		t.Fatalf("BindTmux: %v", err)
+	}
+	if err := hub.RequestStateSync(); err != nil {
+		t.Fatalf("RequestStateSync: %v", err)
+	}
+	waitForTargetPaneID(t, hub, "13")
+
+	h, err := NewServer(Config{Hub: hub})
+	if err != nil {
+		t.Fatalf("NewServer: %v", err)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/api/panes/13", nil)
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, body = %s", rec.Code, rec.Body.String())
+	}
+
+	var payload struct {
+		Resource string `json:"resource"`
+		Panes    []struct {
+			PaneID string `json:"pane_id"`
+			Links  []struct {
+				Rel  string `json:"rel"`
+				Href string `json:"href"`
+			} `json:"links"`
+		} `json:"panes"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &payload); err != nil {
+		t.Fatalf("decode response: %v", err)
+	}
+	if payload.Resource != "wmux-pane" {
+		t.Fatalf("resource = %q, want %q", payload.Resource, "wmux-pane")
+	}
+	if len(payload.Panes) != 1 || payload.Panes[0].PaneID != "13" {
+		t.Fatalf("unexpected panes payload: %#v", payload.Panes)
+	}
+	if !hasPaneLink(payload.Panes[0].Links, "self", "/api/panes/13") {
+		t.Fatalf("pane self link missing: %#v", payload.Panes[0].Links)
+	}
+}
+
+func TestAPIPaneFormatReturnsDisplayMessageValue(t *testing.T) {
+	hub := wshub.New(policy.Default(), "webui")
+	tmux := &scriptedTmuxSender{hub: hub}
+	if err := hub.BindTmux(tmux); err != nil {
+		t.Fatalf("BindTmux: %v", err)
+	}
+	if err := hub.RequestStateSync(); err != nil {
+		t.Fatalf("RequestStateSync: %v", err)
+	}
+	waitForTargetPaneID(t, hub, "13")
+
+	h, err := NewServer(Config{Hub: hub})
+	if err != nil {
+		t.Fatalf("NewServer: %v", err)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/api/panes/13/format?fmt=%23%7Bpane_pid%7D", nil)
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, body = %s", rec.Code, rec.Body.String())
+	}
+	if got := rec.Body.String(); got != "4242" {
+		t.Fatalf("body = %q, want %q", got, "4242")
+	}
+}
+
+func TestAPIPaneFormatReportsTmuxError(t *testing.T) {
+	hub := wshub.New(policy.Default(), "webui")
+	tmux := &scriptedTmuxSender{hub: hub}
+	if err := hub.BindTmux(tmux); err != nil {
+		t.Fatalf("BindTmux: %v", err)
+	}
+	if err := hub.RequestStateSync(); err != nil {
+		t.Fatalf("RequestStateSync: %v", err)
+	}
+	waitForTargetPaneID(t, hub, "13")
+
+	h, err := NewServer(Config{Hub: hub})
+	if err != nil {
+		t.Fatalf("NewServer: %v", err)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/api/panes/13/format?fmt=%23%7Bbogus", nil)
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusBadGateway {
+		t.Fatalf("status = %d, body = %s", rec.Code, rec.Body.String())
+	}
+	if got := strings.TrimSpace(rec.Body.String()); got != "display-message failed: parse error: unterminated format" {
+		t.Fatalf("body = %q, want tmux error text", got)
+	}
+}
+
+func TestAPIPaneFormatRejectsInvalidFormat(t *testing.T) {
+	hub := wshub.New(policy.Default(), "webui")
+	tmux := &scriptedTmuxSender{hub: hub}
+	if err := hub.BindTmux(tmux); err != nil {
+		t.Fatalf("BindTmux: %v", err)
+	}
+
+	h, err := NewServer(Config{Hub: hub})
+	if err != nil {
+		t.Fatalf("NewServer: %v", err)
+	}
+
+	for _, target := range []string{
+		"/api/panes/13/format",
+		"/api/panes/13/format?fmt=a%0Ab",
+		"/api/panes/13/format?fmt=" + strings.Repeat("x", maxPaneFormatLength+1),
+	} {
+		req := httptest.NewRequest(http.MethodGet, target, nil)
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		if rec.Code != http.StatusBadRequest {
+			t.Fatalf("%s: status = %d, body = %s", target, rec.Code, rec.Body.String())
+		}
+	}
+	if line := tmux.LastCommandWithPrefix("display-message "); line != "" {
+		t.Fatalf("unexpected display-message command: %q", line)
+	}
+}
+
+func TestAPIPaneReturnsNotFoundForUnknownPane(t *testing.T) {
+	hub := wshub.New(policy.Default(), "webui")
+	h, err := NewServer(Config{Hub: hub})
+	if err != nil {
+		t.Fatalf("NewServer: %v", err)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/api/panes/404", nil)
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("status = %d, body = %s", rec.Code, rec.Body.String())
+	}
+}
+
+func TestAPIPanesCreatesPaneWithOptions(t *testing.T) {
+	hub := wshub.New(policy.Default(), "webui")
+	tmux := &scriptedTmuxSender{hub: hub}
+	if err := hub.BindTmux(tmux); err != nil {
+		t.Fatalf("BindTmux: %v", err)
+	}
+	if err := hub.RequestStateSync(); err != nil {
+		t.Fatalf("RequestStateSync: %v", err)
+	}
+	waitForTargetPaneID(t, hub, "13")
+
+	h, err := NewServer(Config{Hub: hub})
+	if err != nil {
+		t.Fatalf("NewServer: %v", err)
+	}
+
+	body := strings.NewReader(`{"env":{"FOO":"bar","ALPHA":"1"},"cwd":"/tmp/work dir","cmd":["bash","-lc","echo hi"]}`)
+	req := httptest.NewRequest(http.MethodPost, "/api/panes", body)
+	req.Header.Set("Content-Type", "application/json")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("status = %d, body = %s", rec.Code, rec.Body.String())
+	}
+	if got := rec.Header().Get("Location"); got != "/api/panes/14" {
+		t.Fatalf("location = %q, want %q", got, "/api/panes/14")
+	}
+
+	var payload struct {
+		Resource string `json:"resource"`
+		Actions  []struct {
+			Name   string         `json:"name"`
+			Schema map[string]any `json:"schema"`
+		} `json:"actions"`
+		Panes []struct {
+			PaneID string `json:"pane_id"`
+			Links  []struct {
+				Rel  string `json:"rel"`
+				Href string `json:"href"`
+			} `json:"links"`
+		} `json:"panes"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &payload); err != nil {
+		t.Fatalf("decode response: %v", err)
+	}
+	if payload.Resource != "wmux-pane" {
+		t.Fatalf("resource = %q, want %q", payload.Resource, "wmux-pane")
+	}
+	if len(payload.Panes) != 1 || payload.Panes[0].PaneID != "14" {
+		t.Fatalf("unexpected panes payload: %#v", payload.Panes)
+	}
+	if !hasPaneLink(payload.Panes[0].Links, "self", "/api/panes/14") {
+		t.Fatalf("missing self link in create response: %#v", payload.Panes[0].Links)
+	}
+	if len(payload.Actions) == 0 || payload.Actions[0].Name != "create-pane" {
+		t.Fatalf("missing create-pane action: %#v", payload.Actions)
+	}
+	if _, ok := payload.Actions[0].Schema["properties"]; !ok {
+		t.Fatalf("missing machine-readable schema in action: %#v", payload.Actions[0].Schema)
+	}
+
+	line := tmux.LastCommandWithPrefix("split-window ")
+	if line == "" {
+		t.Fatalf("missing split-window command")
+	}
+	if !strings.Contains(line, "split-window -P -F '#{pane_id}' -t webui") {
+		t.Fatalf("unexpected split-window command: %q", line)
+	}
+	if !strings.Contains(line, "-c '/tmp/work dir'") {
+		t.Fatalf("split-window missing cwd: %q", line)
+	}
+	if !strings.Contains(line, "-e 'ALPHA=1' -e 'FOO=bar'") {
+		t.Fatalf("split-window missing env vars: %q", line)
+	}
+	if !strings.Contains(line, "'bash -lc '\\''echo hi'\\'''") {
+		t.Fatalf("split-window missing cmd argv: %q", line)
+	}
+}
+
+func TestAPIPanesPassesSizeToSplitWindow(t *testing.T) {
+	hub := wshub.New(policy.Default(), "webui")
+	tmux := &scriptedTmuxSender{hub: hub}
+	if err := hub.BindTmux(tmux); err != nil {
+		t.Fatalf("BindTmux: %v", err)
+	}
+	h, err := NewServer(Config{Hub: hub})
+	if err != nil {
+		t.Fatalf("NewServer: %v", err)
+	}
+
+	cases := []struct {
+		size string
+		code int
+		flag string
+	}{
+		{"30%", http.StatusCreated, "-t webui -p 30"},
+		{"12", http.StatusCreated, "-t webui -l 12"},
+		{"0", http.StatusBadRequest, ""},
+		{"100%", http.StatusBadRequest, ""},
+		{"1001", http.StatusBadRequest, ""},
+		{"+5", http.StatusBadRequest, ""},
+		{"half", http.StatusBadRequest, ""},
+	}
+	for _, tc := range cases {
+		before := tmux.LastCommandWithPrefix("split-window ")
+		req := httptest.NewRequest(http.MethodPost, "/api/panes", strings.NewReader(`{"size":"`+tc.size+`"}`))
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+
+		if rec.Code != tc.code {
+			t.Fatalf("size %q: status = %d, body = %s", tc.size, rec.Code, rec.Body.String())
+		}
+		line := tmux.LastCommandWithPrefix("split-window ")
+		if tc.flag != "" && !strings.Contains(line, tc.flag) {
+			t.Fatalf("size %q: split-window = %q, want %q", tc.size, line, tc.flag)
+		}
+		if tc.flag == "" && line != before {
+			t.Fatalf("size %q: unexpected split-window %q", tc.size, line)
+		}
+	}
+}
+
+func TestAPIPanesReportsCursorOnRequest(t *testing.T) {
+	hub := wshub.New(policy.Default(), "webui")
+	tmux := &scriptedTmuxSender{hub: hub}
+	if err := hub.BindTmux(tmux); err != nil {
+		t.Fatalf("BindTmux: %v", err)
+	}
+	h, err := NewServer(Config{Hub: hub})
+	if err != nil {
+		t.Fatalf("NewServer: %v", err)
+	}
+
+	create := func(path string) hypermediaDocument {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, path, nil))
+		if rec.Code != http.StatusCreated {
+			t.Fatalf("POST %s status = %d, body = %s", path, rec.Code, rec.Body.String())
+		}
+		var doc hypermediaDocument
+		if err := json.Unmarshal(rec.Body.Bytes(), &doc); err != nil {
+			t.Fatalf("decode: %v", err)
+		}
+		return doc
+	}
+
+	doc := create("/api/panes")
+	if doc.Panes[0].Cursor != nil {
+		t.Fatalf("cursor = %+v without ?cursor=1, want none", doc.Panes[0].Cursor)
+	}
+	if line := tmux.LastCommandWithPrefix("display-message -p -t %14 "); line != "" {
+		t.Fatalf("cursor queried without ?cursor=1: %q", line)
+	}
+
+	doc = create("/api/panes?cursor=1")
+	if got := doc.Panes[0].Cursor; got == nil || *got != (wshub.PaneCursor{X: 3, Y: 7}) {
+		t.Fatalf("cursor = %+v, want {3 7}", got)
+	}
+}
+
+func TestAPIPanesRejectsInvalidEnvKey(t *testing.T) {
+	hub := wshub.New(policy.Default(), "webui")
+	tmux := &scriptedTmuxSender{hub: hub}
+	if err := hub.BindTmux(tmux); err != nil {
+		t.Fatalf("BindTmux: %v", err)
+	}
+
+	h, err := NewServer(Config{Hub: hub})
+	if err != nil {
+		t.Fatalf("NewServer: %v", err)
+	}
+
+	body := strings.NewReader(`{"env":{"BAD-KEY":"v"}}`)
+	req := httptest.NewRequest(http.MethodPost, "/api/panes", body)
+	req.Header.Set("Content-Type", "application/json")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("status = %d, body = %s", rec.Code, rec.Body.String())
+	}
+	if line := tmux.LastCommandWithPrefix("split-window "); line != "" {
+		t.Fatalf("unexpected split-window command: %q", line)
+	}
+}
+
+func TestAPIPanesEnforcesAdvertisedSchema(t *testing.T) {
+	hub := wshub.New(policy.Default(), "webui")
+	tmux := &scriptedTmuxSender{hub: hub}
+	if err := hub.BindTmux(tmux); err != nil {
+		t.Fatalf("BindTmux: %v", err)
+	}
+	if err := hub.RequestStateSync(); err != nil {
+		t.Fatalf("RequestStateSync: %v", err)
+	}
+	waitForTargetPaneID(t, hub, "13")
+	h, err := NewServer(Config{Hub: hub})
+	if err != nil {
+		t.Fatalf("NewServer: %v", err)
+	}
+
+	cases := []struct {
+		body string
+		code int
+		msg  string
+	}{
+		{``, http.StatusCreated, ""},
+		{`{"env":{"A_1":"x"},"cwd":"/tmp","cmd":["ls","-l"],"size":"5"}`, http.StatusCreated, ""},
+		{`[]`, http.StatusBadRequest, "request body: must be an object"},
+		{`{"extra":1}`, http.StatusBadRequest, "extra: unknown field"},
+		{`{"env":"A=1"}`, http.StatusBadRequest, "env: must be an object"},
+		{`{"env":{"BAD-KEY":"v"}}`, http.StatusBadRequest, "env.BAD-KEY: property name: must match"},
+		{`{"env":{"A":1}}`, http.StatusBadRequest, "env.A: must be a string"},
+		{`{"cwd":5}`, http.StatusBadRequest, "cwd: must be a string"},
+		{`{"cwd":""}`, http.StatusBadRequest, "cwd: must be at least 1 characters"},
+		{`{"cwd":"  "}`, http.StatusBadRequest, "cwd: must match"},
+		{`{"cmd":"ls"}`, http.StatusBadRequest, "cmd: must be an array"},
+		{`{"cmd":["ls",1]}`, http.StatusBadRequest, "cmd[1]: must be a string"},
+		{`{"size":30}`, http.StatusBadRequest, "size: must be a string"},
+		{`{"size":"half"}`, http.StatusBadRequest, "size: must match"},
+	}
+	for _, tc := range cases {
+		before := tmux.LastCommandWithPrefix("split-window ")
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/panes", strings.NewReader(tc.body)))
+
+		if rec.Code != tc.code {
+			t.Fatalf("%s: status = %d, body = %s", tc.body, rec.Code, rec.Body.String())
+		}
+		if tc.msg != "" && !strings.HasPrefix(rec.Body.String(), tc.msg) {
+			t.Fatalf("%s: body = %q, want prefix %q", tc.body, rec.Body.String(), tc.msg)
+		}
+		if tc.code != http.StatusCreated && tmux.LastCommandWithPrefix("split-window ") != before {
+			t.Fatalf("%s: split-window sent for a rejected body", tc.body)
+		}
+	}
+}
+
+func TestAPIPanesCapsEnvCountAndValueSize(t *testing.T) {
+	hub := wshub.New(policy.Default(), "webui")
+	tmux := &scriptedTmuxSender{hub: hub}
+	if err := hub.BindTmux(tmux); err != nil {
+		t.Fatalf("BindTmux: %v", err)
+	}
+	if err := hub.RequestStateSync(); err != nil {
+		t.Fatalf("RequestStateSync: %v", err)
+	}
+	waitForTargetPaneID(t, hub, "13")
+	h, err := NewServer(Config{Hub: hub, MaxCreateEnv: 3, MaxCreateEnvValue: 4})
+	if err != nil {
+		t.Fatalf("NewServer: %v", err)
+	}
+
+	cases := []struct {
+		body string
+		code int
+		msg  string
+	}{
+		{`{"env":{"A":"1","B":"2","C":"3"}}`, http.StatusCreated, ""},
+		{`{"env":{"A":"1","B":"2","C":"3","D":"4"}}`, http.StatusBadRequest, "env: must have at most 3 entries, got 4"},
+		{`{"env":{"A":"abcd","B":""}}`, http.StatusCreated, ""},
+		{`{"env":{"A":"abcd","B":"abcde"}}`, http.StatusBadRequest, "env.B: must be at most 4 bytes, got 5"},
+		// The cap is in bytes: "é" is one character but two bytes.
+		{`{"env":{"A":"ééé"}}`, http.StatusBadRequest, "env.A: must be at most 4 bytes, got 6"},
+	}
+	for _, tc := range cases {
+		before := tmux.LastCommandWithPrefix("split-window ")
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/panes", strings.NewReader(tc.body)))
+
+		if rec.Code != tc.code {
+			t.Fatalf("%s: status = %d, body = %s", tc.body, rec.Code, rec.Body.String())
+		}
+		if tc.msg != "" && !strings.HasPrefix(rec.Body.String(), tc.msg) {
+			t.Fatalf("%s: body = %q, want prefix %q", tc.body, rec.Body.String(), tc.msg)
+		}
+		if tc.code != http.StatusCreated && tmux.LastCommandWithPrefix("split-window ") != before {
+			t.Fatalf("%s: split-window sent for a rejected body", tc.body)
+		}
+	}
+}
+
+func TestAPIOpenAPIMatchesHandlers(t *testing.T) {
+	hub := wshub.New(policy.Default(), "webui")
+	tmux := &scriptedTmuxSender{hub: hub}
+	if err := hub.BindTmux(tmux); err != nil {
+		t.Fatalf("BindTmux: %v", err)
+	}
+	if err := hub.RequestStateSync(); err != nil {
+		t.Fatalf("RequestStateSync: %v", err)
+	}
+	waitForTargetPaneID(t, hub, "13")
+	h, err := NewServer(Config{Hub: hub})
+	if err != nil {
+		t.Fatalf("NewServer: %v", err)
+	}
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/openapi.json", nil))
+	if rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != "application/json" {
+		t.Fatalf("status = %d, content-type = %q", rec.Code, rec.Header().Get("Content-Type"))
+	}
+	var spec struct {
+		OpenAPI string                               `json:"openapi"`
+		Paths   map[string]map[string]map[string]any `json:"paths"`
+		Comps   struct {
+			Schemas map[string]map[string]any `json:"schemas"`
+		} `json:"components"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &spec); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if spec.OpenAPI != "3.1.0" {
+		t.Fatalf("openapi = %q, want 3.1.0", spec.OpenAPI)
+	}
+
+	// The create-pane body is the schema the handler enforces.
+	body := spec.Paths["/api/panes"]["post"]["requestBody"].(map[string]any)
+	got := body["content"].(map[string]any)["application/json"].(map[string]any)["schema"]
+	var want any
+	b, _ := json.Marshal(createPaneSchema())
+	_ = json.Unmarshal(b, &want)
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("create-pane schema = %v, want %v", got, want)
+	}
+
+	pane := spec.Comps.Schemas["PaneDocument"]
+	if props, _ := pane["properties"].(map[string]any); props["pane_id"] == nil || props["ready"] == nil {
+		t.Fatalf("PaneDocument schema = %v, want pane_id and ready properties", pane)
+	}
+
+	// Every documented operation is routed.
+	for path, ops := range spec.Paths {
+		for method := range ops {
+			target := strings.ReplaceAll(path, "{pane_id}", "13")
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, httptest.NewRequest(strings.ToUpper(method), target, nil))
+			if rec.Code == http.StatusNotFound || rec.Code == http.StatusMethodNotAllowed {
+				t.Fatalf("%s %s: status = %d", method, target, rec.Code)
+			}
+		}
+	}
+}
+
+func TestAPIPanesIdempotencyKeyReplaysCreatedPane(t *testing.T) {
+	hub := wshub.New(policy.Default(), "webui")
+	tmux := &scriptedTmuxSender{hub: hub}
+	if err := hub.BindTmux(tmux); err != nil {
+		t.Fatalf("BindTmux: %v", err)
+	}
+	if err := hub.RequestStateSync(); err != nil {
+		t.Fatalf("RequestStateSync: %v", err)
+	}
+	waitForTargetPaneID(t, hub, "13")
+	h, err := NewServer(Config{Hub: hub})
+	if err != nil {
+		t.Fatalf("NewServer: %v", err)
+	}
+	post := func(key, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/api/panes", strings.NewReader(body))
+		if key != "" {
+			req.Header.Set("Idempotency-Key", key)
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+
+	first := post("retry-1", `{"cwd":"/tmp"}`)
+	if first.Code != http.StatusCreated {
+		t.Fatalf("first: status = %d, body = %s", first.Code, first.Body.String())
+	}
+	retry := post("retry-1", `{"cwd":"/tmp"}`)
+	if retry.Code != http.StatusOK || retry.Header().Get("Idempotent-Replayed") != "true" {
+		t.Fatalf("retry: status = %d, replayed = %q", retry.Code, retry.Header().Get("Idempotent-Replayed"))
+	}
+	if retry.Header().Get("Location") != first.Header().Get("Location") || retry.Body.String() != first.Body.String() {
+		t.Fatalf("retry = %s %s, want the first result %s %s",
+			retry.Header().Get("Location"), retry.Body.String(), first.Header().Get("Location"), first.Body.String())
+	}
+	if n := tmux.CountCommandsWithPrefix("split-window "); n != 1 {
+		t.Fatalf("split-window sent %d times, want 1", n)
+	}
+
+	if rec := post("retry-1", `{"cwd":"/var"}`); rec.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("reused key: status = %d, want 422", rec.Code)
+	}
+	if rec := post(strings.Repeat("k", maxIdempotencyKeyLength+1), ``); rec.Code != http.StatusBadRequest {
+		t.Fatalf("long key: status = %d, want 400", rec.Code)
+	}
+	if rec := post("retry-2", `{"cwd":"/tmp"}`); rec.Code != http.StatusCreated {
+		t.Fatalf("new key: status = %d, want 201", rec.Code)
+	}
+	if rec := post("", `{"cwd":"/tmp"}`); rec.Code != http.StatusCreated {
+		t.Fatalf("no key: status = %d, want 201", rec.Code)
+	}
+	if n := tmux.CountCommandsWithPrefix("split-window "); n != 3 {
+		t.Fatalf("split-window sent %d times, want 3", n)
+	}
+}
+
+func TestCreatePaneKeysExpireAndStayBounded(t *testing.T) {
+	keys := newCreatePaneKeys()
+	now := time.Unix(1700000000, 0)
+	keys.now = func() time.Time { return now }
+	req := sha256.Sum256([]byte("body"))
+
+	entry, replay, err := keys.claim(context.Background(), "a", req)
+	if err != nil || replay {
+		t.Fatalf("claim = %v, %v; want a fresh entry", replay, err)
+	}
+	keys.finish(entry, wshub.PaneInfo{PaneID: "14"})
+	if got, replay, _ := keys.claim(context.Background(), "a", req); !replay || got.pane.PaneID != "14" {
+		t.Fatalf("claim within TTL: replay = %v, pane = %q", replay, got.pane.PaneID)
+	}
+
+	now = now.Add(idempotencyTTL + time.Second)
+	entry, replay, _ = keys.claim(context.Background(), "a", req)
+	if replay {
+		t.Fatalf("claim after TTL replayed")
+	}
+	keys.abandon("a", entry)
+
+	for i := 0; i < maxIdempotencyKeys+10; i++ {
+		now = now.Add(time.Millisecond)
+		entry, _, _ := keys.claim(context.Background(), strconv.Itoa(i), req)
+		keys.finish(entry, wshub.PaneInfo{PaneID: strconv.Itoa(i)})
+	}
+	if len(keys.entries) != maxIdempotencyKeys {
+		t.Fatalf("entries = %d, want %d", len(keys.entries), maxIdempotencyKeys)
+	}
+	if _, ok := keys.entries["0"]; ok {
+		t.Fatalf("oldest key was not evicted")
+	}
+}
+
+func TestValidateSchemaRequiredAndMaxLength(t *testing.T) {
+	schema := renameWindowAction("1").Schema.(map[string]any)
+	if err := validateSchema(schema, map[string]any{}, ""); err == nil || err.Error() != "name is required" {
+		t.Fatalf("missing name: err = %v", err)
+	}
+	long := strings.Repeat("x", maxWindowNameLength+1)
+	if err := validateSchema(schema, map[string]any{"name": long}, ""); err == nil || !strings.Contains(err.Error(), "at most") {
+		t.Fatalf("long name: err = %v", err)
+	}
+	if err := validateSchema(schema, map[string]any{"name": "logs"}, ""); err != nil {
+		t.Fatalf("valid name: err = %v", err)
+	}
+}
+
+func TestAPIPanesRejectsNonPost(t *testing.T) {
+	hub := wshub.New(policy.Default(), "webui")
+	h, err := NewServer(Config{Hub: hub})
+	if err != nil {
+		t.Fatalf("NewServer: %v", err)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/api/panes", nil)
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Fatalf("status = %d, body = %s", rec.Code, rec.Body.String())
+	}
+}
+
+// silentTmuxSender accepts commands but never answers them, like a hung tmux.
+type silentTmuxSender struct{}
+
+func (silentTmuxSender) Send(string) error { return nil }
+
+func TestAPIPanesReturnsGatewayTimeoutWhenRequestDeadlineExpires(t *testing.T) {
+	hub := wshub.New(policy.Default(), "webui")
+	if err := hub.BindTmux(silentTmuxSender{}); err != nil {
+		t.Fatalf("BindTmux: %v", err)
+	}
+
+	h, err := NewServer(Config{Hub: hub, CreatePaneTimeout: 50 * time.Millisecond})
+	if err != nil {
+		t.Fatalf("NewServer: %v", err)
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/api/panes", strings.NewReader(`{}`))
+	req.Header.Set("Content-Type", "application/json")
+	rec := httptest.NewRecorder()
+	start := time.Now()
+	h.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusGatewayTimeout {
+		t.Fatalf("status = %d, body = %s", rec.Code, rec.Body.String())
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Fatalf("request took %v, want it bounded by the create timeout", elapsed)
+	}
+}
+
+func TestAPIPaneImageRendersPNGSizedToPane(t *testing.T) {
+	hub := wshub.New(policy.Default(), "webui")
+	tmux := &scriptedTmuxSender{hub: hub}
+	if err := hub.BindTmux(tmux); err != nil {
+		t.Fatalf("BindTmux: %v", err)
+	}
+	if err := hub.RequestStateSync(); err != nil {
+		t.Fatalf("RequestStateSync: %v", err)
+	}
+	waitForTargetPaneID(t, hub, "13")
+
+	h, err := NewServer(Config{Hub: hub, ImageCellWidth: 6, ImageCellHeight: 8})
+	if err != nil {
+		t.Fatalf("NewServer: %v", err)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/api/panes/13/image", nil)
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, body = %s", rec.Code, rec.Body.String())
+	}
+	if got := rec.Header().Get("Content-Type"); got != "image/png" {
+		t.Fatalf("content-type = %q, want image/png", got)
+	}
+	img, err := png.Decode(rec.Body)
+	if err != nil {
+		t.Fatalf("decode png: %v", err)
+	}
+	if b := img.Bounds(); b.Dx() != 120*6 || b.Dy() != 40*8 {
+		t.Fatalf("bounds = %v, want %dx%d", b, 120*6, 40*8)
+	}
+	red := false
+	for y := 0; y < 8 && !red; y++ {
+		for x := 0; x < 6; x++ {
+			if r, g, b, _ := img.At(x, y).RGBA(); r > 0 && g == 0 && b == 0 {
+				red = true
+				break
+			}
+		}
+	}
+	if !red {
+		t.Fatalf("expected red glyph pixels in the first cell")
+	}
+	if line := tmux.LastCommandWithPrefix("capture-pane "); line != "capture-pane -p -e -N -t %13" {
+		t.Fatalf("capture command = %q", line)
+	}
+}
+
+func TestAPIPaneImageReturnsNotFoundForUnknownPane(t *testing.T) {
+	hub := wshub.New(policy.Default(), "webui")
+	h, err := NewServer(Config{Hub: hub})
+	if err != nil {
+		t.Fatalf("NewServer: %v", err)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/api/panes/99/image", nil)
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("status = %d, body = %s", rec.Code, rec.Body.String())
+	}
+}
+
+func TestAPIPaneOperationSendsKeys(t *testing.T) {
+	hub := wshub.New(policy.Default(), "webui")
+	tmux := &scriptedTmuxSender{hub: hub}
+	if err := hub.BindTmux(tmux); err != nil {
+		t.Fatalf("BindTmux: %v", err)
+	}
+	if err := hub.RequestStateSync(); err != nil {
+		t.Fatalf("RequestStateSync: %v", err)
+	}
+	waitForTargetPaneID(t, hub, "13")
+
+	h, err := NewServer(Config{Hub: hub})
+	if err != nil {
+		t.Fatalf("NewServer: %v", err)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/api/panes/13", nil)
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	var doc hypermediaDocument
+	if err := json.Unmarshal(rec.Body.Bytes(), &doc); err != nil {
+		t.Fatalf("decode pane document: %v", err)
+	}
+	var names []string
+	for _, a := range doc.Actions {
+		if a.Href == "/api/panes/13/"+a.Name {
+			names = append(names, a.Name)
+		}
+	}
+	if got := strings.Join(names, ","); got != "clear,eof,interrupt" {
+		t.Fatalf("pane operation actions = %q, want clear,eof,interrupt", got)
+	}
+
+	req = httptest.NewRequest(http.MethodPost, "/api/panes/13/interrupt", nil)
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusNoContent {
+		t.Fatalf("status = %d, body = %s", rec.Code, rec.Body.String())
+	}
+	if line := tmux.LastCommandWithPrefix("send-keys "); line != "send-keys -t %13 C-c" {
+		t.Fatalf("send-keys command = %q", line)
+	}
+}
+
+func TestAPIPanesKeysSendsToEachPane(t *testing.T) {
+	hub := wshub.New(policy.Default(), "webui")
+	tmux := &scriptedTmuxSender{hub: hub}
+	if err := hub.BindTmux(tmux); err != nil {
+		t.Fatalf("BindTmux: %v", err)
+	}
+	if err := hub.RequestStateSync(); err != nil {
+		t.Fatalf("RequestStateSync: %v", err)
+	}
+	waitForTargetPaneID(t, hub, "13")
+
+	h, err := NewServer(Config{Hub: hub})
+	if err != nil {
+		t.Fatalf("NewServer: %v", err)
+	}
+
+	body := `{"pane_ids":["13","%13","99"],"literal":"make\n"}`
+	req := httptest.NewRequest(http.MethodPost, "/api/panes/keys", strings.NewReader(body))
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, body = %s", rec.Code, rec.Body.String())
+	}
+	var payload struct {
+		Results []sendKeysResult `json:"results"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &payload); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	want := []sendKeysResult{{PaneID: "13", OK: true}, {PaneID: "99", Error: "pane not found"}}
+	if !reflect.DeepEqual(payload.Results, want) {
+		t.Fatalf("results = %+v, want %+v", payload.Results, want)
+	}
+
+	tmux.mu.Lock()
+	var sent []string
+	for _, line := range tmux.lines {
+		if strings.HasPrefix(line, "send-keys ") {
+			sent = append(sent, line)
+		}
+	}
+	tmux.mu.Unlock()
+	if strings.Join(sent, "|") != "send-keys -t %13 -l make|send-keys -t %13 Enter" {
+		t.Fatalf("send-keys commands = %q", sent)
+	}
+
+	for _, bad := range []string{`{"pane_ids":[],"literal":"x"}`, `{"pane_ids":["13"],"literal":""}`, `{"pane_ids":["13"]`} {
+		req := httptest.NewRequest(http.MethodPost, "/api/panes/keys", strings.NewReader(bad))
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		if rec.Code != http.StatusBadRequest {
+			t.Fatalf("%s: status = %d, want 400", bad, rec.Code)
+		}
+	}
+}
+
+func TestAPIPaneOperationBlockedByPolicy(t *testing.T) {
+	hub := wshub.New(policy.Policy{}, "webui")
+	tmux := &scriptedTmuxSender{hub: hub}
+	if err := hub.BindTmux(tmux); err != nil {
+		t.Fatalf("BindTmux: %v", err)
+	}
+	if err := hub.RequestStateSync(); err != nil {
+		t.Fatalf("RequestStateSync: %v", err)
+	}
+	waitForTargetPaneID(t, hub, "13")
+
+	h, err := NewServer(Config{Hub: hub})
+	if err != nil {
+		t.Fatalf("NewServer: %v", err)
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/api/panes/13/clear", nil)
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusForbidden {
+		t.Fatalf("status = %d, body = %s", rec.Code, rec.Body.String())
+	}
+	if line := tmux.LastCommandWithPrefix("send-keys "); line != "" {
+		t.Fatalf("unexpected send-keys command: %q", line)
+	}
+}
+
+func TestAPIOptionsSetsOnlyAllowListedOptions(t *testing.T) {
+	hub := wshub.New(policy.Default(), "webui")
+	tmux := &scriptedTmuxSender{hub: hub}
+	if err := hub.BindTmux(tmux); err != nil {
+		t.Fatalf("BindTmux: %v", err)
+	}
+	settable, err := policy.ParseOptionPolicy("mouse, history-limit")
+	if err != nil {
+		t.Fatalf("ParseOptionPolicy: %v", err)
+	}
+	h, err := NewServer(Config{Hub: hub, SettableOptions: settable})
+	if err != nil {
+		t.Fatalf("NewServer: %v", err)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/api/options", nil)
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	var listing struct {
+		Settable []string `json:"settable"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &listing); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if got := strings.Join(listing.Settable, ","); got != "history-limit,mouse" {
+		t.Fatalf("settable = %q, want history-limit,mouse", got)
+	}
+
+	cases := []struct {
+		body string
+		code int
+		cmd  string
+	}{
+		{`{"name":"mouse","value":"ON"}`, http.StatusNoContent, "set-option -t webui mouse on"},
+		{`{"name":"history-limit","value":"50000"}`, http.StatusNoContent, "set-option -t webui history-limit 50000"},
+		{`{"name":"status","value":"off"}`, http.StatusBadRequest, ""},
+		{`{"name":"mouse","value":"maybe"}`, http.StatusBadRequest, ""},
+		{`{"name":"history-limit","value":"-1"}`, http.StatusBadRequest, ""},
+		{`{"name":"mouse","value":"on","extra":1}`, http.StatusBadRequest, ""},
+	}
+	for _, tc := range cases {
+		before := tmux.LastCommandWithPrefix("set-option ")
+		req := httptest.NewRequest(http.MethodPost, "/api/options", strings.NewReader(tc.body))
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+
+		if rec.Code != tc.code {
+			t.Fatalf("%s: status = %d, body = %s", tc.body, rec.Code, rec.Body.String())
+		}
+		got := tmux.LastCommandWithPrefix("set-option ")
+		if tc.cmd != "" && got != tc.cmd {
+			t.Fatalf("%s: set-option command = %q, want %q", tc.body, got, tc.cmd)
+		}
+		if tc.cmd == "" && got != before {
+			t.Fatalf("%s: unexpected set-option command %q", tc.body, got)
+		}
+	}
+}
+
+func TestAPIContentsSinceMark(t *testing.T) {
+	hub := wshub.New(policy.Default(), "webui")
+	tmux := &scriptedTmuxSender{hub: hub, historySize: 100}
+	if err := hub.BindTmux(tmux); err != nil {
+		t.Fatalf("BindTmux: %v", err)
+	}
+	if err := hub.RequestStateSync(); err != nil {
+		t.Fatalf("RequestStateSync: %v", err)
+	}
+	waitForTargetPaneID(t, hub, "13")
+	h, err := NewServer(Config{Hub: hub})
+	if err != nil {
+		t.Fatalf("NewServer: %v", err)
+	}
+	serve := func(method, path string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(method, path, nil))
+		return rec
+	}
+
+	if rec := serve(http.MethodGet, "/api/contents/13?since_mark=1"); rec.Code != http.StatusConflict {
+		t.Fatalf("since_mark without mark: status = %d, want 409", rec.Code)
+	}
+
+	rec := serve(http.MethodPost, "/api/panes/13/mark")
+	if rec.Code != http.StatusOK || strings.TrimSpace(rec.Body.String()) != `{"pane_id":"13","history_size":100}` {
+		t.Fatalf("mark: status = %d, body = %s", rec.Code, rec.Body.String())
+	}
+
+	tmux.mu.Lock()
+	tmux.historySize = 130
+	tmux.mu.Unlock()
+	rec = serve(http.MethodGet, "/api/contents/13?since_mark=1")
+	if rec.Code != http.StatusOK || rec.Body.String() != "since-mark-line\n" {
+		t.Fatalf("since_mark: status = %d, body = %q", rec.Code, rec.Body.String())
+	}
+	if got := tmux.LastCommandWithPrefix("capture-pane "); got != "capture-pane -p -S -30 -N -t %13" {
+		t.Fatalf("capture command = %q", got)
+	}
+
+	// History that shrank since the mark starts at the top of the screen.
+	tmux.mu.Lock()
+	tmux.historySize = 0
+	tmux.mu.Unlock()
+	serve(http.MethodGet, "/api/contents/13?since_mark=1")
+	if got := tmux.LastCommandWithPrefix("capture-pane "); got != "capture-pane -p -S 0 -N -t %13" {
+		t.Fatalf("capture command after clear = %q", got)
+	}
+
+	if rec := serve(http.MethodGet, "/api/panes/13/mark"); rec.Code != http.StatusMethodNotAllowed {
+		t.Fatalf("GET mark: status = %d, want 405", rec.Code)
+	}
+	if rec := serve(http.MethodPost, "/api/panes/99/mark"); rec.Code != http.StatusNotFound {
+		t.Fatalf("mark unknown pane: status = %d, want 404", rec.Code)
+	}
+}
+
+func TestAPIAdminHidePane(t *testing.T) {
+	hub := wshub.New(policy.Default(), "webui")
+	tmux := &scriptedTmuxSender{hub: hub}
+	if err := hub.BindTmux(tmux); err != nil {
+		t.Fatalf("BindTmux: %v", err)
+	}
+	if err := hub.RequestStateSync(); err != nil {
+		t.Fatalf("RequestStateSync: %v", err)
+	}
+	waitForTargetPaneID(t, hub, "13")
+	h, err := NewServer(Config{Hub: hub})
+	if err != nil {
+		t.Fatalf("NewServer: %v", err)
+	}
+	serve := func(method, path, body string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(method, path, strings.NewReader(body)))
+		return rec
+	}
+
+	rec := serve(http.MethodPost, "/api/admin/hide-pane", `{"pane_id":"13"}`)
+	if rec.Code != http.StatusOK || strings.TrimSpace(rec.Body.String()) != `{"hidden_panes":["13"]}` {
+		t.Fatalf("hide: status = %d, body = %s", rec.Code, rec.Body.String())
+	}
+	if len(hub.CurrentTargetSessionPaneInfos()) != 0 {
+		t.Fatalf("hidden pane still listed: %#v", hub.CurrentTargetSessionPaneInfos())
+	}
+	if rec := serve(http.MethodGet, "/api/panes/13", ""); rec.Code != http.StatusNotFound {
+		t.Fatalf("GET hidden pane: status = %d, want 404", rec.Code)
+	}
+	if rec := serve(http.MethodPost, "/api/admin/hide-pane", `{"pane_id":"99"}`); rec.Code != http.StatusNotFound {
+		t.Fatalf("hide unknown pane: status = %d, want 404", rec.Code)
+	}
+
+	rec = serve(http.MethodPost, "/api/admin/unhide-pane", `{"pane_id":"13"}`)
+	if rec.Code != http.StatusOK || strings.TrimSpace(rec.Body.String()) != `{"hidden_panes":[]}` {
+		t.Fatalf("unhide: status = %d, body = %s", rec.Code, rec.Body.String())
+	}
+	if rec := serve(http.MethodGet, "/api/panes/13", ""); rec.Code != http.StatusOK {
+		t.Fatalf("GET unhidden pane: status = %d, want 200", rec.Code)
+	}
+	if rec := serve(http.MethodPost, "/api/admin/unhide-pane", `{"pane_id":"13"}`); rec.Code != http.StatusNotFound {
+		t.Fatalf("unhide visible pane: status = %d, want 404", rec.Code)
+	}
+	if rec := serve(http.MethodGet, "/api/admin/hide-pane", ""); rec.Code != http.StatusMethodNotAllowed {
+		t.Fatalf("GET hide-pane: status = %d, want 405", rec.Code)
+	}
+}
+
+func TestAPIAdminHidePaneFiltersWebSocket(t *testing.T) {
+	hub := wshub.New(policy.Default(), "webui")
+	tmux := &scriptedTmuxSender{hub: hub, listsNewPane: true}
+	if err := hub.BindTmux(tmux); err != nil {
+		t.Fatalf("BindTmux: %v", err)
+	}
+	if err := hub.RequestStateSync(); err != nil {
+		t.Fatalf("RequestStateSync: %v", err)
+	}
+	waitForTargetPaneID(t, hub, "14")
+	h, err := NewServer(Config{Hub: hub})
+	if err != nil {
+		t.Fatalf("NewServer: %v", err)
+	}
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http")+"/ws", nil)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer conn.Close()
+	type wsMsg struct {
+		T     string `json:"t"`
+		State *struct {
+			Panes []struct {
+				PaneID string `json:"pane_id"`
+			} `json:"panes"`
+		} `json:"state"`
+		PaneOutput *struct {
+			PaneID string `json:"pane_id"`
+			Data   string `json:"data"`
+		} `json:"pane_output"`
+	}
+	statePanes := func(m wsMsg) string {
+		var ids []string
+		for _, p := range m.State.Panes {
+			ids = append(ids, p.PaneID)
+		}
+		return strings.Join(ids, ",")
+	}
+	// next returns the next message of type typ, failing on any pane_output
+	// for the hidden pane on the way.
+	next := func(typ string) wsMsg {
+		t.Helper()
+		_ = conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+		for {
+			var m wsMsg
+			if err := conn.ReadJSON(&m); err != nil {
+				t.Fatalf("waiting for %s: %v", typ, err)
+			}
+			if m.PaneOutput != nil && m.PaneOutput.PaneID == "%13" && m.PaneOutput.Data == "secret" {
+				t.Fatalf("hidden pane output sent over /ws")
+			}
+			if m.T == typ {
+				return m
+			}
+		}
+	}
+
+	if got := statePanes(next("tmux_state")); got != "%13,%14" {
+		t.Fatalf("initial state panes = %q, want %%13,%%14", got)
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/admin/hide-pane", strings.NewReader(`{"pane_id":"13"}`)))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("hide: status = %d, body = %s", rec.Code, rec.Body.String())
+	}
+	if got := statePanes(next("tmux_state")); got != "%14" {
+		t.Fatalf("state panes after hide = %q, want %%14", got)
+	}
+
+	hub.BroadcastTmuxStdoutLine("%output %13 secret")
+	hub.BroadcastTmuxStdoutLine("%output %14 visible")
+	if out := next("pane_output"); out.PaneOutput.PaneID != "%14" || out.PaneOutput.Data != "visible" {
+		t.Fatalf("pane_output = %+v, want %%14 visible", out.PaneOutput)
+	}
+
+	late, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http")+"/ws", nil)
+	if err != nil {
+		t.Fatalf("dial after hide: %v", err)
+	}
+	defer late.Close()
+	_ = late.SetReadDeadline(time.Now().Add(2 * time.Second))
+	for {
+		var m wsMsg
+		if err := late.ReadJSON(&m); err != nil {
+			t.Fatalf("waiting for tmux_state: %v", err)
+		}
+		if m.T == "tmux_state" {
+			if got := statePanes(m); got != "%14" {
+				t.Fatalf("state panes on connect = %q, want %%14", got)
+			}
+			break
+		}
+	}
+}
+
+func TestHiddenPaneContentsNeverReachWebSocketClients(t *testing.T) {
+	hub := wshub.New(policy.Default(), "webui")
+	tmux := &scriptedTmuxSender{hub: hub, listsNewPane: true}
+	if err := hub.BindTmux(tmux); err != nil {
+		t.Fatalf("BindTmux: %v", err)
+	}
+	if err := hub.RequestStateSync(); err != nil {
+		t.Fatalf("RequestStateSync: %v", err)
+	}
+	waitForTargetPaneID(t, hub, "14")
+	h, err := NewServer(Config{Hub: hub})
+	if err != nil {
+		t.Fatalf("NewServer: %v", err)
+	}
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/admin/hide-pane", strings.NewReader(`{"pane_id":"13"}`)))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("hide: status = %d, body = %s", rec.Code, rec.Body.String())
+	}
+
+	wsURL := "ws" + strings.TrimPrefix(srv.URL, "http") + "/ws"
+	sender, _, err := websocket.DefaultDialer.Dial(wsURL, nil)
+	if err != nil {
+		t.Fatalf("dial sender: %v", err)
+	}
+	defer sender.Close()
+	observer, _, err := websocket.DefaultDialer.Dial(wsURL, nil)
+	if err != nil {
+		t.Fatalf("dial observer: %v", err)
+	}
+	defer observer.Close()
+
+	type wsMsg struct {
+		T       string `json:"t"`
+		Message string `json:"message"`
+		Command *struct {
+			Output []string `json:"output"`
+		} `json:"command"`
+	}
+	// next returns the next message of type typ on conn, failing on any
+	// message that carries the hidden pane's id or contents on the way.
+	next := func(conn *websocket.Conn, typ string) wsMsg {
+		t.Helper()
+		_ = conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+		for {
+			_, data, err := conn.ReadMessage()
+			if err != nil {
+				t.Fatalf("waiting for %s: %v", typ, err)
+			}
+			if s := string(data); strings.Contains(s, "%13") || strings.Contains(s, "red") {
+				t.Fatalf("hidden pane leaked over /ws: %s", s)
+			}
+			var m wsMsg
+			if err := json.Unmarshal(data, &m); err != nil {
+				t.Fatalf("decode %s: %v", data, err)
+			}
+			if m.T == typ {
+				return m
+			}
+		}
+	}
+	next(sender, "tmux_state")
+	next(observer, "tmux_state")
+
+	for _, argv := range []string{
+		`["capture-pane","-p","-e","-N","-t","%13"]`,
+		`["capture-pane","-p","-N","-t","webui:0"]`,
+	} {
+		if err := sender.WriteMessage(websocket.TextMessage, []byte(`{"t":"cmd","argv":`+argv+`}`)); err != nil {
+			t.Fatalf("write cmd: %v", err)
+		}
+		if m := next(sender, "error"); m.Message == "" {
+			t.Fatalf("cmd %s: empty error message", argv)
+		}
+	}
+	if n := tmux.CountCommandsWithPrefix("capture-pane"); n != 0 {
+		t.Fatalf("capture-pane sent to tmux %d times, want 0", n)
+	}
+
+	if err := sender.WriteMessage(websocket.TextMessage, []byte(`{"t":"cmd","argv":["list-panes","-a"]}`)); err != nil {
+		t.Fatalf("write list-panes: %v", err)
+	}
+	m := next(sender, "tmux_command")
+	if out := strings.Join(m.Command.Output, "\n"); !strings.Contains(out, "%14") {
+		t.Fatalf("list-panes output = %q, want the %%14 row", out)
+	}
+
+	// The observer sent nothing, so it sees no command responses at all.
+	_ = observer.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+	for {
+		_, data, err := observer.ReadMessage()
+		if err != nil {
+			break
+		}
+		if s := string(data); strings.Contains(s, `"tmux_command"`) || strings.Contains(s, "%13") {
+			t.Fatalf("observer received %s", s)
+		}
+	}
+}
+
+func TestAPIBuffersSetAndPaste(t *testing.T) {
+	hub := wshub.New(policy.Default(), "webui")
+	tmux := &scriptedTmuxSender{hub: hub}
+	if err := hub.BindTmux(tmux); err != nil {
+		t.Fatalf("BindTmux: %v", err)
+	}
+	if err := hub.RequestStateSync(); err != nil {
+		t.Fatalf("RequestStateSync: %v", err)
+	}
+	waitForTargetPaneID(t, hub, "13")
+	h, err := NewServer(Config{Hub: hub})
+	if err != nil {
+		t.Fatalf("NewServer: %v", err)
+	}
+
+	cases := []struct {
+		path   string
+		body   string
+		code   int
+		prefix string
+		cmd    string
+	}{
+		{"/api/buffers", `{"data":"echo $HOME\nls \"a\"\t\u0007"}`, http.StatusNoContent, "set-buffer ", `set-buffer -- "echo \$HOME\nls \"a\"\t\007"`},
+		{"/api/buffers", `{"name":"clip","data":"plain"}`, http.StatusNoContent, "set-buffer ", "set-buffer -b clip -- plain"},
+		{"/api/buffers", `{"name":"no spaces","data":"x"}`, http.StatusBadRequest, "set-buffer ", ""},
+		{"/api/buffers", `{"data":"x","extra":1}`, http.StatusBadRequest, "set-buffer ", ""},
+		{"/api/panes/13/paste", ``, http.StatusNoContent, "paste-buffer ", "paste-buffer -p -t %13"},
+		{"/api/panes/13/paste", `{"name":"clip"}`, http.StatusNoContent, "paste-buffer ", "paste-buffer -p -t %13 -b clip"},
+		{"/api/panes/99/paste", ``, http.StatusNotFound, "paste-buffer ", ""},
+	}
+	for _, tc := range cases {
+		before := tmux.LastCommandWithPrefix(tc.prefix)
+		req := httptest.NewRequest(http.MethodPost, tc.path, strings.NewReader(tc.body))
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+
+		if rec.Code != tc.code {
+			t.Fatalf("%s %s: status = %d, body = %s", tc.path, tc.body, rec.Code, rec.Body.String())
+		}
+		got := tmux.LastCommandWithPrefix(tc.prefix)
+		if tc.cmd != "" && got != tc.cmd {
+			t.Fatalf("%s %s: command = %q, want %q", tc.path, tc.body, got, tc.cmd)
+		}
+		if tc.cmd == "" && got != before {
+			t.Fatalf("%s %s: unexpected command %q", tc.path, tc.body, got)
+		}
+	}
+
+	big := `{"data":"` + strings.Repeat("x", maxBufferBytes+1) + `"}`
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/buffers", strings.NewReader(big)))
+	if rec.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("oversized buffer status = %d, want %d", rec.Code, http.StatusRequestEntityTooLarge)
+	}
+}
+
+func TestAPIDebugUnicodeCapturesLatestReport(t *testing.T) {
+	hub := wshub.New(policy.Default(), "webui")
+	tmux := &scriptedTmuxSender{hub: hub}
+	if err := hub.BindTmux(tmux); err != nil {
+		t.Fatalf("BindTmux: %v", err)
+	}
+	if err := hub.RequestStateSync(); err != nil {
+		t.Fatalf("RequestStateSync: %v", err)
+	}
+	waitForTargetPaneID(t, hub, "13")
+
+	h, err := NewServer(Config{Hub: hub})
+	if err != nil {
+		t.Fatalf("NewServer: %v", err)
+	}
+
+	body := strings.NewReader(`{"renderer":"ghostty","url":"http://localhost/p/13?term=ghostty","user_agent":"ua","source":"pane_snapshot","pane_id":"13","data_length":32,"data_sample":"bad\ufffdchars"}`)
+	postReq := httptest.NewRequest(http.MethodPost, "/api/debug/unicode", body)
+	postReq.Header.Set("Content-Type", "application/json")
+	postRec := httptest.NewRecorder()
+	h.ServeHTTP(postRec, postReq)
+
+	if postRec.Code != http.StatusOK {
+		t.Fatalf("post status = %d, body = %s", postRec.Code, postRec.Body.String())
+	}
+
+	var postResp map[string]any
+	if err := json.Unmarshal(postRec.Body.Bytes(), &postResp); err != nil {
+		t.Fatalf("decode post response: %v", err)
+	}
 	if _, ok := postResp["report_id"]; !ok {
 		t.Fatalf("missing report_id in post response: %v", postResp)
 	}
 
-	getReq := httptest.NewRequest(http.MethodGet, "/api/debug/unicode", nil)
-	getRec := httptest.NewRecorder()
-	h.ServeHTTP(getRec, getReq)
+	getReq := httptest.NewRequest(http.MethodGet, "/api/debug/unicode", nil)
+	getRec := httptest.NewRecorder()
+	h.ServeHTTP(getRec, getReq)
+
+	if getRec.Code != http.StatusOK {
+		t.Fatalf("get status = %d, body = %s", getRec.Code, getRec.Body.String())
+	}
+
+	var report unicodeDebugRecord
+	if err := json.Unmarshal(getRec.Body.Bytes(), &report); err != nil {
+		t.Fatalf("decode get response: %v", err)
+	}
+	if report.Client.PaneID != "13" {
+		t.Fatalf("client pane id = %q, want %q", report.Client.PaneID, "13")
+	}
+	if report.Server.TmuxPaneID != "%13" {
+		t.Fatalf("server tmux pane id = %q, want %q", report.Server.TmuxPaneID, "%13")
+	}
+	if report.Server.PlainSample != "plain-line" {
+		t.Fatalf("plain sample = %q, want %q", report.Server.PlainSample, "plain-line")
+	}
+	if report.Server.EscapedSample != "\u001b[31mred\u001b[0m" {
+		t.Fatalf("escaped sample = %q, want escape-decorated output", report.Server.EscapedSample)
+	}
+}
+
+func TestUnicodeDebugStoreEvictsByAgeAndCount(t *testing.T) {
+	now := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	store := &unicodeDebugStore{now: func() time.Time { return now }}
+
+	store.add(unicodeDebugRecord{})
+	now = now.Add(30 * time.Minute)
+	second := store.add(unicodeDebugRecord{})
+	if len(store.reports) != 2 {
+		t.Fatalf("reports = %d, want 2", len(store.reports))
+	}
+
+	now = now.Add(45 * time.Minute)
+	got, ok := store.latest()
+	if !ok || got.ID != second || len(store.reports) != 1 {
+		t.Fatalf("latest = %+v, %v with %d reports; want id %d alone", got, ok, len(store.reports), second)
+	}
+	if got.ReceivedAt != "2026-01-02T03:34:05Z" {
+		t.Fatalf("received_at = %q", got.ReceivedAt)
+	}
+
+	now = now.Add(time.Hour)
+	if _, ok := store.latest(); ok {
+		t.Fatalf("expected every report to expire")
+	}
+
+	for i := 0; i < maxUnicodeDebugReports+5; i++ {
+		store.add(unicodeDebugRecord{})
+	}
+	if len(store.reports) != maxUnicodeDebugReports {
+		t.Fatalf("reports = %d, want %d", len(store.reports), maxUnicodeDebugReports)
+	}
+}
+
+func TestAPIDebugParseErrorsReturnsRecentParseErrors(t *testing.T) {
+	hub := wshub.New(policy.Default(), "webui")
+	h, err := NewServer(Config{Hub: hub, EnableDebug: true})
+	if err != nil {
+		t.Fatalf("NewServer: %v", err)
+	}
+
+	hub.BroadcastTmuxStdoutLine("%end 1 2 3")
+
+	deadline := time.Now().Add(2 * time.Second)
+	for len(hub.RecentParseErrors()) == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/api/debug/parse-errors", nil)
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, body = %s", rec.Code, rec.Body.String())
+	}
+
+	var payload struct {
+		ParseErrors []wshub.ParseErrorRecord `json:"parse_errors"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &payload); err != nil {
+		t.Fatalf("decode response: %v", err)
+	}
+	if len(payload.ParseErrors) != 1 {
+		t.Fatalf("expected one parse error, got %#v", payload.ParseErrors)
+	}
+	got := payload.ParseErrors[0]
+	if got.Line != "%end 1 2 3" || got.Message != "end/error without begin" || got.At == "" {
+		t.Fatalf("unexpected parse error record: %#v", got)
+	}
+}
+
+func TestAPIInfoReportsUptimeAndTmuxConnection(t *testing.T) {
+	now := time.Now()
+	connected := false
+	hub := wshub.New(policy.Default(), "webui")
+	h, err := NewServer(Config{
+		Hub:       hub,
+		StartedAt: now.Add(-90 * time.Minute),
+		TmuxConnectedSince: func() (time.Time, bool) {
+			return now.Add(-42 * time.Second), connected
+		},
+	})
+	if err != nil {
+		t.Fatalf("NewServer: %v", err)
+	}
+
+	get := func() infoResponse {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/info", nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status = %d, body = %s", rec.Code, rec.Body.String())
+		}
+		var info infoResponse
+		if err := json.Unmarshal(rec.Body.Bytes(), &info); err != nil {
+			t.Fatalf("decode response: %v", err)
+		}
+		return info
+	}
+
+	info := get()
+	if info.UptimeSeconds != 5400 || info.Uptime != "1h30m0s" || info.StartedAt == "" {
+		t.Fatalf("uptime = %d / %q, want 5400 / 1h30m0s", info.UptimeSeconds, info.Uptime)
+	}
+	if info.TmuxConnected || info.TmuxConnectionSeconds != 0 || info.TmuxConnectedAt != "" {
+		t.Fatalf("disconnected info = %+v", info)
+	}
+
+	if info.TmuxConfigErrors == nil || len(info.TmuxConfigErrors) != 0 {
+		t.Fatalf("config errors = %#v, want empty list", info.TmuxConfigErrors)
+	}
+	if strings.Join(info.WSProtocols, ",") != wshub.ProtocolV1 {
+		t.Fatalf("ws_protocols = %q, want [%s]", info.WSProtocols, wshub.ProtocolV1)
+	}
+
+	connected = true
+	info = get()
+	if !info.TmuxConnected || info.TmuxConnectionSeconds != 42 || info.TmuxConnection != "42s" || info.TmuxConnectedAt == "" {
+		t.Fatalf("connected info = %+v", info)
+	}
+
+	hub.BroadcastTmuxStdoutLine("%config-error /etc/tmux.conf:1: unknown option: bogus")
+	deadline := time.Now().Add(2 * time.Second)
+	for len(info.TmuxConfigErrors) == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+		info = get()
+	}
+	if len(info.TmuxConfigErrors) != 1 || info.TmuxConfigErrors[0].Message != "/etc/tmux.conf:1: unknown option: bogus" {
+		t.Fatalf("config errors = %+v", info.TmuxConfigErrors)
+	}
+}
+
+func TestAPIDebugPendingListsUnansweredCommands(t *testing.T) {
+	hub := wshub.New(policy.Default(), "webui")
+	if err := hub.BindTmux(silentTmuxSender{}); err != nil {
+		t.Fatalf("BindTmux: %v", err)
+	}
+	if err := hub.RequestStateSync(); err != nil {
+		t.Fatalf("RequestStateSync: %v", err)
+	}
+	h, err := NewServer(Config{Hub: hub, EnableDebug: true})
+	if err != nil {
+		t.Fatalf("NewServer: %v", err)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/api/debug/pending", nil)
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, body = %s", rec.Code, rec.Body.String())
+	}
+	var payload struct {
+		Pending []wshub.PendingCommandInfo `json:"pending"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &payload); err != nil {
+		t.Fatalf("decode response: %v", err)
+	}
+	if len(payload.Pending) != 1 || payload.Pending[0].Name != "list-panes" || payload.Pending[0].QueuedAt == "" || payload.Pending[0].AgeMS < 0 {
+		t.Fatalf("pending = %#v, want one unanswered list-panes", payload.Pending)
+	}
+}
+
+func TestAPIDebugOutputLatencyReportsExtendedOutputAge(t *testing.T) {
+	hub := wshub.New(policy.Default(), "webui")
+	h, err := NewServer(Config{Hub: hub, EnableDebug: true})
+	if err != nil {
+		t.Fatalf("NewServer: %v", err)
+	}
+
+	hub.BroadcastTmuxStdoutLine("%extended-output %13 25 : hi")
+
+	var payload wshub.OutputLatency
+	deadline := time.Now().Add(2 * time.Second)
+	for payload.Samples == 0 && time.Now().Before(deadline) {
+		req := httptest.NewRequest(http.MethodGet, "/api/debug/output-latency", nil)
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status = %d, body = %s", rec.Code, rec.Body.String())
+		}
+		if err := json.Unmarshal(rec.Body.Bytes(), &payload); err != nil {
+			t.Fatalf("decode response: %v", err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if want := (wshub.OutputLatency{Samples: 1, LastMS: 25, MaxMS: 25, TotalMS: 25}); payload != want {
+		t.Fatalf("latency = %+v, want %+v", payload, want)
+	}
+}
+
+func TestAPIDebugParserQueueCountsEvents(t *testing.T) {
+	hub := wshub.New(policy.Default(), "webui")
+	h, err := NewServer(Config{Hub: hub, EnableDebug: true})
+	if err != nil {
+		t.Fatalf("NewServer: %v", err)
+	}
+
+	hub.BroadcastTmuxStdoutLine("%output %13 hi")
+
+	var payload wshub.ParserQueueStats
+	deadline := time.Now().Add(2 * time.Second)
+	for payload.Events == 0 && time.Now().Before(deadline) {
+		req := httptest.NewRequest(http.MethodGet, "/api/debug/parser-queue", nil)
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status = %d, body = %s", rec.Code, rec.Body.String())
+		}
+		if err := json.Unmarshal(rec.Body.Bytes(), &payload); err != nil {
+			t.Fatalf("decode response: %v", err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if payload.Events != 1 || payload.MaxDepth < 1 || payload.Saturated != 0 {
+		t.Fatalf("stats = %+v, want one event and no saturation", payload)
+	}
+}
+
+func TestAPIOutputStreamsInterleavedPaneOutput(t *testing.T) {
+	hub := wshub.New(policy.Default(), "webui")
+	h, err := NewServer(Config{Hub: hub})
+	if err != nil {
+		t.Fatalf("NewServer: %v", err)
+	}
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/api/output")
+	if err != nil {
+		t.Fatalf("GET /api/output: %v", err)
+	}
+	defer resp.Body.Close()
+	if got := resp.Header.Get("Content-Type"); got != "application/x-ndjson" {
+		t.Fatalf("content-type = %q, want application/x-ndjson", got)
+	}
+
+	hub.BroadcastTmuxStdoutLine("%output %13 one")
+	hub.BroadcastTmuxStdoutLine("%output %14 two")
+	hub.BroadcastTmuxStdoutLine("%output %13 three")
+
+	dec := json.NewDecoder(resp.Body)
+	want := []wshub.PaneOutput{
+		{PaneID: "13", Data: "one"},
+		{PaneID: "14", Data: "two"},
+		{PaneID: "13", Data: "three"},
+	}
+	for i, w := range want {
+		var got wshub.PaneOutput
+		if err := dec.Decode(&got); err != nil {
+			t.Fatalf("decode chunk %d: %v", i, err)
+		}
+		if got != w {
+			t.Fatalf("chunk %d = %#v, want %#v", i, got, w)
+		}
+	}
+}
+
+func TestAPIOutputOutlivesServerTimeouts(t *testing.T) {
+	hub := wshub.New(policy.Default(), "webui")
+	h, err := NewServer(Config{Hub: hub})
+	if err != nil {
+		t.Fatalf("NewServer: %v", err)
+	}
+	srv := httptest.NewUnstartedServer(h)
+	srv.Config.ReadTimeout = 50 * time.Millisecond
+	srv.Config.WriteTimeout = 50 * time.Millisecond
+	srv.Start()
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/api/output")
+	if err != nil {
+		t.Fatalf("GET /api/output: %v", err)
+	}
+	defer resp.Body.Close()
+
+	time.Sleep(200 * time.Millisecond)
+	hub.BroadcastTmuxStdoutLine("%output %13 late")
+
+	var got wshub.PaneOutput
+	if err := json.NewDecoder(resp.Body).Decode(&got); err != nil {
+		t.Fatalf("decode after the write timeout: %v", err)
+	}
+	if got != (wshub.PaneOutput{PaneID: "13", Data: "late"}) {
+		t.Fatalf("chunk = %#v, want pane 13 late", got)
+	}
+}
+
+func TestNewServerLogsRequestsAtDebugLevel(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	h, err := NewServer(Config{Hub: wshub.New(policy.Default(), "webui"), Logger: logger})
+	if err != nil {
+		t.Fatalf("NewServer: %v", err)
+	}
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/policy", nil))
+
+	out := buf.String()
+	if !strings.Contains(out, "path=/api/policy") || !strings.Contains(out, "status=200") {
+		t.Fatalf("log output = %q, want request record", out)
+	}
+
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http")+"/ws", nil)
+	if err != nil {
+		t.Fatalf("dial through request logger: %v", err)
+	}
+	conn.Close()
+}
+
+func TestNewServerPropagatesRequestID(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	hub := wshub.NewWithOptions(policy.Default(), "webui", wshub.Options{Logger: logger})
+	tmux := &scriptedTmuxSender{hub: hub}
+	if err := hub.BindTmux(tmux); err != nil {
+		t.Fatalf("BindTmux: %v", err)
+	}
+	if err := hub.RequestStateSync(); err != nil {
+		t.Fatalf("RequestStateSync: %v", err)
+	}
+	waitForTargetPaneID(t, hub, "13")
+	h, err := NewServer(Config{Hub: hub, Logger: logger})
+	if err != nil {
+		t.Fatalf("NewServer: %v", err)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/api/contents/13", nil)
+	req.Header.Set("X-Request-ID", "trace-42")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, body = %s", rec.Code, rec.Body.String())
+	}
+	if got := rec.Header().Get("X-Request-ID"); got != "trace-42" {
+		t.Fatalf("X-Request-ID = %q, want inbound id echoed", got)
+	}
+	out := buf.String()
+	if !strings.Contains(out, `msg="tmux command" request_id=trace-42 command=capture-pane target=%13`) {
+		t.Fatalf("log output = %q, want tmux command tagged with request id", out)
+	}
+	if !strings.Contains(out, `msg="http request" request_id=trace-42`) {
+		t.Fatalf("log output = %q, want request record tagged with request id", out)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/api/policy", nil)
+	req.Header.Set("X-Request-ID", "not valid\n")
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if got := rec.Header().Get("X-Request-ID"); len(got) != 16 || got == "not valid\n" {
+		t.Fatalf("X-Request-ID = %q, want a generated id", got)
+	}
+}
+
+func TestAPIPaneRecordWritesCastFile(t *testing.T) {
+	hub := wshub.New(policy.Default(), "webui")
+	tmux := &scriptedTmuxSender{hub: hub}
+	if err := hub.BindTmux(tmux); err != nil {
+		t.Fatalf("BindTmux: %v", err)
+	}
+	if err := hub.RequestStateSync(); err != nil {
+		t.Fatalf("RequestStateSync: %v", err)
+	}
+	waitForTargetPaneID(t, hub, "13")
+
+	dir := t.TempDir()
+	h, err := NewServer(Config{Hub: hub, RecordDir: dir})
+	if err != nil {
+		t.Fatalf("NewServer: %v", err)
+	}
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/panes/13/record", strings.NewReader(`{"path":"../escape.cast"}`)))
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("escaping path status = %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/api/panes/13/record", strings.NewReader(`{"path":"demo.cast"}`))
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("start status = %d, body = %s", rec.Code, rec.Body.String())
+	}
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/panes/13/record", nil))
+	if rec.Code != http.StatusConflict {
+		t.Fatalf("second start status = %d, want %d", rec.Code, http.StatusConflict)
+	}
+
+	hub.BroadcastTmuxStdoutLine("%output %99 other")
+	hub.BroadcastTmuxStdoutLine("%output %13 hello")
+	path := filepath.Join(dir, "demo.cast")
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		data, _ := os.ReadFile(path)
+		if strings.Contains(string(data), "hello") {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodDelete, "/api/panes/13/record", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("stop status = %d, body = %s", rec.Code, rec.Body.String())
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("read cast: %v", err)
+	}
+	lines := strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("cast lines = %q, want header and one event", lines)
+	}
+	if !strings.HasPrefix(lines[0], `{"version":2,"width":120,"height":40,`) {
+		t.Fatalf("header = %s", lines[0])
+	}
+	if !strings.HasSuffix(lines[1], `,"o","hello"]`) {
+		t.Fatalf("event = %s", lines[1])
+	}
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodDelete, "/api/panes/13/record", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("second stop status = %d, want %d", rec.Code, http.StatusNotFound)
+	}
+}
+
+func TestAPIPaneRecordRequiresRecordDir(t *testing.T) {
+	hub := wshub.New(policy.Default(), "webui")
+	tmux := &scriptedTmuxSender{hub: hub}
+	if err := hub.BindTmux(tmux); err != nil {
+		t.Fatalf("BindTmux: %v", err)
+	}
+	if err := hub.RequestStateSync(); err != nil {
+		t.Fatalf("RequestStateSync: %v", err)
+	}
+	waitForTargetPaneID(t, hub, "13")
+
+	h, err := NewServer(Config{Hub: hub})
+	if err != nil {
+		t.Fatalf("NewServer: %v", err)
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/panes/13/record", strings.NewReader(`{"path":"../escape.cast"}`)))
+	if rec.Code != http.StatusForbidden {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusForbidden)
+	}
+}
+
+func TestAPIRecordingPlayStreamsCastFromSeek(t *testing.T) {
+	dir := t.TempDir()
+	cast := `{"version":2,"width":80,"height":24,"title":"wmux pane 13"}
+[0.0,"o","a"]
+[0.5,"o","b"]
+[1.0,"i","typed"]
+[1.5,"o","c"]
+[2.0,"o","d"]
+`
+	if err := os.WriteFile(filepath.Join(dir, "demo.cast"), []byte(cast), 0o644); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	h, err := NewServer(Config{Hub: wshub.New(policy.Default(), "webui"), RecordDir: dir})
+	if err != nil {
+		t.Fatalf("NewServer: %v", err)
+	}
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+
+	for _, tc := range []struct {
+		path string
+		want int
+	}{
+		{"/api/recordings/missing.cast/play", http.StatusNotFound},
+		{"/api/recordings/demo.cast/play?speed=0", http.StatusBadRequest},
+		{"/api/recordings/demo.cast/play?seek=-1", http.StatusBadRequest},
+		{"/api/recordings/demo.cast", http.StatusNotFound},
+	} {
+		resp, err := http.Get(srv.URL + tc.path)
+		if err != nil {
+			t.Fatalf("GET %s: %v", tc.path, err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != tc.want {
+			t.Fatalf("GET %s: status = %d, want %d", tc.path, resp.StatusCode, tc.want)
+		}
+	}
+
+	start := time.Now()
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http")+"/api/recordings/demo.cast/play?speed=10&seek=1", nil)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer conn.Close()
+	_ = conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+
+	var msgs []replayMsg
+	for {
+		var msg replayMsg
+		if err := conn.ReadJSON(&msg); err != nil {
+			break
+		}
+		msgs = append(msgs, msg)
+	}
+	if len(msgs) != 5 || msgs[0].T != "replay_start" || msgs[4].T != "replay_end" {
+		t.Fatalf("messages = %+v, want replay_start, 3 pane_output, replay_end", msgs)
+	}
+	if info := msgs[0].Replay; info.PaneID != "13" || info.Width != 80 || info.Speed != 10 || info.Seek != 1 {
+		t.Fatalf("replay_start = %+v", info)
+	}
+	for i, want := range []string{"ab", "c", "d"} {
+		out := msgs[i+1].PaneOutput
+		if msgs[i+1].T != "pane_output" || out.PaneID != "13" || out.Data != want {
+			t.Fatalf("message %d = %+v, want pane_output %q", i+1, msgs[i+1], want)
+		}
+	}
+	// From seek=1 the last event is 1s in; at 10x speed that takes 100ms.
+	if elapsed := time.Since(start); elapsed < 100*time.Millisecond {
+		t.Fatalf("playback took %v, want at least 100ms", elapsed)
+	}
+}
+
+func TestAPIRecordingPlayRequiresRecordDir(t *testing.T) {
+	h, err := NewServer(Config{Hub: wshub.New(policy.Default(), "webui")})
+	if err != nil {
+		t.Fatalf("NewServer: %v", err)
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/recordings/demo.cast/play", nil))
+	if rec.Code != http.StatusForbidden {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusForbidden)
+	}
+}
+
+func TestAPIWindowReturnsWindowWithPanesAndRenameAction(t *testing.T) {
+	hub := wshub.New(policy.Default(), "webui")
+	tmux := &scriptedTmuxSender{hub: hub}
+	if err := hub.BindTmux(tmux); err != nil {
+		t.Fatalf("BindTmux: %v", err)
+	}
+	if err := hub.RequestStateSync(); err != nil {
+		t.Fatalf("RequestStateSync: %v", err)
+	}
+	waitForTargetPaneID(t, hub, "13")
+
+	h, err := NewServer(Config{Hub: hub})
+	if err != nil {
+		t.Fatalf("NewServer: %v", err)
+	}
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/windows/1", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, body = %s", rec.Code, rec.Body.String())
+	}
+	var doc hypermediaDocument
+	if err := json.Unmarshal(rec.Body.Bytes(), &doc); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if doc.Resource != "wmux-window" {
+		t.Fatalf("resource = %q, want wmux-window", doc.Resource)
+	}
+	if len(doc.Windows) != 1 || doc.Windows[0].WindowID != "1" || doc.Windows[0].Name != "main" {
+		t.Fatalf("unexpected windows: %#v", doc.Windows)
+	}
+	if len(doc.Panes) != 1 || doc.Panes[0].PaneID != "13" {
+		t.Fatalf("unexpected panes: %#v", doc.Panes)
+	}
+	if len(doc.Actions) != 1 || doc.Actions[0].Name != "rename-window" || doc.Actions[0].Href != "/api/windows/1/rename" {
+		t.Fatalf("unexpected actions: %#v", doc.Actions)
+	}
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/windows/9", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("unknown window status = %d, want %d", rec.Code, http.StatusNotFound)
+	}
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/windows/1/rename", strings.NewReader(`{"name":"logs"}`)))
+	if rec.Code != http.StatusNoContent {
+		t.Fatalf("rename status = %d, body = %s", rec.Code, rec.Body.String())
+	}
+	if got := tmux.LastCommandWithPrefix("rename-window "); got != "rename-window -t @1 logs" {
+		t.Fatalf("rename command = %q", got)
+	}
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/windows/1/rename", strings.NewReader(`{"name":"a\nb"}`)))
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("multi-line rename status = %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+}
+
+func TestAPIAdminKillSession(t *testing.T) {
+	hub := wshub.New(policy.Default(), "webui")
+	h, err := NewServer(Config{Hub: hub})
+	if err != nil {
+		t.Fatalf("NewServer: %v", err)
+	}
+
+	kill := func(contentType, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/api/admin/kill-session", strings.NewReader(body))
+		if contentType != "" {
+			req.Header.Set("Content-Type", contentType)
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := kill("application/json", `{"session":"webui"}`)
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("unbound status = %d, want %d", rec.Code, http.StatusServiceUnavailable)
+	}
+
+	tmux := &scriptedTmuxSender{hub: hub}
+	if err := hub.BindTmux(tmux); err != nil {
+		t.Fatalf("BindTmux: %v", err)
+	}
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/admin/kill-session", nil))
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Fatalf("GET status = %d, want %d", rec.Code, http.StatusMethodNotAllowed)
+	}
+
+	// Bodies a cross-site form can send without a preflight are refused.
+	for _, contentType := range []string{"", "text/plain", "application/x-www-form-urlencoded"} {
+		if rec := kill(contentType, `{"session":"webui"}`); rec.Code != http.StatusUnsupportedMediaType {
+			t.Fatalf("Content-Type %q status = %d, want %d", contentType, rec.Code, http.StatusUnsupportedMediaType)
+		}
+	}
+	if rec := kill("application/json", `{}`); rec.Code != http.StatusBadRequest {
+		t.Fatalf("missing session status = %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+	if rec := kill("application/json", `{"session":"other"}`); rec.Code != http.StatusConflict {
+		t.Fatalf("wrong session status = %d, want %d", rec.Code, http.StatusConflict)
+	}
+	if got := tmux.CountCommandsWithPrefix("kill-session "); got != 0 {
+		t.Fatalf("kill-session sent %d times for rejected requests", got)
+	}
+
+	rec = kill("application/json; charset=utf-8", `{"session":"webui"}`)
+	if rec.Code != http.StatusAccepted {
+		t.Fatalf("status = %d, body = %s", rec.Code, rec.Body.String())
+	}
+	if got := tmux.LastCommandWithPrefix("kill-session "); got != "kill-session -t webui" {
+		t.Fatalf("kill command = %q", got)
+	}
+	if err := hub.Policy().ValidateCommand("kill-session"); err == nil {
+		t.Fatalf("kill-session must not be allowed over /ws")
+	}
+}
+
+func TestAPIPolicyListsAllowedCommands(t *testing.T) {
+	hub := wshub.New(policy.Default(), "webui")
+	h, err := NewServer(Config{Hub: hub})
+	if err != nil {
+		t.Fatalf("NewServer: %v", err)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/api/policy", nil)
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, body = %s", rec.Code, rec.Body.String())
+	}
+	var payload struct {
+		Allowed []string `json:"allowed"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &payload); err != nil {
+		t.Fatalf("decode response: %v", err)
+	}
+	want := []string{"capture-pane", "display-message", "kill-window", "list-panes", "list-windows", "paste-buffer", "refresh-client", "send-keys", "set-buffer", "show-options"}
+	if strings.Join(payload.Allowed, ",") != strings.Join(want, ",") {
+		t.Fatalf("allowed = %v, want %v", payload.Allowed, want)
+	}
+}
+
+func TestAPIAdminClientsListsConnectedClients(t *testing.T) {
+	hub := wshub.New(policy.Default(), "webui")
+	h, err := NewServer(Config{Hub: hub})
+	if err != nil {
+		t.Fatalf("NewServer: %v", err)
+	}
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http")+"/ws", nil)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer conn.Close()
+	if err := conn.WriteJSON(map[string]any{"t": "hello", "name": "alice-laptop"}); err != nil {
+		t.Fatalf("write hello: %v", err)
+	}
+
+	var clients []wshub.ClientInfo
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		resp, err := http.Get(srv.URL + "/api/admin/clients")
+		if err != nil {
+			t.Fatalf("GET /api/admin/clients: %v", err)
+		}
+		var payload struct {
+			Clients []wshub.ClientInfo `json:"clients"`
+		}
+		err = json.NewDecoder(resp.Body).Decode(&payload)
+		resp.Body.Close()
+		if err != nil {
+			t.Fatalf("decode response: %v", err)
+		}
+		clients = payload.Clients
+		if len(clients) == 1 && clients[0].Name == "alice-laptop" {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if len(clients) != 1 {
+		t.Fatalf("unexpected clients: %#v", clients)
+	}
+	c := clients[0]
+	if c.Name != "alice-laptop" || c.RemoteAddr == "" || c.ConnectedAt == "" || c.MessagesSent != 1 {
+		t.Fatalf("unexpected client info: %#v", c)
+	}
+}
+
+func TestAPIAdminClientsKeepsTermAcrossHello(t *testing.T) {
+	hub := wshub.New(policy.Default(), "webui")
+	h, err := NewServer(Config{Hub: hub})
+	if err != nil {
+		t.Fatalf("NewServer: %v", err)
+	}
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http")+"/ws", nil)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer conn.Close()
+	hellos := []map[string]any{
+		{"t": "hello", "name": "alice-laptop", "term": "xterm-256color"},
+		{"t": "hello", "name": "alice-desktop"},
+	}
+	for _, hello := range hellos {
+		if err := conn.WriteJSON(hello); err != nil {
+			t.Fatalf("write hello: %v", err)
+		}
+	}
+
+	var clients []wshub.ClientInfo
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		resp, err := http.Get(srv.URL + "/api/admin/clients")
+		if err != nil {
+			t.Fatalf("GET /api/admin/clients: %v", err)
+		}
+		var payload struct {
+			Clients []wshub.ClientInfo `json:"clients"`
+		}
+		err = json.NewDecoder(resp.Body).Decode(&payload)
+		resp.Body.Close()
+		if err != nil {
+			t.Fatalf("decode response: %v", err)
+		}
+		clients = payload.Clients
+		if len(clients) == 1 && clients[0].MessagesSent == int64(len(hellos)) {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if len(clients) != 1 || clients[0].Name != "alice-desktop" || clients[0].Term != "xterm-256color" {
+		t.Fatalf("clients = %#v, want the second name and the first term", clients)
+	}
+}
+
+func TestPrecompressedHandlerServesPrecompressedAsset(t *testing.T) {
+	fsys := fstest.MapFS{
+		"app.js":    {Data: []byte("plain")},
+		"app.js.gz": {Data: []byte("compressed")},
+		"other.js":  {Data: []byte("other")},
+	}
+	h := precompressedHandler(fsys, http.FileServerFS(fsys))
+
+	req := httptest.NewRequest(http.MethodGet, "/app.js", nil)
+	req.Header.Set("Accept-Encoding", "br, gzip")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, body = %s", rec.Code, rec.Body.String())
+	}
+	if got := rec.Header().Get("Content-Encoding"); got != "gzip" {
+		t.Fatalf("content-encoding = %q, want gzip", got)
+	}
+	if got := rec.Header().Get("Content-Type"); !strings.Contains(got, "javascript") {
+		t.Fatalf("content-type = %q, want javascript", got)
+	}
+	if got := rec.Body.String(); got != "compressed" {
+		t.Fatalf("body = %q, want compressed sibling", got)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/app.js", nil)
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if got := rec.Header().Get("Content-Encoding"); got != "" {
+		t.Fatalf("content-encoding without accept = %q, want empty", got)
+	}
+	if got := rec.Header().Get("Vary"); got != "Accept-Encoding" {
+		t.Fatalf("vary = %q, want Accept-Encoding", got)
+	}
+	if got := rec.Body.String(); got != "plain" {
+		t.Fatalf("body = %q, want plain file", got)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/other.js", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if got := rec.Header().Get("Content-Encoding"); got != "" {
+		t.Fatalf("content-encoding without sibling = %q, want empty", got)
+	}
+	if got := rec.Body.String(); got != "other" {
+		t.Fatalf("body = %q, want other", got)
+	}
+}
+
+func TestPrecompressedHandlerSupportsRangeRequests(t *testing.T) {
+	fsys := fstest.MapFS{"app.js": {Data: []byte("0123456789")}}
+	h := precompressedHandler(fsys, http.FileServerFS(fsys))
+
+	req := httptest.NewRequest(http.MethodGet, "/app.js", nil)
+	req.Header.Set("Range", "bytes=2-4")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusPartialContent {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusPartialContent)
+	}
+	if got := rec.Body.String(); got != "234" {
+		t.Fatalf("body = %q, want %q", got, "234")
+	}
+}
+
+func TestPrecompressedHandlerNegotiatesEncoding(t *testing.T) {
+	fsys := fstest.MapFS{
+		"app.js":    {Data: []byte("plain")},
+		"app.js.br": {Data: []byte("brotli")},
+		"app.js.gz": {Data: []byte("gzipped")},
+	}
+	h := precompressedHandler(fsys, http.FileServerFS(fsys))
+
+	cases := []struct {
+		accept   string
+		encoding string
+		body     string
+	}{
+		{"gzip, br", "br", "brotli"},
+		{"br;q=0, gzip", "gzip", "gzipped"},
+		{"br;q=0.5, gzip;q=0.8", "gzip", "gzipped"},
+		{"*", "br", "brotli"},
+		{"gzip;q=0, *;q=0.1", "br", "brotli"},
+		{"br;q=0, gzip;q=0", "", "plain"},
+		{"identity", "", "plain"},
+	}
+	for _, tc := range cases {
+		req := httptest.NewRequest(http.MethodGet, "/app.js", nil)
+		req.Header.Set("Accept-Encoding", tc.accept)
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+
+		if got := rec.Header().Get("Content-Encoding"); got != tc.encoding {
+			t.Fatalf("Accept-Encoding %q: content-encoding = %q, want %q", tc.accept, got, tc.encoding)
+		}
+		if got := rec.Body.String(); got != tc.body {
+			t.Fatalf("Accept-Encoding %q: body = %q, want %q", tc.accept, got, tc.body)
+		}
+		if got := rec.Header().Get("Vary"); got != "Accept-Encoding" {
+			t.Fatalf("Accept-Encoding %q: vary = %q, want Accept-Encoding", tc.accept, got)
+		}
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/app.js.br", nil)
+	req.Header.Set("Accept-Encoding", "br")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if got := rec.Header().Get("Content-Encoding"); got != "" {
+		t.Fatalf("direct sibling request content-encoding = %q, want empty", got)
+	}
+}
+
+func hasDocLink(links []struct {
+	Rel       string "json:\"rel\""
+	Href      string "json:\"href\""
+	Method    string "json:\"method\""
+	Templated bool   "json:\"templated\""
+}, rel, href, method string) bool {
+	for _, link := range links {
+		if link.Rel == rel && link.Href == href && link.Method == method {
+			return true
+		}
+	}
+	return false
+}
+
+func hasDocAction(actions []struct {
+	Name   string "json:\"name\""
+	Method string "json:\"method\""
+	Href   string "json:\"href\""
+}, name, href, method string) bool {
+	for _, action := range actions {
+		if action.Name == name && action.Href == href && action.Method == method {
+			return true
+		}
+	}
+	return false
+}
+
+func hasPaneLink(links []struct {
+	Rel  string "json:\"rel\""
+	Href string "json:\"href\""
+}, rel, href string) bool {
+	for _, link := range links {
+		if link.Rel == rel && link.Href == href {
+			return true
+		}
+	}
+	return false
+}
+
+func waitForTargetPaneID(t *testing.T, hub *wshub.Hub, paneID string) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		for _, pane := range hub.CurrentTargetSessionPaneInfos() {
+			if pane.PaneID == paneID {
+				return
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("pane %s did not appear in target session state", paneID)
+}
+
+type scriptedTmuxSender struct {
+	hub *wshub.Hub
+	mu  sync.Mutex
+	// emptyCapture makes plain capture-pane return no lines.
+	emptyCapture bool
+	// captureLines, when set, replaces the plain capture-pane output.
+	captureLines []string
+	// historySize answers display-message for #{history_size}.
+	historySize int
+	// listsNewPane makes list-panes also report %14, the pane split-window
+	// creates, so created panes resolve from the model.
+	listsNewPane bool
+
+	lines []string
+}
+
+func (s *scriptedTmuxSender) Send(line string) error {
+	s.mu.Lock()
+	s.lines = append(s.lines, line)
+	s.mu.Unlock()
+
+	switch {
+	case strings.HasPrefix(line, "list-panes "):
+		s.mu.Lock()
+		listsNewPane := s.listsNewPane
+		s.mu.Unlock()
+		go func() {
+			s.hub.BroadcastTmuxStdoutLine("%begin 1 1 0")
+			s.hub.BroadcastTmuxStdoutLine("__WMUX___pane\twebui\t%13\t@1\t0\t1\t0\t0\t120\t40\tbash\tbash\t0\tmain\t1")
+			if listsNewPane {
+				s.hub.BroadcastTmuxStdoutLine("__WMUX___pane\twebui\t%14\t@1\t0\t1\t1\t0\t60\t40\tbash\tbash\t0\tmain\t1")
+			}
+			s.hub.BroadcastTmuxStdoutLine("%end 1 1 0")
+		}()
+	case strings.HasPrefix(line, "split-window "):
+		go func() {
+			s.hub.BroadcastTmuxStdoutLine("%begin 5 5 0")
+			s.hub.BroadcastTmuxStdoutLine("%14")
+			s.hub.BroadcastTmuxStdoutLine("%end 5 5 0")
+		}()
+	case strings.HasPrefix(line, "display-message -p -t %14 "):
+		go func() {
+			s.hub.BroadcastTmuxStdoutLine("%begin 13 13 0")
+			s.hub.BroadcastTmuxStdoutLine("__WMUX_CURSOR\t3\t7")
+			s.hub.BroadcastTmuxStdoutLine("%end 13 13 0")
+		}()
+	case strings.HasPrefix(line, "rename-window "):
+		go func() {
+			s.hub.BroadcastTmuxStdoutLine("%begin 7 7 0")
+			s.hub.BroadcastTmuxStdoutLine("%end 7 7 0")
+		}()
+	case line == "display-message -p -t %13 '#{pane_pid}'":
+		go func() {
+			s.hub.BroadcastTmuxStdoutLine("%begin 6 6 0")
+			s.hub.BroadcastTmuxStdoutLine("4242")
+			s.hub.BroadcastTmuxStdoutLine("%end 6 6 0")
+		}()
+	case line == "display-message -p -t %13 '#{history_size}'":
+		s.mu.Lock()
+		size := s.historySize
+		s.mu.Unlock()
+		go func() {
+			s.hub.BroadcastTmuxStdoutLine("%begin 11 11 0")
+			s.hub.BroadcastTmuxStdoutLine(strconv.Itoa(size))
+			s.hub.BroadcastTmuxStdoutLine("%end 11 11 0")
+		}()
+	case strings.HasPrefix(line, "capture-pane -p -S "):
+		go func() {
+			s.hub.BroadcastTmuxStdoutLine("%begin 12 12 0")
+			s.hub.BroadcastTmuxStdoutLine("since-mark-line")
+			s.hub.BroadcastTmuxStdoutLine("%end 12 12 0")
+		}()
+	case line == "display-message -p -t %13 '#{bogus'":
+		go func() {
+			s.hub.BroadcastTmuxStdoutLine("%begin 10 10 0")
+			s.hub.BroadcastTmuxStdoutLine("parse error: unterminated format")
+			s.hub.BroadcastTmuxStdoutLine("%error 10 10 0")
+		}()
+	case line == "capture-pane -p -N -t %14":
+		go func() {
+			s.hub.BroadcastTmuxStdoutLine("%begin 15 15 0")
+			s.hub.BroadcastTmuxStdoutLine("user@host:~$ ")
+			s.hub.BroadcastTmuxStdoutLine("%end 15 15 0")
+		}()
+	case line == "capture-pane -p -N -t %13":
+		s.mu.Lock()
+		captured := s.captureLines
+		s.mu.Unlock()
+		go func() {
+			s.hub.BroadcastTmuxStdoutLine("%begin 2 2 0")
+			switch {
+			case captured != nil:
+				for _, l := range captured {
+					s.hub.BroadcastTmuxStdoutLine(l)
+				}
+			case !s.emptyCapture:
+				s.hub.BroadcastTmuxStdoutLine("plain-line")
+			}
+			s.hub.BroadcastTmuxStdoutLine("%end 2 2 0")
+		}()
+	case line == "capture-pane -p -J -N -t %13", line == "capture-pane -p -e -J -N -t %13":
+		go func() {
+			s.hub.BroadcastTmuxStdoutLine("%begin 9 9 0")
+			s.hub.BroadcastTmuxStdoutLine("joined-logical-line")
+			s.hub.BroadcastTmuxStdoutLine("%end 9 9 0")
+		}()
+	case line == "capture-pane -p -e -N -t %13":
+		go func() {
+			s.hub.BroadcastTmuxStdoutLine("%begin 3 3 0")
+			s.hub.BroadcastTmuxStdoutLine("\u001b[31mred\u001b[0m")
+			s.hub.BroadcastTmuxStdoutLine("%end 3 3 0")
+		}()
+	case strings.HasPrefix(line, "send-keys "), strings.HasPrefix(line, "set-option "),
+		strings.HasPrefix(line, "set-buffer "), strings.HasPrefix(line, "paste-buffer "),
+		strings.HasPrefix(line, "refresh-client "):
+		go func() {
+			s.hub.BroadcastTmuxStdoutLine("%begin 8 8 0")
+			s.hub.BroadcastTmuxStdoutLine("%end 8 8 0")
+		}()
+	default:
+		go func() {
+			s.hub.BroadcastTmuxStdoutLine("%begin 4 4 0")
+			s.hub.BroadcastTmuxStdoutLine("%error 4 4 0")
+		}()
+	}
+	return nil
+}
+
+func (s *scriptedTmuxSender) LastCommandWithPrefix(prefix string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := len(s.lines) - 1; i >= 0; i-- {
+		if strings.HasPrefix(s.lines[i], prefix) {
+			return s.lines[i]
+		}
+	}
+	return ""
+}
+
+func (s *scriptedTmuxSender) CountCommandsWithPrefix(prefix string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := 0
+	for _, line := range s.lines {
+		if strings.HasPrefix(line, prefix) {
+			n++
+		}
+	}
+	return n
+}
+
+func TestNewServerCORSAllowList(t *testing.T) {
+	hub := wshub.New(policy.Default(), "webui")
+	origins, err := ParseCORSOrigins("https://Tools.example.com, https://tools.example.com/")
+	if err != nil {
+		t.Fatalf("ParseCORSOrigins: %v", err)
+	}
+	if len(origins) != 1 || origins[0] != "https://tools.example.com" {
+		t.Fatalf("origins = %q, want one normalized origin", origins)
+	}
+	h, err := NewServer(Config{Hub: hub, CORSOrigins: origins})
+	if err != nil {
+		t.Fatalf("NewServer: %v", err)
+	}
+
+	req := httptest.NewRequest(http.MethodOptions, "/api/panes", nil)
+	req.Header.Set("Origin", "https://tools.example.com")
+	req.Header.Set("Access-Control-Request-Method", http.MethodPost)
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusNoContent {
+		t.Fatalf("preflight status = %d, want 204", rec.Code)
+	}
+	if got := rec.Header().Get("Access-Control-Allow-Origin"); got != "https://tools.example.com" {
+		t.Fatalf("Allow-Origin = %q, want the request origin echoed", got)
+	}
+	if got := rec.Header().Get("Access-Control-Allow-Methods"); !strings.Contains(got, "POST") {
+		t.Fatalf("Allow-Methods = %q, want POST", got)
+	}
+	if got := rec.Header().Get("Access-Control-Allow-Headers"); !strings.Contains(got, "Content-Type") || !strings.Contains(got, "Idempotency-Key") {
+		t.Fatalf("Allow-Headers = %q, want Content-Type and Idempotency-Key", got)
+	}
+	if got := rec.Header().Get("Access-Control-Expose-Headers"); !strings.Contains(got, "X-Request-ID") || !strings.Contains(got, "Idempotent-Replayed") {
+		t.Fatalf("Expose-Headers = %q, want X-Request-ID and Idempotent-Replayed", got)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/api/policy", nil)
+	req.Header.Set("Origin", "https://tools.example.com")
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK || rec.Header().Get("Access-Control-Allow-Origin") != "https://tools.example.com" {
+		t.Fatalf("GET status = %d, Allow-Origin = %q", rec.Code, rec.Header().Get("Access-Control-Allow-Origin"))
+	}
+
+	req = httptest.NewRequest(http.MethodOptions, "/api/panes", nil)
+	req.Header.Set("Origin", "https://evil.example.com")
+	req.Header.Set("Access-Control-Request-Method", http.MethodPost)
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if got := rec.Header().Get("Access-Control-Allow-Origin"); got != "" {
+		t.Fatalf("Allow-Origin = %q for an unlisted origin, want none", got)
+	}
+	if rec.Code == http.StatusNoContent {
+		t.Fatalf("preflight from an unlisted origin was answered")
+	}
+
+	for _, bad := range []string{"*", "https://*.example.com", "ftp://example.com", "https://example.com/app", "example.com"} {
+		if _, err := ParseCORSOrigins(bad); err == nil {
+			t.Fatalf("ParseCORSOrigins(%q) succeeded, want error", bad)
+		}
+	}
+}
+
+func TestAPIClientSize(t *testing.T) {
+	hub := wshub.New(policy.Default(), "webui")
+	tmux := &scriptedTmuxSender{hub: hub}
+	if err := hub.BindTmux(tmux); err != nil {
+		t.Fatalf("BindTmux: %v", err)
+	}
+	h, err := NewServer(Config{Hub: hub})
+	if err != nil {
+		t.Fatalf("NewServer: %v", err)
+	}
+
+	getSize := func() wshub.ClientSize {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/client", nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("GET /api/client status = %d, body = %s", rec.Code, rec.Body.String())
+		}
+		var size wshub.ClientSize
+		if err := json.Unmarshal(rec.Body.Bytes(), &size); err != nil {
+			t.Fatalf("decode: %v", err)
+		}
+		return size
+	}
+	if got := getSize(); got != wshub.DefaultClientSize {
+		t.Fatalf("initial size = %+v, want %+v", got, wshub.DefaultClientSize)
+	}
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/client/size", strings.NewReader(`{"width":200,"height":50}`)))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("POST status = %d, body = %s", rec.Code, rec.Body.String())
+	}
+	if got := tmux.LastCommandWithPrefix("refresh-client "); got != "refresh-client -C 200x50" {
+		t.Fatalf("tmux command = %q, want refresh-client -C 200x50", got)
+	}
+	if got := getSize(); got != (wshub.ClientSize{Width: 200, Height: 50}) {
+		t.Fatalf("size after resize = %+v, want 200x50", got)
+	}
 
-	if getRec.Code != http.StatusOK {
-		t.Fatalf("get status = %d, body = %s", getRec.Code, getRec.Body.String())
+	for _, body := range []string{`{"width":0,"height":50}`, `{"width":80,"height":20000}`, `{"width":"80"}`, `{"width":80,"height":24,"x":1}`} {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/client/size", strings.NewReader(body)))
+		if rec.Code != http.StatusBadRequest {
+			t.Fatalf("body %s: status = %d, want 400", body, rec.Code)
+		}
 	}
+}
 
-	var report unicodeDebugRecord
-	if err := json.Unmarshal(getRec.Body.Bytes(), &report); err != nil {
-		t.Fatalf("decode get response: %v", err)
+func TestAPIPaneHeadChecksExistenceWithoutTmux(t *testing.T) {
+	hub := wshub.New(policy.Default(), "webui")
+	tmux := &scriptedTmuxSender{hub: hub}
+	if err := hub.BindTmux(tmux); err != nil {
+		t.Fatalf("BindTmux: %v", err)
 	}
-	if report.Client.PaneID != "13" {
-		t.Fatalf("client pane id = %q, want %q", report.Client.PaneID, "13")
+	if err := hub.RequestStateSync(); err != nil {
+		t.Fatalf("RequestStateSync: %v", err)
 	}
-	if report.Server.TmuxPaneID != "%13" {
-		t.Fatalf("server tmux pane id = %q, want %q", report.Server.TmuxPaneID, "%13")
+	waitForTargetPaneID(t, hub, "13")
+	h, err := NewServer(Config{Hub: hub})
+	if err != nil {
+		t.Fatalf("NewServer: %v", err)
 	}
-	if report.Server.PlainSample != "plain-line" {
-		t.Fatalf("plain sample = %q, want %q", report.Server.PlainSample, "plain-line")
+	tmux.mu.Lock()
+	sent := len(tmux.lines)
+	tmux.mu.Unlock()
+
+	for path, want := range map[string]int{"/api/panes/13": http.StatusOK, "/api/panes/99": http.StatusNotFound} {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodHead, path, nil))
+		if rec.Code != want {
+			t.Fatalf("HEAD %s status = %d, want %d", path, rec.Code, want)
+		}
+		if rec.Body.Len() != 0 {
+			t.Fatalf("HEAD %s body = %q, want none", path, rec.Body.String())
+		}
 	}
-	if report.Server.EscapedSample != "\u001b[31mred\u001b[0m" {
-		t.Fatalf("escaped sample = %q, want escape-decorated output", report.Server.EscapedSample)
+
+	tmux.mu.Lock()
+	defer tmux.mu.Unlock()
+	if len(tmux.lines) != sent {
+		t.Fatalf("HEAD sent tmux commands: %q", tmux.lines[sent:])
 	}
 }
 
-func hasDocLink(links []struct {
-	Rel       string "json:\"rel\""
-	Href      string "json:\"href\""
-	Method    string "json:\"method\""
-	Templated bool   "json:\"templated\""
-}, rel, href, method string) bool {
-	for _, link := range links {
-		if link.Rel == rel && link.Href == href && link.Method == method {
-			return true
+func TestAPIContentsCaptureSizeLimit(t *testing.T) {
+	for _, tc := range []struct {
+		overflow string
+		code     int
+		body     string
+	}{
+		{wshub.CaptureTruncate, http.StatusOK, "[wmux: capture truncated at 4 bytes; 1 of 1 lines omitted]\n"},
+		{wshub.CaptureError, http.StatusRequestEntityTooLarge, "capture exceeds the size limit\n"},
+	} {
+		hub := wshub.NewWithOptions(policy.Default(), "webui", wshub.Options{MaxCaptureBytes: 4, CaptureOverflow: tc.overflow})
+		tmux := &scriptedTmuxSender{hub: hub}
+		if err := hub.BindTmux(tmux); err != nil {
+			t.Fatalf("BindTmux: %v", err)
+		}
+		if err := hub.RequestStateSync(); err != nil {
+			t.Fatalf("RequestStateSync: %v", err)
+		}
+		waitForTargetPaneID(t, hub, "13")
+		h, err := NewServer(Config{Hub: hub})
+		if err != nil {
+			t.Fatalf("NewServer: %v", err)
+		}
+
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/contents/13", nil))
+		if rec.Code != tc.code || rec.Body.String() != tc.body {
+			t.Fatalf("%s: status = %d, body = %q; want %d %q", tc.overflow, rec.Code, rec.Body.String(), tc.code, tc.body)
 		}
 	}
-	return false
 }
 
-func hasDocAction(actions []struct {
-	Name   string "json:\"name\""
-	Method string "json:\"method\""
-	Href   string "json:\"href\""
-}, name, href, method string) bool {
-	for _, action := range actions {
-		if action.Name == name && action.Href == href && action.Method == method {
-			return true
+func TestBasePathMountsRoutesUnderPrefix(t *testing.T) {
+	hub := wshub.New(policy.Default(), "webui")
+	tmux := &scriptedTmuxSender{hub: hub}
+	if err := hub.BindTmux(tmux); err != nil {
+		t.Fatalf("BindTmux: %v", err)
+	}
+	if err := hub.RequestStateSync(); err != nil {
+		t.Fatalf("RequestStateSync: %v", err)
+	}
+	waitForTargetPaneID(t, hub, "13")
+
+	h, err := NewServer(Config{Hub: hub, DefaultTerm: "xterm", BasePath: "/tools/wmux"})
+	if err != nil {
+		t.Fatalf("NewServer: %v", err)
+	}
+	get := func(target string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, target, nil))
+		return rec
+	}
+
+	rec := get("/tools/wmux/api/state.json")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("state status = %d, body = %s", rec.Code, rec.Body.String())
+	}
+	var doc hypermediaDocument
+	if err := json.Unmarshal(rec.Body.Bytes(), &doc); err != nil {
+		t.Fatalf("decode state: %v", err)
+	}
+	for _, link := range doc.Links {
+		if !strings.HasPrefix(link.Href, "/tools/wmux/") {
+			t.Fatalf("root link %s href = %q, want /tools/wmux prefix", link.Rel, link.Href)
+		}
+		if link.Example != "" && !strings.HasPrefix(link.Example, "/tools/wmux/") {
+			t.Fatalf("root link %s example = %q, want /tools/wmux prefix", link.Rel, link.Example)
+		}
+	}
+	for _, action := range doc.Actions {
+		if !strings.HasPrefix(action.Href, "/tools/wmux/") {
+			t.Fatalf("action %s href = %q, want /tools/wmux prefix", action.Name, action.Href)
+		}
+	}
+	if len(doc.Panes) != 1 || len(doc.Panes[0].Links) == 0 {
+		t.Fatalf("panes = %+v, want one pane with links", doc.Panes)
+	}
+	for _, link := range doc.Panes[0].Links {
+		if !strings.HasPrefix(link.Href, "/tools/wmux/") {
+			t.Fatalf("pane link %s href = %q, want /tools/wmux prefix", link.Rel, link.Href)
+		}
+	}
+
+	rec = get("/tools/wmux/p/13")
+	if rec.Code != http.StatusFound {
+		t.Fatalf("pane status = %d, body = %s", rec.Code, rec.Body.String())
+	}
+	if got := rec.Header().Get("Location"); got != "/tools/wmux/p/13?term=xterm" {
+		t.Fatalf("pane redirect = %q, want /tools/wmux/p/13?term=xterm", got)
+	}
+
+	rec = get("/tools/wmux/p/13?term=xterm")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("index status = %d", rec.Code)
+	}
+	if body := rec.Body.String(); !strings.Contains(body, `<base href="/tools/wmux/" />`) {
+		t.Fatalf("index does not point <base> at the prefix:\n%s", body)
+	}
+
+	rec = get("/tools/wmux/app.js")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("static status = %d", rec.Code)
+	}
+
+	rec = get("/tools/wmux")
+	if rec.Code != http.StatusMovedPermanently || rec.Header().Get("Location") != "/tools/wmux/" {
+		t.Fatalf("bare prefix = %d %q, want redirect to /tools/wmux/", rec.Code, rec.Header().Get("Location"))
+	}
+
+	for _, target := range []string{"/api/state.json", "/p/13?term=xterm", "/tools/wmuxx/api/state.json"} {
+		if rec := get(target); rec.Code != http.StatusNotFound {
+			t.Fatalf("GET %s status = %d, want 404 outside the prefix", target, rec.Code)
 		}
 	}
-	return false
 }
 
-func hasPaneLink(links []struct {
-	Rel  string "json:\"rel\""
-	Href string "json:\"href\""
-}, rel, href string) bool {
-	for _, link := range links {
-		if link.Rel == rel && link.Href == href {
-			return true
+func TestAPIPanesCreateLocationUnderBasePath(t *testing.T) {
+	hub := wshub.New(policy.Default(), "webui")
+	tmux := &scriptedTmuxSender{hub: hub}
+	if err := hub.BindTmux(tmux); err != nil {
+		t.Fatalf("BindTmux: %v", err)
+	}
+	if err := hub.RequestStateSync(); err != nil {
+		t.Fatalf("RequestStateSync: %v", err)
+	}
+	waitForTargetPaneID(t, hub, "13")
+
+	h, err := NewServer(Config{Hub: hub, BasePath: "/wmux"})
+	if err != nil {
+		t.Fatalf("NewServer: %v", err)
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/wmux/api/panes", strings.NewReader(`{}`))
+	req.Header.Set("Content-Type", "application/json")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("status = %d, body = %s", rec.Code, rec.Body.String())
+	}
+	if got := rec.Header().Get("Location"); got != "/wmux/api/panes/14" {
+		t.Fatalf("location = %q, want /wmux/api/panes/14", got)
+	}
+	var doc hypermediaDocument
+	if err := json.Unmarshal(rec.Body.Bytes(), &doc); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if len(doc.Links) == 0 || doc.Links[0].Href != "/wmux/api/panes/14" {
+		t.Fatalf("self link = %+v, want /wmux/api/panes/14", doc.Links)
+	}
+}
+
+func TestTmuxNotReadyResponsesCarryRetryAfter(t *testing.T) {
+	hub := wshub.New(policy.Default(), "webui")
+	h, err := NewServer(Config{Hub: hub})
+	if err != nil {
+		t.Fatalf("NewServer: %v", err)
+	}
+	post := func(target, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, target, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := post("/api/admin/kill-session", `{"session":"webui"}`)
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusServiceUnavailable)
+	}
+	if got := rec.Header().Get("Retry-After"); got != "1" {
+		t.Fatalf("Retry-After = %q, want 1", got)
+	}
+
+	hub.BroadcastReconnecting(1, time.Now().Add(6500*time.Millisecond))
+	rec = post("/api/admin/kill-session", `{"session":"webui"}`)
+	if got := rec.Header().Get("Retry-After"); got != "7" {
+		t.Fatalf("Retry-After while reconnecting = %q, want 7 (rounded up)", got)
+	}
+
+	rec = post("/api/buffers", `{"data":"hello"}`)
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("buffers status = %d, body = %s", rec.Code, rec.Body.String())
+	}
+	if got := rec.Header().Get("Retry-After"); got != "7" {
+		t.Fatalf("buffers Retry-After = %q, want 7", got)
+	}
+}
+
+func TestRawWSRouteRequiresEnableRawWS(t *testing.T) {
+	hub := wshub.New(policy.Default(), "webui")
+	for _, enabled := range []bool{false, true} {
+		h, err := NewServer(Config{Hub: hub, EnableRawWS: enabled})
+		if err != nil {
+			t.Fatalf("NewServer: %v", err)
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/ws/raw", nil))
+		// Without upgrade headers the enabled handler refuses with 400.
+		want := http.StatusNotFound
+		if enabled {
+			want = http.StatusBadRequest
+		}
+		if rec.Code != want {
+			t.Fatalf("enabled=%v: status = %d, want %d", enabled, rec.Code, want)
 		}
 	}
-	return false
 }
 
-func waitForTargetPaneID(t *testing.T, hub *wshub.Hub, paneID string) {
-	t.Helper()
-	deadline := time.Now().Add(2 * time.Second)
-	for time.Now().Before(deadline) {
-		for _, pane := range hub.CurrentTargetSessionPaneInfos() {
-			if pane.PaneID == paneID {
-				return
+func TestDebugRoutesRequireEnableDebug(t *testing.T) {
+	hub := wshub.New(policy.Default(), "webui")
+	for _, enabled := range []bool{false, true} {
+		h, err := NewServer(Config{Hub: hub, EnableDebug: enabled})
+		if err != nil {
+			t.Fatalf("NewServer: %v", err)
+		}
+		for _, path := range []string{"/api/debug/parse-errors", "/api/debug/pending", "/api/debug/output-latency", "/api/debug/parser-queue"} {
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+			want := http.StatusNotFound
+			if enabled {
+				want = http.StatusOK
+			}
+			if rec.Code != want {
+				t.Fatalf("enabled=%v: %s status = %d, want %d", enabled, path, rec.Code, want)
 			}
 		}
-		time.Sleep(10 * time.Millisecond)
 	}
-	t.Fatalf("pane %s did not appear in target session state", paneID)
 }
 
-type scriptedTmuxSender struct {
-	hub *wshub.Hub
-	mu  sync.Mutex
+func TestAPIPanesCreateWaitsForReadyPrompt(t *testing.T) {
+	hub := wshub.New(policy.Default(), "webui")
+	tmux := &scriptedTmuxSender{hub: hub}
+	if err := hub.BindTmux(tmux); err != nil {
+		t.Fatalf("BindTmux: %v", err)
+	}
+	if err := hub.RequestStateSync(); err != nil {
+		t.Fatalf("RequestStateSync: %v", err)
+	}
+	waitForTargetPaneID(t, hub, "13")
 
-	lines []string
-}
+	h, err := NewServer(Config{Hub: hub})
+	if err != nil {
+		t.Fatalf("NewServer: %v", err)
+	}
+	post := func(query string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/api/panes"+query, nil)
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+	ready := func(rec *httptest.ResponseRecorder) any {
+		t.Helper()
+		var payload struct {
+			Panes []map[string]any `json:"panes"`
+		}
+		if err := json.Unmarshal(rec.Body.Bytes(), &payload); err != nil || len(payload.Panes) != 1 {
+			t.Fatalf("decode %s: %v", rec.Body.String(), err)
+		}
+		return payload.Panes[0]["ready"]
+	}
 
-func (s *scriptedTmuxSender) Send(line string) error {
-	s.mu.Lock()
-	s.lines = append(s.lines, line)
-	s.mu.Unlock()
+	rec := post("?wait_ready=1&ready_regex=" + url.QueryEscape(`\$$`))
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("status = %d, body = %s", rec.Code, rec.Body.String())
+	}
+	if got := ready(rec); got != true {
+		t.Fatalf("ready = %v, want true", got)
+	}
+	if tmux.LastCommandWithPrefix("capture-pane -p -N -t %14") == "" {
+		t.Fatalf("new pane was not polled")
+	}
 
-	switch {
-	case strings.HasPrefix(line, "list-panes "):
-		go func() {
-			s.hub.BroadcastTmuxStdoutLine("%begin 1 1 0")
-			s.hub.BroadcastTmuxStdoutLine("__WMUX___pane\twebui\t%13\t@1\t0\t1\t0\t0\t120\t40\tbash\tbash\t0\tmain")
-			s.hub.BroadcastTmuxStdoutLine("%end 1 1 0")
-		}()
-	case strings.HasPrefix(line, "split-window "):
-		go func() {
-			s.hub.BroadcastTmuxStdoutLine("%begin 5 5 0")
-			s.hub.BroadcastTmuxStdoutLine("%14")
-			s.hub.BroadcastTmuxStdoutLine("%end 5 5 0")
-		}()
-	case line == "capture-pane -p -N -t %13":
-		go func() {
-			s.hub.BroadcastTmuxStdoutLine("%begin 2 2 0")
-			s.hub.BroadcastTmuxStdoutLine("plain-line")
-			s.hub.BroadcastTmuxStdoutLine("%end 2 2 0")
-		}()
-	case line == "capture-pane -p -e -N -t %13":
-		go func() {
-			s.hub.BroadcastTmuxStdoutLine("%begin 3 3 0")
-			s.hub.BroadcastTmuxStdoutLine("\u001b[31mred\u001b[0m")
-			s.hub.BroadcastTmuxStdoutLine("%end 3 3 0")
-		}()
-	default:
-		go func() {
-			s.hub.BroadcastTmuxStdoutLine("%begin 4 4 0")
-			s.hub.BroadcastTmuxStdoutLine("%error 4 4 0")
-		}()
+	rec = post("?ready_regex=" + url.QueryEscape(`^>>> $`) + "&ready_timeout_ms=200")
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("status = %d, body = %s", rec.Code, rec.Body.String())
+	}
+	if got := ready(rec); got != false {
+		t.Fatalf("ready = %v, want false after the timeout", got)
 	}
-	return nil
-}
 
-func (s *scriptedTmuxSender) LastCommandWithPrefix(prefix string) string {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-	for i := len(s.lines) - 1; i >= 0; i-- {
-		if strings.HasPrefix(s.lines[i], prefix) {
-			return s.lines[i]
+	if got := ready(post("")); got != nil {
+		t.Fatalf("ready = %v without wait_ready, want it omitted", got)
+	}
+
+	// Once tmux lists the new pane, the response is built from the model
+	// and must still carry ready.
+	tmux.mu.Lock()
+	tmux.listsNewPane = true
+	tmux.mu.Unlock()
+	if err := hub.RequestStateSync(); err != nil {
+		t.Fatalf("RequestStateSync: %v", err)
+	}
+	waitForTargetPaneID(t, hub, "14")
+	rec = post("?wait_ready=1")
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("status = %d, body = %s", rec.Code, rec.Body.String())
+	}
+	if got := ready(rec); got != true {
+		t.Fatalf("ready = %v for a pane resolved from the model, want true", got)
+	}
+	if !strings.Contains(rec.Body.String(), `"width":60`) {
+		t.Fatalf("body = %s, want the pane resolved from the model", rec.Body.String())
+	}
+
+	for _, query := range []string{"?wait_ready=1&ready_regex=(", "?wait_ready=1&ready_timeout_ms=0", "?wait_ready=1&ready_timeout_ms=600000"} {
+		if rec := post(query); rec.Code != http.StatusBadRequest {
+			t.Fatalf("POST %s status = %d, want 400", query, rec.Code)
 		}
 	}
-	return ""
+}
+
+type replayModeSender struct{}
+
+func (replayModeSender) Send(string) error { return wshub.ErrReplayMode }
+
+func TestReplayModeResponsesAreNotRetryable(t *testing.T) {
+	hub := wshub.New(policy.Default(), "webui")
+	if err := hub.BindTmux(replayModeSender{}); err != nil {
+		t.Fatalf("BindTmux: %v", err)
+	}
+	hub.BroadcastConnectedNoSync()
+	h, err := NewServer(Config{Hub: hub})
+	if err != nil {
+		t.Fatalf("NewServer: %v", err)
+	}
+	req := httptest.NewRequest(http.MethodPost, "/api/buffers", strings.NewReader(`{"data":"hello"}`))
+	req.Header.Set("Content-Type", "application/json")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusNotImplemented {
+		t.Fatalf("status = %d, body = %s", rec.Code, rec.Body.String())
+	}
+	if got := rec.Header().Get("Retry-After"); got != "" {
+		t.Fatalf("Retry-After = %q, want none", got)
+	}
 }
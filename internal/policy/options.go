@@ -0,0 +1,102 @@
+package policy
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// OptionPolicy is the allow-list of tmux session options that may be changed
+// at runtime. It is kept apart from Policy: set-option is never allowed as a
+// raw command, only through this list with a validated value.
+type OptionPolicy struct {
+	allowed map[string]struct{}
+}
+
+// settableOptions validates and normalizes values for the options an
+// OptionPolicy may allow. An option without an entry cannot be allowed.
+var settableOptions = map[string]func(string) (string, error){
+	"history-limit":   intOption(0, 1_000_000),
+	"mouse":           enumOption("on", "off"),
+	"status":          enumOption("on", "off"),
+	"status-position": enumOption("top", "bottom"),
+	"status-interval": intOption(0, 86_400),
+	"mode-keys":       enumOption("vi", "emacs"),
+}
+
+// DefaultSettableOptions is the option list used when none is configured.
+const DefaultSettableOptions = "history-limit,mouse"
+
+// ParseOptionPolicy builds an OptionPolicy from a comma-separated list of
+// option names. An empty list allows nothing.
+func ParseOptionPolicy(list string) (OptionPolicy, error) {
+	p := OptionPolicy{allowed: map[string]struct{}{}}
+	for _, name := range strings.Split(list, ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+		if _, ok := settableOptions[name]; !ok {
+			return OptionPolicy{}, fmt.Errorf("unsupported option %q (supported: %s)", name, strings.Join(SupportedOptions(), ", "))
+		}
+		p.allowed[name] = struct{}{}
+	}
+	return p, nil
+}
+
+// SupportedOptions returns, sorted, every option name ParseOptionPolicy
+// accepts.
+func SupportedOptions() []string {
+	out := make([]string, 0, len(settableOptions))
+	for name := range settableOptions {
+		out = append(out, name)
+	}
+	sort.Strings(out)
+	return out
+}
+
+// Validate checks that name is allowed and value is valid for it, and
+// returns the normalized value to pass to set-option.
+func (p OptionPolicy) Validate(name, value string) (string, error) {
+	if _, ok := p.allowed[name]; !ok {
+		return "", fmt.Errorf("option not settable: %s", name)
+	}
+	v, err := settableOptions[name](strings.TrimSpace(value))
+	if err != nil {
+		return "", fmt.Errorf("invalid value for %s: %w", name, err)
+	}
+	return v, nil
+}
+
+// Allowed returns the settable option names in sorted order.
+func (p OptionPolicy) Allowed() []string {
+	out := make([]string, 0, len(p.allowed))
+	for name := range p.allowed {
+		out = append(out, name)
+	}
+	sort.Strings(out)
+	return out
+}
+
+func intOption(lo, hi int) func(string) (string, error) {
+	return func(v string) (string, error) {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < lo || n > hi {
+			return "", fmt.Errorf("want an integer between %d and %d", lo, hi)
+		}
+		return strconv.Itoa(n), nil
+	}
+}
+
+func enumOption(values ...string) func(string) (string, error) {
+	return func(v string) (string, error) {
+		v = strings.ToLower(v)
+		for _, allowed := range values {
+			if v == allowed {
+				return v, nil
+			}
+		}
+		return "", fmt.Errorf("want one of: %s", strings.Join(values, ", "))
+	}
+}
@@ -1,7 +1,11 @@
 package policy
 
 import (
+	"bufio"
 	"fmt"
+	"os"
+	"regexp"
+	"sort"
 	"strings"
 )
 
@@ -19,9 +23,62 @@ func Default() Policy {
 		"display-message": {},
 		"capture-pane":    {},
 		"show-options":    {},
+		"set-buffer":      {},
+		"paste-buffer":    {},
 	}}
 }
 
+// Admin returns the policy for commands reserved to the admin HTTP API.
+// They are never allowed over /ws, whatever Default or a policy file says,
+// since a client could otherwise tear down the session for everyone.
+func Admin() Policy {
+	return Policy{allowed: map[string]struct{}{
+		"kill-session": {},
+	}}
+}
+
+var validCommandName = regexp.MustCompile(`^[a-z][a-z0-9-]*$`)
+
+// Parse builds a Policy from a command allow-list: one tmux command name per
+// line, with blank lines and lines starting with # ignored. Names are
+// lowercased. An empty list allows nothing. Commands reserved to Admin are
+// rejected.
+func Parse(text string) (Policy, error) {
+	p := Policy{allowed: map[string]struct{}{}}
+	sc := bufio.NewScanner(strings.NewReader(text))
+	for n := 1; sc.Scan(); n++ {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		name := strings.ToLower(line)
+		if !validCommandName.MatchString(name) {
+			return Policy{}, fmt.Errorf("line %d: invalid command name %q", n, line)
+		}
+		if _, reserved := Admin().allowed[name]; reserved {
+			return Policy{}, fmt.Errorf("line %d: %s is reserved for the admin API", n, name)
+		}
+		p.allowed[name] = struct{}{}
+	}
+	if err := sc.Err(); err != nil {
+		return Policy{}, err
+	}
+	return p, nil
+}
+
+// LoadFile reads a Policy from the allow-list file at path (see Parse).
+func LoadFile(path string) (Policy, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Policy{}, err
+	}
+	p, err := Parse(string(data))
+	if err != nil {
+		return Policy{}, fmt.Errorf("%s: %w", path, err)
+	}
+	return p, nil
+}
+
 func (p Policy) Validate(line string) error {
 	cmd := commandName(line)
 	return p.ValidateCommand(cmd)
@@ -37,6 +94,16 @@ func (p Policy) ValidateCommand(cmd string) error {
 	return nil
 }
 
+// Allowed returns the permitted command names in sorted order.
+func (p Policy) Allowed() []string {
+	out := make([]string, 0, len(p.allowed))
+	for cmd := range p.allowed {
+		out = append(out, cmd)
+	}
+	sort.Strings(out)
+	return out
+}
+
 func commandName(line string) string {
 	line = strings.TrimSpace(line)
 	if line == "" {
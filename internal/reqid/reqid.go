@@ -0,0 +1,52 @@
+// Package reqid carries a per-request correlation id through a context, so
+// log lines written while serving an HTTP request, including those for the
+// tmux commands it triggers, can be tied back to it.
+package reqid
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"log/slog"
+	"regexp"
+)
+
+// Header is the HTTP header that carries the id in both directions.
+const Header = "X-Request-ID"
+
+// validID bounds inbound ids to a log-safe character set and length.
+var validID = regexp.MustCompile(`^[A-Za-z0-9._:\-]{1,128}$`)
+
+type contextKey struct{}
+
+// New returns a random 16-character hex id.
+func New() string {
+	b := make([]byte, 8)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// Valid reports whether an inbound id may be used as is.
+func Valid(id string) bool {
+	return validID.MatchString(id)
+}
+
+// NewContext returns a copy of ctx carrying id.
+func NewContext(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, contextKey{}, id)
+}
+
+// FromContext returns the id stored in ctx, or "" if there is none.
+func FromContext(ctx context.Context) string {
+	id, _ := ctx.Value(contextKey{}).(string)
+	return id
+}
+
+// Logger returns logger with a request_id attribute when ctx carries an id,
+// and logger unchanged otherwise.
+func Logger(ctx context.Context, logger *slog.Logger) *slog.Logger {
+	if id := FromContext(ctx); id != "" {
+		return logger.With("request_id", id)
+	}
+	return logger
+}
@@ -0,0 +1,45 @@
+package reqid
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+func TestNewIsValid(t *testing.T) {
+	a, b := New(), New()
+	if len(a) != 16 || !Valid(a) {
+		t.Fatalf("New() = %q, want 16 valid hex characters", a)
+	}
+	if a == b {
+		t.Fatalf("New() returned %q twice", a)
+	}
+}
+
+func TestValid(t *testing.T) {
+	for _, id := range []string{"abc-123", "trace:1.2_3"} {
+		if !Valid(id) {
+			t.Fatalf("Valid(%q) = false", id)
+		}
+	}
+	for _, id := range []string{"", "has space", "line\nbreak", strings.Repeat("x", 129)} {
+		if Valid(id) {
+			t.Fatalf("Valid(%q) = true", id)
+		}
+	}
+}
+
+func TestLoggerAddsRequestID(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&buf, nil))
+
+	Logger(context.Background(), logger).Info("plain")
+	Logger(NewContext(context.Background(), "req-1"), logger).Info("tagged")
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 || strings.Contains(lines[0], "request_id") || !strings.Contains(lines[1], "request_id=req-1") {
+		t.Fatalf("log output = %q", buf.String())
+	}
+}
@@ -0,0 +1,195 @@
+package termimg
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Font is a monospaced bitmap font. Each glyph is a Width x Height mask in
+// cell coordinates, so glyphs can be blitted without further positioning.
+type Font struct {
+	Width  int
+	Height int
+	glyphs map[rune][]bool
+}
+
+// maxFontCell bounds the glyph cell accepted from a font file.
+const maxFontCell = 64
+
+// Glyph returns the mask for r, falling back to '?' and then to nil (draw
+// nothing) when the font has neither.
+func (f *Font) Glyph(r rune) []bool {
+	if g, ok := f.glyphs[r]; ok {
+		return g
+	}
+	return f.glyphs['?']
+}
+
+// builtinGlyphs is a classic 5x7 ASCII font for 0x20-0x7e. Each glyph is five
+// column bytes; bit 0 is the top row.
+var builtinGlyphs = [95][5]byte{
+	{0x00, 0x00, 0x00, 0x00, 0x00}, {0x00, 0x00, 0x5f, 0x00, 0x00}, {0x00, 0x07, 0x00, 0x07, 0x00}, {0x14, 0x7f, 0x14, 0x7f, 0x14},
+	{0x24, 0x2a, 0x7f, 0x2a, 0x12}, {0x23, 0x13, 0x08, 0x64, 0x62}, {0x36, 0x49, 0x55, 0x22, 0x50}, {0x00, 0x05, 0x03, 0x00, 0x00},
+	{0x00, 0x1c, 0x22, 0x41, 0x00}, {0x00, 0x41, 0x22, 0x1c, 0x00}, {0x08, 0x2a, 0x1c, 0x2a, 0x08}, {0x08, 0x08, 0x3e, 0x08, 0x08},
+	{0x00, 0x50, 0x30, 0x00, 0x00}, {0x08, 0x08, 0x08, 0x08, 0x08}, {0x00, 0x60, 0x60, 0x00, 0x00}, {0x20, 0x10, 0x08, 0x04, 0x02},
+	{0x3e, 0x51, 0x49, 0x45, 0x3e}, {0x00, 0x42, 0x7f, 0x40, 0x00}, {0x42, 0x61, 0x51, 0x49, 0x46}, {0x21, 0x41, 0x45, 0x4b, 0x31},
+	{0x18, 0x14, 0x12, 0x7f, 0x10}, {0x27, 0x45, 0x45, 0x45, 0x39}, {0x3c, 0x4a, 0x49, 0x49, 0x30}, {0x01, 0x71, 0x09, 0x05, 0x03},
+	{0x36, 0x49, 0x49, 0x49, 0x36}, {0x06, 0x49, 0x49, 0x29, 0x1e}, {0x00, 0x36, 0x36, 0x00, 0x00}, {0x00, 0x56, 0x36, 0x00, 0x00},
+	{0x08, 0x14, 0x22, 0x41, 0x00}, {0x14, 0x14, 0x14, 0x14, 0x14}, {0x00, 0x41, 0x22, 0x14, 0x08}, {0x02, 0x01, 0x51, 0x09, 0x06},
+	{0x32, 0x49, 0x79, 0x41, 0x3e}, {0x7e, 0x11, 0x11, 0x11, 0x7e}, {0x7f, 0x49, 0x49, 0x49, 0x36}, {0x3e, 0x41, 0x41, 0x41, 0x22},
+	{0x7f, 0x41, 0x41, 0x22, 0x1c}, {0x7f, 0x49, 0x49, 0x49, 0x41}, {0x7f, 0x09, 0x09, 0x09, 0x01}, {0x3e, 0x41, 0x49, 0x49, 0x7a},
+	{0x7f, 0x08, 0x08, 0x08, 0x7f}, {0x00, 0x41, 0x7f, 0x41, 0x00}, {0x20, 0x40, 0x41, 0x3f, 0x01}, {0x7f, 0x08, 0x14, 0x22, 0x41},
+	{0x7f, 0x40, 0x40, 0x40, 0x40}, {0x7f, 0x02, 0x0c, 0x02, 0x7f}, {0x7f, 0x04, 0x08, 0x10, 0x7f}, {0x3e, 0x41, 0x41, 0x41, 0x3e},
+	{0x7f, 0x09, 0x09, 0x09, 0x06}, {0x3e, 0x41, 0x51, 0x21, 0x5e}, {0x7f, 0x09, 0x19, 0x29, 0x46}, {0x46, 0x49, 0x49, 0x49, 0x31},
+	{0x01, 0x01, 0x7f, 0x01, 0x01}, {0x3f, 0x40, 0x40, 0x40, 0x3f}, {0x1f, 0x20, 0x40, 0x20, 0x1f}, {0x3f, 0x40, 0x38, 0x40, 0x3f},
+	{0x63, 0x14, 0x08, 0x14, 0x63}, {0x07, 0x08, 0x70, 0x08, 0x07}, {0x61, 0x51, 0x49, 0x45, 0x43}, {0x00, 0x7f, 0x41, 0x41, 0x00},
+	{0x02, 0x04, 0x08, 0x10, 0x20}, {0x00, 0x41, 0x41, 0x7f, 0x00}, {0x04, 0x02, 0x01, 0x02, 0x04}, {0x40, 0x40, 0x40, 0x40, 0x40},
+	{0x00, 0x01, 0x02, 0x04, 0x00}, {0x20, 0x54, 0x54, 0x54, 0x78}, {0x7f, 0x48, 0x44, 0x44, 0x38}, {0x38, 0x44, 0x44, 0x44, 0x20},
+	{0x38, 0x44, 0x44, 0x48, 0x7f}, {0x38, 0x54, 0x54, 0x54, 0x18}, {0x08, 0x7e, 0x09, 0x01, 0x02}, {0x0c, 0x52, 0x52, 0x52, 0x3e},
+	{0x7f, 0x08, 0x04, 0x04, 0x78}, {0x00, 0x44, 0x7d, 0x40, 0x00}, {0x20, 0x40, 0x44, 0x3d, 0x00}, {0x7f, 0x10, 0x28, 0x44, 0x00},
+	{0x00, 0x41, 0x7f, 0x40, 0x00}, {0x7c, 0x04, 0x18, 0x04, 0x78}, {0x7c, 0x08, 0x04, 0x04, 0x78}, {0x38, 0x44, 0x44, 0x44, 0x38},
+	{0x7c, 0x14, 0x14, 0x14, 0x08}, {0x08, 0x14, 0x14, 0x18, 0x7c}, {0x7c, 0x08, 0x04, 0x04, 0x08}, {0x48, 0x54, 0x54, 0x54, 0x20},
+	{0x04, 0x3f, 0x44, 0x40, 0x20}, {0x3c, 0x40, 0x40, 0x20, 0x7c}, {0x1c, 0x20, 0x40, 0x20, 0x1c}, {0x3c, 0x40, 0x30, 0x40, 0x3c},
+	{0x44, 0x28, 0x10, 0x28, 0x44}, {0x0c, 0x50, 0x50, 0x50, 0x3c}, {0x44, 0x64, 0x54, 0x4c, 0x44}, {0x00, 0x08, 0x36, 0x41, 0x00},
+	{0x00, 0x00, 0x7f, 0x00, 0x00}, {0x00, 0x41, 0x36, 0x08, 0x00}, {0x08, 0x04, 0x08, 0x10, 0x08},
+}
+
+// BuiltinFont returns the embedded 5x7 ASCII font in a 6x8 cell.
+func BuiltinFont() *Font {
+	f := &Font{Width: 6, Height: 8, glyphs: make(map[rune][]bool, len(builtinGlyphs))}
+	for i, cols := range builtinGlyphs {
+		mask := make([]bool, f.Width*f.Height)
+		for x, bits := range cols {
+			for y := 0; y < 7; y++ {
+				if bits&(1<<y) != 0 {
+					mask[y*f.Width+x] = true
+				}
+			}
+		}
+		f.glyphs[rune(0x20+i)] = mask
+	}
+	return f
+}
+
+// ParseBDF reads a monospaced font in the X11 BDF format. Glyphs are placed
+// in the cell described by FONTBOUNDINGBOX using each glyph's BBX.
+func ParseBDF(r io.Reader) (*Font, error) {
+	sc := bufio.NewScanner(r)
+	f := &Font{glyphs: map[rune][]bool{}}
+	var fontXOff, fontYOff int
+
+	var (
+		encoding   = -1
+		bbx        [4]int
+		inBitmap   bool
+		bitmapRows []string
+	)
+	line := 0
+	for sc.Scan() {
+		line++
+		fields := strings.Fields(sc.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		if inBitmap {
+			if fields[0] != "ENDCHAR" {
+				bitmapRows = append(bitmapRows, fields[0])
+				continue
+			}
+			inBitmap = false
+			if encoding >= 0 {
+				mask, err := placeBDFGlyph(f, fontXOff, fontYOff, bbx, bitmapRows)
+				if err != nil {
+					return nil, fmt.Errorf("bdf line %d: %w", line, err)
+				}
+				f.glyphs[rune(encoding)] = mask
+			}
+			continue
+		}
+
+		switch fields[0] {
+		case "FONTBOUNDINGBOX":
+			vals, err := atoiFields(fields[1:], 4)
+			if err != nil {
+				return nil, fmt.Errorf("bdf line %d: FONTBOUNDINGBOX: %w", line, err)
+			}
+			f.Width, f.Height, fontXOff, fontYOff = vals[0], vals[1], vals[2], vals[3]
+			if f.Width <= 0 || f.Height <= 0 || f.Width > maxFontCell || f.Height > maxFontCell {
+				return nil, fmt.Errorf("bdf line %d: unsupported font cell %dx%d", line, f.Width, f.Height)
+			}
+		case "STARTCHAR":
+			encoding = -1
+			bbx = [4]int{f.Width, f.Height, fontXOff, fontYOff}
+		case "ENCODING":
+			vals, err := atoiFields(fields[1:], 1)
+			if err != nil {
+				return nil, fmt.Errorf("bdf line %d: ENCODING: %w", line, err)
+			}
+			encoding = vals[0]
+		case "BBX":
+			vals, err := atoiFields(fields[1:], 4)
+			if err != nil {
+				return nil, fmt.Errorf("bdf line %d: BBX: %w", line, err)
+			}
+			copy(bbx[:], vals)
+		case "BITMAP":
+			if f.Width == 0 {
+				return nil, fmt.Errorf("bdf line %d: BITMAP before FONTBOUNDINGBOX", line)
+			}
+			inBitmap = true
+			bitmapRows = bitmapRows[:0]
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	if len(f.glyphs) == 0 {
+		return nil, fmt.Errorf("bdf: no glyphs found")
+	}
+	return f, nil
+}
+
+func placeBDFGlyph(f *Font, fontXOff, fontYOff int, bbx [4]int, rows []string) ([]bool, error) {
+	w, h, xOff, yOff := bbx[0], bbx[1], bbx[2], bbx[3]
+	mask := make([]bool, f.Width*f.Height)
+	// Rows are counted from the top of the font cell; the baseline sits
+	// fontYOff above the cell bottom.
+	top := (f.Height + fontYOff) - (yOff + h)
+	left := xOff - fontXOff
+	for r := 0; r < h && r < len(rows); r++ {
+		bits, err := strconv.ParseUint(rows[r], 16, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid bitmap row %q", rows[r])
+		}
+		rowBits := len(rows[r]) * 4
+		for c := 0; c < w && c < rowBits; c++ {
+			if bits&(1<<(rowBits-1-c)) == 0 {
+				continue
+			}
+			x, y := left+c, top+r
+			if x < 0 || y < 0 || x >= f.Width || y >= f.Height {
+				continue
+			}
+			mask[y*f.Width+x] = true
+		}
+	}
+	return mask, nil
+}
+
+func atoiFields(fields []string, n int) ([]int, error) {
+	if len(fields) < n {
+		return nil, fmt.Errorf("expected %d values", n)
+	}
+	vals := make([]int, n)
+	for i := 0; i < n; i++ {
+		v, err := strconv.Atoi(fields[i])
+		if err != nil {
+			return nil, err
+		}
+		vals[i] = v
+	}
+	return vals, nil
+}
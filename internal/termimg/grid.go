@@ -0,0 +1,269 @@
+// Package termimg renders captured terminal contents (text with ANSI SGR
+// escapes, as produced by capture-pane -e) into raster images.
+package termimg
+
+import (
+	"image/color"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Cell is one character position of a terminal grid.
+type Cell struct {
+	Rune rune
+	FG   color.RGBA
+	BG   color.RGBA
+	Bold bool
+	// Underline draws a line along the bottom of the cell.
+	Underline bool
+}
+
+// Grid is a fixed-size terminal screen, indexed [row][col].
+type Grid struct {
+	Width  int
+	Height int
+	Cells  [][]Cell
+}
+
+// Default colors used for SGR 39/49 and for cells that were never written.
+var (
+	DefaultForeground = color.RGBA{R: 0xd0, G: 0xd0, B: 0xd0, A: 0xff}
+	DefaultBackground = color.RGBA{R: 0x00, G: 0x00, B: 0x00, A: 0xff}
+)
+
+type sgrState struct {
+	fg, bg    color.RGBA
+	fgIndex   int // palette index for bold brightening, -1 when not 0-7
+	bold      bool
+	underline bool
+	reverse   bool
+}
+
+func (s *sgrState) reset() {
+	*s = sgrState{fg: DefaultForeground, bg: DefaultBackground, fgIndex: -1}
+}
+
+func (s sgrState) cell(r rune) Cell {
+	fg, bg := s.fg, s.bg
+	if s.bold && s.fgIndex >= 0 && s.fgIndex < 8 {
+		fg = PaletteColor(s.fgIndex + 8)
+	}
+	if s.reverse {
+		fg, bg = bg, fg
+	}
+	return Cell{Rune: r, FG: fg, BG: bg, Bold: s.bold, Underline: s.underline}
+}
+
+// ParseANSI lays out content on a width x height grid. Lines are separated
+// by "\n"; text past the grid edges is clipped. SGR sequences set colors and
+// attributes, and other escape sequences are skipped.
+func ParseANSI(content string, width, height int) Grid {
+	if width < 0 {
+		width = 0
+	}
+	if height < 0 {
+		height = 0
+	}
+	var st sgrState
+	st.reset()
+	blank := st.cell(' ')
+
+	g := Grid{Width: width, Height: height, Cells: make([][]Cell, height)}
+	for y := range g.Cells {
+		row := make([]Cell, width)
+		for x := range row {
+			row[x] = blank
+		}
+		g.Cells[y] = row
+	}
+
+	x, y := 0, 0
+	for i := 0; i < len(content); {
+		c := content[i]
+		switch {
+		case c == '\x1b':
+			i = skipEscape(content, i, &st)
+			continue
+		case c == '\n':
+			x = 0
+			y++
+			i++
+			continue
+		case c == '\r':
+			x = 0
+			i++
+			continue
+		case c == '\t':
+			x = (x/8 + 1) * 8
+			i++
+			continue
+		case c < 0x20 || c == 0x7f:
+			i++
+			continue
+		}
+
+		r, size := utf8.DecodeRuneInString(content[i:])
+		i += size
+		if y < height && x < width {
+			g.Cells[y][x] = st.cell(r)
+		}
+		x++
+	}
+	return g
+}
+
+// skipEscape consumes the escape sequence starting at content[i] (an ESC)
+// and returns the index just past it, applying SGR sequences to st.
+func skipEscape(content string, i int, st *sgrState) int {
+	if i+1 >= len(content) {
+		return len(content)
+	}
+	switch content[i+1] {
+	case '[':
+		j := i + 2
+		for j < len(content) && (content[j] < 0x40 || content[j] > 0x7e) {
+			j++
+		}
+		if j >= len(content) {
+			return len(content)
+		}
+		if content[j] == 'm' {
+			applySGR(st, content[i+2:j])
+		}
+		return j + 1
+	case ']':
+		// OSC, terminated by BEL or ST.
+		for j := i + 2; j < len(content); j++ {
+			if content[j] == '\a' {
+				return j + 1
+			}
+			if content[j] == '\x1b' && j+1 < len(content) && content[j+1] == '\\' {
+				return j + 2
+			}
+		}
+		return len(content)
+	default:
+		return i + 2
+	}
+}
+
+func applySGR(st *sgrState, params string) {
+	if params == "" {
+		st.reset()
+		return
+	}
+	fields := strings.FieldsFunc(params, func(r rune) bool { return r == ';' || r == ':' })
+	codes := make([]int, 0, len(fields))
+	for _, f := range fields {
+		n, err := strconv.Atoi(f)
+		if err != nil {
+			n = 0
+		}
+		codes = append(codes, n)
+	}
+
+	for k := 0; k < len(codes); k++ {
+		code := codes[k]
+		switch {
+		case code == 0:
+			st.reset()
+		case code == 1:
+			st.bold = true
+		case code == 22:
+			st.bold = false
+		case code == 4:
+			st.underline = true
+		case code == 24:
+			st.underline = false
+		case code == 7:
+			st.reverse = true
+		case code == 27:
+			st.reverse = false
+		case code >= 30 && code <= 37:
+			st.fg, st.fgIndex = PaletteColor(code-30), code-30
+		case code == 39:
+			st.fg, st.fgIndex = DefaultForeground, -1
+		case code >= 40 && code <= 47:
+			st.bg = PaletteColor(code - 40)
+		case code == 49:
+			st.bg = DefaultBackground
+		case code >= 90 && code <= 97:
+			st.fg, st.fgIndex = PaletteColor(code-90+8), -1
+		case code >= 100 && code <= 107:
+			st.bg = PaletteColor(code - 100 + 8)
+		case code == 38 || code == 48:
+			c, used, ok := extendedColor(codes[k+1:])
+			k += used
+			if !ok {
+				continue
+			}
+			if code == 38 {
+				st.fg, st.fgIndex = c, -1
+			} else {
+				st.bg = c
+			}
+		}
+	}
+}
+
+// extendedColor decodes the arguments following SGR 38/48: "5;n" for a
+// palette index or "2;r;g;b" for truecolor. It returns how many codes were
+// consumed.
+func extendedColor(codes []int) (color.RGBA, int, bool) {
+	if len(codes) == 0 {
+		return color.RGBA{}, 0, false
+	}
+	switch codes[0] {
+	case 5:
+		if len(codes) < 2 {
+			return color.RGBA{}, len(codes), false
+		}
+		return PaletteColor(codes[1]), 2, true
+	case 2:
+		if len(codes) < 4 {
+			return color.RGBA{}, len(codes), false
+		}
+		return color.RGBA{R: clampByte(codes[1]), G: clampByte(codes[2]), B: clampByte(codes[3]), A: 0xff}, 4, true
+	}
+	return color.RGBA{}, 1, false
+}
+
+func clampByte(n int) uint8 {
+	if n < 0 {
+		return 0
+	}
+	if n > 0xff {
+		return 0xff
+	}
+	return uint8(n)
+}
+
+var basePalette = [16]color.RGBA{
+	{0x00, 0x00, 0x00, 0xff}, {0xcd, 0x00, 0x00, 0xff}, {0x00, 0xcd, 0x00, 0xff}, {0xcd, 0xcd, 0x00, 0xff},
+	{0x00, 0x00, 0xee, 0xff}, {0xcd, 0x00, 0xcd, 0xff}, {0x00, 0xcd, 0xcd, 0xff}, {0xe5, 0xe5, 0xe5, 0xff},
+	{0x7f, 0x7f, 0x7f, 0xff}, {0xff, 0x00, 0x00, 0xff}, {0x00, 0xff, 0x00, 0xff}, {0xff, 0xff, 0x00, 0xff},
+	{0x5c, 0x5c, 0xff, 0xff}, {0xff, 0x00, 0xff, 0xff}, {0x00, 0xff, 0xff, 0xff}, {0xff, 0xff, 0xff, 0xff},
+}
+
+// PaletteColor returns entry n of the xterm 256-color palette. Out-of-range
+// indexes map to the default foreground.
+func PaletteColor(n int) color.RGBA {
+	switch {
+	case n >= 0 && n < 16:
+		return basePalette[n]
+	case n >= 16 && n < 232:
+		n -= 16
+		level := func(v int) uint8 {
+			if v == 0 {
+				return 0
+			}
+			return uint8(55 + v*40)
+		}
+		return color.RGBA{R: level(n / 36), G: level(n / 6 % 6), B: level(n % 6), A: 0xff}
+	case n >= 232 && n < 256:
+		v := uint8(8 + (n-232)*10)
+		return color.RGBA{R: v, G: v, B: v, A: 0xff}
+	}
+	return DefaultForeground
+}
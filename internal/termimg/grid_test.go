@@ -0,0 +1,61 @@
+package termimg
+
+import (
+	"image/color"
+	"testing"
+)
+
+func TestParseANSIAppliesSGRColors(t *testing.T) {
+	g := ParseANSI("a\x1b[31mb\x1b[0mc\n\x1b[38;5;21;48;2;1;2;3mx", 4, 2)
+
+	if got := g.Cells[0][0]; got.Rune != 'a' || got.FG != DefaultForeground {
+		t.Fatalf("cell 0,0 = %#v", got)
+	}
+	if got := g.Cells[0][1]; got.Rune != 'b' || got.FG != PaletteColor(1) {
+		t.Fatalf("cell 0,1 = %#v", got)
+	}
+	if got := g.Cells[0][2]; got.Rune != 'c' || got.FG != DefaultForeground {
+		t.Fatalf("cell 0,2 = %#v", got)
+	}
+	want := color.RGBA{R: 1, G: 2, B: 3, A: 0xff}
+	if got := g.Cells[1][0]; got.Rune != 'x' || got.FG != PaletteColor(21) || got.BG != want {
+		t.Fatalf("cell 1,0 = %#v", got)
+	}
+	if got := g.Cells[1][3]; got.Rune != ' ' || got.BG != DefaultBackground {
+		t.Fatalf("unwritten cell = %#v", got)
+	}
+}
+
+func TestParseANSIClipsToGridAndSkipsOtherEscapes(t *testing.T) {
+	g := ParseANSI("\x1b]0;title\x07\x1b[2Jhello\nab\nc", 3, 2)
+
+	if g.Width != 3 || g.Height != 2 || len(g.Cells) != 2 || len(g.Cells[0]) != 3 {
+		t.Fatalf("unexpected grid shape: %dx%d", g.Width, g.Height)
+	}
+	if got := string([]rune{g.Cells[0][0].Rune, g.Cells[0][1].Rune, g.Cells[0][2].Rune}); got != "hel" {
+		t.Fatalf("row 0 = %q, want %q", got, "hel")
+	}
+	if got := g.Cells[1][1].Rune; got != 'b' {
+		t.Fatalf("row 1 col 1 = %q, want 'b'", got)
+	}
+}
+
+func TestParseANSIReverseAndBoldBrighten(t *testing.T) {
+	g := ParseANSI("\x1b[1;32mA\x1b[22;7mB", 2, 1)
+
+	if got := g.Cells[0][0]; !got.Bold || got.FG != PaletteColor(10) {
+		t.Fatalf("bold cell = %#v, want bright green", got)
+	}
+	if got := g.Cells[0][1]; got.FG != DefaultBackground || got.BG != PaletteColor(2) {
+		t.Fatalf("reverse cell = %#v", got)
+	}
+}
+
+func TestPaletteColorCubeAndGrayscale(t *testing.T) {
+	if got, want := PaletteColor(196), (color.RGBA{R: 0xff, A: 0xff}); got != want {
+		t.Fatalf("PaletteColor(196) = %#v, want %#v", got, want)
+	}
+	if got, want := PaletteColor(232), (color.RGBA{R: 8, G: 8, B: 8, A: 0xff}); got != want {
+		t.Fatalf("PaletteColor(232) = %#v, want %#v", got, want)
+	}
+}
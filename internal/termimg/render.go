@@ -0,0 +1,145 @@
+package termimg
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+)
+
+// MaxImageSide caps either dimension of a rendered image in pixels.
+const MaxImageSide = 8192
+
+// Options controls how a Grid is rasterized.
+type Options struct {
+	// Font draws glyphs; nil uses BuiltinFont.
+	Font *Font
+	// CellWidth and CellHeight are the pixel size of one terminal cell.
+	// Zero uses twice the font cell. Glyphs are scaled by the largest whole
+	// factor that fits and centered in the cell.
+	CellWidth  int
+	CellHeight int
+}
+
+// CellSize resolves the effective cell size for opts.
+func (o Options) CellSize() (int, int) {
+	f := o.font()
+	w, h := o.CellWidth, o.CellHeight
+	if w <= 0 {
+		w = f.Width * 2
+	}
+	if h <= 0 {
+		h = f.Height * 2
+	}
+	return w, h
+}
+
+func (o Options) font() *Font {
+	if o.Font != nil {
+		return o.Font
+	}
+	return builtin
+}
+
+var builtin = BuiltinFont()
+
+// Render draws g into an RGBA image of g.Width x g.Height cells. It fails
+// when the result would exceed MaxImageSide in either dimension.
+func Render(g Grid, opts Options) (*image.RGBA, error) {
+	cw, ch := opts.CellSize()
+	if g.Width <= 0 || g.Height <= 0 {
+		return nil, fmt.Errorf("grid must have positive width and height")
+	}
+	if g.Width*cw > MaxImageSide || g.Height*ch > MaxImageSide {
+		return nil, fmt.Errorf("image %dx%d exceeds %d pixels per side", g.Width*cw, g.Height*ch, MaxImageSide)
+	}
+
+	font := opts.font()
+	scale := min(cw/font.Width, ch/font.Height)
+	if scale < 1 {
+		scale = 1
+	}
+	offX := (cw - font.Width*scale) / 2
+	offY := (ch - font.Height*scale) / 2
+
+	img := image.NewRGBA(image.Rect(0, 0, g.Width*cw, g.Height*ch))
+	for y, row := range g.Cells {
+		for x, cell := range row {
+			x0, y0 := x*cw, y*ch
+			fillRect(img, x0, y0, cw, ch, cell.BG)
+			if !drawBoxGlyph(img, x0, y0, cw, ch, cell) {
+				drawGlyph(img, font.Glyph(cell.Rune), font, x0+offX, y0+offY, scale, cell)
+			}
+			if cell.Underline {
+				fillRect(img, x0, y0+ch-max(1, scale), cw, max(1, scale), cell.FG)
+			}
+		}
+	}
+	return img, nil
+}
+
+func drawGlyph(img *image.RGBA, mask []bool, f *Font, x0, y0, scale int, cell Cell) {
+	if mask == nil || cell.Rune == ' ' {
+		return
+	}
+	for gy := 0; gy < f.Height; gy++ {
+		for gx := 0; gx < f.Width; gx++ {
+			if !mask[gy*f.Width+gx] {
+				continue
+			}
+			w := scale
+			// Bold is approximated by widening each stroke one pixel.
+			if cell.Bold {
+				w++
+			}
+			fillRect(img, x0+gx*scale, y0+gy*scale, w, scale, cell.FG)
+		}
+	}
+}
+
+// boxSegments maps light box-drawing characters to the cell edges they
+// connect: left, right, up, down. Drawing them as lines keeps pane borders
+// continuous regardless of the font.
+var boxSegments = map[rune][4]bool{
+	'─': {true, true, false, false},
+	'│': {false, false, true, true},
+	'┌': {false, true, false, true},
+	'┐': {true, false, false, true},
+	'└': {false, true, true, false},
+	'┘': {true, false, true, false},
+	'├': {false, true, true, true},
+	'┤': {true, false, true, true},
+	'┬': {true, true, false, true},
+	'┴': {true, true, true, false},
+	'┼': {true, true, true, true},
+}
+
+func drawBoxGlyph(img *image.RGBA, x0, y0, cw, ch int, cell Cell) bool {
+	seg, ok := boxSegments[cell.Rune]
+	if !ok {
+		return false
+	}
+	t := max(1, min(cw, ch)/8)
+	cx, cy := x0+(cw-t)/2, y0+(ch-t)/2
+	if seg[0] {
+		fillRect(img, x0, cy, cx-x0+t, t, cell.FG)
+	}
+	if seg[1] {
+		fillRect(img, cx, cy, x0+cw-cx, t, cell.FG)
+	}
+	if seg[2] {
+		fillRect(img, cx, y0, t, cy-y0+t, cell.FG)
+	}
+	if seg[3] {
+		fillRect(img, cx, cy, t, y0+ch-cy, cell.FG)
+	}
+	return true
+}
+
+func fillRect(img *image.RGBA, x, y, w, h int, c color.RGBA) {
+	r := image.Rect(x, y, x+w, y+h).Intersect(img.Rect)
+	for py := r.Min.Y; py < r.Max.Y; py++ {
+		for px := r.Min.X; px < r.Max.X; px++ {
+			img.SetRGBA(px, py, c)
+		}
+	}
+}
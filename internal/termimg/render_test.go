@@ -0,0 +1,89 @@
+package termimg
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRenderSizesImageFromGridAndCell(t *testing.T) {
+	g := ParseANSI("\x1b[41mX", 3, 2)
+	img, err := Render(g, Options{CellWidth: 10, CellHeight: 20})
+	if err != nil {
+		t.Fatalf("Render: %v", err)
+	}
+	if b := img.Bounds(); b.Dx() != 30 || b.Dy() != 40 {
+		t.Fatalf("bounds = %v, want 30x40", b)
+	}
+	if got := img.RGBAAt(0, 0); got != PaletteColor(1) {
+		t.Fatalf("background pixel = %#v, want red", got)
+	}
+	if got := img.RGBAAt(15, 5); got != DefaultBackground {
+		t.Fatalf("second cell pixel = %#v, want default background", got)
+	}
+
+	fg := 0
+	for y := 0; y < 20; y++ {
+		for x := 0; x < 10; x++ {
+			if img.RGBAAt(x, y) == DefaultForeground {
+				fg++
+			}
+		}
+	}
+	if fg == 0 {
+		t.Fatalf("expected glyph pixels in first cell")
+	}
+}
+
+func TestRenderRejectsOversizedImage(t *testing.T) {
+	g := ParseANSI("", 1000, 10)
+	if _, err := Render(g, Options{CellWidth: 16, CellHeight: 16}); err == nil {
+		t.Fatalf("expected error for image wider than %d", MaxImageSide)
+	}
+}
+
+const testBDF = `STARTFONT 2.1
+FONT test
+SIZE 4 75 75
+FONTBOUNDINGBOX 4 4 0 -1
+CHARS 1
+STARTCHAR A
+ENCODING 65
+BBX 2 2 1 0
+BITMAP
+C0
+40
+ENDCHAR
+ENDFONT
+`
+
+func TestParseBDFPlacesGlyphInFontCell(t *testing.T) {
+	f, err := ParseBDF(strings.NewReader(testBDF))
+	if err != nil {
+		t.Fatalf("ParseBDF: %v", err)
+	}
+	if f.Width != 4 || f.Height != 4 {
+		t.Fatalf("cell = %dx%d, want 4x4", f.Width, f.Height)
+	}
+	// Baseline is one row above the bottom, so the 2-row glyph fills rows 1-2.
+	want := []bool{
+		false, false, false, false,
+		false, true, true, false,
+		false, false, true, false,
+		false, false, false, false,
+	}
+	got := f.Glyph('A')
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("glyph mask = %v, want %v", got, want)
+		}
+	}
+	if f.Glyph('Z') != nil {
+		t.Fatalf("expected nil mask for missing glyph without '?' fallback")
+	}
+}
+
+func TestParseBDFRejectsEmptyFont(t *testing.T) {
+	if _, err := ParseBDF(strings.NewReader("STARTFONT 2.1\nENDFONT\n")); err == nil {
+		t.Fatalf("expected error for font without glyphs")
+	}
+}
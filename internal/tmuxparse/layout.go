@@ -0,0 +1,125 @@
+package tmuxparse
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// LayoutPane is the geometry of one leaf pane in a tmux window layout.
+type LayoutPane struct {
+	PaneID string
+	Left   int
+	Top    int
+	Width  int
+	Height int
+}
+
+// ParseLayout parses a tmux window layout string such as
+// "c7b4,159x48,0,0{79x48,0,0,1,79x48,80,0,2}" and returns its leaf panes in
+// layout order. Pane ids are returned with the "%" prefix.
+func ParseLayout(layout string) ([]LayoutPane, error) {
+	_, body, ok := strings.Cut(strings.TrimSpace(layout), ",")
+	if !ok {
+		return nil, fmt.Errorf("layout missing checksum")
+	}
+	p := layoutParser{s: body}
+	var panes []LayoutPane
+	if err := p.cell(&panes); err != nil {
+		return nil, err
+	}
+	if p.i != len(p.s) {
+		return nil, fmt.Errorf("unexpected trailing layout data at offset %d", p.i)
+	}
+	return panes, nil
+}
+
+type layoutParser struct {
+	s string
+	i int
+}
+
+func (p *layoutParser) cell(panes *[]LayoutPane) error {
+	width, err := p.number()
+	if err != nil {
+		return err
+	}
+	if err := p.expect('x'); err != nil {
+		return err
+	}
+	height, err := p.number()
+	if err != nil {
+		return err
+	}
+	if err := p.expect(','); err != nil {
+		return err
+	}
+	left, err := p.number()
+	if err != nil {
+		return err
+	}
+	if err := p.expect(','); err != nil {
+		return err
+	}
+	top, err := p.number()
+	if err != nil {
+		return err
+	}
+
+	if p.i >= len(p.s) {
+		return fmt.Errorf("layout cell missing pane id or children")
+	}
+	switch p.s[p.i] {
+	case ',':
+		p.i++
+		id, err := p.number()
+		if err != nil {
+			return err
+		}
+		*panes = append(*panes, LayoutPane{
+			PaneID: "%" + strconv.Itoa(id),
+			Left:   left,
+			Top:    top,
+			Width:  width,
+			Height: height,
+		})
+		return nil
+	case '{', '[':
+		closing := byte('}')
+		if p.s[p.i] == '[' {
+			closing = ']'
+		}
+		p.i++
+		for {
+			if err := p.cell(panes); err != nil {
+				return err
+			}
+			if p.i < len(p.s) && p.s[p.i] == ',' {
+				p.i++
+				continue
+			}
+			return p.expect(closing)
+		}
+	default:
+		return fmt.Errorf("unexpected %q in layout at offset %d", p.s[p.i], p.i)
+	}
+}
+
+func (p *layoutParser) number() (int, error) {
+	start := p.i
+	for p.i < len(p.s) && p.s[p.i] >= '0' && p.s[p.i] <= '9' {
+		p.i++
+	}
+	if start == p.i {
+		return 0, fmt.Errorf("expected number in layout at offset %d", start)
+	}
+	return strconv.Atoi(p.s[start:p.i])
+}
+
+func (p *layoutParser) expect(ch byte) error {
+	if p.i >= len(p.s) || p.s[p.i] != ch {
+		return fmt.Errorf("expected %q in layout at offset %d", ch, p.i)
+	}
+	p.i++
+	return nil
+}
@@ -0,0 +1,40 @@
+package tmuxparse
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseLayoutSinglePane(t *testing.T) {
+	got, err := ParseLayout("b25f,80x24,0,0,3")
+	if err != nil {
+		t.Fatalf("ParseLayout: %v", err)
+	}
+	want := []LayoutPane{{PaneID: "%3", Width: 80, Height: 24}}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("layout mismatch: got=%+v want=%+v", got, want)
+	}
+}
+
+func TestParseLayoutNestedSplits(t *testing.T) {
+	got, err := ParseLayout("c7b4,159x48,0,0{79x48,0,0,1,79x48,80,0[79x24,80,0,2,79x23,80,25,5]}")
+	if err != nil {
+		t.Fatalf("ParseLayout: %v", err)
+	}
+	want := []LayoutPane{
+		{PaneID: "%1", Left: 0, Top: 0, Width: 79, Height: 48},
+		{PaneID: "%2", Left: 80, Top: 0, Width: 79, Height: 24},
+		{PaneID: "%5", Left: 80, Top: 25, Width: 79, Height: 23},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("layout mismatch: got=%+v want=%+v", got, want)
+	}
+}
+
+func TestParseLayoutRejectsMalformed(t *testing.T) {
+	for _, layout := range []string{"", "abcd", "abcd,80x24,0,0", "abcd,80x24,0,0{80x24,0,0,1", "abcd,80x24,0,0,1junk"} {
+		if _, err := ParseLayout(layout); err == nil {
+			t.Fatalf("expected error for %q", layout)
+		}
+	}
+}
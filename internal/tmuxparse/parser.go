@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"strconv"
 	"strings"
+	"time"
 )
 
 type Callbacks struct {
@@ -39,6 +40,12 @@ func (p *Parser) FeedLine(line string) {
 			p.emitError(ParseError{Line: line, Message: "malformed control boundary"})
 			return
 		}
+		if n, ok := parseInterleavedNotification(line); ok {
+			if p.cb.OnNotification != nil {
+				p.cb.OnNotification(n)
+			}
+			return
+		}
 		if p.cb.OnCommandLine != nil {
 			p.cb.OnCommandLine(*p.current, line)
 		}
@@ -170,6 +177,54 @@ func parseHeader(raw string) (BlockHeader, error) {
 	return BlockHeader{EpochSeconds: epoch, CommandID: commandID, Flags: flags}, nil
 }
 
+// asyncNotifications lists notification names tmux may interleave with an
+// open command block. Other %-prefixed lines inside a block (for example a
+// "%14" pane id printed by split-window -P) remain command output.
+var asyncNotifications = map[string]struct{}{
+	"output":                  {},
+	"extended-output":         {},
+	"layout-change":           {},
+	"window-add":              {},
+	"window-close":            {},
+	"window-renamed":          {},
+	"window-pane-changed":     {},
+	"unlinked-window-add":     {},
+	"unlinked-window-close":   {},
+	"unlinked-window-renamed": {},
+	"session-changed":         {},
+	"session-renamed":         {},
+	"sessions-changed":        {},
+	"session-window-changed":  {},
+	"client-session-changed":  {},
+	"client-detached":         {},
+	"pane-mode-changed":       {},
+	"paste-buffer-changed":    {},
+	"paste-buffer-deleted":    {},
+	"subscription-changed":    {},
+	"continue":                {},
+	"pause":                   {},
+	"message":                 {},
+	"config-error":            {},
+	"exit":                    {},
+}
+
+// parseInterleavedNotification reports whether a line seen inside a command
+// block is a well-formed async notification rather than command output.
+func parseInterleavedNotification(line string) (Notification, bool) {
+	if !strings.HasPrefix(line, "%") {
+		return Notification{}, false
+	}
+	name, _ := splitNameAndRest(strings.TrimPrefix(line, "%"))
+	if _, ok := asyncNotifications[name]; !ok {
+		return Notification{}, false
+	}
+	n, err := parseNotification(line)
+	if err != nil {
+		return Notification{}, false
+	}
+	return n, true
+}
+
 func parseNotification(line string) (Notification, error) {
 	if line == "" || line[0] != '%' {
 		return Notification{}, fmt.Errorf("not a notification")
@@ -192,8 +247,17 @@ func parseNotification(line string) (Notification, error) {
 		if len(fields) < 2 {
 			return Notification{}, fmt.Errorf("extended-output missing required fields")
 		}
+		ageMS, err := strconv.ParseInt(fields[1], 10, 64)
+		if err != nil || ageMS < 0 {
+			return Notification{}, fmt.Errorf("extended-output invalid age %q", fields[1])
+		}
 		n.Args = fields
 		n.Value = value
+		n.Extended = &ExtendedOutput{
+			PaneID: fields[0],
+			Age:    time.Duration(ageMS) * time.Millisecond,
+			Flags:  append([]string(nil), fields[2:]...),
+		}
 	case "subscription-changed":
 		base, value := splitByColon(rest)
 		fields := strings.Fields(base)
@@ -220,6 +284,12 @@ func parseNotification(line string) (Notification, error) {
 		}
 		n.Args = []string{a}
 		n.Text = tail
+	case "layout-change":
+		fields := strings.Fields(rest)
+		if len(fields) < 2 {
+			return Notification{}, fmt.Errorf("layout-change missing required fields")
+		}
+		n.Args = fields
 	case "window-renamed":
 		a, tail := splitOnce(strings.TrimSpace(rest), ' ')
 		if a == "" {
@@ -227,6 +297,12 @@ func parseNotification(line string) (Notification, error) {
 		}
 		n.Args = []string{a}
 		n.Text = tail
+	case "window-add", "window-close", "unlinked-window-add", "unlinked-window-close":
+		fields := strings.Fields(rest)
+		if len(fields) < 1 {
+			return Notification{}, fmt.Errorf("%s missing window id", name)
+		}
+		n.Args = fields[:1]
 	default:
 		n.Args = strings.Fields(rest)
 	}
@@ -3,6 +3,7 @@ package tmuxparse
 import (
 	"reflect"
 	"testing"
+	"time"
 )
 
 func TestParserCommandBlockAndNotification(t *testing.T) {
@@ -56,6 +57,39 @@ func TestParserCommandBlockAndNotification(t *testing.T) {
 	}
 }
 
+func TestParserRoutesOutputInsideCommandBlockToNotification(t *testing.T) {
+	var (
+		lines []string
+		notes []Notification
+		ends  int
+		errs  []ParseError
+	)
+	p := NewParser(Callbacks{
+		OnCommandLine:  func(_ BlockHeader, line string) { lines = append(lines, line) },
+		OnCommandEnd:   func(BlockHeader, BlockHeader, bool) { ends++ },
+		OnNotification: func(n Notification) { notes = append(notes, n) },
+		OnError:        func(err ParseError) { errs = append(errs, err) },
+	})
+
+	p.FeedLine("%begin 1363006971 3 1")
+	p.FeedLine("%14")
+	p.FeedLine("%output %1 hello")
+	p.FeedLine("%end 1363006971 3 1")
+
+	if len(errs) != 0 {
+		t.Fatalf("unexpected parse errors: %+v", errs)
+	}
+	if got, want := lines, []string{"%14"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("command lines = %v, want %v", got, want)
+	}
+	if len(notes) != 1 || notes[0].Name != "output" || notes[0].Args[0] != "%1" || notes[0].Value != "hello" {
+		t.Fatalf("unexpected notifications: %#v", notes)
+	}
+	if ends != 1 {
+		t.Fatalf("ends = %d, want 1", ends)
+	}
+}
+
 func TestParserExtendedOutputAndSubscription(t *testing.T) {
 	var notes []Notification
 	p := NewParser(Callbacks{OnNotification: func(n Notification) { notes = append(notes, n) }})
@@ -75,6 +109,16 @@ func TestParserExtendedOutputAndSubscription(t *testing.T) {
 	if got, want := notes[0].Value, "hello world"; got != want {
 		t.Fatalf("unexpected extended-output value: %q", got)
 	}
+	ext := notes[0].Extended
+	if ext == nil {
+		t.Fatalf("extended-output missing typed fields")
+	}
+	if ext.PaneID != "%1" || ext.Age != 12*time.Millisecond || !reflect.DeepEqual(ext.Flags, []string{"foo", "bar"}) {
+		t.Fatalf("unexpected extended-output fields: %+v", *ext)
+	}
+	if notes[1].Extended != nil {
+		t.Fatalf("subscription-changed should not carry extended-output fields")
+	}
 	if got, want := notes[1].Name, "subscription-changed"; got != want {
 		t.Fatalf("unexpected second notification name: %s", got)
 	}
@@ -83,6 +127,21 @@ func TestParserExtendedOutputAndSubscription(t *testing.T) {
 	}
 }
 
+func TestParserRejectsExtendedOutputWithInvalidAge(t *testing.T) {
+	var notes []Notification
+	var errs []ParseError
+	p := NewParser(Callbacks{
+		OnNotification: func(n Notification) { notes = append(notes, n) },
+		OnError:        func(err ParseError) { errs = append(errs, err) },
+	})
+
+	p.FeedLine("%extended-output %1 soon : hello")
+
+	if len(notes) != 0 || len(errs) != 1 {
+		t.Fatalf("notes = %d, errors = %d; want 0 and 1", len(notes), len(errs))
+	}
+}
+
 func TestParserOutputPreservesLeadingSpaces(t *testing.T) {
 	var notes []Notification
 	p := NewParser(Callbacks{OnNotification: func(n Notification) { notes = append(notes, n) }})
@@ -109,3 +168,40 @@ func TestParserEndWithoutBeginIsError(t *testing.T) {
 		t.Fatalf("expected one error, got %d", len(errs))
 	}
 }
+
+func TestParserLayoutChange(t *testing.T) {
+	var notes []Notification
+	var errs []ParseError
+	p := NewParser(Callbacks{
+		OnNotification: func(n Notification) { notes = append(notes, n) },
+		OnError:        func(err ParseError) { errs = append(errs, err) },
+	})
+
+	p.FeedLine("%layout-change @2 c7b4,159x48,0,0{79x48,0,0,1,79x48,80,0,2} c7b4,159x48,0,0{79x48,0,0,1,79x48,80,0,2} *")
+	p.FeedLine("%layout-change @2")
+
+	if len(notes) != 1 {
+		t.Fatalf("expected one notification, got %#v", notes)
+	}
+	if got, want := notes[0].Args[:2], []string{"@2", "c7b4,159x48,0,0{79x48,0,0,1,79x48,80,0,2}"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("layout-change args mismatch: got=%v want=%v", got, want)
+	}
+	if len(errs) != 1 {
+		t.Fatalf("expected one parse error for missing layout, got %#v", errs)
+	}
+}
+
+func TestParseNotificationWindowAddAndClose(t *testing.T) {
+	for _, name := range []string{"window-add", "window-close", "unlinked-window-add", "unlinked-window-close"} {
+		n, err := parseNotification("%" + name + " @9")
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if n.Name != name || !reflect.DeepEqual(n.Args, []string{"@9"}) {
+			t.Fatalf("%s: unexpected notification %#v", name, n)
+		}
+		if _, err := parseNotification("%" + name); err == nil {
+			t.Fatalf("%s: expected error for missing window id", name)
+		}
+	}
+}
@@ -1,6 +1,9 @@
 package tmuxparse
 
-import "sync"
+import (
+	"fmt"
+	"sync"
+)
 
 // StreamParser is the high-level control-mode parser API. It exposes parsed
 // Command, Notification, and ParseError structs over a single event channel.
@@ -60,7 +63,14 @@ func (s *StreamParser) Close() {
 	s.mu.Unlock()
 }
 
+// onCommandBegin starts a command. Parser reports a well-formed %begin
+// inside a block as an output line, so a begin with a command still open
+// means the callbacks went out of step; the stale command is reported and
+// dropped rather than silently overwritten.
 func (s *StreamParser) onCommandBegin(h BlockHeader) {
+	if s.current != nil {
+		s.emit(ParseError{Message: fmt.Sprintf("command begin while command %d is still open; discarding its partial output", s.current.Header.CommandID)})
+	}
 	s.current = &Command{Header: h}
 }
 
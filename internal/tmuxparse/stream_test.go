@@ -45,3 +45,45 @@ func TestStreamParserEvents(t *testing.T) {
 		t.Fatalf("expected one parse error, got %d (%#v)", len(parseErrors), parseErrors)
 	}
 }
+
+func TestStreamParserDiscardsCommandOnNestedBegin(t *testing.T) {
+	sp := NewStreamParser(8)
+
+	// Parser never reports a nested begin, so drive the callbacks directly.
+	sp.onCommandBegin(BlockHeader{EpochSeconds: 1, CommandID: 5})
+	sp.onCommandLine(BlockHeader{}, "stale")
+	sp.onCommandBegin(BlockHeader{EpochSeconds: 1, CommandID: 6})
+	sp.onCommandLine(BlockHeader{}, "fresh")
+	sp.onCommandEnd(BlockHeader{EpochSeconds: 1, CommandID: 6}, BlockHeader{EpochSeconds: 1, CommandID: 6}, true)
+
+	sp.FeedLine("%begin 2 7 0")
+	sp.FeedLine("clean")
+	sp.FeedLine("%end 2 7 0")
+	sp.Close()
+
+	var (
+		commands    []Command
+		parseErrors []ParseError
+	)
+	for ev := range sp.Events() {
+		switch x := ev.(type) {
+		case Command:
+			commands = append(commands, x)
+		case ParseError:
+			parseErrors = append(parseErrors, x)
+		}
+	}
+
+	if len(parseErrors) != 1 {
+		t.Fatalf("expected one parse error, got %#v", parseErrors)
+	}
+	if len(commands) != 2 {
+		t.Fatalf("expected two commands, got %#v", commands)
+	}
+	if commands[0].Header.CommandID != 6 || len(commands[0].Output) != 1 || commands[0].Output[0] != "fresh" {
+		t.Fatalf("nested command = %#v, want only its own output", commands[0])
+	}
+	if commands[1].Header.CommandID != 7 || len(commands[1].Output) != 1 || commands[1].Output[0] != "clean" {
+		t.Fatalf("next command = %#v, want clean block", commands[1])
+	}
+}
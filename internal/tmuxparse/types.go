@@ -1,5 +1,7 @@
 package tmuxparse
 
+import "time"
+
 // BlockHeader is the three-field header used by %begin/%end/%error lines.
 type BlockHeader struct {
 	EpochSeconds int64
@@ -24,10 +26,23 @@ type Notification struct {
 	Args  []string
 	Text  string
 	Value string
+	// Extended is set for %extended-output notifications only.
+	Extended *ExtendedOutput
 }
 
 func (Notification) streamEvent() {}
 
+// ExtendedOutput holds the typed header fields of an %extended-output
+// notification: "%extended-output %pane-id age ... : value".
+type ExtendedOutput struct {
+	PaneID string
+	// Age is how long tmux held the output before sending it.
+	Age time.Duration
+	// Flags are the fields between the age and the colon, reserved by tmux
+	// for future use.
+	Flags []string
+}
+
 // ParseError describes a malformed control-mode line or invalid state
 // transition encountered while parsing.
 type ParseError struct {
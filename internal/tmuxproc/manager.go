@@ -3,9 +3,13 @@ package tmuxproc
 import (
 	"bufio"
 	"context"
+	"errors"
 	"fmt"
 	"io"
-	"log"
+	"io/fs"
+	"log/slog"
+	"math/rand/v2"
+	"os"
 	"os/exec"
 	"strings"
 	"sync"
@@ -17,6 +21,10 @@ import (
 type SocketTarget struct {
 	Name string
 	Path string
+	// ConfigFile is passed to every tmux invocation as -f. tmux only reads
+	// it when that invocation starts the server (for example the
+	// new-session in EnsureSession).
+	ConfigFile string
 }
 
 func (s SocketTarget) Validate() error {
@@ -49,15 +57,41 @@ type Config struct {
 	OnStderrLine      func(string)
 	OnConnected       func()
 	OnDisconnect      func(error)
+	// OnReconnecting is called before each backoff wait with the attempt
+	// number (1 for the first retry since the last connection or startup)
+	// and the time the next attempt starts.
+	OnReconnecting func(attempt int, nextRetry time.Time)
+	// InitialCommand is the shell command run in the first pane when the
+	// target session is created. Empty runs the default shell.
+	InitialCommand string
+	// InitialWindows names extra windows opened with new-window after the
+	// target session is created.
+	InitialWindows []string
+	// MaxLineBytes caps one control-mode stdout line. Longer lines are
+	// dropped with a warning instead of ending the connection. 0 uses
+	// DefaultMaxLineBytes.
+	MaxLineBytes int
+	// Logger receives lifecycle events. Defaults to slog.Default().
+	Logger *slog.Logger
 }
 
+// DefaultMaxLineBytes is used when Config.MaxLineBytes is unset.
+const DefaultMaxLineBytes = 64 << 20
+
 type Manager struct {
 	cfg Config
+	// int64n returns a uniform value in [0, n); replaced in tests.
+	int64n func(n int64) int64
+	// starter runs tmux; replaced in tests by a scripted fake.
+	starter processStarter
 
 	mu      sync.Mutex
 	stdin   io.WriteCloser
 	running bool
 	lastErr error
+	// connectedAt is when the current control client started; zero while
+	// disconnected.
+	connectedAt time.Time
 }
 
 func NewManager(cfg Config) *Manager {
@@ -67,11 +101,83 @@ func NewManager(cfg Config) *Manager {
 	if cfg.BackoffMax < cfg.BackoffBase {
 		cfg.BackoffMax = 10 * time.Second
 	}
-	return &Manager{cfg: cfg}
+	if cfg.MaxLineBytes <= 0 {
+		cfg.MaxLineBytes = DefaultMaxLineBytes
+	}
+	if cfg.Logger == nil {
+		cfg.Logger = slog.Default()
+	}
+	return &Manager{cfg: cfg, int64n: rand.Int64N, starter: execStarter{tmuxBin: cfg.TmuxBin}}
+}
+
+// processStarter runs the tmux invocations Manager.Run makes. args already
+// carry the socket and config flags.
+type processStarter interface {
+	// Start runs the long-lived control client on a terminal.
+	Start(ctx context.Context, args []string) (tmuxProcess, error)
+	// CombinedOutput runs a short-lived command such as has-session.
+	CombinedOutput(ctx context.Context, args []string) ([]byte, error)
+}
+
+// tmuxProcess is a running control client: reads return its output,
+// writes go to its input, and Wait returns once it has exited. Close
+// releases the terminal, which also ends pending reads.
+type tmuxProcess interface {
+	io.ReadWriteCloser
+	Wait() error
+}
+
+// execStarter runs the tmux binary, attaching the control client to a PTY.
+type execStarter struct {
+	tmuxBin string
+}
+
+func (s execStarter) Start(ctx context.Context, args []string) (tmuxProcess, error) {
+	cmd := exec.CommandContext(ctx, s.tmuxBin, args...)
+	ptmx, err := pty.Start(cmd)
+	if err != nil {
+		return nil, err
+	}
+	return ptyProcess{File: ptmx, cmd: cmd}, nil
+}
+
+func (s execStarter) CombinedOutput(ctx context.Context, args []string) ([]byte, error) {
+	return exec.CommandContext(ctx, s.tmuxBin, args...).CombinedOutput()
+}
+
+type ptyProcess struct {
+	*os.File
+	cmd *exec.Cmd
+}
+
+func (p ptyProcess) Wait() error { return p.cmd.Wait() }
+
+// jitteredBackoff picks a full-jitter delay in [base, ceiling] so instances
+// restarting against the same tmux server spread their reconnects.
+func jitteredBackoff(base, ceiling time.Duration, int64n func(int64) int64) time.Duration {
+	if ceiling <= base {
+		return base
+	}
+	return base + time.Duration(int64n(int64(ceiling-base)+1))
+}
+
+// nextBackoffCeiling doubles ceiling, capped at max.
+func nextBackoffCeiling(ceiling, max time.Duration) time.Duration {
+	if ceiling >= max {
+		return max
+	}
+	ceiling *= 2
+	if ceiling > max {
+		ceiling = max
+	}
+	return ceiling
 }
 
 func buildTmuxArgs(socket SocketTarget, argv ...string) []string {
 	args := socket.Args()
+	if conf := strings.TrimSpace(socket.ConfigFile); conf != "" {
+		args = append([]string{"-f", conf}, args...)
+	}
 	if len(args) == 0 {
 		return append([]string(nil), argv...)
 	}
@@ -85,46 +191,111 @@ func command(tmuxBin string, socket SocketTarget, argv ...string) *exec.Cmd {
 	return exec.Command(tmuxBin, buildTmuxArgs(socket, argv...)...)
 }
 
-func commandContext(ctx context.Context, tmuxBin string, socket SocketTarget, argv ...string) *exec.Cmd {
-	return exec.CommandContext(ctx, tmuxBin, buildTmuxArgs(socket, argv...)...)
+// missingBinaryError turns exec's opaque "executable file not found" into an
+// actionable message. Other errors are returned unchanged.
+func missingBinaryError(tmuxBin string, err error) error {
+	if errors.Is(err, exec.ErrNotFound) {
+		return fmt.Errorf("tmux binary %q not found on PATH; install tmux or set --tmux-bin", tmuxBin)
+	}
+	if errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("tmux binary %q does not exist; install tmux or set --tmux-bin", tmuxBin)
+	}
+	return err
 }
 
 func CheckTmux(tmuxBin string, socket SocketTarget) error {
 	cmd := command(tmuxBin, socket, "-V")
 	out, err := cmd.CombinedOutput()
 	if err != nil {
+		if missing := missingBinaryError(tmuxBin, err); missing != err {
+			return missing
+		}
 		return fmt.Errorf("tmux sanity check failed: %w (%s)", err, string(out))
 	}
 	return nil
 }
 
-func EnsureSession(tmuxBin string, socket SocketTarget, name string) error {
-	check := command(tmuxBin, socket, "has-session", "-t", name)
-	if err := check.Run(); err == nil {
+func SessionExists(tmuxBin string, socket SocketTarget, name string) bool {
+	return command(tmuxBin, socket, "has-session", "-t", name).Run() == nil
+}
+
+// EnsureSession creates session name unless it already exists. A non-empty
+// initialCmd is passed to new-session as the first pane's shell command, and
+// each of windows is then opened with new-window, in order, behind the first
+// window. Both are ignored when the session already exists.
+//
+// Another process may create the session between has-session and
+// new-session, in which case new-session fails with "duplicate session".
+// That failure is tolerated when has-session then finds the session; the
+// windows are left to whoever created it.
+func EnsureSession(tmuxBin string, socket SocketTarget, name, initialCmd string, windows []string) error {
+	return ensureSession(context.Background(), execStarter{tmuxBin: tmuxBin}, tmuxBin, socket, name, initialCmd, windows)
+}
+
+// ensureSession is EnsureSession running each tmux invocation through
+// starter, so ctx bounds them.
+func ensureSession(ctx context.Context, starter processStarter, tmuxBin string, socket SocketTarget, name, initialCmd string, windows []string) error {
+	exists := func() bool {
+		_, err := starter.CombinedOutput(ctx, buildTmuxArgs(socket, "has-session", "-t", name))
+		return err == nil
+	}
+	if exists() {
 		return nil
 	}
-	create := command(tmuxBin, socket, "new-session", "-d", "-s", name)
-	if out, err := create.CombinedOutput(); err != nil {
+	args := []string{"new-session", "-d", "-s", name}
+	if initialCmd != "" {
+		args = append(args, initialCmd)
+	}
+	if out, err := starter.CombinedOutput(ctx, buildTmuxArgs(socket, args...)); err != nil {
+		if missing := missingBinaryError(tmuxBin, err); missing != err {
+			return missing
+		}
+		if strings.Contains(string(out), "duplicate session") && exists() {
+			return nil
+		}
 		return fmt.Errorf("create session %q: %w (%s)", name, err, string(out))
 	}
+	for _, window := range windows {
+		if out, err := starter.CombinedOutput(ctx, buildTmuxArgs(socket, "new-window", "-d", "-t", name+":", "-n", window)); err != nil {
+			return fmt.Errorf("create window %q in session %q: %w (%s)", window, name, err, string(out))
+		}
+	}
 	return nil
 }
 
+// ConnectedSince returns when the current tmux control connection was
+// established. It reports false while disconnected.
+func (m *Manager) ConnectedSince() (time.Time, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.connectedAt, !m.connectedAt.IsZero()
+}
+
+// ErrNotReady is wrapped by Send errors when the line did not reach tmux:
+// the control connection is not up yet, is being re-established, or closed
+// while the line was being written. No response will follow.
+var ErrNotReady = errors.New("tmux control mode not ready")
+
+// Send writes one command line to the control connection. Its errors wrap
+// ErrNotReady.
 func (m *Manager) Send(line string) error {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 	if !m.running || m.stdin == nil {
 		if m.lastErr != nil {
-			return fmt.Errorf("tmux unavailable: %w", m.lastErr)
+			return fmt.Errorf("%w: tmux unavailable: %w", ErrNotReady, m.lastErr)
 		}
-		return fmt.Errorf("tmux control mode not ready")
+		return ErrNotReady
 	}
-	_, err := io.WriteString(m.stdin, line+"\n")
-	return err
+	if _, err := io.WriteString(m.stdin, line+"\n"); err != nil {
+		return fmt.Errorf("%w: write: %w", ErrNotReady, err)
+	}
+	return nil
 }
 
 func (m *Manager) Run(ctx context.Context) {
-	backoff := m.cfg.BackoffBase
+	ceiling := m.cfg.BackoffBase
+	attempt := 0
 	for {
 		if ctx.Err() != nil {
 			return
@@ -134,19 +305,17 @@ func (m *Manager) Run(ctx context.Context) {
 			if ctx.Err() != nil {
 				return
 			}
-			log.Printf("tmux target unavailable: %v", err)
+			delay := jitteredBackoff(m.cfg.BackoffBase, ceiling, m.int64n)
+			m.cfg.Logger.Warn("tmux target unavailable", "session", m.cfg.TargetSession, "err", err, "retry_in", delay)
 			m.markDisconnected(err)
+			attempt++
+			m.reportReconnecting(attempt, delay)
 			select {
 			case <-ctx.Done():
 				return
-			case <-time.After(backoff):
-			}
-			if backoff < m.cfg.BackoffMax {
-				backoff *= 2
-				if backoff > m.cfg.BackoffMax {
-					backoff = m.cfg.BackoffMax
-				}
+			case <-time.After(delay):
 			}
+			ceiling = nextBackoffCeiling(ceiling, m.cfg.BackoffMax)
 			continue
 		}
 
@@ -154,35 +323,38 @@ func (m *Manager) Run(ctx context.Context) {
 		if ctx.Err() != nil {
 			return
 		}
-		log.Printf("tmux control client exited: %v", err)
+		// A client that ran resets the backoff, but the first delay is still
+		// drawn over [base, 2*base]: when a shared tmux server goes away,
+		// every instance sees the exit at the same moment.
+		ceiling = nextBackoffCeiling(m.cfg.BackoffBase, m.cfg.BackoffMax)
+		delay := jitteredBackoff(m.cfg.BackoffBase, ceiling, m.int64n)
+		m.cfg.Logger.Warn("tmux control client exited; restarting", "err", err, "retry_in", delay)
 		m.markDisconnected(err)
-		backoff = m.cfg.BackoffBase
+		attempt = 1
+		m.reportReconnecting(attempt, delay)
 
 		select {
 		case <-ctx.Done():
 			return
-		case <-time.After(backoff):
-		}
-		if backoff < m.cfg.BackoffMax {
-			backoff *= 2
-			if backoff > m.cfg.BackoffMax {
-				backoff = m.cfg.BackoffMax
-			}
+		case <-time.After(delay):
 		}
+		ceiling = nextBackoffCeiling(ceiling, m.cfg.BackoffMax)
 	}
 }
 
 func (m *Manager) checkTargetSession(ctx context.Context) error {
-	cmd := commandContext(ctx, m.cfg.TmuxBin, m.cfg.Socket, "has-session", "-t", m.cfg.TargetSession)
-	out, err := cmd.CombinedOutput()
+	out, err := m.starter.CombinedOutput(ctx, buildTmuxArgs(m.cfg.Socket, "has-session", "-t", m.cfg.TargetSession))
 	if err == nil {
 		return nil
 	}
 	if m.cfg.AutoCreateSession {
-		if ensureErr := EnsureSession(m.cfg.TmuxBin, m.cfg.Socket, m.cfg.TargetSession); ensureErr == nil {
+		if ensureErr := ensureSession(ctx, m.starter, m.cfg.TmuxBin, m.cfg.Socket, m.cfg.TargetSession, m.cfg.InitialCommand, m.cfg.InitialWindows); ensureErr == nil {
 			return nil
 		}
 	}
+	if missing := missingBinaryError(m.cfg.TmuxBin, err); missing != err {
+		return missing
+	}
 	msg := strings.TrimSpace(string(out))
 	if msg != "" {
 		return fmt.Errorf("target session %q unavailable: %s", m.cfg.TargetSession, msg)
@@ -191,38 +363,38 @@ func (m *Manager) checkTargetSession(ctx context.Context) error {
 }
 
 func (m *Manager) runOnce(ctx context.Context) error {
-	cmd := commandContext(ctx, m.cfg.TmuxBin, m.cfg.Socket, "-CC", "attach-session", "-t", m.cfg.TargetSession)
-	ptmx, err := pty.Start(cmd)
+	proc, err := m.starter.Start(ctx, buildTmuxArgs(m.cfg.Socket, "-CC", "attach-session", "-t", m.cfg.TargetSession))
 	if err != nil {
-		return err
+		return missingBinaryError(m.cfg.TmuxBin, err)
 	}
 	defer func() {
-		_ = ptmx.Close()
+		_ = proc.Close()
 	}()
 
-	log.Printf("tmux control client started")
+	m.cfg.Logger.Info("tmux control client started", "session", m.cfg.TargetSession)
 
 	m.mu.Lock()
-	m.stdin = ptmx
+	m.stdin = proc
 	m.running = true
 	m.lastErr = nil
+	m.connectedAt = time.Now()
 	m.mu.Unlock()
 	if m.cfg.OnConnected != nil {
 		m.cfg.OnConnected()
 	}
 
 	errCh := make(chan error, 1)
-	go m.readLines(ptmx, errCh, m.cfg.OnStdoutLine)
+	go m.readLines(proc, errCh, m.cfg.OnStdoutLine)
 
 	waitErr := make(chan error, 1)
 	go func() {
-		waitErr <- cmd.Wait()
+		waitErr <- proc.Wait()
 	}()
 
 	var result error
 	select {
 	case <-ctx.Done():
-		_ = ptmx.Close()
+		_ = proc.Close()
 		result = ctx.Err()
 	case err := <-errCh:
 		result = err
@@ -233,15 +405,23 @@ func (m *Manager) runOnce(ctx context.Context) error {
 	m.mu.Lock()
 	m.running = false
 	m.stdin = nil
+	m.connectedAt = time.Time{}
 	m.mu.Unlock()
 	return result
 }
 
+func (m *Manager) reportReconnecting(attempt int, delay time.Duration) {
+	if m.cfg.OnReconnecting != nil {
+		m.cfg.OnReconnecting(attempt, time.Now().Add(delay))
+	}
+}
+
 func (m *Manager) markDisconnected(err error) {
 	m.mu.Lock()
 	changed := m.running || m.stdin != nil || !sameError(m.lastErr, err)
 	m.running = false
 	m.stdin = nil
+	m.connectedAt = time.Time{}
 	m.lastErr = err
 	m.mu.Unlock()
 	if changed && m.cfg.OnDisconnect != nil {
@@ -256,16 +436,41 @@ func sameError(a, b error) bool {
 	return strings.TrimSpace(a.Error()) == strings.TrimSpace(b.Error())
 }
 
+// readLines delivers r line by line to onLine with any trailing "\r"
+// removed. A line longer than MaxLineBytes is discarded, up to its newline,
+// and logged; the reader carries on with the next line so one runaway
+// %output cannot take down the control connection.
 func (m *Manager) readLines(r io.Reader, errCh chan<- error, onLine func(string)) {
-	s := bufio.NewScanner(r)
-	buf := make([]byte, 0, 64*1024)
-	s.Buffer(buf, 1024*1024)
-	for s.Scan() {
-		if onLine != nil {
-			onLine(strings.TrimSuffix(s.Text(), "\r"))
+	br := bufio.NewReaderSize(r, 64*1024)
+	var line []byte
+	dropped := 0
+	for {
+		chunk, err := br.ReadSlice('\n')
+		switch {
+		case dropped > 0:
+			dropped += len(chunk)
+		case len(line)+len(chunk) > m.cfg.MaxLineBytes+1:
+			dropped = len(line) + len(chunk)
+			line = line[:0]
+		default:
+			line = append(line, chunk...)
+		}
+		if err == bufio.ErrBufferFull {
+			continue
+		}
+		if dropped > 0 {
+			m.cfg.Logger.Warn("dropped oversized tmux output line", "bytes", dropped, "max_bytes", m.cfg.MaxLineBytes)
+			dropped = 0
+		} else if len(line) > 0 && onLine != nil {
+			text := strings.TrimSuffix(string(line), "\n")
+			onLine(strings.TrimSuffix(text, "\r"))
+		}
+		line = line[:0]
+		if err != nil {
+			if err != io.EOF {
+				errCh <- err
+			}
+			return
 		}
-	}
-	if err := s.Err(); err != nil {
-		errCh <- err
 	}
 }
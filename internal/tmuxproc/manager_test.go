@@ -2,9 +2,14 @@ package tmuxproc
 
 import (
 	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"math/rand/v2"
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 )
@@ -67,7 +72,7 @@ func TestEnsureSessionUsesSocketFlagsForHasAndCreate(t *testing.T) {
 	`)
 	t.Setenv("WMUX_ARGS_LOG", logPath)
 
-	if err := EnsureSession(script, SocketTarget{Path: "/tmp/ovm.sock"}, "dev"); err != nil {
+	if err := EnsureSession(script, SocketTarget{Path: "/tmp/ovm.sock"}, "dev", "", nil); err != nil {
 		t.Fatalf("EnsureSession: %v", err)
 	}
 
@@ -83,6 +88,22 @@ func TestEnsureSessionUsesSocketFlagsForHasAndCreate(t *testing.T) {
 	}
 }
 
+func TestSessionExistsReportsHasSessionResult(t *testing.T) {
+	script := writeFakeTmuxScript(t, `
+	if [ "$3" = "present" ]; then
+	  exit 0
+	fi
+	exit 1
+	`)
+
+	if !SessionExists(script, SocketTarget{}, "present") {
+		t.Fatalf("SessionExists(present) = false, want true")
+	}
+	if SessionExists(script, SocketTarget{}, "missing") {
+		t.Fatalf("SessionExists(missing) = true, want false")
+	}
+}
+
 func TestRunOnceUsesSocketFlagsForAttach(t *testing.T) {
 	logPath := filepath.Join(t.TempDir(), "tmux-args.log")
 	script := writeFakeTmuxScript(t, `
@@ -107,6 +128,35 @@ func TestRunOnceUsesSocketFlagsForAttach(t *testing.T) {
 	}
 }
 
+func TestConnectedSinceTracksControlConnection(t *testing.T) {
+	script := writeFakeTmuxScript(t, `exit 0`)
+	var (
+		since     time.Time
+		connected bool
+	)
+	var m *Manager
+	m = NewManager(Config{
+		TmuxBin:       script,
+		TargetSession: "dev",
+		OnConnected:   func() { since, connected = m.ConnectedSince() },
+	})
+
+	if _, ok := m.ConnectedSince(); ok {
+		t.Fatalf("ConnectedSince reported a connection before Run")
+	}
+	before := time.Now()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	_ = m.runOnce(ctx)
+
+	if !connected || since.Before(before) {
+		t.Fatalf("ConnectedSince during connection = %v, %v; want a time after %v", since, connected, before)
+	}
+	if _, ok := m.ConnectedSince(); ok {
+		t.Fatalf("ConnectedSince reported a connection after the client exited")
+	}
+}
+
 func writeFakeTmuxScript(t *testing.T, body string) string {
 	t.Helper()
 	path := filepath.Join(t.TempDir(), "fake-tmux.sh")
@@ -125,3 +175,522 @@ func readFile(t *testing.T, path string) string {
 	}
 	return string(b)
 }
+
+func TestCheckTmuxReportsMissingBinary(t *testing.T) {
+	err := CheckTmux("wmux-definitely-missing-tmux", SocketTarget{})
+	if err == nil {
+		t.Fatalf("expected error for missing tmux binary")
+	}
+	if !strings.Contains(err.Error(), "not found on PATH") || !strings.Contains(err.Error(), "--tmux-bin") {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestRunOnceReportsMissingBinary(t *testing.T) {
+	m := NewManager(Config{TmuxBin: filepath.Join(t.TempDir(), "missing-tmux"), TargetSession: "dev"})
+
+	err := m.runOnce(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "--tmux-bin") {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestJitteredBackoffStaysWithinBoundsAndVaries(t *testing.T) {
+	base := 500 * time.Millisecond
+	max := 10 * time.Second
+	rng := rand.New(rand.NewPCG(1, 2))
+	m := NewManager(Config{BackoffBase: base, BackoffMax: max})
+	m.int64n = rng.Int64N
+
+	ceiling := base
+	for step := 0; step < 8; step++ {
+		seen := map[time.Duration]struct{}{}
+		for i := 0; i < 50; i++ {
+			d := jitteredBackoff(base, ceiling, m.int64n)
+			if d < base || d > ceiling {
+				t.Fatalf("step %d: backoff %v outside [%v, %v]", step, d, base, ceiling)
+			}
+			seen[d] = struct{}{}
+		}
+		if ceiling > base && len(seen) < 2 {
+			t.Fatalf("step %d: backoff did not vary within [%v, %v]", step, base, ceiling)
+		}
+		ceiling = nextBackoffCeiling(ceiling, max)
+	}
+	if ceiling != max {
+		t.Fatalf("ceiling = %v, want capped at %v", ceiling, max)
+	}
+}
+
+func TestJitteredBackoffAtBaseIsBase(t *testing.T) {
+	got := jitteredBackoff(time.Second, time.Second, func(int64) int64 {
+		t.Fatalf("rand source called with no jitter range")
+		return 0
+	})
+	if got != time.Second {
+		t.Fatalf("backoff = %v, want %v", got, time.Second)
+	}
+}
+
+func TestEnsureSessionPassesConfigFile(t *testing.T) {
+	logPath := filepath.Join(t.TempDir(), "tmux-args.log")
+	script := writeFakeTmuxScript(t, `
+	echo "$@" >> "$WMUX_ARGS_LOG"
+	case "$*" in
+	  *has-session*) exit 1 ;;
+	esac
+	exit 0
+	`)
+	t.Setenv("WMUX_ARGS_LOG", logPath)
+
+	if err := EnsureSession(script, SocketTarget{Name: "ovm", ConfigFile: "/etc/wmux.tmux.conf"}, "dev", "", nil); err != nil {
+		t.Fatalf("EnsureSession: %v", err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(readFile(t, logPath)), "\n")
+	if len(lines) != 2 || lines[1] != "-f /etc/wmux.tmux.conf -L ovm new-session -d -s dev" {
+		t.Fatalf("tmux calls = %q, want new-session with -f", lines)
+	}
+}
+
+func TestEnsureSessionPassesInitialCommandOnCreate(t *testing.T) {
+	logPath := filepath.Join(t.TempDir(), "tmux-args.log")
+	script := writeFakeTmuxScript(t, `
+	for arg in "$@"; do printf '[%s]' "$arg" >> "$WMUX_ARGS_LOG"; done
+	echo >> "$WMUX_ARGS_LOG"
+	case "$*" in
+	  *has-session*) exit 1 ;;
+	esac
+	exit 0
+	`)
+	t.Setenv("WMUX_ARGS_LOG", logPath)
+
+	if err := EnsureSession(script, SocketTarget{}, "dev", "htop -d 10", nil); err != nil {
+		t.Fatalf("EnsureSession: %v", err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(readFile(t, logPath)), "\n")
+	if len(lines) != 2 || lines[1] != "[new-session][-d][-s][dev][htop -d 10]" {
+		t.Fatalf("tmux calls = %q, want new-session with the initial command as one argument", lines)
+	}
+}
+
+func TestEnsureSessionOpensInitialWindowsAfterCreate(t *testing.T) {
+	logPath := filepath.Join(t.TempDir(), "tmux-args.log")
+	script := writeFakeTmuxScript(t, `
+	for arg in "$@"; do printf '[%s]' "$arg" >> "$WMUX_ARGS_LOG"; done
+	echo >> "$WMUX_ARGS_LOG"
+	case "$*" in
+	  *has-session*) exit 1 ;;
+	esac
+	exit 0
+	`)
+	t.Setenv("WMUX_ARGS_LOG", logPath)
+
+	if err := EnsureSession(script, SocketTarget{}, "dev", "", []string{"logs", "build shell"}); err != nil {
+		t.Fatalf("EnsureSession: %v", err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(readFile(t, logPath)), "\n")
+	want := []string{
+		"[has-session][-t][dev]",
+		"[new-session][-d][-s][dev]",
+		"[new-window][-d][-t][dev:][-n][logs]",
+		"[new-window][-d][-t][dev:][-n][build shell]",
+	}
+	if strings.Join(lines, "\n") != strings.Join(want, "\n") {
+		t.Fatalf("tmux calls = %q, want %q", lines, want)
+	}
+}
+
+func TestEnsureSessionReportsInitialWindowFailure(t *testing.T) {
+	script := writeFakeTmuxScript(t, `
+	case "$*" in
+	  *has-session*) exit 1 ;;
+	  *new-window*) echo "bad window" >&2; exit 1 ;;
+	esac
+	exit 0
+	`)
+
+	err := EnsureSession(script, SocketTarget{}, "dev", "", []string{"logs"})
+	if err == nil || !strings.Contains(err.Error(), `create window "logs"`) {
+		t.Fatalf("EnsureSession error = %v, want window failure", err)
+	}
+}
+
+func TestEnsureSessionIgnoresInitialCommandForExistingSession(t *testing.T) {
+	logPath := filepath.Join(t.TempDir(), "tmux-args.log")
+	script := writeFakeTmuxScript(t, `
+	echo "$@" >> "$WMUX_ARGS_LOG"
+	exit 0
+	`)
+	t.Setenv("WMUX_ARGS_LOG", logPath)
+
+	if err := EnsureSession(script, SocketTarget{}, "dev", "htop", []string{"logs"}); err != nil {
+		t.Fatalf("EnsureSession: %v", err)
+	}
+
+	if got := strings.TrimSpace(readFile(t, logPath)); got != "has-session -t dev" {
+		t.Fatalf("tmux calls = %q, want only has-session", got)
+	}
+}
+
+func TestRunReportsReconnectAttemptsWhileTargetMissing(t *testing.T) {
+	script := writeFakeTmuxScript(t, `
+	exit 1
+	`)
+
+	type report struct {
+		attempt   int
+		nextRetry time.Time
+	}
+	reports := make(chan report, 16)
+	m := NewManager(Config{
+		TmuxBin:       script,
+		TargetSession: "dev",
+		BackoffBase:   5 * time.Millisecond,
+		BackoffMax:    20 * time.Millisecond,
+		OnReconnecting: func(attempt int, nextRetry time.Time) {
+			reports <- report{attempt, nextRetry}
+		},
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		m.Run(ctx)
+		close(done)
+	}()
+
+	for want := 1; want <= 3; want++ {
+		select {
+		case r := <-reports:
+			if r.attempt != want {
+				t.Fatalf("attempt = %d, want %d", r.attempt, want)
+			}
+			if until := time.Until(r.nextRetry); until > 20*time.Millisecond {
+				t.Fatalf("next retry %v away, want within backoff max", until)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for reconnect attempt %d", want)
+		}
+	}
+	cancel()
+	<-done
+}
+
+func TestEnsureSessionToleratesConcurrentCreate(t *testing.T) {
+	dir := t.TempDir()
+	logPath := filepath.Join(dir, "tmux-args.log")
+	// The first has-session misses; new-session then loses the race to
+	// another instance, after which the session exists.
+	script := writeFakeTmuxScript(t, `
+	echo "$@" >> "$WMUX_ARGS_LOG"
+	case "$*" in
+	  *has-session*)
+	    [ -e "$WMUX_SESSION_MARK" ] && exit 0
+	    exit 1 ;;
+	  *new-session*)
+	    touch "$WMUX_SESSION_MARK"
+	    echo "duplicate session: dev" >&2
+	    exit 1 ;;
+	esac
+	exit 0
+	`)
+	t.Setenv("WMUX_ARGS_LOG", logPath)
+	t.Setenv("WMUX_SESSION_MARK", filepath.Join(dir, "created"))
+
+	if err := EnsureSession(script, SocketTarget{}, "dev", "", nil); err != nil {
+		t.Fatalf("EnsureSession: %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(readFile(t, logPath)), "\n")
+	if len(lines) != 3 || lines[2] != "has-session -t dev" {
+		t.Fatalf("tmux calls = %q, want has-session re-check after new-session", lines)
+	}
+}
+
+func TestEnsureSessionReportsOtherCreateFailures(t *testing.T) {
+	script := writeFakeTmuxScript(t, `
+	case "$*" in
+	  *new-session*) echo "no server running" >&2 ;;
+	esac
+	exit 1
+	`)
+
+	err := EnsureSession(script, SocketTarget{}, "dev", "", nil)
+	if err == nil || !strings.Contains(err.Error(), "no server running") {
+		t.Fatalf("EnsureSession error = %v, want create failure", err)
+	}
+}
+
+func TestReadLinesDropsOversizedLineAndContinues(t *testing.T) {
+	var logs strings.Builder
+	m := NewManager(Config{
+		MaxLineBytes: 16,
+		Logger:       slog.New(slog.NewTextHandler(&logs, nil)),
+	})
+	input := "%begin 1 1 0\r\n" + "%output %1 " + strings.Repeat("x", 200*1024) + "\n\n%end 1 1 0\n%exit"
+	var got []string
+	errCh := make(chan error, 1)
+	m.readLines(strings.NewReader(input), errCh, func(line string) { got = append(got, line) })
+
+	want := []string{"%begin 1 1 0", "", "%end 1 1 0", "%exit"}
+	if strings.Join(got, "|") != strings.Join(want, "|") {
+		t.Fatalf("lines = %q, want %q", got, want)
+	}
+	select {
+	case err := <-errCh:
+		t.Fatalf("readLines reported %v, want the oversized line skipped", err)
+	default:
+	}
+	if !strings.Contains(logs.String(), "dropped oversized tmux output line") || !strings.Contains(logs.String(), "bytes=204812") {
+		t.Fatalf("log = %q, want a warning with the dropped size", logs.String())
+	}
+}
+
+func TestSendReportsNotReady(t *testing.T) {
+	m := NewManager(Config{TargetSession: "dev"})
+	if err := m.Send("list-panes"); !errors.Is(err, ErrNotReady) {
+		t.Fatalf("Send before connect = %v, want ErrNotReady", err)
+	}
+
+	// A write that loses the race with the connection closing.
+	r, w := io.Pipe()
+	r.Close()
+	m.mu.Lock()
+	m.running = true
+	m.stdin = w
+	m.mu.Unlock()
+	err := m.Send("list-panes")
+	if !errors.Is(err, ErrNotReady) || !errors.Is(err, io.ErrClosedPipe) {
+		t.Fatalf("Send on closed stdin = %v, want ErrNotReady wrapping the write error", err)
+	}
+}
+
+// fakeTmux is a processStarter that stands in for tmux. Short-lived
+// commands, recorded in commands, fail hasSessionFailures times before
+// succeeding, and each control client it starts is handed to the test on
+// procs to script.
+type fakeTmux struct {
+	mu                 sync.Mutex
+	hasSessionFailures int
+	commands           []string
+	procs              chan *fakeProcess
+}
+
+func (f *fakeTmux) CombinedOutput(_ context.Context, args []string) ([]byte, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.commands = append(f.commands, strings.Join(args, " "))
+	if f.hasSessionFailures > 0 {
+		f.hasSessionFailures--
+		return []byte("can't find session: dev"), errors.New("exit status 1")
+	}
+	return nil, nil
+}
+
+func (f *fakeTmux) Start(context.Context, []string) (tmuxProcess, error) {
+	p := &fakeProcess{exited: make(chan struct{})}
+	p.out, p.outW = io.Pipe()
+	f.procs <- p
+	return p, nil
+}
+
+// fakeProcess is a control client whose output the test writes with emit
+// and which ends when the test calls exit or the manager closes it.
+type fakeProcess struct {
+	out    *io.PipeReader
+	outW   *io.PipeWriter
+	exited chan struct{}
+	once   sync.Once
+
+	mu    sync.Mutex
+	input strings.Builder
+}
+
+func (p *fakeProcess) Read(b []byte) (int, error) { return p.out.Read(b) }
+
+func (p *fakeProcess) Write(b []byte) (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.input.Write(b)
+}
+
+func (p *fakeProcess) Close() error {
+	p.exit()
+	return nil
+}
+
+func (p *fakeProcess) Wait() error {
+	<-p.exited
+	return errors.New("exit status 1")
+}
+
+func (p *fakeProcess) emit(lines ...string) {
+	for _, line := range lines {
+		_, _ = io.WriteString(p.outW, line+"\n")
+	}
+}
+
+func (p *fakeProcess) exit() {
+	p.once.Do(func() {
+		_ = p.outW.Close()
+		close(p.exited)
+	})
+}
+
+func (p *fakeProcess) written() string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.input.String()
+}
+
+func TestRunRestartsControlClientWithGrowingBackoff(t *testing.T) {
+	type report struct {
+		attempt int
+		delay   time.Duration
+	}
+	reports := make(chan report, 16)
+	lines := make(chan string, 16)
+	connected := make(chan struct{}, 4)
+	disconnected := make(chan error, 4)
+	fake := &fakeTmux{hasSessionFailures: 3, procs: make(chan *fakeProcess, 4)}
+	m := NewManager(Config{
+		TargetSession: "dev",
+		BackoffBase:   10 * time.Millisecond,
+		BackoffMax:    40 * time.Millisecond,
+		OnStdoutLine:  func(line string) { lines <- line },
+		OnConnected:   func() { connected <- struct{}{} },
+		OnDisconnect:  func(err error) { disconnected <- err },
+		OnReconnecting: func(attempt int, nextRetry time.Time) {
+			reports <- report{attempt, time.Until(nextRetry)}
+		},
+	})
+	m.starter = fake
+	// Always draw the top of the jitter range, so each delay is the ceiling.
+	m.int64n = func(n int64) int64 { return n - 1 }
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		m.Run(ctx)
+		close(done)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	timedOut := func(what string) {
+		t.Helper()
+		t.Fatalf("timed out waiting for %s", what)
+	}
+	expectReport := func(attempt int, delay time.Duration) {
+		t.Helper()
+		select {
+		case r := <-reports:
+			if r.attempt != attempt || r.delay > delay || r.delay < delay-5*time.Millisecond {
+				t.Fatalf("reconnect report = attempt %d in %v, want attempt %d in %v", r.attempt, r.delay, attempt, delay)
+			}
+		case <-time.After(5 * time.Second):
+			timedOut("a reconnect report")
+		}
+	}
+
+	// The target is missing three times: the backoff doubles up to the max.
+	expectReport(1, 10*time.Millisecond)
+	expectReport(2, 20*time.Millisecond)
+	expectReport(3, 40*time.Millisecond)
+
+	var proc *fakeProcess
+	select {
+	case proc = <-fake.procs:
+	case <-time.After(5 * time.Second):
+		timedOut("the control client to start")
+	}
+	select {
+	case <-connected:
+	case <-time.After(5 * time.Second):
+		timedOut("OnConnected")
+	}
+	proc.emit("%begin 1 1 0", "%end 1 1 0")
+	for _, want := range []string{"%begin 1 1 0", "%end 1 1 0"} {
+		select {
+		case got := <-lines:
+			if got != want {
+				t.Fatalf("stdout line = %q, want %q", got, want)
+			}
+		case <-time.After(5 * time.Second):
+			timedOut("stdout line " + want)
+		}
+	}
+	if err := m.Send("list-panes"); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	if got := proc.written(); got != "list-panes\n" {
+		t.Fatalf("control client input = %q, want list-panes", got)
+	}
+
+	// The control client exits: the manager reports the disconnect and
+	// restarts it after a delay jittered over [base, 2*base], not the grown
+	// one.
+	proc.exit()
+	select {
+	case err := <-disconnected:
+		if err == nil {
+			t.Fatalf("OnDisconnect error = nil, want the exit error")
+		}
+	case <-time.After(5 * time.Second):
+		timedOut("OnDisconnect")
+	}
+	expectReport(1, 20*time.Millisecond)
+	select {
+	case <-fake.procs:
+	case <-time.After(5 * time.Second):
+		timedOut("the control client to restart")
+	}
+	select {
+	case <-connected:
+	case <-time.After(5 * time.Second):
+		timedOut("OnConnected after restart")
+	}
+}
+
+func TestRunCreatesMissingSessionThroughStarter(t *testing.T) {
+	fake := &fakeTmux{hasSessionFailures: 2, procs: make(chan *fakeProcess, 1)}
+	m := NewManager(Config{
+		TmuxBin:           "/nonexistent/tmux",
+		TargetSession:     "dev",
+		Socket:            SocketTarget{Name: "ovm"},
+		AutoCreateSession: true,
+		InitialWindows:    []string{"logs"},
+	})
+	m.starter = fake
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		m.Run(ctx)
+		close(done)
+	}()
+	select {
+	case <-fake.procs:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("timed out waiting for the control client to start")
+	}
+	cancel()
+	<-done
+
+	fake.mu.Lock()
+	got := strings.Join(fake.commands, "\n")
+	fake.mu.Unlock()
+	want := strings.Join([]string{
+		"-L ovm has-session -t dev",
+		"-L ovm has-session -t dev",
+		"-L ovm new-session -d -s dev",
+		"-L ovm new-window -d -t dev: -n logs",
+	}, "\n")
+	if got != want {
+		t.Fatalf("starter commands =\n%s\nwant\n%s", got, want)
+	}
+}
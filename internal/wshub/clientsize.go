@@ -0,0 +1,87 @@
+package wshub
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ClientSize is the terminal size of the tmux control client, which bounds
+// the size tmux gives the target session's windows.
+type ClientSize struct {
+	Width  int `json:"width"`
+	Height int `json:"height"`
+}
+
+// DefaultClientSize is the size tmux gives a control client until it is
+// resized with refresh-client -C.
+var DefaultClientSize = ClientSize{Width: 80, Height: 24}
+
+// MaxClientDimension is the largest width or height tmux accepts for a
+// client (WINDOW_MAXIMUM).
+const MaxClientDimension = 10000
+
+// ValidateClientSize checks that width and height are within tmux's limits.
+func ValidateClientSize(width, height int) error {
+	if width < 1 || width > MaxClientDimension || height < 1 || height > MaxClientDimension {
+		return fmt.Errorf("client size must be between 1x1 and %dx%d", MaxClientDimension, MaxClientDimension)
+	}
+	return nil
+}
+
+// parseClientSizeArg parses the WxH (or W,H) argument of refresh-client -C.
+// Per-window sizes ("@1:WxH") are not client sizes and are rejected.
+func parseClientSizeArg(arg string) (ClientSize, bool) {
+	w, h, ok := strings.Cut(arg, "x")
+	if !ok {
+		w, h, ok = strings.Cut(arg, ",")
+	}
+	if !ok {
+		return ClientSize{}, false
+	}
+	width, err1 := strconv.Atoi(w)
+	height, err2 := strconv.Atoi(h)
+	if err1 != nil || err2 != nil || ValidateClientSize(width, height) != nil {
+		return ClientSize{}, false
+	}
+	return ClientSize{Width: width, Height: height}, true
+}
+
+// refreshClientSize returns the size a refresh-client argv sets with -C,
+// if any.
+func refreshClientSize(argv []string) *ClientSize {
+	for i := 1; i < len(argv)-1; i++ {
+		if argv[i] == "-C" {
+			if size, ok := parseClientSizeArg(argv[i+1]); ok {
+				return &size
+			}
+			return nil
+		}
+	}
+	return nil
+}
+
+// ClientSize returns the control client's current size: DefaultClientSize
+// until a refresh-client -C, from the API or a WebSocket client, succeeds.
+// It resets when tmux reconnects.
+func (h *Hub) ClientSize() ClientSize {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return h.clientSize
+}
+
+// ResizeClient sets the control client's size with refresh-client -C. It
+// fails if policy blocks refresh-client.
+func (h *Hub) ResizeClient(ctx context.Context, width, height int) error {
+	if err := ValidateClientSize(width, height); err != nil {
+		return err
+	}
+	if err := h.Policy().ValidateCommand("refresh-client"); err != nil {
+		return err
+	}
+	argv := []string{"refresh-client", "-C", fmt.Sprintf("%dx%d", width, height)}
+	_, err := h.runCommandAndWait(ctx, argv, 5*time.Second, false)
+	return err
+}
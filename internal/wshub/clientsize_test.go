@@ -0,0 +1,74 @@
+package wshub
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/ampcode/wmux/internal/policy"
+)
+
+func TestHubTracksRefreshClientSize(t *testing.T) {
+	h := New(policy.Default(), "dev")
+	if err := h.BindTmux(&countingSender{}); err != nil {
+		t.Fatalf("BindTmux: %v", err)
+	}
+	c := &client{send: make(chan serverMsg, 32)}
+	h.addClient(c)
+
+	sendFromClient(t, h, c, "refresh-client", "-C", "120x40")
+	h.BroadcastTmuxStdoutLine("%begin 1 1 0")
+	h.BroadcastTmuxStdoutLine("%end 1 1 0")
+
+	deadline := time.After(time.Second)
+	for {
+		var msg serverMsg
+		select {
+		case msg = <-c.send:
+		case <-deadline:
+			t.Fatalf("timed out waiting for client_size")
+		}
+		if msg.T != "client_size" {
+			continue
+		}
+		if *msg.ClientSize != (ClientSize{Width: 120, Height: 40}) {
+			t.Fatalf("client_size = %+v, want 120x40", *msg.ClientSize)
+		}
+		break
+	}
+	if got := h.ClientSize(); got != (ClientSize{Width: 120, Height: 40}) {
+		t.Fatalf("ClientSize = %+v, want 120x40", got)
+	}
+
+	// A failed resize and a per-window size leave the client size alone.
+	sendFromClient(t, h, c, "refresh-client", "-C", "90x30")
+	h.BroadcastTmuxStdoutLine("%begin 2 2 0")
+	h.BroadcastTmuxStdoutLine("%error 2 2 0")
+	sendFromClient(t, h, c, "refresh-client", "-C", "@1:90x30")
+	h.BroadcastTmuxStdoutLine("%begin 3 3 0")
+	h.BroadcastTmuxStdoutLine("%end 3 3 0")
+	waitForCommand(t, c, 3)
+	if got := h.ClientSize(); got != (ClientSize{Width: 120, Height: 40}) {
+		t.Fatalf("ClientSize = %+v after failed and per-window resizes, want 120x40", got)
+	}
+
+	h.BroadcastDisconnected(errors.New("exit"))
+	if got := h.ClientSize(); got != DefaultClientSize {
+		t.Fatalf("ClientSize = %+v after disconnect, want default", got)
+	}
+}
+
+func waitForCommand(t *testing.T, c *client, id int64) {
+	t.Helper()
+	deadline := time.After(time.Second)
+	for {
+		select {
+		case msg := <-c.send:
+			if msg.T == "tmux_command" && msg.Command.CommandID == id {
+				return
+			}
+		case <-deadline:
+			t.Fatalf("timed out waiting for command %d", id)
+		}
+	}
+}
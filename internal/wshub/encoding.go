@@ -0,0 +1,90 @@
+package wshub
+
+import (
+	"encoding/base64"
+	"fmt"
+)
+
+// Output encoding modes selectable with set-encoding.
+const (
+	outputEncodingUTF8 = "utf8"
+	outputEncodingRaw  = "raw"
+)
+
+// setOutputEncoding switches how pane_output is delivered to c: as UTF-8
+// text (the default) or as base64 of the raw pane bytes.
+func (c *client) setOutputEncoding(mode string) error {
+	switch mode {
+	case outputEncodingUTF8, outputEncodingRaw:
+	default:
+		return fmt.Errorf("unsupported encoding mode %q (allowed: %s, %s)", mode, outputEncodingUTF8, outputEncodingRaw)
+	}
+	c.metaMu.Lock()
+	defer c.metaMu.Unlock()
+	c.rawOutput = mode == outputEncodingRaw
+	return nil
+}
+
+func (c *client) wantsRawOutput() bool {
+	c.metaMu.Lock()
+	defer c.metaMu.Unlock()
+	return c.rawOutput
+}
+
+// encodeOutput adapts a pane_output message to c's encoding. It reports
+// false when there is nothing to deliver: a UTF-8 chunk left empty because
+// its bytes are carried into the next one. Raw clients get the chunk's own
+// bytes, independent of any UTF-8 carry.
+func (c *client) encodeOutput(m serverMsg) (serverMsg, bool) {
+	if m.PaneOutput == nil {
+		return m, true
+	}
+	if !c.wantsRawOutput() {
+		return m, m.PaneOutput.Data != ""
+	}
+	raw := m.PaneOutput.raw
+	if raw == nil {
+		raw = []byte(m.PaneOutput.Data)
+	}
+	return serverMsg{T: "pane_output", PaneOutput: &paneOutputPayload{
+		PaneID:   m.PaneOutput.PaneID,
+		Data:     base64.StdEncoding.EncodeToString(raw),
+		Encoding: "base64",
+	}}, true
+}
+
+// MinOutputChunk is the smallest non-zero Options.MaxOutputChunk: room for
+// any UTF-8 rune and for one base64 quantum.
+const MinOutputChunk = 4
+
+// splitOutput cuts a pane_output whose data exceeds limit bytes into
+// consecutive messages of at most limit bytes. UTF-8 text is cut with
+// splitUTF8AtSafeBoundary so no rune is split; base64 data is cut on 4-byte
+// quanta so each piece decodes on its own. Other messages, and any message
+// when limit is 0, are returned as is.
+func splitOutput(m serverMsg, limit int) []serverMsg {
+	if limit <= 0 || m.PaneOutput == nil || len(m.PaneOutput.Data) <= limit {
+		return []serverMsg{m}
+	}
+	data := m.PaneOutput.Data
+	var out []serverMsg
+	for len(data) > limit {
+		cut := limit
+		if m.PaneOutput.Encoding != "" {
+			cut -= cut % 4
+		} else if piece, _ := splitUTF8AtSafeBoundary([]byte(data[:limit])); len(piece) > 0 && len(piece) < limit {
+			cut = len(piece)
+		}
+		out = append(out, m.withOutputData(data[:cut]))
+		data = data[cut:]
+	}
+	return append(out, m.withOutputData(data))
+}
+
+// withOutputData returns a copy of the pane_output m carrying data.
+func (m serverMsg) withOutputData(data string) serverMsg {
+	p := *m.PaneOutput
+	p.Data = data
+	m.PaneOutput = &p
+	return m
+}
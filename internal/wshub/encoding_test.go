@@ -0,0 +1,127 @@
+package wshub
+
+import (
+	"encoding/base64"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"github.com/ampcode/wmux/internal/policy"
+)
+
+func TestHubRawEncodingDeliversUndecodedBytes(t *testing.T) {
+	h := New(policy.Default(), "dev")
+	text := &client{send: make(chan serverMsg, 32)}
+	raw := &client{send: make(chan serverMsg, 32)}
+	h.addClient(text)
+	h.addClient(raw)
+	if err := raw.setOutputEncoding("raw"); err != nil {
+		t.Fatalf("setOutputEncoding: %v", err)
+	}
+
+	// "─" split across two chunks, then a byte that is not UTF-8.
+	h.BroadcastTmuxStdoutLine(`%output %1 \342`)
+	h.BroadcastTmuxStdoutLine(`%output %1 \224\200`)
+	h.BroadcastTmuxStdoutLine(`%output %1 \377`)
+
+	var rawGot []byte
+	for range 3 {
+		msg := waitPaneOutput(t, raw)
+		if msg.Encoding != "base64" {
+			t.Fatalf("raw encoding = %q, want base64", msg.Encoding)
+		}
+		chunk, err := base64.StdEncoding.DecodeString(msg.Data)
+		if err != nil {
+			t.Fatalf("decode %q: %v", msg.Data, err)
+		}
+		rawGot = append(rawGot, chunk...)
+	}
+	if want := []byte{0xe2, 0x94, 0x80, 0xff}; string(rawGot) != string(want) {
+		t.Fatalf("raw bytes = % x, want % x", rawGot, want)
+	}
+
+	// The text client sees only the completed rune; the trailing invalid
+	// byte is held back as a possible partial rune.
+	if msg := waitPaneOutput(t, text); msg.Encoding != "" || msg.Data != "─" {
+		t.Fatalf("text output = %+v, want %q", *msg, "─")
+	}
+}
+
+func TestSetOutputEncodingRejectsUnknownMode(t *testing.T) {
+	c := &client{}
+	if err := c.setOutputEncoding("latin1"); err == nil {
+		t.Fatalf("expected error for unknown mode")
+	}
+	if err := c.setOutputEncoding("raw"); err != nil || !c.wantsRawOutput() {
+		t.Fatalf("raw mode not set: %v", err)
+	}
+	if err := c.setOutputEncoding("utf8"); err != nil || c.wantsRawOutput() {
+		t.Fatalf("utf8 mode not restored: %v", err)
+	}
+}
+
+func waitPaneOutput(t *testing.T, c *client) *paneOutputPayload {
+	t.Helper()
+	deadline := time.After(2 * time.Second)
+	for {
+		select {
+		case msg := <-c.send:
+			if msg.T == "pane_output" {
+				return msg.PaneOutput
+			}
+		case <-deadline:
+			t.Fatalf("timed out waiting for pane_output")
+		}
+	}
+}
+
+func TestSplitOutputKeepsRunesAndBase64QuantaWhole(t *testing.T) {
+	text := serverMsg{T: "pane_output", PaneOutput: &paneOutputPayload{PaneID: "%1", Data: "ab─cd─"}}
+	var got []string
+	for _, m := range splitOutput(text, 4) {
+		if len(m.PaneOutput.Data) > 4 || !utf8.ValidString(m.PaneOutput.Data) {
+			t.Fatalf("piece %q exceeds the limit or splits a rune", m.PaneOutput.Data)
+		}
+		got = append(got, m.PaneOutput.Data)
+	}
+	if strings.Join(got, "|") != "ab|─c|d─" {
+		t.Fatalf("pieces = %q, want ab|─c|d─", got)
+	}
+	if text.PaneOutput.Data != "ab─cd─" {
+		t.Fatalf("splitOutput modified the shared payload: %q", text.PaneOutput.Data)
+	}
+
+	encoded := base64.StdEncoding.EncodeToString([]byte("0123456789"))
+	raw := serverMsg{T: "pane_output", PaneOutput: &paneOutputPayload{PaneID: "%1", Data: encoded, Encoding: "base64"}}
+	var decoded []byte
+	for _, m := range splitOutput(raw, 6) {
+		chunk, err := base64.StdEncoding.DecodeString(m.PaneOutput.Data)
+		if err != nil || len(m.PaneOutput.Data) > 6 {
+			t.Fatalf("piece %q: %v", m.PaneOutput.Data, err)
+		}
+		decoded = append(decoded, chunk...)
+	}
+	if string(decoded) != "0123456789" {
+		t.Fatalf("decoded = %q, want 0123456789", decoded)
+	}
+
+	if out := splitOutput(text, 0); len(out) != 1 {
+		t.Fatalf("limit 0 split into %d messages, want 1", len(out))
+	}
+}
+
+func TestHubSplitsPaneOutputAboveMaxOutputChunk(t *testing.T) {
+	h := NewWithOptions(policy.Default(), "dev", Options{MaxOutputChunk: 8})
+	c := &client{send: make(chan serverMsg, 32)}
+	h.addClient(c)
+
+	h.BroadcastTmuxStdoutLine(`%output %1 0123456789abcdefXY`)
+	var got []string
+	for range 3 {
+		got = append(got, waitPaneOutput(t, c).Data)
+	}
+	if strings.Join(got, "|") != "01234567|89abcdef|XY" {
+		t.Fatalf("pieces = %q, want ordered 8-byte chunks", got)
+	}
+}
@@ -0,0 +1,187 @@
+package wshub
+
+import (
+	"sync"
+
+	"github.com/ampcode/wmux/internal/tmuxparse"
+)
+
+// parserEventBuffer is the stream parser's channel buffer. A backlog past it
+// counts as saturation: before the event queue existed, that was the point
+// where FeedLine blocked the tmux reader goroutine.
+const parserEventBuffer = 512
+
+// maxParserBacklog caps the event queue. Past it, queued pane output is
+// dropped and the affected panes are captured afresh; other events are
+// always kept.
+const maxParserBacklog = 64 * parserEventBuffer
+
+// ParserQueueStats describes the backlog between the tmux reader and the
+// goroutine that applies parsed events. Counters survive parser resets.
+type ParserQueueStats struct {
+	// Depth is the number of events waiting to be applied right now.
+	Depth int `json:"depth"`
+	// MaxDepth is the largest backlog seen since startup.
+	MaxDepth int `json:"max_depth"`
+	// Events is the number of events queued since startup.
+	Events int64 `json:"events"`
+	// Saturated counts events queued while the backlog was past
+	// parserEventBuffer.
+	Saturated int64 `json:"saturated"`
+	// Dropped counts pane output events discarded because the backlog
+	// reached maxParserBacklog.
+	Dropped int64 `json:"dropped"`
+}
+
+// eventQueue is a FIFO between a StreamParser's event channel and
+// consumeParserEvents. A relay goroutine drains the channel into the queue as
+// fast as events arrive, so a slow broadcast delays delivery instead of
+// stalling FeedLine and with it the tmux control connection. Once the
+// backlog reaches maxParserBacklog the queued pane output is dropped, which
+// a fresh capture of each pane replaces. Command blocks are never dropped:
+// they must stay paired with pending commands.
+type eventQueue struct {
+	mu     sync.Mutex
+	cond   *sync.Cond
+	events []tmuxparse.StreamEvent
+	closed bool
+	stats  ParserQueueStats
+}
+
+func newEventQueue(stats ParserQueueStats) *eventQueue {
+	stats.Depth = 0
+	q := &eventQueue{stats: stats}
+	q.cond = sync.NewCond(&q.mu)
+	return q
+}
+
+// push appends ev and reports whether this push took the backlog past
+// parserEventBuffer. When it took the backlog to maxParserBacklog, dropped
+// lists the panes whose queued output was discarded.
+func (q *eventQueue) push(ev tmuxparse.StreamEvent) (saturatedNow bool, dropped []string) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.closed {
+		return false, nil
+	}
+	q.events = append(q.events, ev)
+	depth := len(q.events)
+	q.stats.Events++
+	q.stats.MaxDepth = max(q.stats.MaxDepth, depth)
+	if depth > parserEventBuffer {
+		q.stats.Saturated++
+	}
+	if depth >= maxParserBacklog {
+		dropped = q.dropOutputLocked()
+	}
+	q.cond.Signal()
+	return depth == parserEventBuffer+1, dropped
+}
+
+// dropOutputLocked removes the queued pane output and returns the panes it
+// was for, in the order they first appear.
+func (q *eventQueue) dropOutputLocked() []string {
+	var panes []string
+	seen := map[string]bool{}
+	kept := q.events[:0]
+	for _, ev := range q.events {
+		paneID, ok := paneOutputTarget(ev)
+		if !ok {
+			kept = append(kept, ev)
+			continue
+		}
+		q.stats.Dropped++
+		if !seen[paneID] {
+			seen[paneID] = true
+			panes = append(panes, paneID)
+		}
+	}
+	clear(q.events[len(kept):])
+	q.events = kept
+	return panes
+}
+
+// paneOutputTarget reports the pane ev carries output for, if it is
+// %output or %extended-output.
+func paneOutputTarget(ev tmuxparse.StreamEvent) (string, bool) {
+	n, ok := ev.(tmuxparse.Notification)
+	if !ok {
+		return "", false
+	}
+	switch {
+	case n.Extended != nil:
+		return n.Extended.PaneID, true
+	case n.Name == "output" && len(n.Args) >= 1:
+		return n.Args[0], true
+	}
+	return "", false
+}
+
+// pop waits for the next event. It returns false once the queue is closed
+// and drained.
+func (q *eventQueue) pop() (tmuxparse.StreamEvent, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for len(q.events) == 0 && !q.closed {
+		q.cond.Wait()
+	}
+	if len(q.events) == 0 {
+		return nil, false
+	}
+	ev := q.events[0]
+	q.events[0] = nil
+	q.events = q.events[1:]
+	if len(q.events) == 0 {
+		q.events = nil
+	}
+	return ev, true
+}
+
+// close lets pop return false once the remaining events are consumed.
+func (q *eventQueue) close() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.closed = true
+	q.cond.Broadcast()
+}
+
+func (q *eventQueue) snapshot() ParserQueueStats {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	stats := q.stats
+	stats.Depth = len(q.events)
+	return stats
+}
+
+// relayParserEvents moves events from parser into q until the parser closes.
+func (h *Hub) relayParserEvents(parser *tmuxparse.StreamParser, q *eventQueue) {
+	defer q.close()
+	for ev := range parser.Events() {
+		saturated, dropped := q.push(ev)
+		if saturated {
+			h.logger().Warn("tmux event backlog exceeded parser buffer; broadcasts are falling behind tmux output", "buffer", parserEventBuffer)
+		}
+		if len(dropped) > 0 {
+			h.logger().Warn("tmux event backlog reached its cap; dropped queued pane output", "cap", maxParserBacklog, "panes", dropped)
+			// Not inline: a send blocked on tmux's stdin must not stop
+			// this goroutine from reading its stdout.
+			go func() {
+				for _, paneID := range dropped {
+					h.resnapshotPane(paneID)
+				}
+			}()
+		}
+	}
+}
+
+// ParserQueueStats reports the backlog of parsed tmux events not yet
+// applied to the model and broadcast.
+func (h *Hub) ParserQueueStats() ParserQueueStats {
+	h.mu.RLock()
+	q := h.parserQueue
+	h.mu.RUnlock()
+	if q == nil {
+		return ParserQueueStats{}
+	}
+	return q.snapshot()
+}
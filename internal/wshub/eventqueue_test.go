@@ -0,0 +1,103 @@
+package wshub
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/ampcode/wmux/internal/policy"
+	"github.com/ampcode/wmux/internal/tmuxparse"
+)
+
+func TestEventQueueKeepsOrderPastParserBuffer(t *testing.T) {
+	q := newEventQueue(ParserQueueStats{})
+	total := parserEventBuffer + 10
+	crossings := 0
+	for i := range total {
+		if saturated, _ := q.push(tmuxparse.ParseError{Line: string(rune('a' + i%26))}); saturated {
+			crossings++
+		}
+	}
+	q.close()
+
+	stats := q.snapshot()
+	if stats.Depth != total || stats.MaxDepth != total || stats.Events != int64(total) {
+		t.Fatalf("stats = %+v, want depth/max/events %d", stats, total)
+	}
+	if stats.Saturated != 10 || crossings != 1 {
+		t.Fatalf("saturated = %d crossings = %d, want 10 and 1", stats.Saturated, crossings)
+	}
+
+	for i := range total {
+		ev, ok := q.pop()
+		if !ok {
+			t.Fatalf("pop %d: queue ended early", i)
+		}
+		if got, want := ev.(tmuxparse.ParseError).Line, string(rune('a'+i%26)); got != want {
+			t.Fatalf("pop %d = %q, want %q", i, got, want)
+		}
+	}
+	if _, ok := q.pop(); ok {
+		t.Fatal("pop after drain on closed queue should report false")
+	}
+	if got := q.snapshot(); got.Depth != 0 || got.MaxDepth != total {
+		t.Fatalf("after drain stats = %+v", got)
+	}
+}
+
+func TestHubParserQueueStatsSurviveReset(t *testing.T) {
+	h := New(policy.Default(), "dev")
+	c := &client{send: make(chan serverMsg, 32)}
+	h.addClient(c)
+
+	h.BroadcastTmuxStdoutLine("%output %1 hi")
+	waitPaneOutput(t, c)
+	if got := h.ParserQueueStats().Events; got < 1 {
+		t.Fatalf("events = %d, want at least 1", got)
+	}
+
+	h.resetParser()
+	if got := h.ParserQueueStats(); got.Events < 1 || got.Depth != 0 {
+		t.Fatalf("after reset stats = %+v, want counters kept and empty backlog", got)
+	}
+}
+
+func TestEventQueueDropsOutputAtCapButKeepsCommands(t *testing.T) {
+	q := newEventQueue(ParserQueueStats{})
+	block := tmuxparse.Command{Success: true, Output: []string{"kept"}}
+	var dropped []string
+	for i := 0; i < maxParserBacklog; i++ {
+		var ev tmuxparse.StreamEvent = tmuxparse.Notification{Name: "output", Args: []string{"%1"}, Value: "x"}
+		switch {
+		case i%1000 == 0:
+			ev = block
+		case i%7 == 0:
+			ev = tmuxparse.Notification{Name: "extended-output", Extended: &tmuxparse.ExtendedOutput{PaneID: "%2"}}
+		}
+		if _, d := q.push(ev); d != nil {
+			if dropped != nil {
+				t.Fatalf("push %d dropped again: %v", i, d)
+			}
+			dropped = d
+		}
+	}
+	if strings.Join(dropped, ",") != "%1,%2" {
+		t.Fatalf("dropped panes = %v, want %%1,%%2", dropped)
+	}
+
+	commands := (maxParserBacklog + 999) / 1000
+	stats := q.snapshot()
+	if stats.Depth != commands || stats.Dropped != int64(maxParserBacklog-commands) {
+		t.Fatalf("stats = %+v, want depth %d and the rest dropped", stats, commands)
+	}
+	q.push(tmuxparse.Notification{Name: "output", Args: []string{"%1"}, Value: "after"})
+	q.close()
+	for i := 0; i < commands; i++ {
+		ev, ok := q.pop()
+		if _, isCommand := ev.(tmuxparse.Command); !ok || !isCommand {
+			t.Fatalf("pop %d = %#v, want a command block", i, ev)
+		}
+	}
+	if ev, _ := q.pop(); ev.(tmuxparse.Notification).Value != "after" {
+		t.Fatalf("output queued after the drop = %#v, want kept", ev)
+	}
+}
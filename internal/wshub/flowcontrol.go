@@ -0,0 +1,98 @@
+package wshub
+
+import (
+	"context"
+	"time"
+)
+
+// Flow control (Options.FlowControl) asks tmux to stop sending a pane's
+// output while a client receiving it is backed up, instead of letting the
+// client's queue overflow and dropping the client.
+//
+// A client counts as backed up once its send queue is flowHighWater full
+// after pane output was queued for it; the pane is then paused with
+// refresh-client -A '%N:pause'. Every client that backed up on a pane is
+// recorded against it, and the pane is continued once all of them have
+// drained to flowLowWater or disconnected. tmux discards what a paused pane
+// prints, so when it reports %continue the hub captures the pane and
+// broadcasts a pane_snapshot for clients to redraw from.
+
+// flowCommandTimeout bounds how long a pause or continue waits for tmux.
+const flowCommandTimeout = 5 * time.Second
+
+// flowHighWater is the queue length at which a client counts as backed up.
+func flowHighWater(capacity int) int {
+	return max(capacity*3/4, 1)
+}
+
+// flowLowWater is the queue length at which a backed-up client counts as
+// drained.
+func flowLowWater(capacity int) int {
+	return capacity / 4
+}
+
+// notePressure records that pane output for tmuxPaneID was just queued for
+// c, pausing the pane when c is backed up. It is called with h.mu held for
+// reading, so the tmux command is sent from another goroutine.
+func (h *Hub) notePressure(c *client, tmuxPaneID string) {
+	if len(c.send) < flowHighWater(cap(c.send)) {
+		return
+	}
+	h.flowMu.Lock()
+	defer h.flowMu.Unlock()
+	if h.flowPaused == nil {
+		h.flowPaused = make(map[string]map[*client]struct{})
+	}
+	waiting, paused := h.flowPaused[tmuxPaneID]
+	if !paused {
+		waiting = make(map[*client]struct{})
+		h.flowPaused[tmuxPaneID] = waiting
+		h.logger().Info("pausing pane output for slow client", "pane", tmuxPaneID, "client", c.id, "queued", len(c.send))
+		go h.sendFlowCommand(tmuxPaneID, "pause")
+	}
+	waiting[c] = struct{}{}
+}
+
+// clientDrained releases the panes c was holding paused, continuing each
+// one no other backed-up client is still holding. It is called as c's
+// queue drains and when c goes away.
+func (h *Hub) clientDrained(c *client) {
+	h.flowMu.Lock()
+	defer h.flowMu.Unlock()
+	for paneID, waiting := range h.flowPaused {
+		if _, ok := waiting[c]; !ok {
+			continue
+		}
+		delete(waiting, c)
+		if len(waiting) == 0 {
+			delete(h.flowPaused, paneID)
+			h.logger().Info("continuing pane output", "pane", paneID)
+			go h.sendFlowCommand(paneID, "continue")
+		}
+	}
+}
+
+// resetFlow forgets paused panes; a new tmux control client starts with
+// every pane running.
+func (h *Hub) resetFlow() {
+	h.flowMu.Lock()
+	defer h.flowMu.Unlock()
+	h.flowPaused = nil
+}
+
+func (h *Hub) sendFlowCommand(tmuxPaneID, action string) {
+	argv := []string{"refresh-client", "-A", tmuxPaneID + ":" + action}
+	if _, err := h.runCommandAndWait(context.Background(), argv, flowCommandTimeout, false); err != nil {
+		h.logger().Warn("tmux flow control failed", "pane", tmuxPaneID, "action", action, "err", err)
+	}
+}
+
+// resnapshotPane broadcasts a fresh capture of a pane whose output was
+// discarded, by tmux while the pane was paused or by the event queue at its
+// cap, so clients can redraw it.
+func (h *Hub) resnapshotPane(tmuxPaneID string) {
+	argv := []string{"capture-pane", "-p", "-e", "-N", "-t", tmuxPaneID}
+	if _, err := h.startCommand(context.Background(), argv, true); err != nil {
+		h.logger().Warn("pane resnapshot failed", "pane", tmuxPaneID, "err", err)
+	}
+}
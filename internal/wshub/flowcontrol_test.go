@@ -0,0 +1,105 @@
+package wshub
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ampcode/wmux/internal/policy"
+)
+
+func waitForSent(t *testing.T, s *countingSender, prefix string, n int) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for s.count(prefix) < n {
+		if time.Now().After(deadline) {
+			t.Fatalf("sent %d %q commands, want %d", s.count(prefix), prefix, n)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// pausedPanes returns the tmux ids of the panes flow control has paused.
+func pausedPanes(h *Hub) []string {
+	h.flowMu.Lock()
+	defer h.flowMu.Unlock()
+	var out []string
+	for paneID := range h.flowPaused {
+		out = append(out, paneID)
+	}
+	return out
+}
+
+func TestHubFlowControlPausesAndContinuesBackedUpPane(t *testing.T) {
+	h := NewWithOptions(policy.Default(), "dev", Options{FlowControl: true})
+	tmux := &countingSender{}
+	if err := h.BindTmux(tmux); err != nil {
+		t.Fatalf("BindTmux: %v", err)
+	}
+	slow := &client{send: make(chan serverMsg, 4)}
+	h.addClient(slow)
+
+	for range flowHighWater(cap(slow.send)) {
+		h.BroadcastTmuxStdoutLine("%output %1 x")
+	}
+	waitForSent(t, tmux, "refresh-client -A %1:pause", 1)
+	if got := pausedPanes(h); len(got) != 1 || got[0] != "%1" {
+		t.Fatalf("paused panes = %v, want [%%1]", got)
+	}
+
+	// More output while paused does not pause again.
+	<-slow.send
+	h.BroadcastTmuxStdoutLine("%output %1 y")
+	time.Sleep(20 * time.Millisecond)
+	if n := tmux.count("refresh-client -A %1:pause"); n != 1 {
+		t.Fatalf("pause sent %d times, want once", n)
+	}
+
+	for len(slow.send) > flowLowWater(cap(slow.send)) {
+		<-slow.send
+	}
+	h.clientDrained(slow)
+	waitForSent(t, tmux, "refresh-client -A %1:continue", 1)
+	if got := pausedPanes(h); len(got) != 0 {
+		t.Fatalf("paused panes = %v after drain, want none", got)
+	}
+
+	h.BroadcastTmuxStdoutLine("%continue %1")
+	waitForSent(t, tmux, "capture-pane -p -e -N -t %1", 1)
+}
+
+func TestHubFlowControlContinuesWhenBackedUpClientLeaves(t *testing.T) {
+	h := NewWithOptions(policy.Default(), "dev", Options{FlowControl: true})
+	tmux := &countingSender{}
+	if err := h.BindTmux(tmux); err != nil {
+		t.Fatalf("BindTmux: %v", err)
+	}
+	slow := &client{send: make(chan serverMsg, 4)}
+	h.addClient(slow)
+
+	for range flowHighWater(cap(slow.send)) {
+		h.BroadcastTmuxStdoutLine("%output %2 x")
+	}
+	waitForSent(t, tmux, "refresh-client -A %2:pause", 1)
+
+	h.removeClient(slow)
+	waitForSent(t, tmux, "refresh-client -A %2:continue", 1)
+}
+
+func TestHubWithoutFlowControlNeverPauses(t *testing.T) {
+	h := New(policy.Default(), "dev")
+	tmux := &countingSender{}
+	if err := h.BindTmux(tmux); err != nil {
+		t.Fatalf("BindTmux: %v", err)
+	}
+	slow := &client{send: make(chan serverMsg, 4)}
+	h.addClient(slow)
+
+	for range cap(slow.send) {
+		h.BroadcastTmuxStdoutLine("%output %1 x")
+	}
+	waitPaneOutput(t, slow)
+	time.Sleep(20 * time.Millisecond)
+	if n := tmux.count("refresh-client"); n != 0 {
+		t.Fatalf("sent %d refresh-client commands without flow control", n)
+	}
+}
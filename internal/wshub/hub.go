@@ -2,9 +2,16 @@ package wshub
 
 import (
 	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"log"
+	"hash/fnv"
+	"io"
+	"log/slog"
+	"maps"
 	"net/http"
 	"regexp"
 	"sort"
@@ -15,6 +22,7 @@ import (
 	"unicode/utf8"
 
 	"github.com/ampcode/wmux/internal/policy"
+	"github.com/ampcode/wmux/internal/reqid"
 	"github.com/ampcode/wmux/internal/tmuxparse"
 	"github.com/gorilla/websocket"
 )
@@ -23,20 +31,144 @@ type TmuxSender interface {
 	Send(line string) error
 }
 
+// Options configures optional hub behavior.
+type Options struct {
+	// KillOrphanedPanes kills a pane whose split-window response arrives
+	// after CreatePane has already timed out.
+	KillOrphanedPanes bool
+	// CreatePaneTimeout bounds how long CreatePane waits for split-window
+	// when the caller's context has no deadline. Defaults to
+	// DefaultCreatePaneTimeout.
+	CreatePaneTimeout time.Duration
+	// ResyncDebounce is the minimum spacing between automatic list-panes
+	// resyncs triggered by notifications and pane creation.
+	ResyncDebounce time.Duration
+	// Sentinel prefixes wmux's own format output (model rows and cursor
+	// markers). Defaults to DefaultSentinel; RandomSentinel avoids
+	// collisions with pane output that happens to use the default.
+	Sentinel string
+	// ExtraPaneFields names additional tmux format variables (without
+	// "#{...}") appended to each list-panes row and reported in a pane's
+	// Extra map. See ParseExtraPaneFields.
+	ExtraPaneFields []string
+	// MaxOutputChunk caps the data of one pane_output message in bytes;
+	// larger output is split into several messages. 0 is unlimited. See
+	// MinOutputChunk.
+	MaxOutputChunk int
+	// MaxCaptureBytes caps the text CapturePane returns; 0 uses
+	// DefaultMaxCaptureBytes. What happens above it is CaptureOverflow.
+	MaxCaptureBytes int
+	// CaptureOverflow is CaptureTruncate (the default when empty) or
+	// CaptureError.
+	CaptureOverflow string
+	// FlowControl pauses a pane's output in tmux while a client receiving
+	// it is backed up, and continues it once the client drains. See
+	// flowcontrol.go.
+	FlowControl bool
+	// Logger receives hub diagnostics. Defaults to slog.Default().
+	Logger *slog.Logger
+}
+
+// DefaultResyncDebounce is used when Options.ResyncDebounce is unset.
+const DefaultResyncDebounce = 200 * time.Millisecond
+
+// DefaultCreatePaneTimeout is used when Options.CreatePaneTimeout is unset.
+const DefaultCreatePaneTimeout = 5 * time.Second
+
 type Hub struct {
+	opts                  Options
 	policy                policy.Policy
 	tmux                  TmuxSender
 	parser                *tmuxparse.StreamParser
 	model                 modelState
 	pending               []pendingCommand
+	pendingSeq            uint64
 	targetSession         string
 	unavailableReason     string
 	stateRefreshScheduled bool
-
-	mu      sync.RWMutex
-	clients map[*client]struct{}
+	// synced is set once a list-panes result has been applied since the
+	// last (re)connect, so the model reflects tmux rather than a blank
+	// start.
+	synced bool
+	// reconnectAttempt and reconnectAt hold the latest backoff report from
+	// the tmux manager; reconnectAttempt is zero while connected.
+	reconnectAttempt int
+	reconnectAt      time.Time
+
+	mu           sync.RWMutex
+	clients      map[*client]struct{}
+	nextClientID int64
+	// connSlots counts WebSocket handlers in flight, including upgrades
+	// not yet registered in clients; see WSConfig.MaxClients.
+	connSlots int
+	// snapshotHashes fingerprints the last pane_snapshot broadcast per tmux
+	// pane id; see broadcastPaneSnapshot.
+	snapshotHashes map[string]uint64
+	// lastStateHash fingerprints the last broadcast tmux_state so identical
+	// snapshots arriving back to back are not re-sent.
+	lastStateHash    uint64
+	hasLastStateHash bool
 
 	outputUTF8Carry map[string][]byte
+	parseErrors     []ParseErrorRecord
+	configErrors    []ConfigErrorRecord
+	outputSubs      map[chan PaneOutput]struct{}
+	// rawSubs receive tmux stdout lines before parsing; see
+	// SubscribeRawLines.
+	rawSubs map[chan string]struct{}
+	// hiddenPanes holds tmux pane ids ("%3") an operator has hidden from
+	// the HTTP API; see HidePane.
+	hiddenPanes map[string]struct{}
+	// clientSize is the control client's size as last set by a successful
+	// refresh-client -C.
+	clientSize ClientSize
+	// outputLatency accumulates the ages tmux reports on %extended-output.
+	outputLatency OutputLatency
+	// parserQueue holds the current parser's events until
+	// consumeParserEvents applies them; see eventQueue.
+	parserQueue *eventQueue
+
+	// flowPaused maps each tmux pane id paused by flow control to the
+	// backed-up clients holding it paused. Guarded by flowMu, which is
+	// never held while taking mu.
+	flowMu     sync.Mutex
+	flowPaused map[string]map[*client]struct{}
+}
+
+// PaneOutput is one decoded chunk of pane output, tagged with its public
+// pane id.
+type PaneOutput struct {
+	PaneID string `json:"pane_id"`
+	Data   string `json:"data"`
+}
+
+// ParseErrorRecord is a retained tmux control-mode parse error kept for
+// diagnostics after the broadcast error message is gone.
+type ParseErrorRecord struct {
+	At      string `json:"at"`
+	Line    string `json:"line,omitempty"`
+	Message string `json:"message"`
+}
+
+const maxParseErrorRecords = 50
+
+// ConfigErrorRecord is a retained %config-error: a configuration line tmux
+// rejected, typically from .tmux.conf at server start.
+type ConfigErrorRecord struct {
+	At      string `json:"at"`
+	Message string `json:"message"`
+}
+
+const maxConfigErrorRecords = 20
+
+// OutputLatency summarizes how long tmux held pane output before sending it,
+// as reported by the age field of %extended-output. Plain %output carries
+// no age and is not counted.
+type OutputLatency struct {
+	Samples int64 `json:"samples"`
+	LastMS  int64 `json:"last_ms"`
+	MaxMS   int64 `json:"max_ms"`
+	TotalMS int64 `json:"total_ms"`
 }
 
 type PaneInfo struct {
@@ -48,24 +180,147 @@ type PaneInfo struct {
 	WindowName  string `json:"window_name"`
 	Width       int    `json:"width"`
 	Height      int    `json:"height"`
-	TmuxPaneID  string `json:"-"`
+	Dead        bool   `json:"dead"`
+	DeadStatus  int    `json:"dead_status"`
+	// PID is the pane's process id; 0 when tmux did not report one.
+	PID          int    `json:"pid"`
+	StartCommand string `json:"start_command"`
+	// Extra holds the configured Options.ExtraPaneFields by name.
+	Extra      map[string]string `json:"extra,omitempty"`
+	TmuxPaneID string            `json:"-"`
+	// TmuxWindowID is the pane's tmux window id ("@1").
+	TmuxWindowID string `json:"-"`
+	// Cursor is set by CreatePane when CreatePaneOptions.Cursor asked for
+	// it and the query succeeded.
+	Cursor *PaneCursor `json:"cursor,omitempty"`
+	// Ready is set by CreatePane when CreatePaneOptions.WaitReady asked
+	// for it: whether the ready signal was seen before the timeout.
+	Ready *bool `json:"ready,omitempty"`
+}
+
+type WindowInfo struct {
+	WindowID     string `json:"window_id"`
+	Index        int    `json:"index"`
+	Name         string `json:"name"`
+	Active       bool   `json:"active"`
+	PaneCount    int    `json:"pane_count"`
+	TmuxWindowID string `json:"-"`
 }
 
 type CreatePaneOptions struct {
 	Env map[string]string `json:"env,omitempty"`
 	Cwd string            `json:"cwd,omitempty"`
 	Cmd []string          `json:"cmd,omitempty"`
+	// Size is the new pane's size: a cell count ("12") or a percentage of
+	// the split pane ("30%"). Empty leaves tmux's default half.
+	Size string `json:"size,omitempty"`
+	// Cursor also queries the new pane's cursor, at the cost of one more
+	// tmux round trip, and reports it in PaneInfo.Cursor.
+	Cursor bool `json:"-"`
+	// WaitReady, when set, makes CreatePane wait for the new pane to look
+	// ready for input (see WaitPaneReady) and report it in PaneInfo.Ready.
+	WaitReady *PaneReadySignal `json:"-"`
+}
+
+const (
+	maxPaneSizeCells   = 1000
+	maxPaneSizePercent = 99
+)
+
+// ParsePaneSize validates a CreatePaneOptions.Size value and returns the
+// number and whether it is a percentage.
+func ParsePaneSize(size string) (int, bool, error) {
+	digits, percent := strings.CutSuffix(strings.TrimSpace(size), "%")
+	n, err := strconv.Atoi(digits)
+	if err != nil || strings.HasPrefix(digits, "+") {
+		return 0, false, fmt.Errorf("invalid size %q: want a cell count or a percentage like 30%%", size)
+	}
+	if percent && (n < 1 || n > maxPaneSizePercent) {
+		return 0, false, fmt.Errorf("invalid size %q: percentage must be between 1 and %d", size, maxPaneSizePercent)
+	}
+	if !percent && (n < 1 || n > maxPaneSizeCells) {
+		return 0, false, fmt.Errorf("invalid size %q: cell count must be between 1 and %d", size, maxPaneSizeCells)
+	}
+	return n, percent, nil
 }
 
 type client struct {
-	conn      *websocket.Conn
-	send      chan serverMsg
-	closeOnce sync.Once
+	conn       *websocket.Conn
+	send       chan serverMsg
+	maxMessage int64
+	// sendMu orders sends on send against its close: enqueue holds it for
+	// reading, close for writing, so no send can reach a closed channel.
+	sendMu sync.RWMutex
+	closed bool
+
+	// lineMode, set by line-mode, delivers pane_output as whole lines;
+	// linePartial holds each pane's incomplete last line meanwhile.
+	lineMu      sync.Mutex
+	lineMode    bool
+	linePartial map[string]string
+
+	id          int64
+	remoteAddr  string
+	connectedAt time.Time
+	// protocol is the negotiated WebSocket subprotocol.
+	protocol string
+
+	metaMu       sync.Mutex
+	name         string
+	messagesSent int64
+	// focusWindow is the tmux window id ("@3") set by focus-window; empty
+	// means the client receives every window.
+	focusWindow string
+	// rawOutput, set by set-encoding, delivers pane_output as base64 of the
+	// raw pane bytes instead of UTF-8 text.
+	rawOutput bool
+	// outputTimestamps, set by output-timestamps, adds ts to pane_output.
+	outputTimestamps bool
+	// term is the terminal type from hello ("xterm-256color", "ghostty"),
+	// kept for diagnostics and future capability-aware output.
+	term string
+}
+
+func (c *client) focusedWindow() string {
+	c.metaMu.Lock()
+	defer c.metaMu.Unlock()
+	return c.focusWindow
 }
 
+// ClientInfo describes a connected WebSocket client for diagnostics.
+type ClientInfo struct {
+	ID           int64  `json:"id"`
+	Name         string `json:"name,omitempty"`
+	RemoteAddr   string `json:"remote_addr"`
+	ConnectedAt  string `json:"connected_at"`
+	MessagesSent int64  `json:"messages_sent"`
+	FocusWindow  string `json:"focus_window,omitempty"`
+	LineMode     bool   `json:"line_mode,omitempty"`
+	// Encoding is "raw" when set via set-encoding; empty means utf8.
+	Encoding string `json:"encoding,omitempty"`
+	// Timestamps is set when the client enabled output-timestamps.
+	Timestamps bool `json:"timestamps,omitempty"`
+	// Protocol is the negotiated WebSocket subprotocol.
+	Protocol string `json:"protocol,omitempty"`
+	// Term is the terminal type the client reported in hello.
+	Term string `json:"term,omitempty"`
+}
+
+const (
+	maxClientNameLength = 128
+	maxClientTermLength = 64
+)
+
+// clientMsg is a message from a /ws client. Name and Term are pointers so a
+// hello can leave either unchanged by omitting it.
 type clientMsg struct {
-	T    string   `json:"t"`
-	Argv []string `json:"argv"`
+	T        string   `json:"t"`
+	Argv     []string `json:"argv"`
+	Name     *string  `json:"name,omitempty"`
+	Term     *string  `json:"term,omitempty"`
+	WindowID string   `json:"window_id,omitempty"`
+	Enabled  bool     `json:"enabled,omitempty"`
+	Mode     string   `json:"mode,omitempty"`
 }
 
 type serverMsg struct {
@@ -76,7 +331,39 @@ type serverMsg struct {
 	PaneOutput   *paneOutputPayload   `json:"pane_output,omitempty"`
 	PaneSnapshot *paneSnapshotPayload `json:"pane_snapshot,omitempty"`
 	PaneCursor   *paneCursorPayload   `json:"pane_cursor,omitempty"`
+	PaneLayout   *paneLayoutPayload   `json:"pane_layout,omitempty"`
+	PaneDead     *paneDeadPayload     `json:"pane_dead,omitempty"`
+	PaneFocus    *paneFocusPayload    `json:"pane_focus,omitempty"`
 	State        *statePayload        `json:"state,omitempty"`
+	Protocol     *protocolPayload     `json:"protocol,omitempty"`
+	Reconnect    *reconnectPayload    `json:"reconnect,omitempty"`
+	ClientSize   *ClientSize          `json:"client_size,omitempty"`
+}
+
+// reconnectPayload describes the tmux manager's next reconnect attempt.
+// RetryInMS is computed when the message is built, so clients need not
+// trust their own clock against NextRetry.
+type reconnectPayload struct {
+	Attempt   int    `json:"attempt"`
+	NextRetry string `json:"next_retry"`
+	RetryInMS int64  `json:"retry_in_ms"`
+}
+
+func newReconnectPayload(attempt int, nextRetry time.Time) *reconnectPayload {
+	return &reconnectPayload{
+		Attempt:   attempt,
+		NextRetry: nextRetry.UTC().Format(time.RFC3339Nano),
+		RetryInMS: max(0, time.Until(nextRetry).Milliseconds()),
+	}
+}
+
+// protocolPayload tells clients the negotiated protocol version and which
+// sentinel to use in their own list-panes and cursor format strings.
+type protocolPayload struct {
+	// Version is the negotiated WebSocket subprotocol, e.g. "wmux.v1".
+	Version      string `json:"version"`
+	Sentinel     string `json:"sentinel"`
+	CursorMarker string `json:"cursor_marker"`
 }
 
 type commandPayload struct {
@@ -85,6 +372,9 @@ type commandPayload struct {
 	Flags        int64    `json:"flags"`
 	Success      bool     `json:"success"`
 	Output       []string `json:"output"`
+	// Error is tmux's error message for a failed command, the %error
+	// output joined by newlines. It is empty on success.
+	Error string `json:"error,omitempty"`
 }
 
 type notificationPayload struct {
@@ -97,6 +387,15 @@ type notificationPayload struct {
 type paneOutputPayload struct {
 	PaneID string `json:"pane_id"`
 	Data   string `json:"data"`
+	// Encoding is "base64" for clients in raw mode and empty otherwise.
+	Encoding string `json:"encoding,omitempty"`
+	// TS is the wall-clock time in Unix nanoseconds at which the hub parsed
+	// the %output, for clients that enabled output-timestamps.
+	TS int64 `json:"ts,omitempty"`
+	// raw is the chunk's undecoded bytes, for clients in raw mode.
+	raw []byte
+	// receivedAt is when the hub parsed the %output; it becomes TS.
+	receivedAt time.Time
 }
 
 type paneSnapshotPayload struct {
@@ -110,11 +409,59 @@ type paneCursorPayload struct {
 	Y      int    `json:"y"`
 }
 
+// paneDeadPayload reports a pane whose process exited while the pane remains
+// (remain-on-exit).
+type paneDeadPayload struct {
+	PaneID string `json:"pane_id"`
+	Status int    `json:"status"`
+}
+
+// paneFocusPayload reports a pane gaining or losing focus, derived from
+// pane_active and window_active changes between state syncs.
+type paneFocusPayload struct {
+	PaneID  string `json:"pane_id"`
+	Focused bool   `json:"focused"`
+}
+
+type paneLayoutPayload struct {
+	WindowID string                `json:"window_id"`
+	Panes    []paneGeometryPayload `json:"panes"`
+}
+
+type paneGeometryPayload struct {
+	PaneID string `json:"pane_id"`
+	Left   int    `json:"left"`
+	Top    int    `json:"top"`
+	Width  int    `json:"width"`
+	Height int    `json:"height"`
+}
+
 type pendingCommand struct {
+	// id identifies the entry for dropPending; assigned by sendPending.
+	id               uint64
 	Name             string
 	TargetPane       string
 	EmitPaneSnapshot bool
 	Wait             chan commandResult
+	QueuedAt         time.Time
+	// ClientSize is the size a refresh-client -C sets, applied when the
+	// command succeeds.
+	ClientSize *ClientSize
+	// Requester is the WebSocket client that sent the command, nil for
+	// commands wmux issued itself.
+	Requester *client
+}
+
+// PendingCommandInfo is a serializable view of a command awaiting its tmux
+// response, for diagnostics.
+type PendingCommandInfo struct {
+	Name       string `json:"name"`
+	TargetPane string `json:"target_pane,omitempty"`
+	// Internal is true for commands issued by wmux itself (for example
+	// via the HTTP API) rather than by a WebSocket client.
+	Internal bool   `json:"internal"`
+	QueuedAt string `json:"queued_at"`
+	AgeMS    int64  `json:"age_ms"`
 }
 
 type commandResult struct {
@@ -128,10 +475,90 @@ var upgrader = websocket.Upgrader{
 
 var safeBareToken = regexp.MustCompile(`^[A-Za-z0-9_@%:./+\-]+$`)
 
-const paneModelFormat = "__WMUX___pane\t#{session_name}\t#{pane_id}\t#{window_id}\t#{pane_index}\t#{pane_active}\t#{pane_left}\t#{pane_top}\t#{pane_width}\t#{pane_height}\t#{pane_current_command}\t#{pane_title}\t#{window_index}\t#{window_name}"
+// paneModelFormat returns the list-panes format whose rows applyOutputLines
+// recognizes for the given sentinel prefix, with any extra fields appended
+// after the known ones.
+func paneModelFormat(sentinel string, extra []string) string {
+	format := sentinel + "_pane\t#{session_name}\t#{pane_id}\t#{window_id}\t#{pane_index}\t#{pane_active}\t#{pane_left}\t#{pane_top}\t#{pane_width}\t#{pane_height}\t#{pane_current_command}\t#{pane_title}\t#{window_index}\t#{window_name}\t#{window_active}\t#{pane_dead}\t#{pane_dead_status}\t#{pane_pid}\t#{pane_start_command}"
+	for _, name := range extra {
+		format += "\t#{" + name + "}"
+	}
+	return format
+}
+
+var validExtraPaneField = regexp.MustCompile(`^[a-z][a-z0-9_]{0,63}$`)
+
+// ParseExtraPaneFields parses a comma-separated list of tmux format variable
+// names for Options.ExtraPaneFields. Names are lowercase identifiers such as
+// pane_tty; duplicates are dropped and an empty list yields nil.
+func ParseExtraPaneFields(list string) ([]string, error) {
+	var out []string
+	seen := map[string]struct{}{}
+	for _, name := range strings.Split(list, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		if !validExtraPaneField.MatchString(name) {
+			return nil, fmt.Errorf("invalid pane field %q: want a tmux format variable name like pane_tty", name)
+		}
+		if _, ok := seen[name]; ok {
+			continue
+		}
+		seen[name] = struct{}{}
+		out = append(out, name)
+	}
+	return out, nil
+}
+
+// cursorMarker returns the display-message marker parsePaneCursorOutput
+// expects for the given sentinel prefix ("__WMUX__" -> "__WMUX_CURSOR").
+func cursorMarker(sentinel string) string {
+	return strings.TrimRight(sentinel, "_") + "_CURSOR"
+}
+
+var validSentinel = regexp.MustCompile(`^[A-Za-z0-9_]{4,64}$`)
+
+// RandomSentinel returns a per-run sentinel prefix so pane output cannot
+// collide with wmux's internal format records.
+func RandomSentinel() string {
+	b := make([]byte, 6)
+	if _, err := rand.Read(b); err != nil {
+		return DefaultSentinel
+	}
+	return "__WMUX_" + hex.EncodeToString(b) + "__"
+}
+
+// ValidateSentinel reports whether s can be used as a sentinel prefix.
+func ValidateSentinel(s string) error {
+	if !validSentinel.MatchString(s) {
+		return fmt.Errorf("sentinel must be 4-64 characters of [A-Za-z0-9_]")
+	}
+	return nil
+}
 
 func New(p policy.Policy, targetSession string) *Hub {
+	return NewWithOptions(p, targetSession, Options{})
+}
+
+func NewWithOptions(p policy.Policy, targetSession string, opts Options) *Hub {
+	if opts.ResyncDebounce <= 0 {
+		opts.ResyncDebounce = DefaultResyncDebounce
+	}
+	if opts.CreatePaneTimeout <= 0 {
+		opts.CreatePaneTimeout = DefaultCreatePaneTimeout
+	}
+	if opts.Sentinel == "" {
+		opts.Sentinel = DefaultSentinel
+	}
+	if opts.MaxCaptureBytes <= 0 {
+		opts.MaxCaptureBytes = DefaultMaxCaptureBytes
+	}
+	if opts.CaptureOverflow == "" {
+		opts.CaptureOverflow = CaptureTruncate
+	}
 	h := &Hub{
+		opts:              opts,
 		policy:            p,
 		clients:           map[*client]struct{}{},
 		model:             newModelState(),
@@ -139,7 +566,12 @@ func New(p policy.Policy, targetSession string) *Hub {
 		targetSession:     targetSession,
 		unavailableReason: "waiting for tmux target",
 		outputUTF8Carry:   map[string][]byte{},
+		outputSubs:        map[chan PaneOutput]struct{}{},
+		hiddenPanes:       map[string]struct{}{},
+		clientSize:        DefaultClientSize,
 	}
+	h.model.prefix = opts.Sentinel
+	h.model.extraFields = opts.ExtraPaneFields
 	h.resetParser()
 	return h
 }
@@ -150,19 +582,12 @@ func (h *Hub) BindTmux(tmux TmuxSender) error {
 }
 
 func (h *Hub) RequestStateSync() error {
-	argv := []string{"list-panes", "-a", "-F", paneModelFormat}
+	argv := []string{"list-panes", "-a", "-F", paneModelFormat(h.opts.Sentinel, h.opts.ExtraPaneFields)}
 	line, err := encodeArgvCommand(argv)
 	if err != nil {
 		return err
 	}
-	if h.tmux == nil {
-		return fmt.Errorf("tmux backend unavailable")
-	}
-	if err := h.tmux.Send(line); err != nil {
-		return err
-	}
-	h.registerPending(argv)
-	return nil
+	return h.sendPending(pendingFromArgv(argv), line)
 }
 
 func (h *Hub) RequestStateSyncWithRetry() {
@@ -174,26 +599,37 @@ func (h *Hub) RequestStateSyncWithRetry() {
 	}
 }
 
-func (h *Hub) RefreshState(timeout time.Duration) error {
-	argv := []string{"list-panes", "-a", "-F", paneModelFormat}
-	res, err := h.runCommandAndWait(argv, timeout, false)
-	if err != nil {
+func (h *Hub) RefreshState(ctx context.Context, timeout time.Duration) error {
+	argv := []string{"list-panes", "-a", "-F", paneModelFormat(h.opts.Sentinel, h.opts.ExtraPaneFields)}
+	if _, err := h.runCommandAndWait(ctx, argv, timeout, false); err != nil {
 		return err
 	}
-	if !res.Success {
-		return fmt.Errorf("list-panes failed")
-	}
 	return nil
 }
 
 func (h *Hub) CurrentState() statePayload {
+	state, _ := h.currentStateAndSynced()
+	return state
+}
+
+// currentStateAndSynced returns CurrentState together with whether the
+// initial sync has completed, read under one lock so a connecting client
+// sees a consistent pair.
+func (h *Hub) currentStateAndSynced() (statePayload, bool) {
 	h.mu.RLock()
 	defer h.mu.RUnlock()
 	state := filterStateToTargetSession(h.model.snapshot(), h.targetSession)
 	if h.unavailableReason != "" {
 		state.Unavailable = &tmuxUnavailableState{Reason: h.unavailableReason}
 	}
-	return state
+	return state, h.synced
+}
+
+// Synced reports whether a list-panes result has been applied since tmux
+// last (re)connected. Until then an absent pane may simply not be known yet.
+func (h *Hub) Synced() bool {
+	_, synced := h.currentStateAndSynced()
+	return synced
 }
 
 func (h *Hub) CurrentTargetSessionPanes() []panePayload {
@@ -216,34 +652,302 @@ func filterStateToTargetSession(state statePayload, targetSession string) stateP
 	}
 
 	filteredWindows := make([]windowPayload, 0, len(state.Windows))
+	sawActive := false
 	for _, window := range state.Windows {
 		if _, ok := windowIDs[window.ID]; ok {
+			// A session has exactly one active window; keep the first one
+			// marked active and clear any stale duplicates.
+			if window.Active && sawActive {
+				window.Active = false
+			}
+			sawActive = sawActive || window.Active
 			filteredWindows = append(filteredWindows, window)
 		}
 	}
+	if !sawActive && len(filteredWindows) > 0 {
+		filteredWindows[0].Active = true
+	}
 
 	return statePayload{Windows: filteredWindows, Panes: filteredPanes, Unavailable: state.Unavailable}
 }
 
+// CurrentTargetSessionPaneInfos returns the target session's panes, leaving
+// out any hidden with HidePane.
 func (h *Hub) CurrentTargetSessionPaneInfos() []PaneInfo {
 	panes := h.CurrentTargetSessionPanes()
 	out := make([]PaneInfo, 0, len(panes))
 	for _, pane := range panes {
+		if h.paneHidden(pane.ID) {
+			continue
+		}
 		out = append(out, PaneInfo{
-			PaneID:      publicPaneID(pane.ID),
-			PaneIndex:   pane.PaneIndex,
-			TmuxPaneID:  pane.ID,
-			Name:        pane.Name,
-			SessionName: pane.SessionName,
-			WindowIndex: pane.WindowIndex,
-			WindowName:  pane.WindowName,
-			Width:       pane.Width,
-			Height:      pane.Height,
+			PaneID:       publicPaneID(pane.ID),
+			PaneIndex:    pane.PaneIndex,
+			TmuxPaneID:   pane.ID,
+			TmuxWindowID: pane.WindowID,
+			Name:         pane.Name,
+			SessionName:  pane.SessionName,
+			WindowIndex:  pane.WindowIndex,
+			WindowName:   pane.WindowName,
+			Width:        pane.Width,
+			Height:       pane.Height,
+			Dead:         pane.Dead,
+			DeadStatus:   pane.DeadStatus,
+			PID:          pane.PID,
+			StartCommand: pane.StartCommand,
+			Extra:        maps.Clone(pane.Extra),
+		})
+	}
+	return out
+}
+
+func (h *Hub) CurrentTargetSessionWindowInfos() []WindowInfo {
+	state := h.CurrentState()
+	paneCounts := make(map[string]int, len(state.Windows))
+	for _, pane := range state.Panes {
+		if h.paneHidden(pane.ID) {
+			continue
+		}
+		paneCounts[pane.WindowID]++
+	}
+	out := make([]WindowInfo, 0, len(state.Windows))
+	for _, window := range state.Windows {
+		out = append(out, WindowInfo{
+			WindowID:     publicWindowID(window.ID),
+			Index:        window.Index,
+			Name:         window.Name,
+			Active:       window.Active,
+			PaneCount:    paneCounts[window.ID],
+			TmuxWindowID: window.ID,
 		})
 	}
 	return out
 }
 
+// TargetSessionWindowByPublicID returns the target-session window with the
+// given public id together with its member panes.
+func (h *Hub) TargetSessionWindowByPublicID(windowID string) (WindowInfo, []PaneInfo, bool) {
+	windowID = strings.TrimSpace(windowID)
+	if windowID == "" {
+		return WindowInfo{}, nil, false
+	}
+	for _, window := range h.CurrentTargetSessionWindowInfos() {
+		if window.WindowID != windowID {
+			continue
+		}
+		var panes []PaneInfo
+		for _, pane := range h.CurrentTargetSessionPaneInfos() {
+			if pane.TmuxWindowID == window.TmuxWindowID {
+				panes = append(panes, pane)
+			}
+		}
+		return window, panes, true
+	}
+	return WindowInfo{}, nil, false
+}
+
+// RenameWindow runs rename-window for tmuxWindowID and waits for tmux to
+// acknowledge it.
+func (h *Hub) RenameWindow(ctx context.Context, tmuxWindowID, name string) error {
+	if _, err := h.runCommandAndWait(ctx, []string{"rename-window", "-t", tmuxWindowID, name}, 5*time.Second, false); err != nil {
+		return err
+	}
+	return nil
+}
+
+// ErrNotTargetSession is returned by KillSession for a session name other
+// than the target session.
+var ErrNotTargetSession = errors.New("session is not the target session")
+
+// KillSession asks tmux to kill the target session, which the caller names
+// as session to confirm it. It returns once the command is sent rather than
+// waiting for a reply: the control client is attached to that session, so
+// tmux answers with %exit, and the usual disconnect and reconnect handling
+// takes over.
+func (h *Hub) KillSession(ctx context.Context, session string) error {
+	if session != h.targetSession {
+		return ErrNotTargetSession
+	}
+	if _, err := h.startCommand(ctx, []string{"kill-session", "-t", h.targetSession}, false); err != nil {
+		return err
+	}
+	reqid.Logger(ctx, h.logger()).Info("killing target session", "session", h.targetSession)
+	return nil
+}
+
+// paneOpTarget in a paneOperations argv is replaced with the tmux pane id.
+const paneOpTarget = "{pane}"
+
+// paneOperations are named shortcuts for common pane actions, each a fixed
+// sequence of tmux commands.
+var paneOperations = map[string][][]string{
+	"clear":     {{"send-keys", "-t", paneOpTarget, "C-l"}},
+	"interrupt": {{"send-keys", "-t", paneOpTarget, "C-c"}},
+	"eof":       {{"send-keys", "-t", paneOpTarget, "C-d"}},
+}
+
+// ErrUnknownPaneOperation is returned for names not in PaneOperations.
+var ErrUnknownPaneOperation = errors.New("unknown pane operation")
+
+// PaneOperations returns the named pane operations in sorted order.
+func PaneOperations() []string {
+	out := make([]string, 0, len(paneOperations))
+	for name := range paneOperations {
+		out = append(out, name)
+	}
+	sort.Strings(out)
+	return out
+}
+
+// PaneOperationAllowed reports whether the policy permits every command
+// the operation runs.
+func (h *Hub) PaneOperationAllowed(name string) bool {
+	steps, ok := paneOperations[name]
+	if !ok {
+		return false
+	}
+	p := h.Policy()
+	for _, argv := range steps {
+		if p.ValidateCommand(argv[0]) != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// RunPaneOperation runs the named operation against tmuxPaneID. The whole
+// operation is rejected up front if policy blocks any of its commands.
+func (h *Hub) RunPaneOperation(ctx context.Context, tmuxPaneID, name string) error {
+	steps, ok := paneOperations[name]
+	if !ok {
+		return ErrUnknownPaneOperation
+	}
+	p := h.Policy()
+	for _, argv := range steps {
+		if err := p.ValidateCommand(argv[0]); err != nil {
+			return err
+		}
+	}
+	for _, step := range steps {
+		argv := make([]string, len(step))
+		for i, arg := range step {
+			if arg == paneOpTarget {
+				arg = tmuxPaneID
+			}
+			argv[i] = arg
+		}
+		if _, err := h.runCommandAndWait(ctx, argv, 5*time.Second, false); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SendKeys types literal into tmuxPaneID. Text is sent with send-keys -l;
+// control characters cannot appear in a control-mode command line, so they
+// are sent as named keys (Enter, Tab, Escape, BSpace, C-a..C-z) or, failing
+// that, as a hex key with -H. It fails if policy blocks send-keys.
+func (h *Hub) SendKeys(ctx context.Context, tmuxPaneID, literal string) error {
+	if err := h.Policy().ValidateCommand("send-keys"); err != nil {
+		return err
+	}
+	for _, argv := range sendKeysSteps(tmuxPaneID, literal) {
+		if _, err := h.runCommandAndWait(ctx, argv, 5*time.Second, false); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func sendKeysSteps(tmuxPaneID, literal string) [][]string {
+	var steps [][]string
+	var text strings.Builder
+	var prev rune
+	flush := func() {
+		if text.Len() > 0 {
+			steps = append(steps, []string{"send-keys", "-t", tmuxPaneID, "-l", text.String()})
+			text.Reset()
+		}
+	}
+	for _, r := range literal {
+		crlf := r == '\n' && prev == '\r'
+		prev = r
+		if r >= 0x20 && r != 0x7f {
+			text.WriteRune(r)
+			continue
+		}
+		if crlf {
+			continue
+		}
+		flush()
+		steps = append(steps, append([]string{"send-keys", "-t", tmuxPaneID}, controlKey(r)...))
+	}
+	flush()
+	return steps
+}
+
+// controlKey returns the send-keys arguments for control character r.
+func controlKey(r rune) []string {
+	switch {
+	case r == '\r' || r == '\n':
+		return []string{"Enter"}
+	case r == '\t':
+		return []string{"Tab"}
+	case r == 0x1b:
+		return []string{"Escape"}
+	case r == 0x7f:
+		return []string{"BSpace"}
+	case r >= 0x01 && r <= 0x1a:
+		return []string{"C-" + string(rune('a'+r-1))}
+	}
+	return []string{"-H", fmt.Sprintf("%02x", r)}
+}
+
+// SetSessionOption runs set-option for name on the target session. It
+// bypasses the command policy, so callers must validate name and value
+// first (see policy.OptionPolicy).
+func (h *Hub) SetSessionOption(ctx context.Context, name, value string) error {
+	argv := []string{"set-option", "-t", h.targetSession, name, value}
+	if _, err := h.runCommandAndWait(ctx, argv, 5*time.Second, false); err != nil {
+		return err
+	}
+	return nil
+}
+
+// SetBuffer stores data in a tmux paste buffer, the named one or, when name
+// is empty, a new automatically named buffer.
+func (h *Hub) SetBuffer(ctx context.Context, name, data string) error {
+	if err := h.Policy().ValidateCommand("set-buffer"); err != nil {
+		return err
+	}
+	argv := []string{"set-buffer"}
+	if name != "" {
+		argv = append(argv, "-b", name)
+	}
+	argv = append(argv, "--", data)
+	if _, err := h.runCommandAndWait(ctx, argv, 5*time.Second, false); err != nil {
+		return err
+	}
+	return nil
+}
+
+// PasteBuffer pastes a tmux paste buffer, the named one or, when name is
+// empty, the most recent, into tmuxPaneID. Bracketed paste is used when
+// the pane's application has asked for it.
+func (h *Hub) PasteBuffer(ctx context.Context, tmuxPaneID, name string) error {
+	if err := h.Policy().ValidateCommand("paste-buffer"); err != nil {
+		return err
+	}
+	argv := []string{"paste-buffer", "-p", "-t", tmuxPaneID}
+	if name != "" {
+		argv = append(argv, "-b", name)
+	}
+	if _, err := h.runCommandAndWait(ctx, argv, 5*time.Second, false); err != nil {
+		return err
+	}
+	return nil
+}
+
 func (h *Hub) CurrentUnavailableReason() string {
 	h.mu.RLock()
 	defer h.mu.RUnlock()
@@ -263,37 +967,222 @@ func (h *Hub) TargetSessionPaneIDByPublicID(paneID string) (string, bool) {
 	return "", false
 }
 
+// HidePane stops wmux from exposing tmuxPaneID: it is left out of
+// CurrentTargetSessionPaneInfos, /api/output and the /ws stream, and
+// TargetSessionPaneIDByPublicID no longer resolves it. WebSocket clients get
+// a tmux_state without it. The pane itself keeps running. The hidden set
+// lives in memory only.
+func (h *Hub) HidePane(tmuxPaneID string) {
+	h.mu.Lock()
+	h.hiddenPanes[tmuxPaneID] = struct{}{}
+	h.mu.Unlock()
+	h.broadcastState(statePointer(h.CurrentState()))
+}
+
+// UnhidePane reverses HidePane. It reports whether the pane was hidden.
+func (h *Hub) UnhidePane(tmuxPaneID string) bool {
+	h.mu.Lock()
+	if _, ok := h.hiddenPanes[tmuxPaneID]; !ok {
+		h.mu.Unlock()
+		return false
+	}
+	delete(h.hiddenPanes, tmuxPaneID)
+	h.mu.Unlock()
+	h.broadcastState(statePointer(h.CurrentState()))
+	return true
+}
+
+// HiddenPanes returns the public ids of hidden panes, sorted numerically.
+func (h *Hub) HiddenPanes() []string {
+	h.mu.RLock()
+	out := make([]string, 0, len(h.hiddenPanes))
+	for id := range h.hiddenPanes {
+		out = append(out, publicPaneID(id))
+	}
+	h.mu.RUnlock()
+	sort.Slice(out, func(i, j int) bool {
+		a, _ := strconv.Atoi(out[i])
+		b, _ := strconv.Atoi(out[j])
+		return a < b
+	})
+	return out
+}
+
+func (h *Hub) paneHidden(tmuxPaneID string) bool {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	_, ok := h.hiddenPanes[tmuxPaneID]
+	return ok
+}
+
 func publicPaneID(tmuxPaneID string) string {
 	return strings.TrimPrefix(strings.TrimSpace(tmuxPaneID), "%")
 }
 
-func (h *Hub) CapturePaneContent(paneID string, withEscapes bool) (string, error) {
+func publicWindowID(tmuxWindowID string) string {
+	return strings.TrimPrefix(strings.TrimSpace(tmuxWindowID), "@")
+}
+
+func (h *Hub) CapturePaneContent(ctx context.Context, paneID string, withEscapes bool) (string, error) {
+	return h.CapturePane(ctx, paneID, CaptureOptions{Escapes: withEscapes})
+}
+
+// CaptureOptions selects capture-pane flags for CapturePane.
+type CaptureOptions struct {
+	// Escapes includes text and background attributes (-e).
+	Escapes bool
+	// JoinWrapped joins visually wrapped lines into their logical line
+	// (-J).
+	JoinWrapped bool
+	// Start, when set, is the first line captured (-S): 0 is the top of the
+	// visible screen and negative lines reach back into history.
+	Start *int
+}
+
+// CapturePane returns paneID's visible contents via capture-pane -p -N.
+func (h *Hub) CapturePane(ctx context.Context, paneID string, opts CaptureOptions) (string, error) {
 	paneID = strings.TrimSpace(paneID)
 	if paneID == "" {
 		return "", fmt.Errorf("pane id is required")
 	}
 
-	argv := []string{"capture-pane", "-p", "-N", "-t", paneID}
-	if withEscapes {
-		argv = []string{"capture-pane", "-p", "-e", "-N", "-t", paneID}
+	argv := []string{"capture-pane", "-p"}
+	if opts.Escapes {
+		argv = append(argv, "-e")
+	}
+	if opts.JoinWrapped {
+		argv = append(argv, "-J")
+	}
+	if opts.Start != nil {
+		argv = append(argv, "-S", strconv.Itoa(*opts.Start))
 	}
+	argv = append(argv, "-N", "-t", paneID)
 
-	res, err := h.runCommandAndWait(argv, 5*time.Second, false)
+	res, err := h.runCommandAndWait(ctx, argv, 5*time.Second, false)
 	if err != nil {
 		return "", err
 	}
-	if !res.Success {
-		if withEscapes {
-			return "", fmt.Errorf("capture-pane with escapes failed")
+
+	return joinCapture(res.Output, h.opts.MaxCaptureBytes, h.opts.CaptureOverflow)
+}
+
+// DefaultMaxCaptureBytes is used when Options.MaxCaptureBytes is unset.
+const DefaultMaxCaptureBytes = 5 << 20
+
+// Options.CaptureOverflow modes.
+const (
+	// CaptureTruncate keeps the leading lines that fit and appends a
+	// marker line.
+	CaptureTruncate = "truncate"
+	// CaptureError fails the capture with ErrCaptureTooLarge.
+	CaptureError = "error"
+)
+
+// ErrCaptureTooLarge is returned by CapturePane when the capture exceeds
+// Options.MaxCaptureBytes in CaptureError mode.
+var ErrCaptureTooLarge = errors.New("capture exceeds the size limit")
+
+// joinCapture joins capture-pane output lines with newlines, stopping once
+// max bytes are reached. Lines that fit are kept whole; in truncate mode a
+// marker line saying so follows them.
+func joinCapture(lines []string, max int, overflow string) (string, error) {
+	var b strings.Builder
+	for i, line := range lines {
+		n := len(line)
+		if i > 0 {
+			n++
+		}
+		if b.Len()+n > max {
+			if overflow == CaptureError {
+				return "", ErrCaptureTooLarge
+			}
+			if b.Len() > 0 {
+				b.WriteByte('\n')
+			}
+			fmt.Fprintf(&b, "[wmux: capture truncated at %d bytes; %d of %d lines omitted]", max, len(lines)-i, len(lines))
+			return b.String(), nil
 		}
-		return "", fmt.Errorf("capture-pane without escapes failed")
+		if i > 0 {
+			b.WriteByte('\n')
+		}
+		b.WriteString(line)
+	}
+	return b.String(), nil
+}
+
+// DisplayPaneFormat expands a tmux format string (for example
+// "#{pane_pid}") in the context of paneID via display-message -p.
+func (h *Hub) DisplayPaneFormat(ctx context.Context, paneID, format string) (string, error) {
+	paneID = strings.TrimSpace(paneID)
+	if paneID == "" {
+		return "", fmt.Errorf("pane id is required")
 	}
 
+	res, err := h.runCommandAndWait(ctx, []string{"display-message", "-p", "-t", paneID, format}, 5*time.Second, false)
+	if err != nil {
+		return "", err
+	}
 	return strings.Join(res.Output, "\n"), nil
 }
 
+// HistorySize returns the number of lines in paneID's scrollback history,
+// tmux's #{history_size}.
+func (h *Hub) HistorySize(ctx context.Context, paneID string) (int, error) {
+	value, err := h.DisplayPaneFormat(ctx, paneID, "#{history_size}")
+	if err != nil {
+		return 0, err
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(value))
+	if err != nil {
+		return 0, fmt.Errorf("unexpected history_size %q", value)
+	}
+	return n, nil
+}
+
+// PaneCursor is a pane's cursor position in cells, 0-based from the top
+// left of the visible screen.
+type PaneCursor struct {
+	X int `json:"x"`
+	Y int `json:"y"`
+}
+
+// QueryPaneCursor asks tmux for paneID's cursor position with the same
+// marker-tagged display-message the web UI sends.
+func (h *Hub) QueryPaneCursor(ctx context.Context, paneID string) (PaneCursor, error) {
+	marker := cursorMarker(h.opts.Sentinel)
+	value, err := h.DisplayPaneFormat(ctx, paneID, marker+"\t#{pane_cursor_x}\t#{pane_cursor_y}")
+	if err != nil {
+		return PaneCursor{}, err
+	}
+	cursor, ok := parsePaneCursorOutput(strings.Split(value, "\n"), marker)
+	if !ok {
+		return PaneCursor{}, fmt.Errorf("unexpected cursor reply %q", value)
+	}
+	return PaneCursor{X: cursor.X, Y: cursor.Y}, nil
+}
+
 func (h *Hub) CreatePane(opts CreatePaneOptions) (PaneInfo, error) {
+	return h.CreatePaneContext(context.Background(), opts)
+}
+
+// CreatePaneContext is CreatePane bounded by ctx. When ctx has no deadline
+// the split-window is bounded by the internal create timeout instead, and
+// ErrCommandTimeout is returned once it passes. When ctx ends first its error
+// is returned. Either way the split-window is still watched for a late
+// response.
+func (h *Hub) CreatePaneContext(ctx context.Context, opts CreatePaneOptions) (PaneInfo, error) {
 	argv := []string{"split-window", "-P", "-F", "#{pane_id}", "-t", h.targetSession}
+	if opts.Size != "" {
+		n, percent, err := ParsePaneSize(opts.Size)
+		if err != nil {
+			return PaneInfo{}, err
+		}
+		if percent {
+			argv = append(argv, "-p", strconv.Itoa(n))
+		} else {
+			argv = append(argv, "-l", strconv.Itoa(n))
+		}
+	}
 	if strings.TrimSpace(opts.Cwd) != "" {
 		argv = append(argv, "-c", opts.Cwd)
 	}
@@ -308,55 +1197,231 @@ func (h *Hub) CreatePane(opts CreatePaneOptions) (PaneInfo, error) {
 		}
 	}
 	if len(opts.Cmd) > 0 {
-		argv = append(argv, joinShellCommand(opts.Cmd))
+		argv = append(argv, JoinShellCommand(opts.Cmd))
 	}
 
-	res, err := h.runCommandAndWait(argv, 5*time.Second, false)
+	done, err := h.startCommand(ctx, argv, false)
 	if err != nil {
 		return PaneInfo{}, err
 	}
-	if !res.Success {
-		return PaneInfo{}, fmt.Errorf("split-window failed")
+	// A caller's deadline replaces the internal timeout rather than racing
+	// it, so the caller sees its own deadline expire.
+	var timeout <-chan time.Time
+	if _, ok := ctx.Deadline(); !ok {
+		timer := time.NewTimer(h.opts.CreatePaneTimeout)
+		defer timer.Stop()
+		timeout = timer.C
 	}
-
-	tmuxPaneID := ""
-	for i := len(res.Output) - 1; i >= 0; i-- {
-		candidate := strings.TrimSpace(res.Output[i])
-		if candidate != "" {
-			tmuxPaneID = candidate
-			break
-		}
+	var res commandResult
+	select {
+	case res = <-done:
+	case <-timeout:
+		go h.handleOrphanedCreate(done)
+		return PaneInfo{}, ErrCommandTimeout
+	case <-ctx.Done():
+		go h.handleOrphanedCreate(done)
+		return PaneInfo{}, ctx.Err()
 	}
+	if !res.Success {
+		return PaneInfo{}, commandFailure("split-window", res.Output)
+	}
+
+	tmuxPaneID := lastNonEmptyLine(res.Output)
 	paneID := publicPaneID(tmuxPaneID)
 	if paneID == "" {
 		return PaneInfo{}, fmt.Errorf("split-window did not return pane id")
 	}
 
-	_ = h.RequestStateSync()
-	return PaneInfo{PaneID: paneID, TmuxPaneID: tmuxPaneID}, nil
+	h.scheduleResync()
+	pane := PaneInfo{PaneID: paneID, TmuxPaneID: tmuxPaneID}
+	if opts.WaitReady != nil {
+		// Not being ready is reported, not an error: the pane exists and
+		// the signal is only a guess.
+		ready := h.WaitPaneReady(ctx, tmuxPaneID, *opts.WaitReady)
+		pane.Ready = &ready
+		if !ready {
+			reqid.Logger(ctx, h.logger()).Info("new pane not ready before timeout", "pane", tmuxPaneID)
+		}
+	}
+	if opts.Cursor {
+		// The pane exists either way; a failed query only leaves Cursor
+		// unset.
+		if cursor, err := h.QueryPaneCursor(ctx, tmuxPaneID); err == nil {
+			pane.Cursor = &cursor
+		} else {
+			reqid.Logger(ctx, h.logger()).Warn("cursor query after create failed", "pane", tmuxPaneID, "err", err)
+		}
+	}
+	return pane, nil
 }
 
-func (h *Hub) HandleWS(w http.ResponseWriter, r *http.Request) {
-	conn, err := upgrader.Upgrade(w, r, nil)
-	if err != nil {
-		log.Printf("ws upgrade failed: %v", err)
+// DefaultClientBuffer is the per-client outbound message buffer used when
+// WSConfig.ClientBuffer is unset.
+const DefaultClientBuffer = 256
+
+// WSConfig tunes per-connection WebSocket behavior.
+type WSConfig struct {
+	// ClientBuffer is the number of outbound messages queued per client.
+	// Larger buffers tolerate bursty output but use more memory and delay
+	// dropping a slow client.
+	ClientBuffer int
+	// MaxMessageBytes caps the size of one inbound frame. Oversized frames
+	// close the connection with a policy-violation close code.
+	MaxMessageBytes int64
+	// MaxClients caps concurrent WebSocket connections; further upgrade
+	// requests get 503. Zero means unlimited.
+	MaxClients int
+	// MaxAge closes a connection with 1012 (service restart) once it has
+	// been open this long, so the client reconnects, possibly to another
+	// backend. Zero keeps connections open indefinitely.
+	MaxAge time.Duration
+}
+
+// wsCloseGrace is how long a client has to answer a server-initiated close
+// before the connection is dropped.
+const wsCloseGrace = 5 * time.Second
+
+// connLimitRetryAfterSeconds is the Retry-After sent with a 503 for a
+// connection over WSConfig.MaxClients. Nothing predicts when a slot frees
+// up, so it is a fixed hint that keeps clients from reconnecting in a loop.
+const connLimitRetryAfterSeconds = 5
+
+// DefaultMaxMessageBytes is used when WSConfig.MaxMessageBytes is unset.
+const DefaultMaxMessageBytes = 1 << 20
+
+var errMessageTooLarge = errors.New("message exceeds size limit")
+
+// orphanedCreateGrace bounds how long a timed-out split-window is watched for
+// a late response before it is given up on.
+const orphanedCreateGrace = 30 * time.Second
+
+// handleOrphanedCreate watches a timed-out split-window for a late response.
+// A pane created that way was never reported to the API caller, so it is
+// logged and, when enabled, killed.
+func (h *Hub) handleOrphanedCreate(done chan commandResult) {
+	var res commandResult
+	select {
+	case res = <-done:
+	case <-time.After(orphanedCreateGrace):
+		h.logger().Warn("split-window timed out with no late response; a pane may have been orphaned", "session", h.targetSession)
+		return
+	}
+	tmuxPaneID := lastNonEmptyLine(res.Output)
+	if !res.Success || tmuxPaneID == "" {
+		return
+	}
+	if !h.opts.KillOrphanedPanes {
+		h.logger().Warn("split-window timed out but created a pane; leaving it running", "pane", tmuxPaneID)
 		return
 	}
+	if _, err := h.runCommandAndWait(context.Background(), []string{"kill-pane", "-t", tmuxPaneID}, 5*time.Second, false); err != nil {
+		h.logger().Error("failed to kill orphaned pane after split-window timeout", "pane", tmuxPaneID, "err", err)
+		return
+	}
+	h.logger().Warn("killed orphaned pane after split-window timeout", "pane", tmuxPaneID)
+}
 
-	c := &client{conn: conn, send: make(chan serverMsg, 256)}
-	h.addClient(c)
-	defer h.removeClient(c)
-	c.enqueue(serverMsg{T: "tmux_state", State: statePointer(h.CurrentState())})
+func lastNonEmptyLine(lines []string) string {
+	for i := len(lines) - 1; i >= 0; i-- {
+		if candidate := strings.TrimSpace(lines[i]); candidate != "" {
+			return candidate
+		}
+	}
+	return ""
+}
 
-	go c.writeLoop()
-	c.readLoop(h)
+func (h *Hub) HandleWS(w http.ResponseWriter, r *http.Request) {
+	h.WSHandler(WSConfig{})(w, r)
 }
 
-func statePointer(s statePayload) *statePayload {
-	return &s
+func (h *Hub) WSHandler(cfg WSConfig) http.HandlerFunc {
+	if cfg.ClientBuffer <= 0 {
+		cfg.ClientBuffer = DefaultClientBuffer
+	}
+	if cfg.MaxMessageBytes <= 0 {
+		cfg.MaxMessageBytes = DefaultMaxMessageBytes
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		protocol, echo, ok := negotiateProtocol(r)
+		if !ok {
+			h.logger().Warn("ws client rejected: unsupported subprotocol", "remote_addr", r.RemoteAddr, "requested", r.Header.Get("Sec-WebSocket-Protocol"))
+			http.Error(w, "unsupported WebSocket subprotocol; supported: "+strings.Join(supportedProtocols, ", "), http.StatusBadRequest)
+			return
+		}
+		if !h.reserveConn(cfg.MaxClients) {
+			h.logger().Warn("ws client rejected: connection limit reached", "remote_addr", r.RemoteAddr, "limit", cfg.MaxClients)
+			w.Header().Set("Retry-After", strconv.Itoa(connLimitRetryAfterSeconds))
+			http.Error(w, "too many clients", http.StatusServiceUnavailable)
+			return
+		}
+		defer h.releaseConn()
+
+		var header http.Header
+		if echo {
+			header = http.Header{"Sec-WebSocket-Protocol": {protocol}}
+		}
+		conn, err := upgrader.Upgrade(w, r, header)
+		if err != nil {
+			h.logger().Warn("ws upgrade failed", "remote_addr", r.RemoteAddr, "err", err)
+			return
+		}
+
+		c := &client{
+			conn:        conn,
+			send:        make(chan serverMsg, cfg.ClientBuffer),
+			maxMessage:  cfg.MaxMessageBytes,
+			remoteAddr:  r.RemoteAddr,
+			connectedAt: time.Now().UTC(),
+			protocol:    protocol,
+		}
+		h.addClient(c)
+		defer h.removeClient(c)
+		h.logger().Info("ws client connected", "client", c.id, "remote_addr", c.remoteAddr, "protocol", protocol)
+		defer func() {
+			info := c.info()
+			h.logger().Info("ws client disconnected", "client", info.ID, "name", info.Name, "remote_addr", info.RemoteAddr, "messages", info.MessagesSent)
+		}()
+		c.enqueue(serverMsg{T: "protocol", Protocol: &protocolPayload{
+			Version:      protocol,
+			Sentinel:     h.opts.Sentinel,
+			CursorMarker: cursorMarker(h.opts.Sentinel),
+		}})
+		state, synced := h.currentStateAndSynced()
+		state = h.withoutHiddenPanes(state)
+		c.enqueue(serverMsg{T: "tmux_state", State: &state})
+		if synced {
+			c.enqueue(serverMsg{T: "ready"})
+		}
+		if reconnect := h.currentReconnect(); reconnect != nil {
+			c.enqueue(serverMsg{T: "tmux_reconnecting", Reconnect: reconnect})
+		}
+
+		go c.writeLoop(h, cfg.MaxAge)
+		c.readLoop(h)
+	}
+}
+
+// reserveConn claims a connection slot, counted from before the upgrade
+// until the handler returns so concurrent upgrades cannot overshoot max.
+// max <= 0 means unlimited.
+func (h *Hub) reserveConn(max int) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if max > 0 && h.connSlots >= max {
+		return false
+	}
+	h.connSlots++
+	return true
+}
+
+func (h *Hub) releaseConn() {
+	h.mu.Lock()
+	h.connSlots--
+	h.mu.Unlock()
 }
 
 func (h *Hub) BroadcastTmuxStdoutLine(line string) {
+	h.publishRawLine(line)
 	h.mu.RLock()
 	parser := h.parser
 	h.mu.RUnlock()
@@ -370,18 +1435,26 @@ func (h *Hub) BroadcastTmuxStderrLine(line string) {
 }
 
 func (h *Hub) BroadcastConnected() {
+	h.BroadcastConnectedNoSync()
+	go h.RequestStateSyncWithRetry()
+}
+
+// BroadcastConnectedNoSync is BroadcastConnected without the list-panes
+// resync, for a sender that never answers commands, such as a replayed
+// transcript, which supplies the state itself.
+func (h *Hub) BroadcastConnectedNoSync() {
 	h.resetParser()
 	h.mu.Lock()
 	h.stateRefreshScheduled = false
 	hadUnavailable := h.unavailableReason != ""
 	h.unavailableReason = ""
+	h.reconnectAttempt = 0
 	snapshot := filterStateToTargetSession(h.model.snapshot(), h.targetSession)
 	h.mu.Unlock()
 
 	if hadUnavailable {
-		h.broadcast(serverMsg{T: "tmux_state", State: &snapshot})
+		h.broadcastState(&snapshot)
 	}
-	go h.RequestStateSyncWithRetry()
 }
 
 func (h *Hub) BroadcastDisconnected(err error) {
@@ -389,6 +1462,9 @@ func (h *Hub) BroadcastDisconnected(err error) {
 	h.resetParser()
 	h.mu.Lock()
 	h.model.reset()
+	h.synced = false
+	h.clientSize = DefaultClientSize
+	h.snapshotHashes = nil
 	h.pending = h.pending[:0]
 	h.stateRefreshScheduled = false
 	h.unavailableReason = reason
@@ -400,10 +1476,45 @@ func (h *Hub) BroadcastDisconnected(err error) {
 	if reason != "" {
 		h.broadcast(serverMsg{T: "error", Message: reason})
 	}
-	h.broadcast(serverMsg{T: "tmux_state", State: &snapshot})
+	h.resetFlow()
+	h.broadcastState(&snapshot)
 	h.broadcast(serverMsg{T: "tmux_restarted"})
 }
 
+// BroadcastReconnecting tells clients when the tmux manager will next try
+// to reconnect. It is wired to tmuxproc.Config.OnReconnecting.
+func (h *Hub) BroadcastReconnecting(attempt int, nextRetry time.Time) {
+	h.mu.Lock()
+	h.reconnectAttempt = attempt
+	h.reconnectAt = nextRetry
+	h.mu.Unlock()
+	h.broadcast(serverMsg{T: "tmux_reconnecting", Reconnect: newReconnectPayload(attempt, nextRetry)})
+}
+
+// currentReconnect returns the pending reconnect attempt for a newly
+// connected client, or nil while tmux is connected.
+func (h *Hub) currentReconnect() *reconnectPayload {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	if h.reconnectAttempt == 0 {
+		return nil
+	}
+	return newReconnectPayload(h.reconnectAttempt, h.reconnectAt)
+}
+
+// RetryAfter estimates how long a request turned away because tmux is not
+// ready should wait: until the manager's next reconnect attempt while one is
+// scheduled, and at least a second, the usual time for a state sync.
+func (h *Hub) RetryAfter() time.Duration {
+	h.mu.RLock()
+	attempt, at := h.reconnectAttempt, h.reconnectAt
+	h.mu.RUnlock()
+	if attempt == 0 {
+		return time.Second
+	}
+	return max(time.Until(at), time.Second)
+}
+
 func unavailableReason(err error) string {
 	if err == nil {
 		return "tmux target unavailable"
@@ -418,21 +1529,38 @@ func unavailableReason(err error) string {
 func (h *Hub) resetParser() {
 	h.mu.Lock()
 	old := h.parser
-	h.parser = tmuxparse.NewStreamParser(512)
+	h.parser = tmuxparse.NewStreamParser(parserEventBuffer)
 	newParser := h.parser
+	var stats ParserQueueStats
+	if h.parserQueue != nil {
+		stats = h.parserQueue.snapshot()
+	}
+	queue := newEventQueue(stats)
+	h.parserQueue = queue
 	h.mu.Unlock()
 
 	if old != nil {
 		old.Close()
 	}
-	go h.consumeParserEvents(newParser)
+	go h.relayParserEvents(newParser, queue)
+	go h.consumeParserEvents(queue)
 }
 
-func (h *Hub) consumeParserEvents(parser *tmuxparse.StreamParser) {
-	for ev := range parser.Events() {
+func (h *Hub) consumeParserEvents(queue *eventQueue) {
+	for {
+		ev, ok := queue.pop()
+		if !ok {
+			return
+		}
 		switch e := ev.(type) {
 		case tmuxparse.Command:
 			pending := h.shiftPending()
+			resized := pending.ClientSize != nil && e.Success
+			if resized {
+				h.mu.Lock()
+				h.clientSize = *pending.ClientSize
+				h.mu.Unlock()
+			}
 			if pending.Wait != nil {
 				select {
 				case pending.Wait <- commandResult{Success: e.Success, Output: append([]string(nil), e.Output...)}:
@@ -441,49 +1569,109 @@ func (h *Hub) consumeParserEvents(parser *tmuxparse.StreamParser) {
 			}
 
 			var state *statePayload
+			var died []panePayload
+			var focus []paneFocusPayload
+			becameReady := false
 			h.mu.Lock()
+			prevPanes := h.model.panes
 			if h.model.applyOutputLines(e.Output) {
 				snapshot := h.model.snapshot()
 				state = &snapshot
+				died = newlyDeadPanes(prevPanes, h.model.panes)
+				focus = paneFocusChanges(prevPanes, h.model.panes)
+			}
+			if pending.Name == "list-panes" && e.Success && !h.synced {
+				h.synced = true
+				becameReady = true
 			}
 			h.mu.Unlock()
 
-			h.broadcast(serverMsg{T: "tmux_command", Command: &commandPayload{
-				EpochSeconds: e.Header.EpochSeconds,
-				CommandID:    e.Header.CommandID,
-				Flags:        e.Header.Flags,
-				Success:      e.Success,
-				Output:       append([]string(nil), e.Output...),
-			}})
+			errText := ""
+			if !e.Success {
+				errText = commandErrorText(e.Output)
+			}
+			if pending.Requester != nil {
+				h.replyToRequester(pending.Requester, serverMsg{T: "tmux_command", Command: &commandPayload{
+					EpochSeconds: e.Header.EpochSeconds,
+					CommandID:    e.Header.CommandID,
+					Flags:        e.Header.Flags,
+					Success:      e.Success,
+					Output:       h.withoutHiddenPaneLines(e.Output),
+					Error:        errText,
+				}})
+			}
 			if state != nil {
-				h.broadcast(serverMsg{T: "tmux_state", State: state})
+				h.broadcastState(state)
+			}
+			if becameReady {
+				h.broadcast(serverMsg{T: "ready"})
+			}
+			if resized {
+				h.broadcast(serverMsg{T: "client_size", ClientSize: pending.ClientSize})
+			}
+			for _, pane := range died {
+				h.broadcast(serverMsg{T: "pane_dead", PaneDead: &paneDeadPayload{PaneID: pane.ID, Status: pane.DeadStatus}})
+			}
+			for i := range focus {
+				h.broadcast(serverMsg{T: "pane_focus", PaneFocus: &focus[i]})
 			}
 			if pending.Name == "capture-pane" && pending.TargetPane != "" && pending.EmitPaneSnapshot {
-				h.broadcast(serverMsg{T: "pane_snapshot", PaneSnapshot: &paneSnapshotPayload{
+				h.broadcastPaneSnapshot(&paneSnapshotPayload{
 					PaneID: pending.TargetPane,
 					Data:   strings.Join(e.Output, "\n"),
-				}})
+				}, pending.Requester)
 			}
 			if pending.Name == "display-message" && pending.TargetPane != "" {
-				if cursor, ok := parsePaneCursorOutput(e.Output); ok {
+				if cursor, ok := parsePaneCursorOutput(e.Output, cursorMarker(h.opts.Sentinel)); ok {
 					cursor.PaneID = pending.TargetPane
 					h.broadcast(serverMsg{T: "pane_cursor", PaneCursor: cursor})
 				}
 			}
 
 		case tmuxparse.Notification:
-			if (e.Name == "output" || e.Name == "extended-output") && len(e.Args) >= 1 {
-				decoded := h.decodePaneOutputData(e.Args[0], e.Value)
-				if decoded == "" {
+			paneID := ""
+			switch {
+			case e.Extended != nil:
+				paneID = e.Extended.PaneID
+				h.recordOutputAge(e.Extended.Age)
+			case e.Name == "output" && len(e.Args) >= 1:
+				paneID = e.Args[0]
+			}
+			if paneID != "" {
+				receivedAt := time.Now()
+				raw := []byte(tmuxparse.DecodeEscapedValue(e.Value))
+				if len(raw) == 0 {
 					continue
 				}
+				decoded := h.decodePaneOutputBytes(paneID, raw)
 				h.broadcast(serverMsg{T: "pane_output", PaneOutput: &paneOutputPayload{
-					PaneID: e.Args[0],
-					Data:   decoded,
+					PaneID:     paneID,
+					Data:       decoded,
+					raw:        raw,
+					receivedAt: receivedAt,
 				}})
+				if decoded != "" {
+					h.publishPaneOutput(PaneOutput{PaneID: publicPaneID(paneID), Data: decoded})
+				}
+				continue
+			}
+
+			if e.Name == "config-error" {
+				h.recordConfigError(e.Text)
+				h.broadcast(serverMsg{T: "tmux_config_error", Message: e.Text})
 				continue
 			}
 
+			if e.Name == "continue" && len(e.Args) >= 1 && h.opts.FlowControl {
+				h.resnapshotPane(e.Args[0])
+			}
+			if e.Name == "layout-change" && len(e.Args) >= 2 {
+				h.applyLayoutChange(e.Args[0], e.Args[1])
+			}
+			if (e.Name == "window-close" || e.Name == "unlinked-window-close") && len(e.Args) >= 1 {
+				h.applyWindowClose(e.Args[0])
+			}
+
 			h.broadcast(serverMsg{T: "tmux_notification", Notification: &notificationPayload{
 				Name:  e.Name,
 				Args:  append([]string(nil), e.Args...),
@@ -491,23 +1679,145 @@ func (h *Hub) consumeParserEvents(parser *tmuxparse.StreamParser) {
 				Value: e.Value,
 			}})
 			if notificationRequiresModelRefresh(e.Name) {
-				h.scheduleStateRefresh()
+				h.scheduleResync()
 			}
 
 		case tmuxparse.ParseError:
+			h.recordParseError(e)
 			h.broadcast(serverMsg{T: "error", Message: "tmux parse error: " + e.Error()})
 		}
 	}
 }
 
+func (h *Hub) recordParseError(e tmuxparse.ParseError) {
+	h.logger().Warn("tmux parse error", "line", e.Line, "message", e.Message)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.parseErrors = append(h.parseErrors, ParseErrorRecord{
+		At:      time.Now().UTC().Format(time.RFC3339Nano),
+		Line:    e.Line,
+		Message: e.Message,
+	})
+	if len(h.parseErrors) > maxParseErrorRecords {
+		h.parseErrors = append([]ParseErrorRecord{}, h.parseErrors[len(h.parseErrors)-maxParseErrorRecords:]...)
+	}
+}
+
+func (h *Hub) recordOutputAge(age time.Duration) {
+	ms := age.Milliseconds()
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.outputLatency.Samples++
+	h.outputLatency.LastMS = ms
+	h.outputLatency.TotalMS += ms
+	h.outputLatency.MaxMS = max(h.outputLatency.MaxMS, ms)
+}
+
+// OutputLatencySnapshot returns the output ages recorded since startup.
+func (h *Hub) OutputLatencySnapshot() OutputLatency {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return h.outputLatency
+}
+
+func (h *Hub) recordConfigError(message string) {
+	h.logger().Warn("tmux config error", "message", message)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.configErrors = append(h.configErrors, ConfigErrorRecord{
+		At:      time.Now().UTC().Format(time.RFC3339Nano),
+		Message: message,
+	})
+	if len(h.configErrors) > maxConfigErrorRecords {
+		h.configErrors = append([]ConfigErrorRecord{}, h.configErrors[len(h.configErrors)-maxConfigErrorRecords:]...)
+	}
+}
+
+// RecentConfigErrors returns retained tmux config errors, oldest first.
+func (h *Hub) RecentConfigErrors() []ConfigErrorRecord {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return append([]ConfigErrorRecord{}, h.configErrors...)
+}
+
+// PendingSnapshot returns the commands awaiting a tmux response, oldest
+// (next to be answered) first.
+func (h *Hub) PendingSnapshot() []PendingCommandInfo {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	now := time.Now()
+	out := make([]PendingCommandInfo, 0, len(h.pending))
+	for _, p := range h.pending {
+		out = append(out, PendingCommandInfo{
+			Name:       p.Name,
+			TargetPane: p.TargetPane,
+			Internal:   p.Wait != nil,
+			QueuedAt:   p.QueuedAt.UTC().Format(time.RFC3339Nano),
+			AgeMS:      now.Sub(p.QueuedAt).Milliseconds(),
+		})
+	}
+	return out
+}
+
+// RecentParseErrors returns retained parse errors, oldest first.
+func (h *Hub) RecentParseErrors() []ParseErrorRecord {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return append([]ParseErrorRecord{}, h.parseErrors...)
+}
+
+// applyLayoutChange pushes pane geometry from a %layout-change immediately,
+// ahead of the follow-up list-panes resync.
+func (h *Hub) applyLayoutChange(windowID, layout string) {
+	panes, err := tmuxparse.ParseLayout(layout)
+	if err != nil {
+		h.logger().Debug("ignoring unparseable layout", "window", windowID, "err", err)
+		return
+	}
+
+	var state *statePayload
+	h.mu.Lock()
+	if h.model.applyLayout(windowID, panes) {
+		snapshot := filterStateToTargetSession(h.model.snapshot(), h.targetSession)
+		state = &snapshot
+	}
+	h.mu.Unlock()
+
+	payload := &paneLayoutPayload{WindowID: windowID, Panes: make([]paneGeometryPayload, 0, len(panes))}
+	for _, p := range panes {
+		payload.Panes = append(payload.Panes, paneGeometryPayload{PaneID: p.PaneID, Left: p.Left, Top: p.Top, Width: p.Width, Height: p.Height})
+	}
+	h.broadcast(serverMsg{T: "pane_layout", PaneLayout: payload})
+	if state != nil {
+		h.broadcastState(state)
+	}
+}
+
+// applyWindowClose removes a closed window and its panes immediately so the
+// window list does not go stale while the next list-panes is pending.
+func (h *Hub) applyWindowClose(windowID string) {
+	var state *statePayload
+	h.mu.Lock()
+	if h.model.removeWindow(windowID) {
+		snapshot := filterStateToTargetSession(h.model.snapshot(), h.targetSession)
+		state = &snapshot
+	}
+	h.mu.Unlock()
+	if state != nil {
+		h.broadcastState(state)
+	}
+}
+
 func notificationRequiresModelRefresh(name string) bool {
 	if name == "layout-change" || name == "sessions-changed" || name == "session-changed" || name == "client-session-changed" {
 		return true
 	}
-	return strings.HasPrefix(name, "window-") || strings.HasPrefix(name, "pane-")
+	return strings.HasPrefix(name, "window-") || strings.HasPrefix(name, "unlinked-window-") || strings.HasPrefix(name, "pane-")
 }
 
-func (h *Hub) scheduleStateRefresh() {
+// scheduleResync coalesces resync requests so at most one list-panes is sent
+// per ResyncDebounce interval, however many notifications ask for it.
+func (h *Hub) scheduleResync() {
 	h.mu.Lock()
 	if h.stateRefreshScheduled {
 		h.mu.Unlock()
@@ -516,18 +1826,23 @@ func (h *Hub) scheduleStateRefresh() {
 	h.stateRefreshScheduled = true
 	h.mu.Unlock()
 
-	time.AfterFunc(120*time.Millisecond, func() {
+	time.AfterFunc(h.opts.ResyncDebounce, func() {
 		h.mu.Lock()
 		h.stateRefreshScheduled = false
 		h.mu.Unlock()
 		if err := h.RequestStateSync(); err != nil {
-			log.Printf("wmux: state refresh failed: %v", err)
+			h.logger().Warn("state refresh failed", "err", err)
 		}
 	})
 }
 
 func (h *Hub) decodePaneOutputData(paneID, value string) string {
-	raw := []byte(tmuxparse.DecodeEscapedValue(value))
+	return h.decodePaneOutputBytes(paneID, []byte(tmuxparse.DecodeEscapedValue(value)))
+}
+
+// decodePaneOutputBytes returns raw as UTF-8 text, holding back a trailing
+// partial rune for paneID's next chunk.
+func (h *Hub) decodePaneOutputBytes(paneID string, raw []byte) string {
 	if len(raw) == 0 {
 		return ""
 	}
@@ -565,12 +1880,142 @@ func splitUTF8AtSafeBoundary(raw []byte) ([]byte, []byte) {
 	return bytes.ToValidUTF8(raw, []byte("\uFFFD")), nil
 }
 
+// SubscribePaneOutput returns a channel receiving decoded output from every
+// pane seen by the control client, in arrival order. The channel is closed
+// when cancel is called or when the subscriber falls more than buffer chunks
+// behind.
+func (h *Hub) SubscribePaneOutput(buffer int) (<-chan PaneOutput, func()) {
+	if buffer <= 0 {
+		buffer = DefaultClientBuffer
+	}
+	ch := make(chan PaneOutput, buffer)
+	h.mu.Lock()
+	h.outputSubs[ch] = struct{}{}
+	h.mu.Unlock()
+	return ch, func() { h.unsubscribePaneOutput(ch) }
+}
+
+func (h *Hub) unsubscribePaneOutput(ch chan PaneOutput) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if _, ok := h.outputSubs[ch]; !ok {
+		return
+	}
+	delete(h.outputSubs, ch)
+	close(ch)
+}
+
+func (h *Hub) publishPaneOutput(out PaneOutput) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	if _, hidden := h.hiddenPanes["%"+out.PaneID]; hidden {
+		return
+	}
+	for ch := range h.outputSubs {
+		select {
+		case ch <- out:
+		default:
+			go h.unsubscribePaneOutput(ch)
+		}
+	}
+}
+
 func (h *Hub) addClient(c *client) {
 	h.mu.Lock()
 	defer h.mu.Unlock()
+	h.nextClientID++
+	c.id = h.nextClientID
 	h.clients[c] = struct{}{}
 }
 
+func (h *Hub) logger() *slog.Logger {
+	if h.opts.Logger == nil {
+		return slog.Default()
+	}
+	return h.opts.Logger
+}
+
+// Policy returns the command policy the hub enforces.
+func (h *Hub) Policy() policy.Policy {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return h.policy
+}
+
+// SetPolicy replaces the command policy. Commands already sent to tmux are
+// unaffected; every later check uses p.
+func (h *Hub) SetPolicy(p policy.Policy) {
+	h.mu.Lock()
+	h.policy = p
+	h.mu.Unlock()
+}
+
+// Clients returns metadata for connected WebSocket clients, ordered by
+// connection id.
+func (h *Hub) Clients() []ClientInfo {
+	h.mu.RLock()
+	out := make([]ClientInfo, 0, len(h.clients))
+	for c := range h.clients {
+		out = append(out, c.info())
+	}
+	h.mu.RUnlock()
+	sort.Slice(out, func(i, j int) bool { return out[i].ID < out[j].ID })
+	return out
+}
+
+// clientName normalizes the name a client reports in hello: trimmed and
+// truncated to maxClientNameLength bytes, cut back to a rune boundary so a
+// multi-byte character is not split.
+func clientName(name string) string {
+	name = strings.TrimSpace(name)
+	if len(name) <= maxClientNameLength {
+		return name
+	}
+	cut := maxClientNameLength
+	for cut > 0 && !utf8.RuneStart(name[cut]) {
+		cut--
+	}
+	return name[:cut]
+}
+
+// clientTerm normalizes the term a client reports in hello: trimmed and
+// truncated like names, and dropped when it is not a plain terminal name.
+func clientTerm(term string) string {
+	term = strings.TrimSpace(term)
+	if len(term) > maxClientTermLength {
+		term = term[:maxClientTermLength]
+	}
+	if !validClientTerm.MatchString(term) {
+		return ""
+	}
+	return term
+}
+
+var validClientTerm = regexp.MustCompile(`^[A-Za-z0-9._+-]*$`)
+
+func (c *client) info() ClientInfo {
+	lineMode := c.inLineMode()
+	c.metaMu.Lock()
+	defer c.metaMu.Unlock()
+	encoding := ""
+	if c.rawOutput {
+		encoding = outputEncodingRaw
+	}
+	return ClientInfo{
+		ID:           c.id,
+		Name:         c.name,
+		RemoteAddr:   c.remoteAddr,
+		ConnectedAt:  c.connectedAt.Format(time.RFC3339Nano),
+		Protocol:     c.protocol,
+		MessagesSent: c.messagesSent,
+		FocusWindow:  c.focusWindow,
+		LineMode:     lineMode,
+		Encoding:     encoding,
+		Timestamps:   c.outputTimestamps,
+		Term:         c.term,
+	}
+}
+
 func (h *Hub) removeClient(c *client) {
 	h.mu.Lock()
 	defer h.mu.Unlock()
@@ -579,31 +2024,334 @@ func (h *Hub) removeClient(c *client) {
 	}
 	delete(h.clients, c)
 	c.close()
+	if h.opts.FlowControl {
+		h.clientDrained(c)
+	}
+}
+
+// broadcastState sends a tmux_state to all clients unless it is identical to
+// the previously broadcast one. Hidden panes are left out before comparing,
+// so hiding or unhiding a pane counts as a change.
+func (h *Hub) broadcastState(state *statePayload) {
+	state = statePointer(h.withoutHiddenPanes(*state))
+	data, err := json.Marshal(state)
+	if err != nil {
+		h.broadcast(serverMsg{T: "tmux_state", State: state})
+		return
+	}
+	sum := fnv.New64a()
+	_, _ = sum.Write(data)
+	hash := sum.Sum64()
+
+	h.mu.Lock()
+	if h.hasLastStateHash && h.lastStateHash == hash {
+		h.mu.Unlock()
+		return
+	}
+	h.lastStateHash = hash
+	h.hasLastStateHash = true
+	h.mu.Unlock()
+
+	h.broadcast(serverMsg{T: "tmux_state", State: state})
 }
 
 func (h *Hub) broadcast(m serverMsg) {
+	var receivedAt time.Time
+	if m.PaneOutput != nil {
+		receivedAt = m.PaneOutput.receivedAt
+	}
 	h.mu.RLock()
 	defer h.mu.RUnlock()
+	if paneID := msgPaneID(m); paneID != "" {
+		if _, hidden := h.hiddenPanes[paneID]; hidden {
+			return
+		}
+	}
 	for c := range h.clients {
-		select {
-		case c.send <- m:
-		default:
-			go h.removeClient(c)
+		scoped, ok := h.scopeToWindow(m, c.focusedWindow())
+		if !ok {
+			continue
+		}
+		if scoped, ok = c.encodeOutput(scoped); !ok {
+			continue
+		}
+		out := []serverMsg{scoped}
+		// Raw output is not text, so line mode does not apply.
+		if scoped.PaneOutput == nil || scoped.PaneOutput.Encoding == "" {
+			if framed, ok := c.frameLines(scoped); ok {
+				out = framed
+			}
+		}
+		h.deliver(c, out, receivedAt)
+	}
+}
+
+// deliver enqueues msgs for c in order, splitting pane_output larger than
+// MaxOutputChunk, and drops c if its queue is full. With flow control, pane
+// output that leaves c backed up pauses the pane.
+func (h *Hub) deliver(c *client, msgs []serverMsg, receivedAt time.Time) {
+	for _, msg := range msgs {
+		for _, piece := range splitOutput(msg, h.opts.MaxOutputChunk) {
+			if !c.enqueue(c.stampOutput(piece, receivedAt)) {
+				go h.removeClient(c)
+				return
+			}
+		}
+		if h.opts.FlowControl && msg.PaneOutput != nil {
+			h.notePressure(c, msg.PaneOutput.PaneID)
+		}
+	}
+}
+
+// scopeToWindow narrows m for a client focused on windowID. It reports false
+// when m concerns only panes known to be in other windows. Output for panes
+// the model has not seen yet is passed through rather than dropped. Callers
+// must hold h.mu.
+func (h *Hub) scopeToWindow(m serverMsg, windowID string) (serverMsg, bool) {
+	if windowID == "" {
+		return m, true
+	}
+	inWindow := func(paneID string) bool {
+		pane, ok := h.model.panes[paneID]
+		return !ok || pane.WindowID == windowID
+	}
+	switch {
+	case m.State != nil:
+		m.State = statePointer(filterStateToWindow(*m.State, windowID))
+	case m.PaneOutput != nil:
+		return m, inWindow(m.PaneOutput.PaneID)
+	case m.PaneSnapshot != nil:
+		return m, inWindow(m.PaneSnapshot.PaneID)
+	case m.PaneCursor != nil:
+		return m, inWindow(m.PaneCursor.PaneID)
+	case m.PaneDead != nil:
+		return m, inWindow(m.PaneDead.PaneID)
+	case m.PaneFocus != nil:
+		return m, inWindow(m.PaneFocus.PaneID)
+	case m.PaneLayout != nil:
+		return m, m.PaneLayout.WindowID == windowID
+	}
+	return m, true
+}
+
+// replyToRequester sends m to c alone, if c is still connected. A command's
+// response goes only to the client that sent it: its output may describe
+// any pane, including hidden ones.
+func (h *Hub) replyToRequester(c *client, m serverMsg) {
+	h.mu.RLock()
+	_, connected := h.clients[c]
+	h.mu.RUnlock()
+	if connected {
+		h.deliver(c, []serverMsg{m}, time.Time{})
+	}
+}
+
+// errHiddenPane and errAmbiguousTarget are returned for /ws commands that
+// could reach a pane hidden with HidePane.
+var (
+	errHiddenPane      = errors.New("pane is hidden")
+	errAmbiguousTarget = errors.New("while panes are hidden, -t must name a pane id such as %3")
+)
+
+// checkHiddenTargets rejects argv when its -t names a hidden pane. While any
+// pane is hidden, targets other than a pane id are rejected too, since a
+// window or session target may resolve to the hidden pane.
+func (h *Hub) checkHiddenTargets(argv []string) error {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	if len(h.hiddenPanes) == 0 {
+		return nil
+	}
+	for i := 1; i < len(argv)-1; i++ {
+		if argv[i] != "-t" {
+			continue
+		}
+		target := argv[i+1]
+		if !tmuxPaneIDPattern.MatchString(target) {
+			return errAmbiguousTarget
+		}
+		if _, hidden := h.hiddenPanes[target]; hidden {
+			return errHiddenPane
+		}
+	}
+	return nil
+}
+
+var tmuxPaneIDPattern = regexp.MustCompile(`^%[0-9]+$`)
+
+// withoutHiddenPaneLines copies output, leaving out lines that mention a
+// hidden pane's id, such as its list-panes row.
+func (h *Hub) withoutHiddenPaneLines(output []string) []string {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	out := make([]string, 0, len(output))
+	for _, line := range output {
+		if !h.mentionsHiddenPaneLocked(line) {
+			out = append(out, line)
+		}
+	}
+	return out
+}
+
+// mentionsHiddenPaneLocked reports whether line contains a hidden pane id
+// not followed by another digit. Callers must hold h.mu.
+func (h *Hub) mentionsHiddenPaneLocked(line string) bool {
+	for paneID := range h.hiddenPanes {
+		rest := line
+		for {
+			i := strings.Index(rest, paneID)
+			if i < 0 {
+				break
+			}
+			rest = rest[i+len(paneID):]
+			if rest == "" || rest[0] < '0' || rest[0] > '9' {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// msgPaneID returns the tmux id of the pane m is about, or "" when m is not
+// about a single pane.
+func msgPaneID(m serverMsg) string {
+	switch {
+	case m.PaneOutput != nil:
+		return m.PaneOutput.PaneID
+	case m.PaneSnapshot != nil:
+		return m.PaneSnapshot.PaneID
+	case m.PaneCursor != nil:
+		return m.PaneCursor.PaneID
+	case m.PaneDead != nil:
+		return m.PaneDead.PaneID
+	case m.PaneFocus != nil:
+		return m.PaneFocus.PaneID
+	}
+	return ""
+}
+
+// withoutHiddenPanes drops the panes hidden with HidePane from state, for
+// the tmux_state sent over /ws.
+func (h *Hub) withoutHiddenPanes(state statePayload) statePayload {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	if len(h.hiddenPanes) == 0 {
+		return state
+	}
+	panes := make([]panePayload, 0, len(state.Panes))
+	for _, p := range state.Panes {
+		if _, hidden := h.hiddenPanes[p.ID]; !hidden {
+			panes = append(panes, p)
 		}
 	}
+	state.Panes = panes
+	return state
+}
+
+// filterStateToWindow keeps only windowID and its panes.
+func filterStateToWindow(state statePayload, windowID string) statePayload {
+	out := statePayload{Unavailable: state.Unavailable, Windows: []windowPayload{}, Panes: []panePayload{}}
+	for _, w := range state.Windows {
+		if w.ID == windowID {
+			out.Windows = append(out.Windows, w)
+		}
+	}
+	for _, p := range state.Panes {
+		if p.WindowID == windowID {
+			out.Panes = append(out.Panes, p)
+		}
+	}
+	return out
+}
+
+func statePointer(s statePayload) *statePayload {
+	return &s
+}
+
+// focusWindow sets c's window filter and sends it the matching state. An
+// empty id clears the filter; ids may be given with or without the "@".
+func (h *Hub) focusWindow(c *client, windowID string) error {
+	windowID = strings.TrimSpace(windowID)
+	if windowID != "" && !strings.HasPrefix(windowID, "@") {
+		windowID = "@" + windowID
+	}
+	state := h.withoutHiddenPanes(h.CurrentState())
+	if windowID != "" && !stateHasWindow(state, windowID) {
+		return fmt.Errorf("unknown window %q", windowID)
+	}
+	c.metaMu.Lock()
+	c.focusWindow = windowID
+	c.metaMu.Unlock()
+	if windowID != "" {
+		state = filterStateToWindow(state, windowID)
+	}
+	c.enqueue(serverMsg{T: "tmux_state", State: &state})
+	return nil
+}
+
+func stateHasWindow(state statePayload, windowID string) bool {
+	for _, w := range state.Windows {
+		if w.ID == windowID {
+			return true
+		}
+	}
+	return false
 }
 
 func (c *client) readLoop(h *Hub) {
 	for {
-		_, data, err := c.conn.ReadMessage()
+		data, err := c.readMessage()
+		if errors.Is(err, errMessageTooLarge) {
+			h.logger().Warn("ws client sent oversized message", "client", c.id, "limit", c.maxMessage)
+			_ = c.conn.WriteControl(websocket.CloseMessage,
+				websocket.FormatCloseMessage(websocket.ClosePolicyViolation, errMessageTooLarge.Error()),
+				time.Now().Add(time.Second))
+			return
+		}
 		if err != nil {
 			return
 		}
+		c.metaMu.Lock()
+		c.messagesSent++
+		c.metaMu.Unlock()
 		var msg clientMsg
 		if err := json.Unmarshal(data, &msg); err != nil {
 			c.enqueue(serverMsg{T: "error", Message: "invalid JSON"})
 			continue
 		}
+		if msg.T == "hello" {
+			c.metaMu.Lock()
+			if msg.Name != nil {
+				c.name = clientName(*msg.Name)
+			}
+			if msg.Term != nil {
+				c.term = clientTerm(*msg.Term)
+			}
+			c.metaMu.Unlock()
+			continue
+		}
+		if msg.T == "focus-window" {
+			if err := h.focusWindow(c, msg.WindowID); err != nil {
+				c.enqueue(serverMsg{T: "error", Message: err.Error()})
+			}
+			continue
+		}
+		if msg.T == "set-encoding" {
+			if err := c.setOutputEncoding(msg.Mode); err != nil {
+				c.enqueue(serverMsg{T: "error", Message: err.Error()})
+			}
+			continue
+		}
+		if msg.T == "output-timestamps" {
+			c.setOutputTimestamps(msg.Enabled)
+			continue
+		}
+		if msg.T == "line-mode" {
+			for _, flushed := range c.setLineMode(msg.Enabled) {
+				c.enqueue(flushed)
+			}
+			continue
+		}
 		if msg.T != "cmd" {
 			c.enqueue(serverMsg{T: "error", Message: "unsupported message type"})
 			continue
@@ -613,34 +2361,25 @@ func (c *client) readLoop(h *Hub) {
 			c.enqueue(serverMsg{T: "error", Message: err.Error()})
 			continue
 		}
-		if err := h.policy.Validate(line); err != nil {
+		if err := h.Policy().Validate(line); err != nil {
 			c.enqueue(serverMsg{T: "error", Message: err.Error()})
 			continue
 		}
-		if h.tmux == nil {
-			c.enqueue(serverMsg{T: "error", Message: "tmux backend unavailable"})
+		if err := h.checkHiddenTargets(msg.Argv); err != nil {
+			c.enqueue(serverMsg{T: "error", Message: err.Error()})
 			continue
 		}
-		if err := h.tmux.Send(line); err != nil {
+		pending := pendingFromArgv(msg.Argv)
+		pending.Requester = c
+		if err := h.sendPending(pending, line); err != nil {
 			c.enqueue(serverMsg{T: "error", Message: err.Error()})
 			continue
 		}
-		h.registerPending(msg.Argv)
-	}
-}
-
-func (h *Hub) registerPending(argv []string) {
-	if len(argv) == 0 {
-		return
 	}
-	p := pendingFromArgv(argv)
-	h.mu.Lock()
-	h.pending = append(h.pending, p)
-	h.mu.Unlock()
 }
 
 func pendingFromArgv(argv []string) pendingCommand {
-	p := pendingCommand{Name: strings.ToLower(strings.TrimSpace(argv[0]))}
+	p := pendingCommand{Name: strings.ToLower(strings.TrimSpace(argv[0])), QueuedAt: time.Now()}
 	for i := 1; i < len(argv)-1; i++ {
 		if argv[i] == "-t" {
 			p.TargetPane = argv[i+1]
@@ -650,16 +2389,63 @@ func pendingFromArgv(argv []string) pendingCommand {
 	if p.Name == "capture-pane" && p.TargetPane != "" {
 		p.EmitPaneSnapshot = true
 	}
+	if p.Name == "refresh-client" {
+		p.ClientSize = refreshClientSize(argv)
+	}
 	return p
 }
 
-func (h *Hub) runCommandAndWait(argv []string, timeout time.Duration, emitPaneSnapshot bool) (commandResult, error) {
+// runCommandAndWait runs argv and waits up to timeout, or until ctx ends,
+// for tmux's response. ctx also supplies the request id for the command's
+// log line.
+func (h *Hub) runCommandAndWait(ctx context.Context, argv []string, timeout time.Duration, emitPaneSnapshot bool) (commandResult, error) {
+	done, err := h.startCommand(ctx, argv, emitPaneSnapshot)
+	if err != nil {
+		return commandResult{}, err
+	}
+
+	select {
+	case res := <-done:
+		if !res.Success {
+			return res, commandFailure(argv[0], res.Output)
+		}
+		return res, nil
+	case <-time.After(timeout):
+		return commandResult{}, ErrCommandTimeout
+	case <-ctx.Done():
+		return commandResult{}, ctx.Err()
+	}
+}
+
+// ErrCommandTimeout is returned when tmux does not answer a command in time.
+var ErrCommandTimeout = errors.New("timed out waiting for tmux response")
+
+// commandErrorText is the error detail tmux gave for a failed command: its
+// %error output, one message per line.
+func commandErrorText(output []string) string {
+	return strings.TrimSpace(strings.Join(output, "\n"))
+}
+
+// commandFailure describes a command tmux answered with %error, including
+// tmux's own message when it sent one.
+func commandFailure(name string, output []string) error {
+	if text := commandErrorText(output); text != "" {
+		return fmt.Errorf("%s failed: %s", name, text)
+	}
+	return fmt.Errorf("%s failed", name)
+}
+
+// startCommand sends argv to tmux and returns the channel that receives its
+// result. The pending entry stays queued after a caller stops waiting, so a
+// late response is still delivered to the returned channel. The command is
+// logged at debug level with the request id carried by ctx, if any.
+func (h *Hub) startCommand(ctx context.Context, argv []string, emitPaneSnapshot bool) (chan commandResult, error) {
 	if len(argv) == 0 {
-		return commandResult{}, fmt.Errorf("argv cannot be empty")
+		return nil, fmt.Errorf("argv cannot be empty")
 	}
 	line, err := encodeArgvCommand(argv)
 	if err != nil {
-		return commandResult{}, err
+		return nil, err
 	}
 
 	done := make(chan commandResult, 1)
@@ -667,32 +2453,54 @@ func (h *Hub) runCommandAndWait(argv []string, timeout time.Duration, emitPaneSn
 	pending.Wait = done
 	pending.EmitPaneSnapshot = emitPaneSnapshot
 
-	h.mu.Lock()
-	h.pending = append(h.pending, pending)
-	h.mu.Unlock()
+	if err := h.sendPending(pending, line); err != nil {
+		return nil, err
+	}
+	reqid.Logger(ctx, h.logger()).Debug("tmux command", "command", argv[0], "target", pending.TargetPane)
+	return done, nil
+}
 
+// ErrTmuxNotReady is returned for a command that could not be sent because
+// the tmux control connection is down or being re-established. Nothing is
+// left waiting for its response; the caller may retry once tmux is back.
+var ErrTmuxNotReady = errors.New("tmux is not connected (reconnecting); command not sent")
+
+// ErrReplayMode is returned by a sender that replays a captured transcript
+// instead of talking to tmux. Unlike ErrTmuxNotReady, retrying will not
+// help: no command is ever sent.
+var ErrReplayMode = errors.New("replaying a transcript; commands are not sent to tmux")
+
+// sendPending queues p and sends line to tmux. p is queued first so a
+// response parsed before Send returns still pairs with it. When the send
+// fails, p is taken back out, since tmux will never answer it, and the
+// failure is reported as ErrTmuxNotReady, or passed through when it is
+// ErrReplayMode; the sender's own error is logged.
+func (h *Hub) sendPending(p pendingCommand, line string) error {
 	if h.tmux == nil {
-		h.removePending(done)
-		return commandResult{}, fmt.Errorf("tmux backend unavailable")
-	}
-	if err := h.tmux.Send(line); err != nil {
-		h.removePending(done)
-		return commandResult{}, err
+		return ErrTmuxNotReady
 	}
+	h.mu.Lock()
+	h.pendingSeq++
+	p.id = h.pendingSeq
+	h.pending = append(h.pending, p)
+	h.mu.Unlock()
 
-	select {
-	case res := <-done:
-		return res, nil
-	case <-time.After(timeout):
-		return commandResult{}, fmt.Errorf("timed out waiting for tmux response")
+	if err := h.tmux.Send(line); err != nil {
+		h.dropPending(p.id)
+		h.logger().Debug("tmux send failed", "command", p.Name, "err", err)
+		if errors.Is(err, ErrReplayMode) {
+			return err
+		}
+		return ErrTmuxNotReady
 	}
+	return nil
 }
 
-func (h *Hub) removePending(done chan commandResult) {
+func (h *Hub) dropPending(id uint64) {
 	h.mu.Lock()
 	defer h.mu.Unlock()
 	for i := range h.pending {
-		if h.pending[i].Wait == done {
+		if h.pending[i].id == id {
 			h.pending = append(h.pending[:i], h.pending[i+1:]...)
 			return
 		}
@@ -710,13 +2518,13 @@ func (h *Hub) shiftPending() pendingCommand {
 	return p
 }
 
-func parsePaneCursorOutput(lines []string) (*paneCursorPayload, bool) {
+func parsePaneCursorOutput(lines []string, marker string) (*paneCursorPayload, bool) {
 	if len(lines) == 0 {
 		return nil, false
 	}
 	line := strings.TrimSpace(lines[0])
 	parts := strings.Split(line, "\t")
-	if len(parts) != 3 || parts[0] != "__WMUX_CURSOR" {
+	if len(parts) != 3 || parts[0] != marker {
 		return nil, false
 	}
 	x, err := strconv.Atoi(parts[1])
@@ -742,11 +2550,47 @@ func encodeArgvCommand(argv []string) (string, error) {
 	parts := make([]string, 0, len(argv))
 	parts = append(parts, cmd)
 	for _, arg := range argv[1:] {
+		if strings.ContainsFunc(arg, isControlRune) {
+			parts = append(parts, quoteEscapedArg(arg))
+			continue
+		}
 		parts = append(parts, quoteArg(arg))
 	}
 	return strings.Join(parts, " "), nil
 }
 
+func isControlRune(r rune) bool {
+	return r < 0x20 || r == 0x7f
+}
+
+// quoteEscapedArg double-quotes arg using tmux backslash escapes, so control
+// characters, a newline in particular, cannot end the control-mode command
+// line early. "$" is escaped to prevent environment variable expansion.
+func quoteEscapedArg(arg string) string {
+	var b strings.Builder
+	b.WriteByte('"')
+	for i := 0; i < len(arg); i++ {
+		c := arg[i]
+		switch {
+		case c == '\\' || c == '"' || c == '$':
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		case c == '\n':
+			b.WriteString(`\n`)
+		case c == '\r':
+			b.WriteString(`\r`)
+		case c == '\t':
+			b.WriteString(`\t`)
+		case c < 0x20 || c == 0x7f:
+			fmt.Fprintf(&b, "\\%03o", c)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	b.WriteByte('"')
+	return b.String()
+}
+
 func quoteArg(arg string) string {
 	if arg == "" {
 		return "''"
@@ -758,7 +2602,10 @@ func quoteArg(arg string) string {
 	return "'" + strings.ReplaceAll(arg, "'", "'\\''") + "'"
 }
 
-func joinShellCommand(argv []string) string {
+// JoinShellCommand quotes each element of argv for tmux and the shell and
+// joins them into a single shell-command string, as used for the command of
+// new panes.
+func JoinShellCommand(argv []string) string {
 	parts := make([]string, 0, len(argv))
 	for _, arg := range argv {
 		parts = append(parts, quoteArg(arg))
@@ -766,27 +2613,94 @@ func joinShellCommand(argv []string) string {
 	return strings.Join(parts, " ")
 }
 
-func (c *client) writeLoop() {
-	for msg := range c.send {
-		if err := c.conn.WriteJSON(msg); err != nil {
-			return
+// writeLoop writes queued messages until the client is closed. With maxAge
+// set it sends a 1012 close frame once the connection is that old and then
+// discards further messages while the client completes the close.
+func (c *client) writeLoop(h *Hub, maxAge time.Duration) {
+	var expired <-chan time.Time
+	if maxAge > 0 {
+		timer := time.NewTimer(maxAge)
+		defer timer.Stop()
+		expired = timer.C
+	}
+	draining := false
+	for {
+		select {
+		case msg, ok := <-c.send:
+			if !ok {
+				return
+			}
+			if draining {
+				continue
+			}
+			if err := c.conn.WriteJSON(msg); err != nil {
+				return
+			}
+			if h.opts.FlowControl && len(c.send) <= flowLowWater(cap(c.send)) {
+				h.clientDrained(c)
+			}
+		case <-expired:
+			expired = nil
+			draining = true
+			h.logger().Info("ws client reached max age", "client", c.id, "max_age", maxAge)
+			_ = c.conn.WriteControl(websocket.CloseMessage,
+				websocket.FormatCloseMessage(websocket.CloseServiceRestart, "connection max age reached"),
+				time.Now().Add(time.Second))
+			conn := c.conn
+			time.AfterFunc(wsCloseGrace, func() { _ = conn.Close() })
 		}
 	}
 }
 
-func (c *client) enqueue(msg serverMsg) {
-	defer func() {
-		_ = recover()
-	}()
+// enqueue queues msg without blocking. It reports false when the client's
+// buffer is full or the client is closed; every send on c.send goes through
+// here.
+func (c *client) enqueue(msg serverMsg) bool {
+	c.sendMu.RLock()
+	defer c.sendMu.RUnlock()
+	if c.closed {
+		return false
+	}
 	select {
 	case c.send <- msg:
+		return true
 	default:
+		return false
 	}
 }
 
+// readMessage reads one frame, giving up after maxMessage bytes. The limit is
+// enforced here rather than with conn.SetReadLimit so the connection can be
+// closed with ClosePolicyViolation instead of gorilla's automatic
+// CloseMessageTooBig.
+func (c *client) readMessage() ([]byte, error) {
+	_, r, err := c.conn.NextReader()
+	if err != nil {
+		return nil, err
+	}
+	if c.maxMessage <= 0 {
+		return io.ReadAll(r)
+	}
+	data, err := io.ReadAll(io.LimitReader(r, c.maxMessage+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > c.maxMessage {
+		return nil, errMessageTooLarge
+	}
+	return data, nil
+}
+
 func (c *client) close() {
-	c.closeOnce.Do(func() {
-		close(c.send)
+	c.sendMu.Lock()
+	if c.closed {
+		c.sendMu.Unlock()
+		return
+	}
+	c.closed = true
+	close(c.send)
+	c.sendMu.Unlock()
+	if c.conn != nil {
 		_ = c.conn.Close()
-	})
+	}
 }
@@ -2,7 +2,20 @@ package wshub
 
 import (
 	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
 	"testing"
+	"time"
+	"unicode/utf8"
+
+	"github.com/ampcode/wmux/internal/policy"
+	"github.com/ampcode/wmux/internal/tmuxparse"
+	"github.com/gorilla/websocket"
 )
 
 func TestFilterStateToTargetSession(t *testing.T) {
@@ -41,6 +54,31 @@ func TestFilterStateToTargetSessionNoTargetReturnsUnchanged(t *testing.T) {
 	}
 }
 
+func TestFilterStateToTargetSessionMarksExactlyOneActiveWindow(t *testing.T) {
+	state := statePayload{
+		Windows: []windowPayload{
+			{ID: "@1", Index: 0, Name: "dev"},
+			{ID: "@2", Index: 1, Name: "api"},
+		},
+		Panes: []panePayload{
+			{ID: "%1", SessionName: "dev", WindowID: "@1"},
+			{ID: "%2", SessionName: "dev", WindowID: "@2"},
+		},
+	}
+
+	got := filterStateToTargetSession(state, "dev")
+	if !got.Windows[0].Active || got.Windows[1].Active {
+		t.Fatalf("expected first window to default active: %#v", got.Windows)
+	}
+
+	state.Windows[0].Active = true
+	state.Windows[1].Active = true
+	got = filterStateToTargetSession(state, "dev")
+	if !got.Windows[0].Active || got.Windows[1].Active {
+		t.Fatalf("expected duplicate active flags to collapse: %#v", got.Windows)
+	}
+}
+
 func TestEncodeArgvCommand(t *testing.T) {
 	line, err := encodeArgvCommand([]string{"send-keys", "-t", "%1", "-l", "hello world"})
 	if err != nil {
@@ -63,6 +101,20 @@ func TestEncodeArgvCommandEscapesSingleQuotes(t *testing.T) {
 	}
 }
 
+func TestEncodeArgvCommandEscapesControlCharacters(t *testing.T) {
+	line, err := encodeArgvCommand([]string{"set-buffer", "--", "a\n$b\\\x1b\"c"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := `set-buffer -- "a\n\$b\\\033\"c"`
+	if line != want {
+		t.Fatalf("encoded line mismatch: got=%q want=%q", line, want)
+	}
+	if strings.ContainsAny(line, "\n\r") {
+		t.Fatalf("encoded line contains a raw line break: %q", line)
+	}
+}
+
 func TestEncodeArgvCommandRejectsEmpty(t *testing.T) {
 	if _, err := encodeArgvCommand(nil); err == nil {
 		t.Fatalf("expected error for empty argv")
@@ -70,7 +122,7 @@ func TestEncodeArgvCommandRejectsEmpty(t *testing.T) {
 }
 
 func TestParsePaneCursorOutput(t *testing.T) {
-	c, ok := parsePaneCursorOutput([]string{"__WMUX_CURSOR\t12\t7"})
+	c, ok := parsePaneCursorOutput([]string{"__WMUX_CURSOR\t12\t7"}, cursorMarker(DefaultSentinel))
 	if !ok {
 		t.Fatalf("expected cursor parse success")
 	}
@@ -111,3 +163,908 @@ func TestDecodePaneOutputDataCarriesAcrossChunks(t *testing.T) {
 		t.Fatalf("decoded chunk mismatch: got=%q want=%q", part2, "─")
 	}
 }
+
+func TestRecordParseErrorKeepsBoundedHistory(t *testing.T) {
+	h := &Hub{}
+	for i := 0; i < maxParseErrorRecords+5; i++ {
+		h.recordParseError(tmuxparse.ParseError{Line: strconv.Itoa(i), Message: "bad"})
+	}
+
+	got := h.RecentParseErrors()
+	if len(got) != maxParseErrorRecords {
+		t.Fatalf("len = %d, want %d", len(got), maxParseErrorRecords)
+	}
+	if got[0].Line != "5" || got[len(got)-1].Line != strconv.Itoa(maxParseErrorRecords+4) {
+		t.Fatalf("unexpected retained range: first=%q last=%q", got[0].Line, got[len(got)-1].Line)
+	}
+}
+
+func TestWSHandlerUsesConfiguredClientBuffer(t *testing.T) {
+	h := New(policy.Default(), "dev")
+	srv := httptest.NewServer(h.WSHandler(WSConfig{ClientBuffer: 8}))
+	defer srv.Close()
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http"), nil)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer conn.Close()
+
+	var msg serverMsg
+	if err := conn.ReadJSON(&msg); err != nil {
+		t.Fatalf("read initial state: %v", err)
+	}
+
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	if len(h.clients) != 1 {
+		t.Fatalf("clients = %d, want 1", len(h.clients))
+	}
+	for c := range h.clients {
+		if got := cap(c.send); got != 8 {
+			t.Fatalf("client buffer = %d, want 8", got)
+		}
+	}
+}
+
+func TestWSHandlerClosesOversizedMessageWithPolicyViolation(t *testing.T) {
+	h := New(policy.Default(), "dev")
+	srv := httptest.NewServer(h.WSHandler(WSConfig{MaxMessageBytes: 64}))
+	defer srv.Close()
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http"), nil)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer conn.Close()
+
+	payload := `{"t":"cmd","argv":["send-keys","` + strings.Repeat("x", 128) + `"]}`
+	if err := conn.WriteMessage(websocket.TextMessage, []byte(payload)); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+
+	_ = conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	for {
+		_, _, err := conn.ReadMessage()
+		if err == nil {
+			continue
+		}
+		if !websocket.IsCloseError(err, websocket.ClosePolicyViolation) {
+			t.Fatalf("read error = %v, want policy-violation close", err)
+		}
+		return
+	}
+}
+
+func TestWSHandlerClosesConnectionsAtMaxAge(t *testing.T) {
+	h := New(policy.Default(), "dev")
+	srv := httptest.NewServer(h.WSHandler(WSConfig{MaxAge: 100 * time.Millisecond}))
+	defer srv.Close()
+
+	// The server starts its timer after the upgrade, so a clock started
+	// before dialing never reads less than the server's connection age.
+	opened := time.Now()
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http"), nil)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer conn.Close()
+
+	_ = conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	for {
+		_, _, err := conn.ReadMessage()
+		if err == nil {
+			continue
+		}
+		if !websocket.IsCloseError(err, websocket.CloseServiceRestart) {
+			t.Fatalf("read error = %v, want service-restart close", err)
+		}
+		if age := time.Since(opened); age < 100*time.Millisecond {
+			t.Fatalf("closed after %v, before max age", age)
+		}
+		return
+	}
+}
+
+func TestHubBroadcastsPaneDeadOnTransition(t *testing.T) {
+	h := New(policy.Default(), "dev")
+	srv := httptest.NewServer(h.WSHandler(WSConfig{}))
+	defer srv.Close()
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http"), nil)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer conn.Close()
+
+	feed := func(id int, dead, status string) {
+		n := strconv.Itoa(id)
+		h.BroadcastTmuxStdoutLine("%begin 1 " + n + " 0")
+		h.BroadcastTmuxStdoutLine("__WMUX___pane\tdev\t%1\t@1\t0\t1\t0\t0\t120\t40\tbash\tbash\t0\tweb\t1\t" + dead + "\t" + status)
+		h.BroadcastTmuxStdoutLine("%end 1 " + n + " 0")
+	}
+	feed(1, "0", "")
+	feed(2, "1", "3")
+
+	_ = conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	for {
+		var msg serverMsg
+		if err := conn.ReadJSON(&msg); err != nil {
+			t.Fatalf("read: %v (no pane_dead received)", err)
+		}
+		if msg.T != "pane_dead" {
+			continue
+		}
+		if msg.PaneDead == nil || msg.PaneDead.PaneID != "%1" || msg.PaneDead.Status != 3 {
+			t.Fatalf("unexpected pane_dead payload: %#v", msg.PaneDead)
+		}
+		return
+	}
+}
+
+func TestHubSkipsIdenticalStateBroadcasts(t *testing.T) {
+	h := New(policy.Default(), "dev")
+	if err := h.BindTmux(&countingSender{}); err != nil {
+		t.Fatalf("BindTmux: %v", err)
+	}
+	c := &client{send: make(chan serverMsg, 32)}
+	h.addClient(c)
+
+	feed := func(id int) {
+		n := strconv.Itoa(id)
+		sendFromClient(t, h, c, "list-panes", "-a")
+		h.BroadcastTmuxStdoutLine("%begin 1 " + n + " 0")
+		h.BroadcastTmuxStdoutLine("__WMUX___pane\tdev\t%1\t@1\t0\t1\t0\t0\t120\t40\tbash\tbash\t0\tweb\t1")
+		h.BroadcastTmuxStdoutLine("%end 1 " + n + " 0")
+	}
+	feed(1)
+	feed(2)
+
+	states, commands := 0, 0
+	deadline := time.After(2 * time.Second)
+	for commands < 2 {
+		select {
+		case msg := <-c.send:
+			switch msg.T {
+			case "tmux_state":
+				states++
+			case "tmux_command":
+				commands++
+			}
+		case <-deadline:
+			t.Fatalf("timed out waiting for command responses (got %d)", commands)
+		}
+	}
+	// Identical snapshots from other paths are suppressed too.
+	h.mu.RLock()
+	snapshot := h.model.snapshot()
+	h.mu.RUnlock()
+	h.broadcastState(&snapshot)
+	for len(c.send) > 0 {
+		if msg := <-c.send; msg.T == "tmux_state" {
+			states++
+		}
+	}
+	if states != 1 {
+		t.Fatalf("tmux_state broadcasts = %d, want 1", states)
+	}
+}
+
+// lateCreateSender holds back every reply until ready is closed, then feeds
+// them to the hub in order from a single goroutine, as tmux's stdout would.
+type lateCreateSender struct {
+	hub     *Hub
+	mu      sync.Mutex
+	lines   []string
+	ready   chan struct{}
+	replies chan []string
+}
+
+func newLateCreateSender(h *Hub) *lateCreateSender {
+	s := &lateCreateSender{hub: h, ready: make(chan struct{}), replies: make(chan []string, 8)}
+	go s.feed()
+	return s
+}
+
+func (s *lateCreateSender) feed() {
+	<-s.ready
+	for reply := range s.replies {
+		for _, line := range reply {
+			s.hub.BroadcastTmuxStdoutLine(line)
+		}
+	}
+}
+
+func (s *lateCreateSender) Send(line string) error {
+	s.mu.Lock()
+	s.lines = append(s.lines, line)
+	s.mu.Unlock()
+	switch {
+	case strings.HasPrefix(line, "split-window "):
+		s.replies <- []string{"%begin 1 1 0", "%21", "%end 1 1 0"}
+	case strings.HasPrefix(line, "kill-pane "):
+		s.replies <- []string{"%begin 2 2 0", "%end 2 2 0"}
+	}
+	return nil
+}
+
+func (s *lateCreateSender) sent(prefix string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, line := range s.lines {
+		if strings.HasPrefix(line, prefix) {
+			return line
+		}
+	}
+	return ""
+}
+
+func TestCreatePaneTimeoutKillsLateCreatedPaneWhenEnabled(t *testing.T) {
+	h := NewWithOptions(policy.Default(), "dev", Options{KillOrphanedPanes: true, CreatePaneTimeout: 20 * time.Millisecond})
+	tmux := newLateCreateSender(h)
+	if err := h.BindTmux(tmux); err != nil {
+		t.Fatalf("BindTmux: %v", err)
+	}
+
+	if _, err := h.CreatePane(CreatePaneOptions{}); err == nil {
+		t.Fatalf("expected CreatePane timeout")
+	}
+	close(tmux.ready)
+
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		if line := tmux.sent("kill-pane "); line != "" {
+			if line != "kill-pane -t %21" {
+				t.Fatalf("unexpected kill-pane command: %q", line)
+			}
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("expected orphaned pane to be killed")
+}
+
+func TestCreatePaneContextWaitsForCallerDeadline(t *testing.T) {
+	// The HTTP create timeout is longer than the internal one by default;
+	// keep that relationship with shorter values.
+	h := NewWithOptions(policy.Default(), "dev", Options{CreatePaneTimeout: 20 * time.Millisecond})
+	if err := h.BindTmux(&countingSender{}); err != nil {
+		t.Fatalf("BindTmux: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 150*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err := h.CreatePaneContext(ctx, CreatePaneOptions{})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("CreatePaneContext err = %v, want context.DeadlineExceeded", err)
+	}
+	if elapsed := time.Since(start); elapsed < 100*time.Millisecond {
+		t.Fatalf("CreatePaneContext returned after %v, before the caller's deadline", elapsed)
+	}
+
+	if _, err := h.CreatePane(CreatePaneOptions{}); !errors.Is(err, ErrCommandTimeout) {
+		t.Fatalf("CreatePane err = %v, want ErrCommandTimeout", err)
+	}
+}
+
+// sendFromClient queues argv through sendPending as if client c had sent it
+// over /ws.
+func sendFromClient(t *testing.T, h *Hub, c *client, argv ...string) {
+	t.Helper()
+	line, err := encodeArgvCommand(argv)
+	if err != nil {
+		t.Fatalf("encodeArgvCommand: %v", err)
+	}
+	p := pendingFromArgv(argv)
+	p.Requester = c
+	if err := h.sendPending(p, line); err != nil {
+		t.Fatalf("sendPending: %v", err)
+	}
+}
+
+type countingSender struct {
+	mu    sync.Mutex
+	lines []string
+}
+
+func (s *countingSender) Send(line string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lines = append(s.lines, line)
+	return nil
+}
+
+func (s *countingSender) count(prefix string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := 0
+	for _, line := range s.lines {
+		if strings.HasPrefix(line, prefix) {
+			n++
+		}
+	}
+	return n
+}
+
+func TestScheduleResyncCoalescesNotificationBursts(t *testing.T) {
+	h := NewWithOptions(policy.Default(), "dev", Options{ResyncDebounce: 50 * time.Millisecond})
+	tmux := &countingSender{}
+	if err := h.BindTmux(tmux); err != nil {
+		t.Fatalf("BindTmux: %v", err)
+	}
+
+	for i := 0; i < 20; i++ {
+		h.BroadcastTmuxStdoutLine("%window-add @" + strconv.Itoa(i))
+	}
+	time.Sleep(200 * time.Millisecond)
+
+	if got := tmux.count("list-panes "); got != 1 {
+		t.Fatalf("list-panes sent %d times, want 1", got)
+	}
+}
+
+func TestHubWindowCloseRemovesWindowFromState(t *testing.T) {
+	h := NewWithOptions(policy.Default(), "dev", Options{ResyncDebounce: time.Hour})
+	c := &client{send: make(chan serverMsg, 32)}
+	h.addClient(c)
+
+	h.BroadcastTmuxStdoutLine("%begin 1 1 0")
+	h.BroadcastTmuxStdoutLine("__WMUX___pane\tdev\t%1\t@1\t0\t1\t0\t0\t120\t40\tbash\tbash\t0\tweb\t1")
+	h.BroadcastTmuxStdoutLine("__WMUX___pane\tdev\t%2\t@2\t0\t1\t0\t0\t120\t40\tbash\tbash\t1\tapi\t0")
+	h.BroadcastTmuxStdoutLine("%end 1 1 0")
+	h.BroadcastTmuxStdoutLine("%unlinked-window-close @2")
+
+	deadline := time.After(2 * time.Second)
+	for {
+		select {
+		case msg := <-c.send:
+			if msg.T != "tmux_state" || msg.State == nil || len(msg.State.Windows) != 1 {
+				continue
+			}
+			if msg.State.Windows[0].ID != "@1" || len(msg.State.Panes) != 1 || msg.State.Panes[0].ID != "%1" {
+				t.Fatalf("unexpected state after window close: %#v", msg.State)
+			}
+			return
+		case <-deadline:
+			t.Fatalf("timed out waiting for state without closed window")
+		}
+	}
+}
+
+func TestHubSendsReadyAfterFirstListPanes(t *testing.T) {
+	h := New(policy.Default(), "dev")
+	tmux := &countingSender{}
+	if err := h.BindTmux(tmux); err != nil {
+		t.Fatalf("BindTmux: %v", err)
+	}
+	early := &client{send: make(chan serverMsg, 32)}
+	h.addClient(early)
+
+	if err := h.RequestStateSync(); err != nil {
+		t.Fatalf("RequestStateSync: %v", err)
+	}
+	h.BroadcastTmuxStdoutLine("%begin 1 1 0")
+	h.BroadcastTmuxStdoutLine("%end 1 1 0")
+
+	deadline := time.After(2 * time.Second)
+	for ready := false; !ready; {
+		select {
+		case msg := <-early.send:
+			ready = msg.T == "ready"
+		case <-deadline:
+			t.Fatalf("timed out waiting for ready after empty list-panes")
+		}
+	}
+
+	if _, synced := h.currentStateAndSynced(); !synced {
+		t.Fatalf("expected hub to be synced")
+	}
+
+	// A client connecting after the sync gets ready right after its state.
+	srv := httptest.NewServer(h.WSHandler(WSConfig{}))
+	defer srv.Close()
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http"), nil)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer conn.Close()
+	_ = conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	var kinds []string
+	for len(kinds) < 3 {
+		var msg serverMsg
+		if err := conn.ReadJSON(&msg); err != nil {
+			t.Fatalf("read: %v", err)
+		}
+		kinds = append(kinds, msg.T)
+	}
+	if strings.Join(kinds, ",") != "protocol,tmux_state,ready" {
+		t.Fatalf("connect messages = %v, want protocol, tmux_state, ready", kinds)
+	}
+
+	h.BroadcastDisconnected(nil)
+	if _, synced := h.currentStateAndSynced(); synced {
+		t.Fatalf("expected disconnect to clear synced")
+	}
+}
+
+func TestHubBroadcastsReconnectingUntilConnected(t *testing.T) {
+	h := New(policy.Default(), "dev")
+	if err := h.BindTmux(&countingSender{}); err != nil {
+		t.Fatalf("BindTmux: %v", err)
+	}
+	c := &client{send: make(chan serverMsg, 32)}
+	h.addClient(c)
+
+	next := time.Now().Add(4 * time.Second)
+	h.BroadcastReconnecting(3, next)
+	msg := <-c.send
+	if msg.T != "tmux_reconnecting" || msg.Reconnect == nil {
+		t.Fatalf("message = %#v, want tmux_reconnecting", msg)
+	}
+	if msg.Reconnect.Attempt != 3 || msg.Reconnect.RetryInMS <= 0 || msg.Reconnect.RetryInMS > 4000 {
+		t.Fatalf("reconnect = %#v, want attempt 3 within 4s", msg.Reconnect)
+	}
+	if msg.Reconnect.NextRetry != next.UTC().Format(time.RFC3339Nano) {
+		t.Fatalf("next_retry = %q", msg.Reconnect.NextRetry)
+	}
+
+	// A client connecting mid-backoff learns about the pending attempt.
+	if got := h.currentReconnect(); got == nil || got.Attempt != 3 {
+		t.Fatalf("currentReconnect() = %#v, want attempt 3", got)
+	}
+	h.BroadcastConnected()
+	if got := h.currentReconnect(); got != nil {
+		t.Fatalf("currentReconnect() after connect = %#v, want nil", got)
+	}
+}
+
+func TestHubFocusWindowScopesStateAndOutput(t *testing.T) {
+	h := New(policy.Default(), "dev")
+	if err := h.BindTmux(&countingSender{}); err != nil {
+		t.Fatalf("BindTmux: %v", err)
+	}
+	c := &client{send: make(chan serverMsg, 32)}
+	h.addClient(c)
+
+	sendFromClient(t, h, c, "list-panes", "-a")
+	h.BroadcastTmuxStdoutLine("%begin 1 1 0")
+	h.BroadcastTmuxStdoutLine("__WMUX___pane\tdev\t%1\t@1\t0\t1\t0\t0\t120\t40\tbash\tbash\t0\tweb\t1")
+	h.BroadcastTmuxStdoutLine("__WMUX___pane\tdev\t%2\t@2\t0\t1\t0\t0\t120\t40\tbash\tbash\t1\tapi\t0")
+	h.BroadcastTmuxStdoutLine("%end 1 1 0")
+	deadline := time.After(2 * time.Second)
+	for done := false; !done; {
+		select {
+		case msg := <-c.send:
+			done = msg.T == "tmux_command"
+		case <-deadline:
+			t.Fatalf("timed out waiting for list-panes result")
+		}
+	}
+	for len(c.send) > 0 {
+		<-c.send
+	}
+
+	if err := h.focusWindow(c, "2"); err != nil {
+		t.Fatalf("focusWindow: %v", err)
+	}
+	msg := <-c.send
+	if msg.T != "tmux_state" || len(msg.State.Windows) != 1 || msg.State.Windows[0].ID != "@2" || len(msg.State.Panes) != 1 || msg.State.Panes[0].ID != "%2" {
+		t.Fatalf("focused state = %#v", msg.State)
+	}
+
+	h.BroadcastTmuxStdoutLine("%output %1 other")
+	h.BroadcastTmuxStdoutLine("%output %2 mine")
+	h.BroadcastTmuxStdoutLine("%output %9 unknown")
+	var got []string
+	deadline = time.After(2 * time.Second)
+	for len(got) < 2 {
+		select {
+		case msg := <-c.send:
+			if msg.T == "pane_output" {
+				got = append(got, msg.PaneOutput.PaneID)
+			}
+		case <-deadline:
+			t.Fatalf("timed out waiting for output, got %v", got)
+		}
+	}
+	if strings.Join(got, ",") != "%2,%9" {
+		t.Fatalf("pane_output delivered for %v, want %%2 and unknown %%9 only", got)
+	}
+
+	if err := h.focusWindow(c, "@7"); err == nil {
+		t.Fatalf("expected error focusing unknown window")
+	}
+	if err := h.focusWindow(c, ""); err != nil {
+		t.Fatalf("clear focus: %v", err)
+	}
+	if msg := <-c.send; len(msg.State.Windows) != 2 {
+		t.Fatalf("unfocused state windows = %d, want 2", len(msg.State.Windows))
+	}
+}
+
+func TestExtendedOutputDeliversPaneOutputAndRecordsAge(t *testing.T) {
+	h := New(policy.Default(), "dev")
+	c := &client{send: make(chan serverMsg, 32)}
+	h.addClient(c)
+
+	h.BroadcastTmuxStdoutLine("%extended-output %3 40 : slow")
+	h.BroadcastTmuxStdoutLine("%extended-output %3 15 : fast")
+
+	var got []string
+	deadline := time.After(2 * time.Second)
+	for len(got) < 2 {
+		select {
+		case msg := <-c.send:
+			if msg.T == "pane_output" {
+				if msg.PaneOutput.PaneID != "%3" {
+					t.Fatalf("pane_output pane = %q, want %%3", msg.PaneOutput.PaneID)
+				}
+				got = append(got, msg.PaneOutput.Data)
+			}
+		case <-deadline:
+			t.Fatalf("timed out waiting for output, got %v", got)
+		}
+	}
+	if strings.Join(got, ",") != "slow,fast" {
+		t.Fatalf("pane_output data = %v, want slow,fast", got)
+	}
+
+	want := OutputLatency{Samples: 2, LastMS: 15, MaxMS: 40, TotalMS: 55}
+	if lat := h.OutputLatencySnapshot(); lat != want {
+		t.Fatalf("latency = %+v, want %+v", lat, want)
+	}
+}
+
+func TestConfigErrorBroadcastAndRetained(t *testing.T) {
+	h := New(policy.Default(), "dev")
+	c := &client{send: make(chan serverMsg, 32)}
+	h.addClient(c)
+
+	h.BroadcastTmuxStdoutLine("%config-error /root/.tmux.conf:3: unknown command: sett")
+
+	deadline := time.After(2 * time.Second)
+	for done := false; !done; {
+		select {
+		case msg := <-c.send:
+			if msg.T == "tmux_notification" {
+				t.Fatalf("config error forwarded as generic notification: %+v", msg.Notification)
+			}
+			if msg.T == "tmux_config_error" {
+				if msg.Message != "/root/.tmux.conf:3: unknown command: sett" {
+					t.Fatalf("message = %q", msg.Message)
+				}
+				done = true
+			}
+		case <-deadline:
+			t.Fatalf("timed out waiting for tmux_config_error")
+		}
+	}
+
+	records := h.RecentConfigErrors()
+	if len(records) != 1 || records[0].Message != "/root/.tmux.conf:3: unknown command: sett" || records[0].At == "" {
+		t.Fatalf("config errors = %+v", records)
+	}
+}
+
+func TestRecordConfigErrorKeepsBoundedHistory(t *testing.T) {
+	h := &Hub{}
+	for i := 0; i < maxConfigErrorRecords+5; i++ {
+		h.recordConfigError(strconv.Itoa(i))
+	}
+	records := h.RecentConfigErrors()
+	if len(records) != maxConfigErrorRecords || records[0].Message != "5" {
+		t.Fatalf("config errors = %d starting at %q, want %d starting at 5", len(records), records[0].Message, maxConfigErrorRecords)
+	}
+}
+
+func TestWSHandlerRejectsClientsBeyondMaxClients(t *testing.T) {
+	h := New(policy.Default(), "dev")
+	srv := httptest.NewServer(h.WSHandler(WSConfig{MaxClients: 2}))
+	defer srv.Close()
+	url := "ws" + strings.TrimPrefix(srv.URL, "http")
+
+	var conns []*websocket.Conn
+	for i := 0; i < 2; i++ {
+		conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+		if err != nil {
+			t.Fatalf("dial %d: %v", i, err)
+		}
+		conns = append(conns, conn)
+	}
+
+	_, resp, err := websocket.DefaultDialer.Dial(url, nil)
+	if err == nil {
+		t.Fatalf("expected third connection to be refused")
+	}
+	if resp == nil || resp.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("refused response = %#v, want 503", resp)
+	}
+	if got := resp.Header.Get("Retry-After"); got != "5" {
+		t.Fatalf("Retry-After = %q, want 5", got)
+	}
+
+	// Closing one connection frees its slot.
+	conns[0].Close()
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+		if err == nil {
+			conn.Close()
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("slot not released after close: %v", err)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	conns[1].Close()
+}
+
+func TestRetryAfterFollowsReconnectBackoff(t *testing.T) {
+	h := New(policy.Default(), "dev")
+	if got := h.RetryAfter(); got != time.Second {
+		t.Fatalf("connected RetryAfter = %v, want 1s", got)
+	}
+
+	h.BroadcastReconnecting(2, time.Now().Add(8*time.Second))
+	if got := h.RetryAfter(); got <= 7*time.Second || got > 8*time.Second {
+		t.Fatalf("reconnecting RetryAfter = %v, want about 8s", got)
+	}
+
+	h.BroadcastReconnecting(3, time.Now().Add(-time.Second))
+	if got := h.RetryAfter(); got != time.Second {
+		t.Fatalf("overdue RetryAfter = %v, want 1s floor", got)
+	}
+
+	h.BroadcastConnected()
+	if got := h.RetryAfter(); got != time.Second {
+		t.Fatalf("reconnected RetryAfter = %v, want 1s", got)
+	}
+}
+
+func TestHubBroadcastDuringClientCloseDoesNotPanic(t *testing.T) {
+	for i := 0; i < 50; i++ {
+		h := New(policy.Default(), "dev")
+		c := &client{send: make(chan serverMsg, 1)}
+		h.addClient(c)
+
+		var wg sync.WaitGroup
+		for g := 0; g < 4; g++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for n := 0; n < 50; n++ {
+					h.broadcast(serverMsg{T: "tmux_restarted"})
+					c.enqueue(serverMsg{T: "error", Message: "x"})
+				}
+			}()
+		}
+		// Close both through the hub and directly, racing the senders.
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			h.removeClient(c)
+		}()
+		go func() {
+			defer wg.Done()
+			c.close()
+		}()
+		wg.Wait()
+
+		if c.enqueue(serverMsg{T: "tmux_restarted"}) {
+			t.Fatalf("enqueue on a closed client reported success")
+		}
+	}
+}
+
+func TestSendKeysStepsSplitsControlCharacters(t *testing.T) {
+	steps := sendKeysSteps("%1", "ls -l\r\nq\x03\x1b\x00")
+	var got []string
+	for _, argv := range steps {
+		got = append(got, strings.Join(argv[3:], " "))
+	}
+	want := "-l ls -l|Enter|-l q|C-c|Escape|-H 00"
+	if strings.Join(got, "|") != want {
+		t.Fatalf("steps = %q, want %q", strings.Join(got, "|"), want)
+	}
+
+	h := New(policy.Policy{}, "dev")
+	if err := h.SendKeys(context.Background(), "%1", "x"); err == nil {
+		t.Fatalf("expected send-keys to be blocked by an empty policy")
+	}
+}
+
+func TestHubCommandPayloadCarriesErrorText(t *testing.T) {
+	h := New(policy.Default(), "dev")
+	if err := h.BindTmux(&countingSender{}); err != nil {
+		t.Fatalf("BindTmux: %v", err)
+	}
+	c := &client{send: make(chan serverMsg, 32)}
+	h.addClient(c)
+
+	sendFromClient(t, h, c, "display-message", "-t", "%99")
+	sendFromClient(t, h, c, "display-message", "-p", "ok")
+	h.BroadcastTmuxStdoutLine("%begin 1 1 0")
+	h.BroadcastTmuxStdoutLine("can't find pane: %99")
+	h.BroadcastTmuxStdoutLine("%error 1 1 0")
+	h.BroadcastTmuxStdoutLine("%begin 2 2 0")
+	h.BroadcastTmuxStdoutLine("ok")
+	h.BroadcastTmuxStdoutLine("%end 2 2 0")
+
+	var commands []*commandPayload
+	deadline := time.After(2 * time.Second)
+	for len(commands) < 2 {
+		select {
+		case msg := <-c.send:
+			if msg.T == "tmux_command" {
+				commands = append(commands, msg.Command)
+			}
+		case <-deadline:
+			t.Fatalf("timed out waiting for command results, got %d", len(commands))
+		}
+	}
+	if commands[0].Success || commands[0].Error != "can't find pane: %99" {
+		t.Fatalf("failed command = %#v, want error text", commands[0])
+	}
+	if !commands[1].Success || commands[1].Error != "" {
+		t.Fatalf("successful command = %#v, want no error", commands[1])
+	}
+}
+
+func TestHubBroadcastsPaneFocusChanges(t *testing.T) {
+	h := New(policy.Default(), "dev")
+	if err := h.BindTmux(&countingSender{}); err != nil {
+		t.Fatalf("BindTmux: %v", err)
+	}
+	c := &client{send: make(chan serverMsg, 32)}
+	h.addClient(c)
+
+	sync := func(active1, active2 string) {
+		sendFromClient(t, h, c, "list-panes", "-a")
+		h.BroadcastTmuxStdoutLine("%begin 1 1 0")
+		h.BroadcastTmuxStdoutLine("__WMUX___pane\tdev\t%1\t@1\t0\t" + active1 + "\t0\t0\t60\t40\tvim\tvim\t0\tweb\t1")
+		h.BroadcastTmuxStdoutLine("__WMUX___pane\tdev\t%2\t@1\t1\t" + active2 + "\t60\t0\t60\t40\tbash\tbash\t0\tweb\t1")
+		h.BroadcastTmuxStdoutLine("%end 1 1 0")
+	}
+	// collect gathers pane_focus messages up to an empty marker command,
+	// which is processed after everything derived from the sync.
+	collect := func() []paneFocusPayload {
+		sendFromClient(t, h, c, "display-message", "-p", "")
+		h.BroadcastTmuxStdoutLine("%begin 2 2 0")
+		h.BroadcastTmuxStdoutLine("%end 2 2 0")
+		var focus []paneFocusPayload
+		commands := 0
+		deadline := time.After(2 * time.Second)
+		for commands < 2 {
+			select {
+			case msg := <-c.send:
+				switch msg.T {
+				case "pane_focus":
+					focus = append(focus, *msg.PaneFocus)
+				case "tmux_command":
+					commands++
+				}
+			case <-deadline:
+				t.Fatalf("timed out waiting for list-panes result")
+			}
+		}
+		return focus
+	}
+
+	sync("1", "0")
+	if got := collect(); len(got) != 0 {
+		t.Fatalf("initial sync focus = %#v, want none", got)
+	}
+	sync("0", "1")
+	got := collect()
+	want := []paneFocusPayload{{PaneID: "%1", Focused: false}, {PaneID: "%2", Focused: true}}
+	if len(got) != len(want) || got[0] != want[0] || got[1] != want[1] {
+		t.Fatalf("focus = %#v, want %#v", got, want)
+	}
+	sync("0", "1")
+	if got := collect(); len(got) != 0 {
+		t.Fatalf("unchanged sync focus = %#v, want none", got)
+	}
+}
+
+func TestJoinCaptureStopsAtLimit(t *testing.T) {
+	lines := []string{"aaaa", "bbbb", "cccc"}
+	if got, err := joinCapture(lines, 14, CaptureTruncate); err != nil || got != "aaaa\nbbbb\ncccc" {
+		t.Fatalf("exact fit = %q, %v", got, err)
+	}
+	got, err := joinCapture(lines, 10, CaptureTruncate)
+	if err != nil {
+		t.Fatalf("truncate: %v", err)
+	}
+	if want := "aaaa\nbbbb\n[wmux: capture truncated at 10 bytes; 1 of 3 lines omitted]"; got != want {
+		t.Fatalf("truncated = %q, want %q", got, want)
+	}
+	if _, err := joinCapture(lines, 10, CaptureError); !errors.Is(err, ErrCaptureTooLarge) {
+		t.Fatalf("error mode err = %v, want ErrCaptureTooLarge", err)
+	}
+}
+
+func TestClientNameTruncatesOnRuneBoundary(t *testing.T) {
+	cases := map[string]string{
+		" alice-laptop ":                                 "alice-laptop",
+		strings.Repeat("a", 200):                         strings.Repeat("a", maxClientNameLength),
+		strings.Repeat("a", maxClientNameLength-1) + "é": strings.Repeat("a", maxClientNameLength-1),
+		strings.Repeat("é", 100):                         strings.Repeat("é", maxClientNameLength/2),
+	}
+	for in, want := range cases {
+		got := clientName(in)
+		if got != want {
+			t.Fatalf("clientName(%q) = %q, want %q", in, got, want)
+		}
+		if !utf8.ValidString(got) {
+			t.Fatalf("clientName(%q) = %q, not valid UTF-8", in, got)
+		}
+	}
+}
+
+func TestClientTermNormalizesHelloTerm(t *testing.T) {
+	cases := map[string]string{
+		" xterm-256color ":       "xterm-256color",
+		"ghostty":                "ghostty",
+		"":                       "",
+		"xterm 256":              "",
+		"xterm\x1b[31m":          "",
+		strings.Repeat("a", 100): strings.Repeat("a", maxClientTermLength),
+	}
+	for in, want := range cases {
+		if got := clientTerm(in); got != want {
+			t.Fatalf("clientTerm(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+type failingSender struct{}
+
+func (failingSender) Send(string) error { return errors.New("write /dev/ptmx: input/output error") }
+
+// echoSender answers each command before Send returns, as tmux can when the
+// reader goroutine wins the race with the writer.
+type echoSender struct{ hub *Hub }
+
+func (s echoSender) Send(string) error {
+	s.hub.BroadcastTmuxStdoutLine("%begin 1 1 0")
+	s.hub.BroadcastTmuxStdoutLine("%end 1 1 0")
+	return nil
+}
+
+func TestHubSendFailureLeavesNoPendingCommand(t *testing.T) {
+	h := New(policy.Default(), "dev")
+	if err := h.RequestStateSync(); !errors.Is(err, ErrTmuxNotReady) {
+		t.Fatalf("RequestStateSync without tmux = %v, want ErrTmuxNotReady", err)
+	}
+	if err := h.BindTmux(failingSender{}); err != nil {
+		t.Fatalf("BindTmux: %v", err)
+	}
+	if err := h.RequestStateSync(); !errors.Is(err, ErrTmuxNotReady) {
+		t.Fatalf("RequestStateSync = %v, want ErrTmuxNotReady", err)
+	}
+	if _, err := h.runCommandAndWait(context.Background(), []string{"list-windows"}, time.Second, false); !errors.Is(err, ErrTmuxNotReady) {
+		t.Fatalf("runCommandAndWait = %v, want ErrTmuxNotReady", err)
+	}
+	if pending := h.PendingSnapshot(); len(pending) != 0 {
+		t.Fatalf("pending = %+v, want none after failed sends", pending)
+	}
+}
+
+func TestHubRegistersPendingBeforeSend(t *testing.T) {
+	h := New(policy.Default(), "dev")
+	if err := h.BindTmux(echoSender{hub: h}); err != nil {
+		t.Fatalf("BindTmux: %v", err)
+	}
+	if err := h.RequestStateSync(); err != nil {
+		t.Fatalf("RequestStateSync: %v", err)
+	}
+	deadline := time.Now().Add(time.Second)
+	for len(h.PendingSnapshot()) != 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("pending = %+v, want the early response paired", h.PendingSnapshot())
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
@@ -0,0 +1,106 @@
+package wshub
+
+import (
+	"strings"
+	"unicode/utf8"
+)
+
+// lineModeMaxLine bounds the partial line buffered per pane for a client in
+// line mode. A pane that writes more than this without a newline has its
+// buffer emitted in pieces of at most this many bytes.
+const lineModeMaxLine = 16 * 1024
+
+// setLineMode turns line framing of pane_output on or off for c. Turning it
+// off returns the buffered partial lines so they can be delivered as-is.
+func (c *client) setLineMode(enabled bool) []serverMsg {
+	c.lineMu.Lock()
+	defer c.lineMu.Unlock()
+	c.lineMode = enabled
+	if enabled {
+		if c.linePartial == nil {
+			c.linePartial = map[string]string{}
+		}
+		return nil
+	}
+	var out []serverMsg
+	for paneID, partial := range c.linePartial {
+		out = append(out, paneOutputMsg(paneID, partial))
+	}
+	c.linePartial = nil
+	return out
+}
+
+func (c *client) inLineMode() bool {
+	c.lineMu.Lock()
+	defer c.lineMu.Unlock()
+	return c.lineMode
+}
+
+// frameLines applies line mode to m. It reports false when m should be sent
+// unchanged: line mode is off or m is not pane_output or pane_dead.
+// Otherwise it returns the messages to send in m's place, which may be none
+// while a line is still incomplete. A pane's partial line is flushed ahead
+// of its pane_dead.
+func (c *client) frameLines(m serverMsg) ([]serverMsg, bool) {
+	c.lineMu.Lock()
+	defer c.lineMu.Unlock()
+	if !c.lineMode {
+		return nil, false
+	}
+	switch {
+	case m.PaneDead != nil:
+		paneID := m.PaneDead.PaneID
+		partial, ok := c.linePartial[paneID]
+		if !ok {
+			return nil, false
+		}
+		delete(c.linePartial, paneID)
+		return []serverMsg{paneOutputMsg(paneID, partial), m}, true
+	case m.PaneOutput != nil:
+		paneID := m.PaneOutput.PaneID
+		lines, rest := splitLines(c.linePartial[paneID] + m.PaneOutput.Data)
+		if rest == "" {
+			delete(c.linePartial, paneID)
+		} else {
+			c.linePartial[paneID] = rest
+		}
+		out := make([]serverMsg, 0, len(lines))
+		for _, line := range lines {
+			out = append(out, paneOutputMsg(paneID, line))
+		}
+		return out, true
+	}
+	return nil, false
+}
+
+// splitLines cuts data into newline-terminated lines and the trailing
+// incomplete line. An incomplete line reaching lineModeMaxLine is emitted in
+// pieces, each cut on a UTF-8 boundary.
+func splitLines(data string) ([]string, string) {
+	var lines []string
+	for {
+		i := strings.IndexByte(data, '\n')
+		if i < 0 {
+			break
+		}
+		lines = append(lines, data[:i+1])
+		data = data[i+1:]
+	}
+	for len(data) >= lineModeMaxLine {
+		cut := lineModeMaxLine
+		// Cutting at the end of data needs no boundary check.
+		for cut > 0 && cut < len(data) && !utf8.RuneStart(data[cut]) {
+			cut--
+		}
+		if cut == 0 {
+			cut = lineModeMaxLine
+		}
+		lines = append(lines, data[:cut])
+		data = data[cut:]
+	}
+	return lines, data
+}
+
+func paneOutputMsg(paneID, data string) serverMsg {
+	return serverMsg{T: "pane_output", PaneOutput: &paneOutputPayload{PaneID: paneID, Data: data}}
+}
@@ -0,0 +1,103 @@
+package wshub
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ampcode/wmux/internal/policy"
+)
+
+func TestSplitLinesKeepsNewlinesAndRemainder(t *testing.T) {
+	lines, rest := splitLines("a\r\nb\n\nc")
+	if strings.Join(lines, "|") != "a\r\n|b\n|\n" || rest != "c" {
+		t.Fatalf("splitLines = %q, %q", lines, rest)
+	}
+
+	long := strings.Repeat("x", lineModeMaxLine-1) + "é" + "tail"
+	lines, rest = splitLines(long)
+	if len(lines) != 1 || len(lines[0]) != lineModeMaxLine-1 || rest != "étail" {
+		t.Fatalf("long line split into %d pieces, rest %q", len(lines), rest)
+	}
+}
+
+func TestSplitLinesHandlesPartialOfExactlyMaxLine(t *testing.T) {
+	exact := strings.Repeat("x", lineModeMaxLine)
+	lines, rest := splitLines(exact)
+	if len(lines) != 1 || lines[0] != exact || rest != "" {
+		t.Fatalf("splitLines(%d bytes) = %d pieces, rest %q", lineModeMaxLine, len(lines), rest)
+	}
+
+	// The same through a line-mode client, where the panic would have
+	// happened inside broadcast.
+	h := New(policy.Default(), "dev")
+	c := &client{send: make(chan serverMsg, 4)}
+	h.addClient(c)
+	c.setLineMode(true)
+	h.BroadcastTmuxStdoutLine("%output %1 " + exact)
+	select {
+	case msg := <-c.send:
+		if msg.PaneOutput == nil || len(msg.PaneOutput.Data) != lineModeMaxLine {
+			t.Fatalf("msg = %+v, want one %d-byte pane_output", msg.T, lineModeMaxLine)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("timed out waiting for pane_output")
+	}
+}
+
+func TestHubLineModeFramesPaneOutput(t *testing.T) {
+	h := New(policy.Default(), "dev")
+	c := &client{send: make(chan serverMsg, 32)}
+	h.addClient(c)
+	c.setLineMode(true)
+
+	h.BroadcastTmuxStdoutLine("%output %1 hel")
+	h.BroadcastTmuxStdoutLine(`%output %1 lo\012wor`)
+	h.BroadcastTmuxStdoutLine(`%output %2 other\012`)
+
+	var got []string
+	deadline := time.After(2 * time.Second)
+	for len(got) < 2 {
+		select {
+		case msg := <-c.send:
+			if msg.PaneOutput != nil {
+				got = append(got, msg.PaneOutput.PaneID+":"+msg.PaneOutput.Data)
+			}
+		case <-deadline:
+			t.Fatalf("timed out waiting for framed output, got %q", got)
+		}
+	}
+	if strings.Join(got, "|") != "%1:hello\n|%2:other\n" {
+		t.Fatalf("framed output = %q", got)
+	}
+	if !c.info().LineMode {
+		t.Fatalf("client info does not report line mode")
+	}
+
+	// Turning line mode off hands back the buffered partial line.
+	flushed := c.setLineMode(false)
+	if len(flushed) != 1 || flushed[0].PaneOutput.PaneID != "%1" || flushed[0].PaneOutput.Data != "wor" {
+		t.Fatalf("flushed = %#v", flushed)
+	}
+	h.BroadcastTmuxStdoutLine("%output %1 raw")
+	select {
+	case msg := <-c.send:
+		if msg.PaneOutput == nil || msg.PaneOutput.Data != "raw" {
+			t.Fatalf("output after line mode off = %#v", msg)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("timed out waiting for unframed output")
+	}
+}
+
+func TestHubLineModeFlushesPartialBeforePaneDead(t *testing.T) {
+	c := &client{send: make(chan serverMsg, 32)}
+	c.setLineMode(true)
+	if out, ok := c.frameLines(paneOutputMsg("%1", "partial")); !ok || len(out) != 0 {
+		t.Fatalf("partial line emitted early: %#v", out)
+	}
+	out, ok := c.frameLines(serverMsg{T: "pane_dead", PaneDead: &paneDeadPayload{PaneID: "%1"}})
+	if !ok || len(out) != 2 || out[0].PaneOutput.Data != "partial" || out[1].T != "pane_dead" {
+		t.Fatalf("pane_dead framing = %#v", out)
+	}
+}
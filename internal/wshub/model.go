@@ -1,12 +1,17 @@
 package wshub
 
 import (
+	"reflect"
 	"sort"
 	"strconv"
 	"strings"
+
+	"github.com/ampcode/wmux/internal/tmuxparse"
 )
 
-const modelPrefix = "__WMUX__"
+// DefaultSentinel is the marker prefix wmux uses to tag its own format
+// output when no per-run sentinel is configured.
+const DefaultSentinel = "__WMUX__"
 
 type statePayload struct {
 	Windows     []windowPayload       `json:"windows"`
@@ -19,30 +24,43 @@ type tmuxUnavailableState struct {
 }
 
 type windowPayload struct {
-	ID    string `json:"id"`
-	Index int    `json:"index"`
-	Name  string `json:"name"`
+	ID     string `json:"id"`
+	Index  int    `json:"index"`
+	Name   string `json:"name"`
+	Active bool   `json:"active"`
 }
 
 type panePayload struct {
-	ID          string `json:"pane_id"`
-	Name        string `json:"name"`
-	SessionName string `json:"session_name"`
-	WindowID    string `json:"window_id"`
-	WindowIndex int    `json:"window_index"`
-	WindowName  string `json:"window_name"`
-	PaneIndex   int    `json:"pane_index"`
-	Active      bool   `json:"active"`
-	Left        int    `json:"left"`
-	Top         int    `json:"top"`
-	Width       int    `json:"width"`
-	Height      int    `json:"height"`
-	Title       string `json:"title"`
+	ID           string `json:"pane_id"`
+	Name         string `json:"name"`
+	SessionName  string `json:"session_name"`
+	WindowID     string `json:"window_id"`
+	WindowIndex  int    `json:"window_index"`
+	WindowName   string `json:"window_name"`
+	WindowActive bool   `json:"-"`
+	PaneIndex    int    `json:"pane_index"`
+	Active       bool   `json:"active"`
+	Left         int    `json:"left"`
+	Top          int    `json:"top"`
+	Width        int    `json:"width"`
+	Height       int    `json:"height"`
+	Title        string `json:"title"`
+	Dead         bool   `json:"dead"`
+	DeadStatus   int    `json:"dead_status"`
+	PID          int    `json:"pid"`
+	StartCommand string `json:"start_command"`
+	// Extra maps each configured extra pane field to its value.
+	Extra map[string]string `json:"extra,omitempty"`
 }
 
 type modelState struct {
-	windows map[string]windowPayload
-	panes   map[string]panePayload
+	// prefix tags model records in command output; see DefaultSentinel.
+	prefix string
+	// extraFields are the format variables appended to each pane row, in
+	// order; see Options.ExtraPaneFields.
+	extraFields []string
+	windows     map[string]windowPayload
+	panes       map[string]panePayload
 }
 
 func newModelState() modelState {
@@ -64,12 +82,16 @@ func (m *modelState) applyOutputLines(lines []string) bool {
 	sawWindows := false
 	sawPanes := false
 
+	prefix := m.prefix
+	if prefix == "" {
+		prefix = DefaultSentinel
+	}
 	for _, line := range lines {
-		if !strings.HasPrefix(line, modelPrefix) {
+		if !strings.HasPrefix(line, prefix+"_") {
 			continue
 		}
 		parts := strings.Split(line, "\t")
-		kind := strings.TrimPrefix(parts[0], modelPrefix+"_")
+		kind := strings.TrimPrefix(parts[0], prefix+"_")
 		switch kind {
 		case "win":
 			if len(parts) < 4 {
@@ -79,13 +101,13 @@ func (m *modelState) applyOutputLines(lines []string) bool {
 			if err != nil {
 				continue
 			}
-			nextWindows[parts[1]] = windowPayload{ID: parts[1], Index: idx, Name: parts[3]}
+			nextWindows[parts[1]] = windowPayload{ID: parts[1], Index: idx, Name: parts[3], Active: len(parts) > 4 && parts[4] == "1"}
 			sawWindows = true
 		case "pane":
 			if len(parts) < 10 {
 				continue
 			}
-			pane, ok := parsePane(parts)
+			pane, ok := parsePane(parts, m.extraFields)
 			if !ok {
 				continue
 			}
@@ -120,13 +142,19 @@ func paneMapsEqual(a, b map[string]panePayload) bool {
 		return false
 	}
 	for k, av := range a {
-		if bv, ok := b[k]; !ok || av != bv {
+		if bv, ok := b[k]; !ok || !panesEqual(av, bv) {
 			return false
 		}
 	}
 	return true
 }
 
+// panesEqual compares two panes field by field. panePayload holds the Extra
+// map, so it cannot be compared with ==.
+func panesEqual(a, b panePayload) bool {
+	return reflect.DeepEqual(a, b)
+}
+
 func windowMapsEqual(a, b map[string]windowPayload) bool {
 	if len(a) != len(b) {
 		return false
@@ -145,11 +173,44 @@ func windowsFromPanes(panes map[string]panePayload) map[string]windowPayload {
 		if pane.WindowID == "" {
 			continue
 		}
-		windows[pane.WindowID] = windowPayload{ID: pane.WindowID, Index: pane.WindowIndex, Name: pane.WindowName}
+		windows[pane.WindowID] = windowPayload{ID: pane.WindowID, Index: pane.WindowIndex, Name: pane.WindowName, Active: pane.WindowActive}
 	}
 	return windows
 }
 
+// applyLayout updates geometry for known panes of windowID from a parsed
+// %layout-change layout. It reports whether any pane changed.
+func (m *modelState) applyLayout(windowID string, layout []tmuxparse.LayoutPane) bool {
+	updated := false
+	for _, lp := range layout {
+		pane, ok := m.panes[lp.PaneID]
+		if !ok || pane.WindowID != windowID {
+			continue
+		}
+		if pane.Left == lp.Left && pane.Top == lp.Top && pane.Width == lp.Width && pane.Height == lp.Height {
+			continue
+		}
+		pane.Left, pane.Top, pane.Width, pane.Height = lp.Left, lp.Top, lp.Width, lp.Height
+		m.panes[lp.PaneID] = pane
+		updated = true
+	}
+	return updated
+}
+
+// removeWindow drops windowID and its panes from the model, as reported by a
+// %window-close. It reports whether anything was removed.
+func (m *modelState) removeWindow(windowID string) bool {
+	_, removed := m.windows[windowID]
+	delete(m.windows, windowID)
+	for id, pane := range m.panes {
+		if pane.WindowID == windowID {
+			delete(m.panes, id)
+			removed = true
+		}
+	}
+	return removed
+}
+
 func (m *modelState) snapshot() statePayload {
 	windows := make([]windowPayload, 0, len(m.windows))
 	for _, w := range m.windows {
@@ -182,13 +243,24 @@ func (m *modelState) snapshot() statePayload {
 	return statePayload{Windows: windows, Panes: panes}
 }
 
-func parsePane(parts []string) (panePayload, bool) {
+// parsePane builds a pane from a split list-panes row. When extraFields is
+// set and the row is long enough to carry them, the trailing columns are
+// taken as their values before the known fields are read.
+func parsePane(parts []string, extraFields []string) (panePayload, bool) {
 	offset := 0
 	sessionName := ""
 	if len(parts) > 1 && !strings.HasPrefix(parts[1], "%") {
 		sessionName = parts[1]
 		offset = 1
 	}
+	var extra map[string]string
+	if n := len(extraFields); n > 0 && len(parts) >= 18+offset+n {
+		extra = make(map[string]string, n)
+		for i, name := range extraFields {
+			extra[name] = parts[len(parts)-n+i]
+		}
+		parts = parts[:len(parts)-n]
+	}
 
 	paneIndex, err := strconv.Atoi(parts[3+offset])
 	if err != nil {
@@ -226,20 +298,92 @@ func parsePane(parts []string) (panePayload, bool) {
 	if len(parts) > 12+offset {
 		windowName = parts[12+offset]
 	}
+	windowActive := len(parts) > 13+offset && parts[13+offset] == "1"
+	dead := len(parts) > 14+offset && parts[14+offset] == "1"
+	deadStatus := 0
+	if dead && len(parts) > 15+offset {
+		if status, err := strconv.Atoi(parts[15+offset]); err == nil {
+			deadStatus = status
+		}
+	}
+	pid := 0
+	if len(parts) > 16+offset {
+		if v, err := strconv.Atoi(parts[16+offset]); err == nil {
+			pid = v
+		}
+	}
+	// The start command is the last field, so any tabs inside it are kept.
+	startCommand := ""
+	if len(parts) > 17+offset {
+		startCommand = strings.Join(parts[17+offset:], "\t")
+	}
 
 	return panePayload{
-		ID:          parts[1+offset],
-		Name:        name,
-		SessionName: sessionName,
-		WindowID:    parts[2+offset],
-		WindowIndex: windowIndex,
-		WindowName:  windowName,
-		PaneIndex:   paneIndex,
-		Active:      parts[4+offset] == "1",
-		Left:        left,
-		Top:         top,
-		Width:       width,
-		Height:      height,
-		Title:       title,
+		ID:           parts[1+offset],
+		Name:         name,
+		SessionName:  sessionName,
+		WindowID:     parts[2+offset],
+		WindowIndex:  windowIndex,
+		WindowName:   windowName,
+		WindowActive: windowActive,
+		PaneIndex:    paneIndex,
+		Active:       parts[4+offset] == "1",
+		Left:         left,
+		Top:          top,
+		Width:        width,
+		Height:       height,
+		Title:        title,
+		Dead:         dead,
+		DeadStatus:   deadStatus,
+		PID:          pid,
+		StartCommand: startCommand,
+		Extra:        extra,
 	}, true
 }
+
+// newlyDeadPanes returns panes that are dead in next but were alive in prev.
+// Panes first seen already dead are not reported.
+func newlyDeadPanes(prev, next map[string]panePayload) []panePayload {
+	var out []panePayload
+	for id, pane := range next {
+		if !pane.Dead {
+			continue
+		}
+		if old, ok := prev[id]; ok && !old.Dead {
+			out = append(out, pane)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].ID < out[j].ID })
+	return out
+}
+
+// paneFocused reports whether pane is the one tmux would deliver keys to in
+// its session: the active pane of the active window.
+func paneFocused(pane panePayload) bool {
+	return pane.Active && pane.WindowActive && !pane.Dead
+}
+
+// paneFocusChanges returns the panes whose focus differs between prev and
+// next, focus losses first. Nothing is reported when prev is empty (the
+// first sync), and panes that disappeared are not reported as losing focus.
+func paneFocusChanges(prev, next map[string]panePayload) []paneFocusPayload {
+	if len(prev) == 0 {
+		return nil
+	}
+	var out, in []paneFocusPayload
+	for id, pane := range next {
+		now := paneFocused(pane)
+		old, ok := prev[id]
+		if (ok && paneFocused(old)) == now {
+			continue
+		}
+		if now {
+			in = append(in, paneFocusPayload{PaneID: id, Focused: true})
+		} else {
+			out = append(out, paneFocusPayload{PaneID: id})
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].PaneID < out[j].PaneID })
+	sort.Slice(in, func(i, j int) bool { return in[i].PaneID < in[j].PaneID })
+	return append(out, in...)
+}
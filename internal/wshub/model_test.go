@@ -1,6 +1,11 @@
 package wshub
 
-import "testing"
+import (
+	"strings"
+	"testing"
+
+	"github.com/ampcode/wmux/internal/tmuxparse"
+)
 
 func TestModelStateApplyOutputLines(t *testing.T) {
 	m := newModelState()
@@ -74,3 +79,173 @@ func TestModelStateApplyOutputLinesReplacesPaneSnapshot(t *testing.T) {
 		t.Fatalf("expected stale pane removal, got %#v", s.Panes)
 	}
 }
+
+func TestModelStateApplyOutputLinesCapturesActiveWindow(t *testing.T) {
+	m := newModelState()
+	m.applyOutputLines([]string{
+		"__WMUX___pane\tdev\t%1\t@1\t0\t1\t0\t0\t120\t40\tbash\tbash\t0\tweb\t0",
+		"__WMUX___pane\tdev\t%2\t@2\t0\t1\t0\t0\t120\t40\tbash\tbash\t1\tapi\t1",
+	})
+
+	s := m.snapshot()
+	if len(s.Windows) != 2 {
+		t.Fatalf("unexpected windows snapshot: %#v", s.Windows)
+	}
+	if s.Windows[0].Active || !s.Windows[1].Active {
+		t.Fatalf("unexpected window active flags: %#v", s.Windows)
+	}
+}
+
+func TestModelStateApplyLayoutUpdatesPaneGeometry(t *testing.T) {
+	m := newModelState()
+	m.applyOutputLines([]string{
+		"__WMUX___pane\tdev\t%1\t@1\t0\t1\t0\t0\t120\t40\tbash\tbash\t0\tweb",
+		"__WMUX___pane\tdev\t%2\t@2\t0\t1\t0\t0\t120\t40\tbash\tbash\t1\tapi",
+	})
+
+	changed := m.applyLayout("@1", []tmuxparse.LayoutPane{
+		{PaneID: "%1", Left: 0, Top: 0, Width: 60, Height: 40},
+		{PaneID: "%2", Left: 61, Top: 0, Width: 59, Height: 40},
+	})
+	if !changed {
+		t.Fatalf("expected layout change")
+	}
+	if got := m.panes["%1"]; got.Width != 60 || got.Height != 40 {
+		t.Fatalf("pane %%1 geometry not updated: %#v", got)
+	}
+	if got := m.panes["%2"]; got.Width != 120 {
+		t.Fatalf("pane in another window should be untouched: %#v", got)
+	}
+	if m.applyLayout("@1", []tmuxparse.LayoutPane{{PaneID: "%1", Width: 60, Height: 40}}) {
+		t.Fatalf("expected no change for identical geometry")
+	}
+}
+
+func TestModelStateIgnoresDefaultPrefixWhenSentinelIsRandom(t *testing.T) {
+	m := newModelState()
+	m.prefix = RandomSentinel()
+	if m.prefix == DefaultSentinel {
+		t.Fatalf("RandomSentinel() = %q, want a non-default sentinel", m.prefix)
+	}
+	if err := ValidateSentinel(m.prefix); err != nil {
+		t.Fatalf("ValidateSentinel(%q) = %v", m.prefix, err)
+	}
+	if m.applyOutputLines([]string{
+		"__WMUX___pane\tdev\t%9\t@1\t0\t1\t0\t0\t120\t40\tbash\tspoofed\t0\tweb",
+	}) {
+		t.Fatalf("expected pane output using the default prefix to be ignored")
+	}
+	if !m.applyOutputLines([]string{
+		m.prefix + "_pane\tdev\t%1\t@1\t0\t1\t0\t0\t120\t40\tbash\tbash\t0\tweb",
+	}) {
+		t.Fatalf("expected model change for sentinel-prefixed line")
+	}
+	s := m.snapshot()
+	if len(s.Panes) != 1 || s.Panes[0].ID != "%1" {
+		t.Fatalf("unexpected panes snapshot: %#v", s.Panes)
+	}
+}
+
+func TestValidateSentinelRejectsUnsafeCharacters(t *testing.T) {
+	for _, s := range []string{"", "abc", "__WMUX #__", "a\tb_c"} {
+		if err := ValidateSentinel(s); err == nil {
+			t.Fatalf("ValidateSentinel(%q) = nil, want error", s)
+		}
+	}
+}
+
+func TestModelStateApplyOutputLinesCapturesDeadPane(t *testing.T) {
+	m := newModelState()
+	m.applyOutputLines([]string{
+		"__WMUX___pane\tdev\t%1\t@1\t0\t1\t0\t0\t120\t40\tbash\tbash\t0\tweb\t1\t0\t",
+	})
+	alive := m.panes
+	m.applyOutputLines([]string{
+		"__WMUX___pane\tdev\t%1\t@1\t0\t1\t0\t0\t120\t40\tbash\tbash\t0\tweb\t1\t1\t127",
+	})
+
+	s := m.snapshot()
+	if len(s.Panes) != 1 || !s.Panes[0].Dead || s.Panes[0].DeadStatus != 127 {
+		t.Fatalf("unexpected dead pane snapshot: %#v", s.Panes)
+	}
+	died := newlyDeadPanes(alive, m.panes)
+	if len(died) != 1 || died[0].ID != "%1" {
+		t.Fatalf("newlyDeadPanes = %#v, want %%1", died)
+	}
+	if again := newlyDeadPanes(m.panes, m.panes); len(again) != 0 {
+		t.Fatalf("newlyDeadPanes for unchanged dead pane = %#v, want none", again)
+	}
+}
+
+func TestModelStateRemoveWindowDropsItsPanes(t *testing.T) {
+	m := newModelState()
+	m.applyOutputLines([]string{
+		"__WMUX___pane\tdev\t%1\t@1\t0\t1\t0\t0\t120\t40\tbash\tbash\t0\tweb",
+		"__WMUX___pane\tdev\t%2\t@2\t0\t1\t0\t0\t120\t40\tbash\tbash\t1\tapi",
+	})
+
+	if !m.removeWindow("@2") {
+		t.Fatalf("expected window @2 to be removed")
+	}
+	if _, ok := m.windows["@2"]; ok {
+		t.Fatalf("window @2 still present: %#v", m.windows)
+	}
+	if _, ok := m.panes["%2"]; ok {
+		t.Fatalf("pane %%2 still present: %#v", m.panes)
+	}
+	if _, ok := m.panes["%1"]; !ok {
+		t.Fatalf("pane %%1 should be untouched: %#v", m.panes)
+	}
+	if m.removeWindow("@2") {
+		t.Fatalf("expected no change removing an unknown window")
+	}
+}
+
+func TestModelStateApplyOutputLinesCapturesPIDAndStartCommand(t *testing.T) {
+	m := newModelState()
+	m.applyOutputLines([]string{
+		"__WMUX___pane\tdev\t%1\t@1\t0\t1\t0\t0\t120\t40\tbash\tbash\t0\tweb\t1\t0\t\t4242\tprintf 'a\tb'",
+		"__WMUX___pane\tdev\t%2\t@1\t1\t0\t0\t0\t120\t40\tbash\tbash\t0\tweb\t1\t0\t\t4243\t",
+	})
+
+	if got := m.panes["%1"]; got.PID != 4242 || got.StartCommand != "printf 'a\tb'" {
+		t.Fatalf("pane %%1 = pid %d, start command %q", got.PID, got.StartCommand)
+	}
+	if got := m.panes["%2"]; got.PID != 4243 || got.StartCommand != "" {
+		t.Fatalf("pane %%2 = pid %d, start command %q", got.PID, got.StartCommand)
+	}
+}
+
+func TestModelStateApplyOutputLinesCapturesExtraFields(t *testing.T) {
+	m := newModelState()
+	m.extraFields = []string{"pane_tty", "pane_current_path"}
+	m.applyOutputLines([]string{
+		"__WMUX___pane\tdev\t%1\t@1\t0\t1\t0\t0\t120\t40\tbash\tbash\t0\tweb\t1\t0\t\t4242\tprintf 'a\tb'\t/dev/pts/3\t/home/me",
+	})
+
+	got := m.panes["%1"]
+	if got.StartCommand != "printf 'a\tb'" {
+		t.Fatalf("start command = %q, want extra fields stripped", got.StartCommand)
+	}
+	if got.Extra["pane_tty"] != "/dev/pts/3" || got.Extra["pane_current_path"] != "/home/me" || len(got.Extra) != 2 {
+		t.Fatalf("extra = %#v", got.Extra)
+	}
+	if !strings.HasSuffix(paneModelFormat(DefaultSentinel, m.extraFields), "#{pane_start_command}\t#{pane_tty}\t#{pane_current_path}") {
+		t.Fatalf("format does not append extra fields: %q", paneModelFormat(DefaultSentinel, m.extraFields))
+	}
+}
+
+func TestParseExtraPaneFields(t *testing.T) {
+	got, err := ParseExtraPaneFields(" pane_tty, pane_pid,,pane_tty ")
+	if err != nil {
+		t.Fatalf("ParseExtraPaneFields: %v", err)
+	}
+	if strings.Join(got, ",") != "pane_tty,pane_pid" {
+		t.Fatalf("fields = %q", got)
+	}
+	for _, bad := range []string{"pane}tty", "#{pane_tty}", "Pane_TTY"} {
+		if _, err := ParseExtraPaneFields(bad); err == nil {
+			t.Fatalf("expected error for %q", bad)
+		}
+	}
+}
@@ -0,0 +1,80 @@
+package wshub
+
+import (
+	"context"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// DefaultPaneReadyTimeout bounds WaitPaneReady when PaneReadySignal.Timeout
+// is unset; MaxPaneReadyTimeout is the largest timeout callers may ask for.
+const (
+	DefaultPaneReadyTimeout = 5 * time.Second
+	MaxPaneReadyTimeout     = time.Minute
+)
+
+// paneReadyPollInterval is how often WaitPaneReady re-checks the pane.
+const paneReadyPollInterval = 100 * time.Millisecond
+
+// PaneReadySignal is what WaitPaneReady takes as a sign that a new pane
+// accepts input. With neither Prompt nor Command set, any text on the
+// visible screen counts, since a shell draws its prompt once it is reading
+// input. When both are set, both must hold.
+type PaneReadySignal struct {
+	// Prompt must match the last non-blank line of the visible screen,
+	// with leading and trailing space trimmed.
+	Prompt *regexp.Regexp
+	// Command must equal #{pane_current_command}, for example the program
+	// a shell wrapper execs into.
+	Command string
+	// Timeout bounds the wait; zero uses DefaultPaneReadyTimeout.
+	Timeout time.Duration
+}
+
+// WaitPaneReady polls paneID until sig holds, Timeout passes, or ctx ends,
+// and reports whether sig was seen. It is a heuristic: a prompt can be
+// drawn before the shell reads input, and a program may never print one.
+// Failed polls are retried until the deadline.
+func (h *Hub) WaitPaneReady(ctx context.Context, paneID string, sig PaneReadySignal) bool {
+	timeout := sig.Timeout
+	if timeout <= 0 {
+		timeout = DefaultPaneReadyTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	ticker := time.NewTicker(paneReadyPollInterval)
+	defer ticker.Stop()
+	for {
+		if h.paneReady(ctx, paneID, sig) {
+			return true
+		}
+		select {
+		case <-ctx.Done():
+			return false
+		case <-ticker.C:
+		}
+	}
+}
+
+func (h *Hub) paneReady(ctx context.Context, paneID string, sig PaneReadySignal) bool {
+	if sig.Command != "" {
+		current, err := h.DisplayPaneFormat(ctx, paneID, "#{pane_current_command}")
+		if err != nil || strings.TrimSpace(current) != sig.Command {
+			return false
+		}
+		if sig.Prompt == nil {
+			return true
+		}
+	}
+	screen, err := h.CapturePane(ctx, paneID, CaptureOptions{})
+	if err != nil {
+		return false
+	}
+	last := lastNonEmptyLine(strings.Split(screen, "\n"))
+	if sig.Prompt == nil {
+		return last != ""
+	}
+	return sig.Prompt.MatchString(last)
+}
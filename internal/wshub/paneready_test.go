@@ -0,0 +1,106 @@
+package wshub
+
+import (
+	"context"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ampcode/wmux/internal/policy"
+)
+
+// readySender answers capture-pane with blankFor blank screens and then
+// one ending in prompt, and display-message with command. Like echoSender
+// it answers before Send returns, so replies are fed from one goroutine at
+// a time.
+type readySender struct {
+	hub          *Hub
+	mu           sync.Mutex
+	captures     int
+	blankFor     int
+	prompt       string
+	command      string
+	commandAsked int
+}
+
+func (s *readySender) Send(line string) error {
+	s.mu.Lock()
+	var out []string
+	switch {
+	case strings.HasPrefix(line, "capture-pane "):
+		s.captures++
+		out = []string{"", ""}
+		if s.captures > s.blankFor {
+			out = []string{"welcome", s.prompt, ""}
+		}
+	case strings.HasPrefix(line, "display-message "):
+		s.commandAsked++
+		out = []string{s.command}
+	}
+	s.mu.Unlock()
+	s.hub.BroadcastTmuxStdoutLine("%begin 1 1 0")
+	for _, l := range out {
+		s.hub.BroadcastTmuxStdoutLine(l)
+	}
+	s.hub.BroadcastTmuxStdoutLine("%end 1 1 0")
+	return nil
+}
+
+func TestWaitPaneReadyPollsUntilPromptMatches(t *testing.T) {
+	h := New(policy.Default(), "dev")
+	s := &readySender{hub: h, blankFor: 2, prompt: "user@host:~$"}
+	if err := h.BindTmux(s); err != nil {
+		t.Fatalf("BindTmux: %v", err)
+	}
+
+	if !h.WaitPaneReady(context.Background(), "%3", PaneReadySignal{Prompt: regexp.MustCompile(`\$$`)}) {
+		t.Fatalf("WaitPaneReady = false, want the prompt to be seen")
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.captures != 3 {
+		t.Fatalf("captures = %d, want 3 (two blank screens, then the prompt)", s.captures)
+	}
+}
+
+func TestWaitPaneReadyDefaultsToAnyScreenText(t *testing.T) {
+	h := New(policy.Default(), "dev")
+	s := &readySender{hub: h, blankFor: 1, prompt: "%"}
+	if err := h.BindTmux(s); err != nil {
+		t.Fatalf("BindTmux: %v", err)
+	}
+	if !h.WaitPaneReady(context.Background(), "%3", PaneReadySignal{}) {
+		t.Fatalf("WaitPaneReady = false, want ready once the screen has text")
+	}
+}
+
+func TestWaitPaneReadyTimesOutOnCommandMismatch(t *testing.T) {
+	h := New(policy.Default(), "dev")
+	s := &readySender{hub: h, prompt: "$", command: "bash"}
+	if err := h.BindTmux(s); err != nil {
+		t.Fatalf("BindTmux: %v", err)
+	}
+
+	start := time.Now()
+	if h.WaitPaneReady(context.Background(), "%3", PaneReadySignal{Command: "vim", Timeout: 300 * time.Millisecond}) {
+		t.Fatalf("WaitPaneReady = true while the pane still runs bash")
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("WaitPaneReady took %v, want it bounded by the 300ms timeout", elapsed)
+	}
+	s.mu.Lock()
+	asked := s.commandAsked
+	s.mu.Unlock()
+	if asked < 2 {
+		t.Fatalf("current command asked %d times, want repeated polls", asked)
+	}
+
+	s.mu.Lock()
+	s.command = "vim"
+	s.mu.Unlock()
+	if !h.WaitPaneReady(context.Background(), "%3", PaneReadySignal{Command: "vim"}) {
+		t.Fatalf("WaitPaneReady = false once the pane runs vim")
+	}
+}
@@ -0,0 +1,40 @@
+package wshub
+
+import (
+	"net/http"
+	"slices"
+
+	"github.com/gorilla/websocket"
+)
+
+// ProtocolV1 is the WebSocket subprotocol for the current message shapes.
+// Clients that request no subprotocol are served as ProtocolV1.
+const ProtocolV1 = "wmux.v1"
+
+// supportedProtocols lists the subprotocols WSHandler accepts, preferred
+// first.
+var supportedProtocols = []string{ProtocolV1}
+
+// SupportedProtocols returns the WebSocket subprotocols the hub accepts,
+// preferred first.
+func SupportedProtocols() []string {
+	return slices.Clone(supportedProtocols)
+}
+
+// negotiateProtocol picks the subprotocol for r from its
+// Sec-WebSocket-Protocol header: the first one the client lists that the
+// hub supports. echo reports whether the choice must be sent back, which is
+// only when the client asked for one. It fails when the client lists
+// subprotocols but none is supported.
+func negotiateProtocol(r *http.Request) (protocol string, echo bool, ok bool) {
+	requested := websocket.Subprotocols(r)
+	if len(requested) == 0 {
+		return ProtocolV1, false, true
+	}
+	for _, p := range requested {
+		if slices.Contains(supportedProtocols, p) {
+			return p, true, true
+		}
+	}
+	return "", false, false
+}
@@ -0,0 +1,57 @@
+package wshub
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/ampcode/wmux/internal/policy"
+	"github.com/gorilla/websocket"
+)
+
+func TestWSHandlerNegotiatesSubprotocol(t *testing.T) {
+	h := New(policy.Default(), "dev")
+	srv := httptest.NewServer(h.WSHandler(WSConfig{}))
+	defer srv.Close()
+	url := "ws" + strings.TrimPrefix(srv.URL, "http")
+
+	dialer := websocket.Dialer{Subprotocols: []string{"wmux.v9", ProtocolV1}}
+	conn, resp, err := dialer.Dial(url, nil)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	if got := resp.Header.Get("Sec-WebSocket-Protocol"); got != ProtocolV1 {
+		t.Fatalf("Sec-WebSocket-Protocol = %q, want %q", got, ProtocolV1)
+	}
+	var msg serverMsg
+	if err := conn.ReadJSON(&msg); err != nil {
+		t.Fatalf("read protocol: %v", err)
+	}
+	if msg.T != "protocol" || msg.Protocol.Version != ProtocolV1 {
+		t.Fatalf("first message = %+v, want protocol version %q", msg, ProtocolV1)
+	}
+	conn.Close()
+
+	// No subprotocol requested: served as v1 without echoing a header.
+	conn, resp, err = websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatalf("dial without subprotocol: %v", err)
+	}
+	if got := resp.Header.Get("Sec-WebSocket-Protocol"); got != "" {
+		t.Fatalf("Sec-WebSocket-Protocol = %q, want none", got)
+	}
+	if err := conn.ReadJSON(&msg); err != nil || msg.Protocol == nil || msg.Protocol.Version != ProtocolV1 {
+		t.Fatalf("protocol message = %+v (err %v), want version %q", msg, err, ProtocolV1)
+	}
+	conn.Close()
+
+	dialer = websocket.Dialer{Subprotocols: []string{"wmux.v2"}}
+	_, resp, err = dialer.Dial(url, nil)
+	if err == nil {
+		t.Fatalf("dial with only an unsupported subprotocol succeeded")
+	}
+	if resp == nil || resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("response = %+v, want 400", resp)
+	}
+}
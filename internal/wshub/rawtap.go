@@ -0,0 +1,163 @@
+package wshub
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// The raw tap is a debugging aid behind GET /ws/raw: it streams every line
+// tmux writes to the control client, before parsing, and writes lines the
+// debugging client sends straight to tmux, skipping the command policy.
+
+// rawNoticePrefix starts the frames /ws/raw sends on its own behalf. tmux
+// control-mode output never starts with '#'.
+const rawNoticePrefix = "#wmux "
+
+// rawUpgrader only accepts same-origin browsers: /ws/raw skips the command
+// policy, so a page on another site must not be able to reach it through
+// the operator's browser. Clients that send no Origin, such as websocat,
+// are accepted.
+var rawUpgrader = websocket.Upgrader{CheckOrigin: sameOrigin}
+
+// sameOrigin reports whether r carries no Origin header, or one whose host
+// is r.Host.
+func sameOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+	return strings.EqualFold(u.Host, r.Host)
+}
+
+// SubscribeRawLines returns a channel receiving every line read from tmux's
+// stdout, unparsed and in arrival order. The channel is closed when cancel
+// is called or when the subscriber falls more than buffer lines behind.
+func (h *Hub) SubscribeRawLines(buffer int) (<-chan string, func()) {
+	if buffer <= 0 {
+		buffer = DefaultClientBuffer
+	}
+	ch := make(chan string, buffer)
+	h.mu.Lock()
+	if h.rawSubs == nil {
+		h.rawSubs = make(map[chan string]struct{})
+	}
+	h.rawSubs[ch] = struct{}{}
+	h.mu.Unlock()
+	return ch, func() { h.unsubscribeRawLines(ch) }
+}
+
+func (h *Hub) unsubscribeRawLines(ch chan string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if _, ok := h.rawSubs[ch]; !ok {
+		return
+	}
+	delete(h.rawSubs, ch)
+	close(ch)
+}
+
+func (h *Hub) publishRawLine(line string) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	for ch := range h.rawSubs {
+		select {
+		case ch <- line:
+		default:
+			go h.unsubscribeRawLines(ch)
+		}
+	}
+}
+
+// SendRawLine writes line to tmux as a control-mode command line without
+// checking it against the command policy. It is queued as a pending command
+// so its response block stays paired; since tmux answers each command of a
+// ';' list with its own block, a line may hold one command only. Blank lines
+// are refused because they detach the control client.
+func (h *Hub) SendRawLine(line string) error {
+	switch {
+	case strings.TrimSpace(line) == "":
+		return errors.New("raw line is empty")
+	case strings.ContainsAny(line, "\r\n"):
+		return errors.New("raw line must be a single line")
+	case strings.Contains(line, ";"):
+		return errors.New("raw line must hold one command; send ';' lists as separate lines")
+	}
+	if err := h.sendPending(pendingCommand{Name: "raw", QueuedAt: time.Now()}, line); err != nil {
+		return err
+	}
+	h.logger().Warn("raw tmux line sent", "line", line)
+	return nil
+}
+
+// RawWSHandler serves the raw tap over a WebSocket. Each text frame the
+// server sends is one tmux stdout line, or a rawNoticePrefix line such as
+// the error for a rejected send. Each text frame the client sends is passed
+// to SendRawLine. A connection that falls behind tmux is closed. Upgrades
+// from a cross-site Origin are refused with 403.
+func (h *Hub) RawWSHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		conn, err := rawUpgrader.Upgrade(w, r, nil)
+		if err != nil {
+			h.logger().Warn("raw ws upgrade failed", "remote_addr", r.RemoteAddr, "err", err)
+			return
+		}
+		defer conn.Close()
+		h.logger().Warn("raw ws client connected", "remote_addr", r.RemoteAddr)
+		defer h.logger().Info("raw ws client disconnected", "remote_addr", r.RemoteAddr)
+
+		lines, cancel := h.SubscribeRawLines(0)
+		defer cancel()
+		notices := make(chan string, 16)
+		go rawWriteLoop(conn, lines, notices)
+
+		conn.SetReadLimit(DefaultMaxMessageBytes)
+		for {
+			kind, data, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			if kind != websocket.TextMessage {
+				continue
+			}
+			if err := h.SendRawLine(string(data)); err != nil {
+				select {
+				case notices <- rawNoticePrefix + "error " + err.Error():
+				default:
+				}
+			}
+		}
+	}
+}
+
+// rawWriteLoop writes lines and notices to conn until lines is closed,
+// which also happens when the subscriber fell behind.
+func rawWriteLoop(conn *websocket.Conn, lines <-chan string, notices <-chan string) {
+	for {
+		var frame string
+		select {
+		case line, ok := <-lines:
+			if !ok {
+				_ = conn.WriteControl(websocket.CloseMessage,
+					websocket.FormatCloseMessage(websocket.CloseTryAgainLater, "raw stream closed or fell behind tmux"),
+					time.Now().Add(time.Second))
+				_ = conn.Close()
+				return
+			}
+			frame = line
+		case frame = <-notices:
+		}
+		if err := conn.WriteMessage(websocket.TextMessage, []byte(frame)); err != nil {
+			_ = conn.Close()
+			return
+		}
+	}
+}
@@ -0,0 +1,138 @@
+package wshub
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ampcode/wmux/internal/policy"
+	"github.com/gorilla/websocket"
+)
+
+func TestSubscribeRawLinesSeesLinesBeforeParsing(t *testing.T) {
+	h := New(policy.Default(), "dev")
+	lines, cancel := h.SubscribeRawLines(4)
+	defer cancel()
+
+	h.BroadcastTmuxStdoutLine("%output %1 hi")
+	h.BroadcastTmuxStdoutLine("not control mode at all")
+	for _, want := range []string{"%output %1 hi", "not control mode at all"} {
+		select {
+		case got := <-lines:
+			if got != want {
+				t.Fatalf("raw line = %q, want %q", got, want)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("timed out waiting for %q", want)
+		}
+	}
+}
+
+func TestSubscribeRawLinesDropsSlowSubscriber(t *testing.T) {
+	h := New(policy.Default(), "dev")
+	lines, cancel := h.SubscribeRawLines(1)
+	defer cancel()
+
+	h.BroadcastTmuxStdoutLine("%output %1 a")
+	h.BroadcastTmuxStdoutLine("%output %1 b")
+	deadline := time.After(time.Second)
+	for {
+		select {
+		case _, ok := <-lines:
+			if !ok {
+				return
+			}
+		case <-deadline:
+			t.Fatalf("slow raw subscriber was not dropped")
+		}
+	}
+}
+
+func TestSendRawLineBypassesPolicyAndStaysPaired(t *testing.T) {
+	h := New(policy.Default(), "dev")
+	if err := h.SendRawLine("kill-server"); !errors.Is(err, ErrTmuxNotReady) {
+		t.Fatalf("SendRawLine without tmux = %v, want ErrTmuxNotReady", err)
+	}
+
+	sender := &countingSender{}
+	if err := h.BindTmux(sender); err != nil {
+		t.Fatalf("BindTmux: %v", err)
+	}
+	for _, line := range []string{"", "  ", "list-panes\nkill-server", "list-panes ; kill-server"} {
+		if err := h.SendRawLine(line); err == nil {
+			t.Fatalf("SendRawLine(%q) succeeded, want error", line)
+		}
+	}
+	if err := h.SendRawLine("kill-server"); err != nil {
+		t.Fatalf("SendRawLine: %v", err)
+	}
+	if sender.count("kill-server") != 1 {
+		t.Fatalf("sent lines = %q, want kill-server", sender.lines)
+	}
+	pending := h.PendingSnapshot()
+	if len(pending) != 1 || pending[0].Name != "raw" {
+		t.Fatalf("pending = %+v, want one raw command", pending)
+	}
+}
+
+func TestRawWSHandlerStreamsAndSendsLines(t *testing.T) {
+	h := New(policy.Default(), "dev")
+	sender := &countingSender{}
+	if err := h.BindTmux(sender); err != nil {
+		t.Fatalf("BindTmux: %v", err)
+	}
+	srv := httptest.NewServer(h.RawWSHandler())
+	defer srv.Close()
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http"), nil)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer conn.Close()
+	_ = conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+
+	if err := conn.WriteMessage(websocket.TextMessage, []byte("display-message -p hi")); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	waitForSent(t, sender, "display-message -p hi", 1)
+
+	h.BroadcastTmuxStdoutLine("%begin 1 1 0")
+	if _, data, err := conn.ReadMessage(); err != nil || string(data) != "%begin 1 1 0" {
+		t.Fatalf("read = %q, %v; want the raw tmux line", data, err)
+	}
+
+	if err := conn.WriteMessage(websocket.TextMessage, []byte("")); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	if _, data, err := conn.ReadMessage(); err != nil || !strings.HasPrefix(string(data), rawNoticePrefix+"error ") {
+		t.Fatalf("read = %q, %v; want an error notice", data, err)
+	}
+}
+
+func TestRawWSHandlerRefusesCrossSiteOrigin(t *testing.T) {
+	h := New(policy.Default(), "dev")
+	if err := h.BindTmux(&countingSender{}); err != nil {
+		t.Fatalf("BindTmux: %v", err)
+	}
+	srv := httptest.NewServer(h.RawWSHandler())
+	defer srv.Close()
+	wsURL := "ws" + strings.TrimPrefix(srv.URL, "http")
+
+	conn, resp, err := websocket.DefaultDialer.Dial(wsURL, http.Header{"Origin": {"https://evil.example"}})
+	if err == nil {
+		conn.Close()
+		t.Fatalf("cross-site upgrade succeeded")
+	}
+	if resp == nil || resp.StatusCode != http.StatusForbidden {
+		t.Fatalf("cross-site upgrade response = %v, want 403", resp)
+	}
+
+	conn, _, err = websocket.DefaultDialer.Dial(wsURL, http.Header{"Origin": {srv.URL}})
+	if err != nil {
+		t.Fatalf("same-origin dial: %v", err)
+	}
+	conn.Close()
+}
@@ -0,0 +1,46 @@
+package wshub
+
+import (
+	"hash/fnv"
+	"time"
+)
+
+// broadcastPaneSnapshot sends a pane_snapshot to every client, unless its
+// content is identical to the last snapshot broadcast for that pane: the
+// other clients already have it, so only requester, the client whose
+// capture-pane produced it, receives it again. A nil requester (a capture
+// wmux issued itself) means nobody does.
+func (h *Hub) broadcastPaneSnapshot(snap *paneSnapshotPayload, requester *client) {
+	if h.paneHidden(snap.PaneID) {
+		return
+	}
+	sum := fnv.New64a()
+	_, _ = sum.Write([]byte(snap.Data))
+	hash := sum.Sum64()
+
+	h.mu.Lock()
+	last, seen := h.snapshotHashes[snap.PaneID]
+	if !seen || last != hash {
+		if h.snapshotHashes == nil {
+			h.snapshotHashes = make(map[string]uint64)
+		}
+		h.snapshotHashes[snap.PaneID] = hash
+	}
+	h.mu.Unlock()
+
+	msg := serverMsg{T: "pane_snapshot", PaneSnapshot: snap}
+	if !seen || last != hash {
+		h.broadcast(msg)
+		return
+	}
+	if requester == nil {
+		h.logger().Debug("skipped unchanged pane snapshot", "pane", snap.PaneID)
+		return
+	}
+	h.mu.RLock()
+	_, connected := h.clients[requester]
+	h.mu.RUnlock()
+	if connected {
+		h.deliver(requester, []serverMsg{msg}, time.Time{})
+	}
+}
@@ -0,0 +1,68 @@
+package wshub
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ampcode/wmux/internal/policy"
+)
+
+// snapshotsBeforeOutput counts the pane_snapshot messages c receives before
+// the next pane_output, which the test sends as a barrier.
+func snapshotsBeforeOutput(t *testing.T, c *client) int {
+	t.Helper()
+	n := 0
+	deadline := time.After(time.Second)
+	for {
+		select {
+		case msg := <-c.send:
+			switch msg.T {
+			case "pane_snapshot":
+				n++
+			case "pane_output":
+				return n
+			}
+		case <-deadline:
+			t.Fatalf("timed out waiting for pane_output barrier")
+		}
+	}
+}
+
+func TestHubSkipsUnchangedPaneSnapshotsExceptForRequester(t *testing.T) {
+	h := New(policy.Default(), "dev")
+	requester := &client{send: make(chan serverMsg, 32)}
+	other := &client{send: make(chan serverMsg, 32)}
+	h.addClient(requester)
+	h.addClient(other)
+
+	capture := func(data string, from *client) {
+		h.mu.Lock()
+		h.pending = append(h.pending, pendingCommand{Name: "capture-pane", TargetPane: "%1", EmitPaneSnapshot: true, Requester: from})
+		h.mu.Unlock()
+		h.BroadcastTmuxStdoutLine("%begin 1 1 0")
+		h.BroadcastTmuxStdoutLine(data)
+		h.BroadcastTmuxStdoutLine("%end 1 1 0")
+		h.BroadcastTmuxStdoutLine("%output %1 .")
+	}
+
+	for _, tc := range []struct {
+		name          string
+		data          string
+		from          *client
+		wantRequester int
+		wantOther     int
+	}{
+		{"first snapshot", "screen A", requester, 1, 1},
+		{"repeat requested", "screen A", requester, 1, 0},
+		{"repeat automatic", "screen A", nil, 0, 0},
+		{"changed automatic", "screen B", nil, 1, 1},
+	} {
+		capture(tc.data, tc.from)
+		if got := snapshotsBeforeOutput(t, requester); got != tc.wantRequester {
+			t.Fatalf("%s: requester got %d snapshots, want %d", tc.name, got, tc.wantRequester)
+		}
+		if got := snapshotsBeforeOutput(t, other); got != tc.wantOther {
+			t.Fatalf("%s: other client got %d snapshots, want %d", tc.name, got, tc.wantOther)
+		}
+	}
+}
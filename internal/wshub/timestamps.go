@@ -0,0 +1,31 @@
+package wshub
+
+import "time"
+
+// setOutputTimestamps turns the ts field of c's pane_output messages on or
+// off.
+func (c *client) setOutputTimestamps(enabled bool) {
+	c.metaMu.Lock()
+	defer c.metaMu.Unlock()
+	c.outputTimestamps = enabled
+}
+
+func (c *client) wantsOutputTimestamps() bool {
+	c.metaMu.Lock()
+	defer c.metaMu.Unlock()
+	return c.outputTimestamps
+}
+
+// stampOutput sets ts on a pane_output bound for c when c asked for
+// timestamps. receivedAt is when the hub parsed the %output the message came
+// from; line mode stamps each line with the chunk that completed it. The
+// payload is copied because it is shared between clients.
+func (c *client) stampOutput(m serverMsg, receivedAt time.Time) serverMsg {
+	if m.PaneOutput == nil || receivedAt.IsZero() || !c.wantsOutputTimestamps() {
+		return m
+	}
+	p := *m.PaneOutput
+	p.TS = receivedAt.UnixNano()
+	m.PaneOutput = &p
+	return m
+}
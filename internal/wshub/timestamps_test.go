@@ -0,0 +1,42 @@
+package wshub
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ampcode/wmux/internal/policy"
+)
+
+func TestHubOutputTimestampsAreOptIn(t *testing.T) {
+	h := New(policy.Default(), "dev")
+	plain := &client{send: make(chan serverMsg, 32)}
+	stamped := &client{send: make(chan serverMsg, 32)}
+	lines := &client{send: make(chan serverMsg, 32)}
+	h.addClient(plain)
+	h.addClient(stamped)
+	h.addClient(lines)
+	stamped.setOutputTimestamps(true)
+	lines.setOutputTimestamps(true)
+	lines.setLineMode(true)
+
+	before := time.Now().UnixNano()
+	h.BroadcastTmuxStdoutLine(`%output %1 ab\012c`)
+
+	if msg := waitPaneOutput(t, plain); msg.TS != 0 {
+		t.Fatalf("plain client ts = %d, want none", msg.TS)
+	}
+	msg := waitPaneOutput(t, stamped)
+	after := time.Now().UnixNano()
+	if msg.TS < before || msg.TS > after {
+		t.Fatalf("ts = %d, want within [%d, %d]", msg.TS, before, after)
+	}
+	if line := waitPaneOutput(t, lines); line.Data != "ab\n" || line.TS != msg.TS {
+		t.Fatalf("line-mode output = %+v, want %q stamped %d", *line, "ab\n", msg.TS)
+	}
+
+	stamped.setOutputTimestamps(false)
+	h.BroadcastTmuxStdoutLine(`%output %1 d`)
+	if msg := waitPaneOutput(t, stamped); msg.TS != 0 {
+		t.Fatalf("ts = %d after disabling, want none", msg.TS)
+	}
+}